@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 	"syscall"
 
 	"github.com/docopt/docopt-go"
@@ -17,6 +18,9 @@ Usage:
 	ergonomadic initdb [--conf <filename>]
 	ergonomadic upgradedb [--conf <filename>]
 	ergonomadic genpasswd [--conf <filename>]
+	ergonomadic checkconfig [--conf <filename>]
+	ergonomadic export [--conf <filename>] [<file>]
+	ergonomadic import [--conf <filename>] <file>
 	ergonomadic run [--conf <filename>]
 	ergonomadic -h | --help
 	ergonomadic --version
@@ -28,6 +32,18 @@ Options:
 	arguments, _ := docopt.Parse(usage, nil, true, version, false)
 
 	configfile := arguments["--conf"].(string)
+	if arguments["checkconfig"].(bool) {
+		errs := irc.CheckConfig(configfile)
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("config OK:", configfile)
+		return
+	}
+
 	config, err := irc.LoadConfig(configfile)
 	if err != nil {
 		log.Fatal("Config file did not load successfully:", err.Error())
@@ -52,6 +68,23 @@ Options:
 	} else if arguments["upgradedb"].(bool) {
 		irc.UpgradeDB(config.Server.Database)
 		log.Println("database upgraded: ", config.Server.Database)
+	} else if arguments["export"].(bool) {
+		out := os.Stdout
+		if filename, ok := arguments["<file>"].(string); ok {
+			out, err = os.Create(filename)
+			if err != nil {
+				log.Fatal("export error: ", err)
+			}
+			defer out.Close()
+		}
+		irc.ExportDB(config.Server.Database, out)
+	} else if arguments["import"].(bool) {
+		in, err := os.Open(arguments["<file>"].(string))
+		if err != nil {
+			log.Fatal("import error: ", err)
+		}
+		defer in.Close()
+		irc.ImportDB(config.Server.Database, in)
 	} else if arguments["run"].(bool) {
 		irc.Log.SetLevel(config.Server.Log)
 		server := irc.NewServer(config)
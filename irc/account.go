@@ -0,0 +1,611 @@
+package irc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Accounts are registered nicknames. A client that has registered or
+// identified to an account keeps its per-account state (such as the
+// SILENCE list) across connections.
+
+type NickServSubCommand string
+
+const (
+	NickServRegister NickServSubCommand = "REGISTER"
+	NickServIdentify NickServSubCommand = "IDENTIFY"
+	NickServGhost    NickServSubCommand = "GHOST"
+	NickServRegain   NickServSubCommand = "REGAIN"
+	NickServVerify   NickServSubCommand = "VERIFY"
+	NickServReset    NickServSubCommand = "RESETPASS"
+	NickServSetPass  NickServSubCommand = "SETPASS"
+	NickServSet      NickServSubCommand = "SET"
+	NickServHelp     NickServSubCommand = "HELP"
+)
+
+// NICKSERV REGISTER <password> [<email>]
+
+type NickServRegisterCommand struct {
+	BaseCommand
+	password string
+	email    string
+	hash     string
+	err      error
+}
+
+func (cmd *NickServRegisterCommand) LoadPassword(server *Server) {
+}
+
+func (cmd *NickServRegisterCommand) CheckPassword() {
+	cmd.hash, cmd.err = GenerateEncodedPassword(cmd.password)
+}
+
+// NICKSERV IDENTIFY <account> <password>
+
+type NickServIdentifyCommand struct {
+	PassCommand
+	account  Name
+	provider AuthProvider
+}
+
+func (cmd *NickServIdentifyCommand) LoadPassword(server *Server) {
+	cmd.provider = server.authProviders[AuthIdentify]
+}
+
+func (cmd *NickServIdentifyCommand) CheckPassword() {
+	cmd.err = cmd.provider.Authenticate(cmd.account, cmd.password)
+}
+
+// NICKSERV GHOST <nick> [<password>]
+// NICKSERV REGAIN <nick> [<password>]
+//
+// The password may be left out by a client already logged in to the nick's
+// account.
+
+type NickServGhostCommand struct {
+	PassCommand
+	nick     Name
+	regain   bool
+	provider AuthProvider
+}
+
+func (cmd *NickServGhostCommand) LoadPassword(server *Server) {
+	if len(cmd.password) > 0 {
+		cmd.provider = server.authProviders[AuthIdentify]
+	}
+}
+
+func (cmd *NickServGhostCommand) CheckPassword() {
+	if cmd.provider != nil {
+		cmd.err = cmd.provider.Authenticate(cmd.nick, cmd.password)
+	}
+}
+
+func ParseNickServCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	switch NickServSubCommand(strings.ToUpper(args[0])) {
+	case NickServRegister:
+		if len(args) < 2 {
+			return nil, NotEnoughArgsError
+		}
+		cmd := &NickServRegisterCommand{
+			password: args[1],
+		}
+		if len(args) > 2 {
+			if !EmailExpr.MatchString(args[2]) {
+				return nil, ErrParseCommand
+			}
+			cmd.email = args[2]
+		}
+		return cmd, nil
+
+	case NickServIdentify:
+		if len(args) < 3 {
+			return nil, NotEnoughArgsError
+		}
+		return &NickServIdentifyCommand{
+			account:     NewName(args[1]),
+			PassCommand: PassCommand{password: []byte(args[2])},
+		}, nil
+
+	case NickServGhost, NickServRegain:
+		if len(args) < 2 {
+			return nil, NotEnoughArgsError
+		}
+		cmd := &NickServGhostCommand{
+			nick:   NewName(args[1]),
+			regain: NickServSubCommand(strings.ToUpper(args[0])) == NickServRegain,
+		}
+		if len(args) > 2 {
+			cmd.password = []byte(args[2])
+		}
+		return cmd, nil
+
+	case NickServSet:
+		return ParseNickServSetCommand(args[1:])
+
+	case NickServHelp:
+		return NewHelpCommand(append([]string{NICKSERV.String()}, args[1:]...)), nil
+
+	case NickServVerify:
+		if len(args) < 3 {
+			return nil, NotEnoughArgsError
+		}
+		return &NickServVerifyCommand{
+			account: NewName(args[1]),
+			code:    args[2],
+		}, nil
+
+	case NickServReset:
+		if len(args) < 2 {
+			return nil, NotEnoughArgsError
+		}
+		return &NickServResetPassCommand{
+			account: NewName(args[1]),
+		}, nil
+
+	case NickServSetPass:
+		if len(args) < 4 {
+			return nil, NotEnoughArgsError
+		}
+		return &NickServSetPassCommand{
+			account:  NewName(args[1]),
+			token:    args[2],
+			password: args[3],
+		}, nil
+	}
+	return nil, ErrParseCommand
+}
+
+//
+// server goroutine
+//
+
+// NickServ commands embedding PassCommand mustn't be handled as PASS
+// before registration.
+
+func (msg *NickServIdentifyCommand) HandleRegServer(server *Server) {
+	msg.Client().ErrNotRegistered()
+}
+
+func (msg *NickServGhostCommand) HandleRegServer(server *Server) {
+	msg.Client().ErrNotRegistered()
+}
+
+func (msg *NickServRegisterCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if client.account != "" {
+		client.Notice("you are already logged in")
+		return
+	}
+	if msg.err != nil {
+		client.Reply(RplNotice(server, client, NewText(msg.err.Error())))
+		return
+	}
+	if server.email.Required && (msg.email == "") {
+		client.Notice("an email address is required: NICKSERV REGISTER <password> <email>")
+		return
+	}
+	if server.emailEnabled() {
+		server.expirePendingAccounts()
+	}
+
+	_, err := server.db.Exec(`
+        INSERT INTO account (name, password, ctime) VALUES (?, ?, ?)`,
+		client.nick.String(), msg.hash, time.Now().Unix())
+	if err != nil {
+		client.Notice("that nickname is already registered")
+		return
+	}
+
+	server.accountSkeletons[Skeleton(client.nick)] = client.nick
+	if server.emailEnabled() && (msg.email != "") {
+		server.RequestVerification(client, client.nick, msg.email)
+		return
+	}
+	client.Login(client.nick)
+}
+
+func (msg *NickServIdentifyCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if msg.err != nil {
+		client.ErrPasswdMismatch()
+		return
+	}
+	if !server.checkAccountActive(client, msg.account) {
+		return
+	}
+	client.Login(msg.account)
+}
+
+func (msg *NickServGhostCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	owner := (client.account != "") &&
+		(client.account.ToLower() == msg.nick.ToLower())
+	if !owner && ((msg.provider == nil) || (msg.err != nil)) {
+		client.ErrPasswdMismatch()
+		return
+	}
+
+	target := server.clients.Get(msg.nick)
+	if (target != nil) && (target != client) {
+		target.Quit(NewText(fmt.Sprintf("GHOST command used by %s", client.nick)))
+	} else if !msg.regain {
+		client.ErrNoSuchNick(msg.nick)
+		return
+	}
+
+	if !msg.regain {
+		return
+	}
+	if client.nick != msg.nick {
+		client.ChangeNickname(msg.nick)
+	}
+	if !owner && server.checkAccountActive(client, msg.nick) {
+		client.Login(msg.nick)
+	}
+}
+
+// CheckNickOwner warns a client using a registered nick without being
+// logged in to its account, and renames it once nick-enforcement has
+// passed.
+func (server *Server) CheckNickOwner(client *Client) {
+	if client.nickTimer != nil {
+		client.nickTimer.Stop()
+	}
+	if (server.nickEnforcement <= 0) ||
+		(client.account.ToLower() == client.nick.ToLower()) ||
+		!server.isAccount(client.nick) {
+		return
+	}
+
+	client.Notice("%s is registered; identify with NICKSERV IDENTIFY within %s or your nick will be changed",
+		client.nick, server.nickEnforcement)
+	nick := client.nick
+	client.nickTimer = time.AfterFunc(server.nickEnforcement, func() {
+		client.nickEnforcement(nick)
+	})
+}
+
+func (msg *NickEnforcementCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if (client.nick != msg.nick) ||
+		(client.account.ToLower() == client.nick.ToLower()) {
+		return
+	}
+	client.ChangeNickname(server.guestNick())
+}
+
+func (server *Server) guestNick() Name {
+	for {
+		nick := NewName(fmt.Sprintf("%s%04d", GUEST_PREFIX, rand.Intn(10000)))
+		if server.clients.Get(nick) == nil {
+			return nick
+		}
+	}
+}
+
+func (server *Server) isAccount(name Name) bool {
+	var found int
+	err := server.db.QueryRow(`SELECT 1 FROM account WHERE name = ?`,
+		name.String()).Scan(&found)
+	return err == nil
+}
+
+// loadAccountSkeletons indexes registered accounts for IsConfusable.
+func (server *Server) loadAccountSkeletons() {
+	server.accountSkeletons = make(map[string]Name)
+	rows, err := server.db.Query(`SELECT name FROM account`)
+	if err != nil {
+		log.Fatal("error loading accounts: ", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			log.Println("Server.loadAccountSkeletons:", err)
+			continue
+		}
+		server.accountSkeletons[Skeleton(NewName(name))] = NewName(name)
+	}
+}
+
+// accountName is account as it was registered, since accounts are found
+// whatever their case; accounts not in the database are left as they are.
+func (server *Server) accountName(account Name) Name {
+	var name string
+	err := server.db.QueryRow(`SELECT name FROM account WHERE name = ?`,
+		account.String()).Scan(&name)
+	if err != nil {
+		return account
+	}
+	return NewName(name)
+}
+
+// Login associates the client with account, merging the account's saved
+// SILENCE list with anything set before logging in.
+func (client *Client) Login(account Name) {
+	account = client.server.accountName(account)
+	client.account = account
+	client.settings = client.server.accountSettings(account)
+	if realname := client.server.accountRealname(account); realname != "" {
+		client.ChangeRealname(realname)
+	}
+
+	client.silence.AddAll(client.server.accountSilence(account))
+	for mask := range client.silence.masks {
+		client.persistSilence(mask, Add)
+	}
+	client.loginMetadata()
+
+	client.RplLoggedIn()
+	client.server.NotifyServices(client)
+	if client.registered {
+		client.applyVHost()
+		client.applyCloakSetting()
+		client.server.CheckNickOwner(client)
+		client.server.DeliverMemos(client)
+	}
+}
+
+// accountSilence is account's saved SILENCE list.
+func (server *Server) accountSilence(account Name) []Name {
+	rows, err := server.db.Query(`
+        SELECT mask FROM account_silence WHERE account = ?`, account.String())
+	if err != nil {
+		log.Println("Server.accountSilence:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	masks := make([]Name, 0)
+	for rows.Next() {
+		var mask string
+		if err := rows.Scan(&mask); err != nil {
+			log.Println("Server.accountSilence:", err)
+			continue
+		}
+		masks = append(masks, NewName(mask))
+	}
+	return masks
+}
+
+func (client *Client) persistSilence(mask Name, op ModeOp) {
+	if client.account == "" {
+		return
+	}
+
+	var err error
+	switch op {
+	case Add:
+		_, err = client.server.db.Exec(`
+            INSERT INTO account_silence (account, mask) VALUES (?, ?)`,
+			client.account.String(), mask.String())
+	case Remove:
+		_, err = client.server.db.Exec(`
+            DELETE FROM account_silence WHERE account = ? AND mask = ?`,
+			client.account.String(), mask.String())
+	}
+	if err != nil {
+		log.Println("Client.persistSilence:", err)
+	}
+}
+
+//
+// SASL
+//
+
+const (
+	SASLPlain       = "PLAIN"
+	SASLOAuthBearer = "OAUTHBEARER"
+	SASLExternal    = "EXTERNAL"
+	SASLAbort       = "*"
+
+	SASL_CHUNK_LEN = 400  // AUTHENTICATE data is sent in chunks of this many bytes
+	SASL_MAX_LEN   = 8192 // base64 bytes of data allowed in all
+)
+
+// AUTHENTICATE <mechanism>
+// AUTHENTICATE <base64 data>
+// AUTHENTICATE *
+//
+// Data longer than a chunk is sent as full chunks, then the rest or, if
+// nothing is left, +.
+
+type AuthenticateCommand struct {
+	PassCommand
+	mechanism string
+	chunk     string // base64 data, or + for none
+	more      bool   // the chunk was full, so the data goes on
+	tooLong   bool
+	account   Name
+	plain     bool   // data held PLAIN credentials
+	bearer    string // or an OAUTHBEARER token
+	external  bool   // or an EXTERNAL authzid, which may be empty
+	provider  AuthProvider
+}
+
+// LoadPassword adds the chunk to the data the client has sent so far,
+// and decodes the data once it's all there.
+func (cmd *AuthenticateCommand) LoadPassword(server *Server) {
+	client := cmd.Client()
+	if cmd.mechanism != "" {
+		client.saslData, client.saslTooLong = "", false
+		return
+	}
+	if client.saslTooLong {
+		// the rest of data already refused, which needs no reply
+		client.saslTooLong = cmd.more
+		cmd.more = true
+		return
+	}
+	if cmd.chunk != "+" {
+		client.saslData += cmd.chunk
+	}
+	if len(client.saslData) > SASL_MAX_LEN {
+		client.saslData, client.saslTooLong = "", cmd.more
+		cmd.tooLong, cmd.more = true, false
+		return
+	}
+	if cmd.more {
+		return
+	}
+	cmd.decode(client.saslData)
+	client.saslData = ""
+
+	if cmd.plain {
+		cmd.provider = server.authProviders[AuthPlain]
+	}
+	if cmd.bearer != "" {
+		cmd.verifier = server.tokenVerifier
+	}
+}
+
+func (cmd *AuthenticateCommand) CheckPassword() {
+	switch {
+	case cmd.plain && (cmd.err == nil):
+		cmd.err = cmd.provider.Authenticate(cmd.account, cmd.password)
+	case cmd.verifier != nil:
+		cmd.account, cmd.err = cmd.verifier.Verify(cmd.bearer)
+	case cmd.bearer != "":
+		cmd.err = ErrAuthFailed
+	}
+}
+
+func ParseAuthenticateCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &AuthenticateCommand{}
+	switch strings.ToUpper(args[0]) {
+	case SASLAbort, SASLPlain, SASLOAuthBearer, SASLExternal:
+		cmd.mechanism = strings.ToUpper(args[0])
+		return cmd, nil
+	case "+":
+		cmd.chunk = "+"
+		return cmd, nil
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(args[0]); err != nil {
+		cmd.mechanism = args[0]
+		return cmd, nil
+	}
+	cmd.chunk = args[0]
+	cmd.more = len(cmd.chunk) == SASL_CHUNK_LEN
+	return cmd, nil
+}
+
+// decode reads the credentials in all the data sent for the mechanism.
+func (cmd *AuthenticateCommand) decode(encoded string) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return
+	}
+
+	// OAUTHBEARER: gs2 header ^A auth=Bearer <token> ^A ^A
+	if strings.HasPrefix(string(data), "n,") {
+		for _, kv := range strings.Split(string(data), "\x01") {
+			if strings.HasPrefix(kv, "auth=Bearer ") {
+				cmd.bearer = strings.TrimPrefix(kv, "auth=Bearer ")
+			}
+		}
+		return
+	}
+
+	// PLAIN: authzid NUL authcid NUL password
+	parts := strings.Split(string(data), "\x00")
+	switch len(parts) {
+	case 3:
+		cmd.plain = true
+		cmd.account = NewName(parts[1])
+		cmd.password = []byte(parts[2])
+		// clients may only authorize as the account they log in to
+		if (parts[0] != "") && (NewName(parts[0]).ToLower() != cmd.account.ToLower()) {
+			cmd.err = ErrAuthFailed
+		}
+	case 1:
+		// EXTERNAL: authzid
+		cmd.external = true
+		cmd.account = NewName(parts[0])
+	}
+}
+
+func (msg *AuthenticateCommand) HandleRegServer(server *Server) {
+	client := msg.Client()
+
+	switch {
+	case msg.mechanism == SASLAbort:
+		client.saslMechanism = ""
+		client.ErrSaslAborted()
+
+	case msg.mechanism == SASLPlain:
+		client.saslMechanism = SASLPlain
+		client.Reply(RplAuthenticate("+"))
+
+	case (msg.mechanism == SASLOAuthBearer) && server.allowsBearer(client):
+		client.saslMechanism = SASLOAuthBearer
+		client.Reply(RplAuthenticate("+"))
+
+	case (msg.mechanism == SASLExternal) && (client.CertFP() != ""):
+		client.saslMechanism = SASLExternal
+		client.Reply(RplAuthenticate("+"))
+
+	case msg.mechanism != "":
+		client.RplSaslMechs(server.SASLMechanisms(client))
+		client.ErrSaslFail()
+
+	case msg.more:
+		// wait for the rest
+
+	case msg.tooLong:
+		client.saslMechanism = ""
+		client.ErrSaslTooLong()
+
+	default:
+		mechanism := client.saslMechanism
+		client.saslMechanism = ""
+		ok := ((mechanism == SASLPlain) && msg.plain) ||
+			((mechanism == SASLOAuthBearer) && (msg.bearer != "")) ||
+			((mechanism == SASLExternal) && msg.external)
+		if ok && (mechanism == SASLExternal) {
+			account := server.certFPAccount(client.CertFP())
+			if (account == "") ||
+				((msg.account != "") && (msg.account.ToLower() != account.ToLower())) {
+				ok = false
+			}
+			msg.account = account
+		}
+		if !ok || (msg.err != nil) || server.accountPending(msg.account) {
+			client.ErrSaslFail()
+			return
+		}
+		client.Login(msg.account)
+		client.RplSaslSuccess()
+	}
+}
+
+func (msg *AuthenticateCommand) HandleServer(server *Server) {
+	msg.Client().ErrSaslAlready()
+}
+
+// Bearer tokens are only for WebSocket clients.
+func (server *Server) allowsBearer(client *Client) bool {
+	return (server.tokenVerifier != nil) && client.listener.websocket
+}
+
+func (server *Server) SASLMechanisms(client *Client) string {
+	mechanisms := []string{SASLPlain}
+	if server.allowsBearer(client) {
+		mechanisms = append(mechanisms, SASLOAuthBearer)
+	}
+	if client.CertFP() != "" {
+		mechanisms = append(mechanisms, SASLExternal)
+	}
+	return strings.Join(mechanisms, ",")
+}
@@ -0,0 +1,263 @@
+package irc
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DEFAULT_ALWAYS_ON_BUFFER = 1024 // replies kept for a detached client
+)
+
+// AlwaysOnConfig keeps clients logged in to an account online after their
+// connection drops. Replies are buffered until the account connects again
+// with SASL, which attaches the new connection to the old client.
+// Multiclient lets connections attach to clients that are still online.
+type AlwaysOnConfig struct {
+	Enabled     bool
+	Multiclient bool
+	Buffer      int
+	// detached clients quit after this long; 0 to keep them forever
+	Expire time.Duration
+}
+
+func (conf *AlwaysOnConfig) validate() error {
+	if conf.Buffer == 0 {
+		conf.Buffer = DEFAULT_ALWAYS_ON_BUFFER
+	}
+	if conf.Buffer < 0 {
+		return fmt.Errorf("Always-on buffer must be positive: %d", conf.Buffer)
+	}
+	if conf.Expire < 0 {
+		return fmt.Errorf("Always-on expire must be positive: %s", conf.Expire)
+	}
+	return nil
+}
+
+// DetachTimeoutCommand is sent by a detached client's expiry timer.
+type DetachTimeoutCommand struct {
+	BaseCommand
+}
+
+func NewDetachTimeoutCommand() *DetachTimeoutCommand {
+	cmd := &DetachTimeoutCommand{}
+	cmd.code = QUIT
+	return cmd
+}
+
+func (msg *DetachTimeoutCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if client.detached {
+		client.Quit("Always-on session expired")
+	}
+}
+
+// detach timer goroutine
+
+func (client *Client) detachTimeout() {
+	client.send(NewDetachTimeoutCommand())
+}
+
+//
+// server goroutine
+//
+
+// IsAlwaysOn reports whether client stays online when its connection
+// drops. A client that sent QUIT has gone already.
+func (server *Server) IsAlwaysOn(client *Client) bool {
+	return server.alwaysOn.Enabled && client.registered && !client.hasQuit &&
+		(client.account != "")
+}
+
+// Detach closes client's connection but leaves it in its channels,
+// buffering replies until the account attaches again.
+func (server *Server) Detach(client *Client) {
+	if client.detached {
+		return
+	}
+	client.detached = true
+	client.socket.Close()
+	client.AutoAway(true)
+	if client.idleTimer != nil {
+		client.idleTimer.Stop()
+	}
+	if client.quitTimer != nil {
+		client.quitTimer.Stop()
+	}
+	if server.alwaysOn.Expire > 0 {
+		client.detachTimer = time.AfterFunc(server.alwaysOn.Expire,
+			client.detachTimeout)
+	}
+	server.sessions[client.account.ToLower()] = client
+	Log.debug.Printf("%s: detached", client)
+}
+
+// Session is the client a connection logged in to account attaches to
+// on registration: a detached always-on client, or with multiclient any
+// registered client logged in to it.
+func (server *Server) Session(account Name) *Client {
+	if account == "" {
+		return nil
+	}
+	if session := server.sessions[account.ToLower()]; session != nil {
+		return session
+	}
+	if !server.alwaysOn.Multiclient {
+		return nil
+	}
+	for _, client := range server.clients.byNick {
+		if client.registered && (client.account.ToLower() == account.ToLower()) {
+			return client
+		}
+	}
+	return nil
+}
+
+// Attach adds the connection of conn, which just registered logged in to
+// session's account, to session. conn itself disappears; everything it
+// sends from now on is handled as session. Attached connections share
+// the capabilities they all negotiated, while each keeps its own.
+func (server *Server) Attach(conn *Client, session *Client) {
+	server.clients.Remove(conn)
+	conn.class.clients -= 1
+	conn.regTimer.Stop()
+	if conn.idleTimer != nil {
+		conn.idleTimer.Stop()
+	}
+	if conn.nickTimer != nil {
+		conn.nickTimer.Stop()
+	}
+	conn.session = session
+
+	detached := session.detached
+	if detached {
+		delete(server.sessions, session.account.ToLower())
+		if session.detachTimer != nil {
+			session.detachTimer.Stop()
+		}
+		session.socket = conn.socket
+		session.listener = conn.listener
+		session.realHostname = conn.realHostname
+		session.capabilities = conn.capabilities
+		session.socketCaps = nil
+		session.capState = conn.capState
+		session.detached = false
+		session.ChangeHost(conn.username, conn.hostname)
+		session.AutoAway(false)
+	} else {
+		if session.socketCaps == nil {
+			session.socketCaps = session.capabilities
+		}
+		session.others = append(session.others, conn)
+		session.shareCapabilities()
+	}
+	session.Active()
+	session.Touch()
+	Log.debug.Printf("%s: attached from %s", session, conn.socket)
+
+	// only the new connection needs the burst
+	labeled := session.labeled
+	session.labeled = make([]string, 0)
+	session.RplWelcome()
+	session.RplYourHost()
+	session.RplCreated()
+	session.RplMyInfo()
+	session.RplISupport(server.ISupport())
+	server.RplNetwork(session)
+	server.LUsers(session)
+	server.MOTD(session)
+	for channel := range session.channels {
+		session.Reply(RplJoin(session, channel))
+		channel.GetTopic(session)
+		channel.Names(session)
+	}
+	if detached {
+		session.labeled = append(session.labeled, session.buffered...)
+		session.buffered = nil
+	}
+	for _, reply := range session.labeled {
+		conn.socket.Write(reply)
+	}
+	session.labeled = labeled
+}
+
+// Disconnect handles the loss of the connection the current command came
+// from. It reports whether client stays online: it has other connections
+// attached, or it is always-on and detaches.
+func (server *Server) Disconnect(client *Client) bool {
+	for i, other := range client.others {
+		if other.socket == client.origin {
+			client.others = append(client.others[:i], client.others[i+1:]...)
+			client.shareCapabilities()
+			return true
+		}
+	}
+	if client.origin != client.socket {
+		// a connection replaced by a later one
+		return true
+	}
+
+	if len(client.others) > 0 {
+		next := client.others[0]
+		client.others = client.others[1:]
+		client.socket = next.socket
+		client.socketCaps = next.capabilities
+		client.listener = next.listener
+		client.shareCapabilities()
+		return true
+	}
+	if server.IsAlwaysOn(client) {
+		server.Detach(client)
+		return true
+	}
+	return false
+}
+
+// negotiated is the capabilities of the connection the current command
+// came from, which CAP changes.
+func (client *Client) negotiated() CapabilitySet {
+	if client.socketCaps == nil {
+		return client.capabilities
+	}
+	for _, other := range client.others {
+		if other.socket == client.origin {
+			return other.capabilities
+		}
+	}
+	return client.socketCaps
+}
+
+// shareCapabilities gives a client with connections attached the
+// capabilities all of them negotiated, or its connection's own once it's
+// the only one left.
+func (client *Client) shareCapabilities() {
+	if client.socketCaps == nil {
+		return
+	}
+	if len(client.others) == 0 {
+		client.capabilities = client.socketCaps
+		client.socketCaps = nil
+		return
+	}
+	shared := make(CapabilitySet)
+	for capability := range client.socketCaps {
+		shared[capability] = true
+	}
+	for _, other := range client.others {
+		for capability := range shared {
+			if !other.capabilities[capability] {
+				delete(shared, capability)
+			}
+		}
+	}
+	client.capabilities = shared
+}
+
+// bufferReply keeps a reply for a detached client, dropping the oldest
+// once the buffer is full.
+func (client *Client) bufferReply(reply string) {
+	if len(client.buffered) >= client.server.alwaysOn.Buffer {
+		client.buffered = client.buffered[1:]
+	}
+	client.buffered = append(client.buffered, reply)
+}
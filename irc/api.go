@@ -0,0 +1,512 @@
+package irc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// The admin API serves server state and admin operations as JSON over
+// HTTP, for dashboards and automation. Requests use HTTP basic auth
+// against the api users in the config, like OPER does against operators.
+//
+//   GET    /api/v1/stats
+//   GET    /api/v1/clients
+//   GET    /api/v1/connects
+//   GET    /api/v1/channels
+//   GET    /api/v1/klines
+//   POST   /api/v1/klines     {"mask": ..., "reason": ..., "minutes": ...}
+//   DELETE /api/v1/klines?mask=<user@host>
+//   POST   /api/v1/kill       {"nick": ..., "reason": ...}
+//   POST   /api/v1/notice     {"message": ...}
+//   POST   /api/v1/rehash
+//   GET    /api/v1/events     a WebSocket of events; see eventstream.go
+//
+// Handlers run in the HTTP server's goroutines; anything touching server
+// state is sent to the server goroutine as an APIRequest.
+
+const (
+	API_PREFIX          = "/api/v1/"
+	RECENT_CONNECTS_MAX = 50 // registrations kept for the dashboard
+)
+
+var (
+	ErrAPINotFound = errors.New("not found")
+)
+
+// APIHandler does the work of an API call in the server goroutine.
+type APIHandler func(*Server, Name) (interface{}, error)
+
+// APIMethod reads an API call's request and returns its handler.
+type APIMethod func(*http.Request) (APIHandler, error)
+
+// APIRequest runs handle in the server goroutine and sends back what it
+// returns.
+type APIRequest struct {
+	user   Name
+	handle APIHandler
+	result chan APIResult
+}
+
+type APIResult struct {
+	value interface{}
+	err   error
+}
+
+type APIClient struct {
+	Nick     string   `json:"nick"`
+	Username string   `json:"username"`
+	Hostname string   `json:"hostname"`
+	IP       string   `json:"ip"`
+	Realname string   `json:"realname"`
+	Account  string   `json:"account,omitempty"`
+	Operator bool     `json:"operator"`
+	Channels []string `json:"channels"`
+	Signon   int64    `json:"signon"`
+	Idle     uint64   `json:"idle"`
+}
+
+type APIChannel struct {
+	Name    string   `json:"name"`
+	Topic   string   `json:"topic"`
+	Modes   string   `json:"modes"`
+	Members int      `json:"members"`
+	Bans    []string `json:"bans"`
+	Excepts []string `json:"excepts"`
+	Invites []string `json:"invites"`
+}
+
+type APIKLine struct {
+	Mask    string `json:"mask"`
+	Reason  string `json:"reason"`
+	Setter  string `json:"setter"`
+	Ctime   int64  `json:"ctime"`
+	Expires int64  `json:"expires,omitempty"`
+	Minutes uint   `json:"minutes,omitempty"` // for new K-lines
+}
+
+type APIStats struct {
+	Users     int   `json:"users"`
+	Invisible int   `json:"invisible"`
+	Operators int   `json:"operators"`
+	Channels  int   `json:"channels"`
+	KLines    int   `json:"klines"`
+	Uptime    int64 `json:"uptime"`
+}
+
+// APIConnect is a recent registration.
+type APIConnect struct {
+	Nick     string `json:"nick"`
+	Username string `json:"username"`
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+	Time     int64  `json:"time"`
+}
+
+type APIKill struct {
+	Nick   string `json:"nick"`
+	Reason string `json:"reason"`
+}
+
+type APINotice struct {
+	Message string `json:"message"`
+}
+
+// APIText is a plain text response instead of JSON.
+type APIText string
+
+//
+// HTTP goroutines
+//
+
+func (config *APIConfig) users() map[Name][]byte {
+	users := make(map[Name][]byte)
+	for name, conf := range config.Users {
+		users[NewName(name)] = conf.PasswordBytes()
+	}
+	return users
+}
+
+func (server *Server) apiListen(config *Config) {
+	mux := http.NewServeMux()
+	server.apiRoutes(mux, config.API.users())
+
+	go func() {
+		Log.info.Printf("%s api listening on %s", server, config.API.Listen)
+		err := http.ListenAndServe(config.API.Listen, mux)
+		if err != nil {
+			Log.error.Printf("%s api listenAndServe error: %s", server, err)
+		}
+	}()
+}
+
+// apiUser checks a request's basic auth, answering it if that fails.
+func apiUser(w http.ResponseWriter, r *http.Request,
+	users map[Name][]byte) (Name, bool) {
+	user, password, ok := r.BasicAuth()
+	hash := users[NewName(user)]
+	if !ok || (hash == nil) || (ComparePassword(hash, []byte(password)) != nil) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="ergonomadic"`)
+		apiError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return "", false
+	}
+	return NewName(user), true
+}
+
+func (server *Server) apiRoutes(mux *http.ServeMux, users map[Name][]byte) {
+	route := func(path string, methods map[string]APIMethod) {
+		mux.HandleFunc(API_PREFIX+path, func(w http.ResponseWriter, r *http.Request) {
+			user, ok := apiUser(w, r, users)
+			if !ok {
+				return
+			}
+
+			// browsers can't send this cross-origin without asking first,
+			// so a page elsewhere can't use a dashboard user's login
+			if (r.Method != "GET") &&
+				(r.Header.Get("Content-Type") != "application/json") {
+				apiError(w, http.StatusUnsupportedMediaType,
+					errors.New("Content-Type must be application/json"))
+				return
+			}
+
+			method := methods[r.Method]
+			if method == nil {
+				apiError(w, http.StatusMethodNotAllowed, errors.New(r.Method))
+				return
+			}
+			handle, err := method(r)
+			if err != nil {
+				apiError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			value, err := server.apiCall(user, handle)
+			if err == ErrAPINotFound {
+				apiError(w, http.StatusNotFound, err)
+				return
+			} else if err != nil {
+				apiError(w, http.StatusBadRequest, err)
+				return
+			}
+			if text, ok := value.(APIText); ok {
+				w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+				io.WriteString(w, string(text))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(value)
+		})
+	}
+
+	static := func(handle APIHandler) APIMethod {
+		return func(*http.Request) (APIHandler, error) {
+			return handle, nil
+		}
+	}
+
+	route("clients", map[string]APIMethod{
+		"GET": static((*Server).apiClients),
+	})
+	route("channels", map[string]APIMethod{
+		"GET": static((*Server).apiChannels),
+	})
+	route("klines", map[string]APIMethod{
+		"GET":    static((*Server).apiKLines),
+		"POST":   apiAddKLine,
+		"DELETE": apiRemoveKLine,
+	})
+	route("kill", map[string]APIMethod{
+		"POST": apiKill,
+	})
+	route("notice", map[string]APIMethod{
+		"POST": apiNotice,
+	})
+	route("stats", map[string]APIMethod{
+		"GET": static((*Server).apiStats),
+	})
+	route("metrics", map[string]APIMethod{
+		"GET": static((*Server).apiMetrics),
+	})
+	route("connects", map[string]APIMethod{
+		"GET": static((*Server).apiConnects),
+	})
+	route("rehash", map[string]APIMethod{
+		"POST": static((*Server).apiRehash),
+	})
+
+	mux.HandleFunc(API_PREFIX+"events", func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := apiUser(w, r, users); ok {
+			server.streamEvents(w, r, user)
+		}
+	})
+}
+
+// apiCall waits for the server goroutine to run handle.
+func (server *Server) apiCall(user Name, handle APIHandler) (interface{}, error) {
+	request := &APIRequest{
+		user:   user,
+		handle: handle,
+		result: make(chan APIResult, 1),
+	}
+	server.apiRequests <- request
+	result := <-request.result
+	return result.value, result.err
+}
+
+func apiError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func apiAddKLine(r *http.Request) (APIHandler, error) {
+	var kline APIKLine
+	if err := json.NewDecoder(r.Body).Decode(&kline); err != nil {
+		return nil, err
+	}
+	if kline.Mask == "" {
+		return nil, errors.New("mask missing")
+	}
+	if kline.Reason == "" {
+		kline.Reason = "No reason"
+	}
+	return func(server *Server, user Name) (interface{}, error) {
+		return server.apiAddKLine(user, kline)
+	}, nil
+}
+
+func apiRemoveKLine(r *http.Request) (APIHandler, error) {
+	mask := r.URL.Query().Get("mask")
+	if mask == "" {
+		return nil, errors.New("mask missing")
+	}
+	return func(server *Server, user Name) (interface{}, error) {
+		return server.apiRemoveKLine(user, KLineMask(mask))
+	}, nil
+}
+
+func apiKill(r *http.Request) (APIHandler, error) {
+	var kill APIKill
+	if err := json.NewDecoder(r.Body).Decode(&kill); err != nil {
+		return nil, err
+	}
+	return func(server *Server, user Name) (interface{}, error) {
+		return server.apiKill(user, kill)
+	}, nil
+}
+
+func apiNotice(r *http.Request) (APIHandler, error) {
+	var notice APINotice
+	if err := json.NewDecoder(r.Body).Decode(&notice); err != nil {
+		return nil, err
+	}
+	if notice.Message == "" {
+		return nil, errors.New("message missing")
+	}
+	return func(server *Server, user Name) (interface{}, error) {
+		return server.apiNotice(user, notice)
+	}, nil
+}
+
+//
+// server goroutine
+//
+
+func (request *APIRequest) run(server *Server) {
+	value, err := request.handle(server, request.user)
+	request.result <- APIResult{value, err}
+}
+
+// apiMask stands in for a user mask in the audit log.
+func apiMask(user Name) Name {
+	return NewName("api:" + user.String())
+}
+
+func (server *Server) apiClients(user Name) (interface{}, error) {
+	clients := make([]APIClient, 0, len(server.clients.byNick))
+	for _, client := range server.clients.byNick {
+		channels := make([]string, 0, len(client.channels))
+		for channel := range client.channels {
+			channels = append(channels, channel.name.String())
+		}
+		sort.Strings(channels)
+		clients = append(clients, APIClient{
+			Nick:     client.nick.String(),
+			Username: client.username.String(),
+			Hostname: client.hostname.String(),
+			IP:       client.IP(),
+			Realname: client.realname.String(),
+			Account:  client.account.String(),
+			Operator: client.flags[Operator],
+			Channels: channels,
+			Signon:   client.SignonTime(),
+			Idle:     client.IdleSeconds(),
+		})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Nick < clients[j].Nick
+	})
+	return clients, nil
+}
+
+func (server *Server) apiStats(user Name) (interface{}, error) {
+	stats := APIStats{
+		Channels: len(server.channels),
+		KLines:   len(server.klines),
+		Uptime:   int64(time.Since(server.ctime).Seconds()),
+	}
+	for _, client := range server.clients.byNick {
+		if !client.registered {
+			continue
+		}
+		stats.Users += 1
+		if client.flags[Invisible] {
+			stats.Invisible += 1
+		}
+		if client.flags[Operator] {
+			stats.Operators += 1
+		}
+	}
+	return stats, nil
+}
+
+// RecordConnect remembers a registration for the dashboard.
+func (server *Server) RecordConnect(client *Client) {
+	server.connects = append(server.connects, APIConnect{
+		Nick:     client.nick.String(),
+		Username: client.username.String(),
+		Hostname: client.hostname.String(),
+		IP:       client.IP(),
+		Time:     time.Now().Unix(),
+	})
+	if len(server.connects) > RECENT_CONNECTS_MAX {
+		server.connects = server.connects[1:]
+	}
+}
+
+func (server *Server) apiConnects(user Name) (interface{}, error) {
+	connects := make([]APIConnect, len(server.connects))
+	// newest first
+	for i, connect := range server.connects {
+		connects[len(connects)-1-i] = connect
+	}
+	return connects, nil
+}
+
+func apiMasks(set *UserMaskSet) []string {
+	masks := make([]string, 0, len(set.masks))
+	for mask := range set.masks {
+		masks = append(masks, mask.String())
+	}
+	sort.Strings(masks)
+	return masks
+}
+
+func (server *Server) apiChannels(user Name) (interface{}, error) {
+	channels := make([]APIChannel, 0, len(server.channels))
+	for _, channel := range server.channels {
+		channels = append(channels, APIChannel{
+			Name:    channel.name.String(),
+			Topic:   channel.topic.String(),
+			Modes:   channel.flags.String(),
+			Members: len(channel.members),
+			Bans:    apiMasks(channel.lists[BanMask]),
+			Excepts: apiMasks(channel.lists[ExceptMask]),
+			Invites: apiMasks(channel.lists[InviteMask]),
+		})
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Name < channels[j].Name
+	})
+	return channels, nil
+}
+
+func (server *Server) apiKLines(user Name) (interface{}, error) {
+	klines := make([]APIKLine, 0, len(server.klines))
+	for mask, kline := range server.klines {
+		if kline.Expired() {
+			server.RemoveKLine(mask)
+			continue
+		}
+		entry := APIKLine{
+			Mask:   kline.mask.String(),
+			Reason: kline.reason.String(),
+			Setter: kline.setter.String(),
+			Ctime:  kline.ctime.Unix(),
+		}
+		if !kline.expires.IsZero() {
+			entry.Expires = kline.expires.Unix()
+		}
+		klines = append(klines, entry)
+	}
+	sort.Slice(klines, func(i, j int) bool {
+		return klines[i].Mask < klines[j].Mask
+	})
+	return klines, nil
+}
+
+func (server *Server) apiAddKLine(user Name, entry APIKLine) (interface{}, error) {
+	mask, now := KLineMask(entry.Mask), time.Now()
+	var expires time.Time
+	if entry.Minutes > 0 {
+		expires = now.Add(time.Duration(entry.Minutes) * time.Minute)
+	}
+	server.audit(user, apiMask(user), KLINE, mask.String(), entry.Reason)
+	server.NoticeOperators(NewText(fmt.Sprintf("%s added K-line for %s: %s",
+		apiMask(user), mask, entry.Reason)))
+	server.AddKLine(NewKLine(mask, NewText(entry.Reason), apiMask(user), now,
+		expires))
+	return map[string]string{"mask": mask.String()}, nil
+}
+
+func (server *Server) apiRemoveKLine(user Name, mask Name) (interface{}, error) {
+	if !server.RemoveKLine(mask) {
+		return nil, ErrAPINotFound
+	}
+	server.audit(user, apiMask(user), UNKLINE, mask.String(), "")
+	server.NoticeOperators(NewText(fmt.Sprintf("%s removed K-line for %s",
+		apiMask(user), mask)))
+	return map[string]string{"mask": mask.String()}, nil
+}
+
+func (server *Server) apiKill(user Name, kill APIKill) (interface{}, error) {
+	target := server.clients.Get(NewName(kill.Nick))
+	if target == nil {
+		return nil, ErrAPINotFound
+	}
+	reason := NewText(kill.Reason)
+	server.audit(user, apiMask(user), KILL, target.nick.String(), kill.Reason)
+	server.NoticeOperators(NewText(fmt.Sprintf("%s used KILL on %s: %s",
+		apiMask(user), target.nick, reason)))
+
+	target.Reply(RplKill(server, target, reason))
+	target.Quit(NewText(fmt.Sprintf("Killed (%s (%s))", apiMask(user), reason)))
+	return map[string]string{"nick": target.nick.String()}, nil
+}
+
+func (server *Server) apiNotice(user Name, notice APINotice) (interface{}, error) {
+	server.audit(user, apiMask(user), NOTICE, "*", notice.Message)
+	message := NewText(notice.Message)
+	for _, client := range server.clients.byNick {
+		client.Reply(RplNotice(server, client, message))
+	}
+	return map[string]int{"clients": len(server.clients.byNick)}, nil
+}
+
+func (server *Server) apiRehash(user Name) (interface{}, error) {
+	err := server.rehash()
+	detail := ""
+	if err != nil {
+		detail = err.Error()
+	}
+	server.audit(user, apiMask(user), REHASH, server.configFile, detail)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"config": server.configFile}, nil
+}
@@ -0,0 +1,97 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// The audit log records privileged actions by operators. Entries are only
+// ever added; AUDIT lists the most recent ones.
+
+// Audit records an action taken by an operator on target.
+func (server *Server) Audit(client *Client, action StringCode, target string,
+	detail string) {
+	server.audit(client.operName, client.UserHost(), action, target, detail)
+}
+
+// audit records an action by oper, connected as mask.
+func (server *Server) audit(oper Name, mask Name, action StringCode,
+	target string, detail string) {
+	_, err := server.db.Exec(`
+        INSERT INTO audit (time, oper, mask, action, target, detail)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		time.Now().Unix(), oper.String(), mask.String(), action.String(),
+		target, detail)
+	if err != nil {
+		log.Println("Server.audit:", err)
+	}
+	Log.info.Printf("audit: %s (%s) %s %s %s", oper, mask, action, target,
+		detail)
+	server.Notify(EventOperAction, map[string]string{
+		"oper":   oper.String(),
+		"mask":   mask.String(),
+		"action": action.String(),
+		"target": target,
+		"detail": detail,
+	})
+}
+
+// AUDIT [<oper>]
+
+type AuditCommand struct {
+	BaseCommand
+	oper Name
+}
+
+func ParseAuditCommand(args []string) (Command, error) {
+	cmd := &AuditCommand{}
+	if len(args) > 0 {
+		cmd.oper = NewName(args[0])
+	}
+	return cmd, nil
+}
+
+func (msg *AuditCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.flags[Operator] {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	query := `SELECT time, oper, mask, action, target, detail FROM audit`
+	args := []interface{}{}
+	if msg.oper != "" {
+		query += ` WHERE oper = ?`
+		args = append(args, msg.oper.String())
+	}
+	query += ` ORDER BY rowid DESC LIMIT ?`
+	args = append(args, AUDIT_LIST_MAX)
+
+	rows, err := server.db.Query(query, args...)
+	if err != nil {
+		log.Println("AuditCommand.HandleServer:", err)
+		return
+	}
+	defer rows.Close()
+
+	lines := make([]string, 0)
+	for rows.Next() {
+		var when int64
+		var oper, mask, action, target, detail string
+		err := rows.Scan(&when, &oper, &mask, &action, &target, &detail)
+		if err != nil {
+			log.Println("AuditCommand.HandleServer:", err)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s (%s) %s %s %s",
+			time.Unix(when, 0).UTC().Format(time.RFC3339), oper, mask, action,
+			target, detail))
+	}
+
+	// oldest first
+	for i := len(lines) - 1; i >= 0; i -= 1 {
+		client.Reply(RplNotice(server, client, NewText(lines[i])))
+	}
+	client.Notice("End of AUDIT")
+}
@@ -0,0 +1,306 @@
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Authentication providers check account passwords for NICKSERV IDENTIFY
+// and SASL PLAIN. They run in the client goroutine, since they may block
+// on bcrypt or the network.
+
+var (
+	ErrAuthFailed      = errors.New("authentication failed")
+	ErrAuthUnavailable = errors.New("authentication provider unavailable")
+)
+
+type AuthProvider interface {
+	Authenticate(account Name, password []byte) error
+}
+
+// mechanisms that may be given their own provider
+const (
+	AuthIdentify = "identify"
+	AuthPlain    = "plain"
+)
+
+const (
+	AuthDB      = "db"
+	AuthLDAP    = "ldap"
+	AuthCommand = "command"
+	AuthWebhook = "webhook"
+
+	DEFAULT_AUTH_TIMEOUT = 10 * time.Second
+)
+
+// NewAuthProviders builds the provider for each mechanism. Mechanisms
+// without one use the account table.
+func NewAuthProviders(config *Config, db *sql.DB) map[string]AuthProvider {
+	providers := map[string]AuthProvider{
+		AuthDB: &DBAuthProvider{db},
+	}
+	for name, conf := range config.Auth.Providers {
+		switch conf.Type {
+		case AuthLDAP:
+			providers[name] = &LDAPAuthProvider{conf}
+		case AuthCommand:
+			providers[name] = &CommandAuthProvider{conf}
+		case AuthWebhook:
+			providers[name] = &WebhookAuthProvider{conf,
+				&http.Client{Timeout: conf.Timeout}}
+		case AuthDB:
+			providers[name] = providers[AuthDB]
+		}
+	}
+
+	mechanisms := make(map[string]AuthProvider)
+	for _, mechanism := range []string{AuthIdentify, AuthPlain} {
+		name := config.Auth.Mechanisms[mechanism]
+		if name == "" {
+			name = AuthDB
+		}
+		mechanisms[mechanism] = providers[name]
+	}
+	return mechanisms
+}
+
+//
+// account table
+//
+
+type DBAuthProvider struct {
+	db *sql.DB
+}
+
+func (provider *DBAuthProvider) Authenticate(account Name, password []byte) error {
+	var encoded string
+	err := provider.db.QueryRow(`SELECT password FROM account WHERE name = ?`,
+		account.String()).Scan(&encoded)
+	if err != nil {
+		return ErrAuthFailed
+	}
+	hash, err := DecodePassword(encoded)
+	if err != nil {
+		return err
+	}
+	return ComparePassword(hash, password)
+}
+
+//
+// LDAP simple bind
+//
+
+type LDAPAuthProvider struct {
+	conf *AuthProviderConfig
+}
+
+// Authenticate binds as bind-dn with the account filled in. Only
+// ldap:// and ldaps:// URLs are supported; there's no StartTLS.
+func (provider *LDAPAuthProvider) Authenticate(account Name, password []byte) error {
+	if len(password) == 0 {
+		// an empty password is an unauthenticated bind, which succeeds
+		return ErrAuthFailed
+	}
+	u, err := url.Parse(provider.conf.URL)
+	if err != nil {
+		return err
+	}
+
+	dialer := &net.Dialer{Timeout: provider.conf.Timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldaps":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host,
+			&tls.Config{ServerName: u.Hostname()})
+	case "ldap":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	default:
+		return fmt.Errorf("unsupported LDAP URL: %s", provider.conf.URL)
+	}
+	if err != nil {
+		Log.error.Printf("ldap: %s", err)
+		return ErrAuthUnavailable
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(provider.conf.Timeout))
+
+	dn := fmt.Sprintf(provider.conf.BindDN, ldapEscape(account.String()))
+	bind := berTLV(0x30, bytes.Join([][]byte{
+		berTLV(0x02, []byte{1}), // message ID
+		berTLV(0x60, bytes.Join([][]byte{ // BindRequest
+			berTLV(0x02, []byte{3}), // version
+			berTLV(0x04, []byte(dn)),
+			berTLV(0x80, password), // simple
+		}, nil)),
+	}, nil))
+	if _, err := conn.Write(bind); err != nil {
+		Log.error.Printf("ldap: %s", err)
+		return ErrAuthUnavailable
+	}
+
+	reader := bufio.NewReader(conn)
+	tag, message, err := readBER(reader)
+	if (err != nil) || (tag != 0x30) {
+		return ErrAuthUnavailable
+	}
+	reader = bufio.NewReader(bytes.NewReader(message))
+	if _, _, err := readBER(reader); err != nil { // message ID
+		return ErrAuthUnavailable
+	}
+	tag, response, err := readBER(reader)
+	if (err != nil) || (tag != 0x61) { // BindResponse
+		return ErrAuthUnavailable
+	}
+	tag, result, err := readBER(bufio.NewReader(bytes.NewReader(response)))
+	if (err != nil) || (tag != 0x0a) || (len(result) != 1) {
+		return ErrAuthUnavailable
+	}
+	if result[0] != 0 {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+func berTLV(tag byte, content []byte) []byte {
+	length := len(content)
+	header := []byte{tag}
+	if length < 0x80 {
+		header = append(header, byte(length))
+	} else {
+		var lengthBytes []byte
+		for ; length > 0; length >>= 8 {
+			lengthBytes = append([]byte{byte(length)}, lengthBytes...)
+		}
+		header = append(header, 0x80|byte(len(lengthBytes)))
+		header = append(header, lengthBytes...)
+	}
+	return append(header, content...)
+}
+
+func readBER(reader *bufio.Reader) (tag byte, content []byte, err error) {
+	if tag, err = reader.ReadByte(); err != nil {
+		return
+	}
+	first, err := reader.ReadByte()
+	if err != nil {
+		return
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		count := int(first & 0x7f)
+		if count > 4 {
+			err = ErrAuthUnavailable
+			return
+		}
+		length = 0
+		for i := 0; i < count; i += 1 {
+			var b byte
+			if b, err = reader.ReadByte(); err != nil {
+				return
+			}
+			length = (length << 8) | int(b)
+		}
+	}
+	content = make([]byte, length)
+	_, err = io.ReadFull(reader, content)
+	return
+}
+
+// ldapEscape escapes an attribute value for a DN (RFC 4514).
+func ldapEscape(value string) string {
+	var escaped bytes.Buffer
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			(i == 0) && ((r == '#') || (r == ' ')),
+			(i == len(value)-1) && (r == ' '):
+			escaped.WriteRune('\\')
+			escaped.WriteRune(r)
+		case r < ' ':
+			fmt.Fprintf(&escaped, "\\%02x", r)
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
+
+//
+// external command
+//
+
+type CommandAuthProvider struct {
+	conf *AuthProviderConfig
+}
+
+// Authenticate runs the command with the account and password on separate
+// lines of its standard input. It succeeds if the command exits with 0.
+func (provider *CommandAuthProvider) Authenticate(account Name, password []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), provider.conf.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, provider.conf.Command[0],
+		provider.conf.Command[1:]...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s\n%s\n", account, password))
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok && (ctx.Err() == nil) {
+			return ErrAuthFailed
+		}
+		Log.error.Printf("auth command: %s", err)
+		return ErrAuthUnavailable
+	}
+	return nil
+}
+
+//
+// webhook
+//
+
+type WebhookAuthProvider struct {
+	conf   *AuthProviderConfig
+	client *http.Client
+}
+
+// Authenticate POSTs {"account": ..., "password": ...} to the URL. It
+// succeeds on any 2xx response.
+func (provider *WebhookAuthProvider) Authenticate(account Name, password []byte) error {
+	body, err := json.Marshal(map[string]string{
+		"account":  account.String(),
+		"password": string(password),
+	})
+	if err != nil {
+		return err
+	}
+	response, err := provider.client.Post(provider.conf.URL, "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		Log.error.Printf("auth webhook: %s", err)
+		return ErrAuthUnavailable
+	}
+	response.Body.Close()
+	if (response.StatusCode < 200) || (response.StatusCode > 299) {
+		return ErrAuthFailed
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	BATCH_TAG = "batch"
+	LABEL_TAG = "label"
+
+	ChathistoryBatch     = "chathistory"
+	LabeledResponseBatch = "labeled-response"
+)
+
+// ReplyBatch is an open batch of replies to a client. Replies sent while
+// it's open carry its tag, and a batch started inside it is nested: the
+// inner batch's BATCH lines carry the outer batch's tag.
+type ReplyBatch struct {
+	id     string
+	parent *ReplyBatch
+}
+
+func (server *Server) NewBatchID() string {
+	server.batchID += 1
+	return strconv.FormatUint(server.batchID, 36)
+}
+
+func RplBatchStart(source Identifiable, id string, kind string,
+	params ...string) string {
+	reply := fmt.Sprintf(":%s %s +%s %s", source, BATCH, id, kind)
+	for _, param := range params {
+		reply += " " + param
+	}
+	return reply
+}
+
+func RplBatchEnd(source Identifiable, id string) string {
+	return fmt.Sprintf(":%s %s -%s", source, BATCH, id)
+}
+
+func RplAck(source Identifiable) string {
+	return fmt.Sprintf(":%s %s", source, ACK)
+}
+
+// inBatch is true for a reply already tagged with a batch.
+func inBatch(reply string) bool {
+	tags, _ := ParseTags(reply)
+	_, ok := tags[BATCH_TAG]
+	return ok
+}
+
+// StartBatch opens a batch of the client's replies. A client without the
+// batch capability gets the replies unbatched, and nil, which EndBatch
+// ignores.
+func (client *Client) StartBatch(kind string, params ...string) *ReplyBatch {
+	return client.startBatch(nil, kind, params)
+}
+
+func (client *Client) startBatch(tags Tags, kind string,
+	params []string) *ReplyBatch {
+	if !client.capabilities[Batch] {
+		return nil
+	}
+	batch := &ReplyBatch{
+		id:     client.server.NewBatchID(),
+		parent: client.batch,
+	}
+	client.Reply(AddTags(RplBatchStart(client.server, batch.id, kind,
+		params...), tags))
+	client.batch = batch
+	return batch
+}
+
+func (client *Client) EndBatch(batch *ReplyBatch) {
+	if batch == nil {
+		return
+	}
+	client.batch = batch.parent
+	client.Reply(RplBatchEnd(client.server, batch.id))
+}
+
+//
+// labeled responses
+//
+
+// Label starts collecting the client's replies so they can be sent with
+// the label of the command being handled.
+func (client *Client) Label(label string) {
+	client.label = label
+	client.labeled = make([]string, 0)
+}
+
+// FlushLabel sends the replies collected since Label: an ACK if there
+// were none, the reply itself if there was one, or else a
+// labeled-response batch around them.
+func (client *Client) FlushLabel() {
+	if client.labeled == nil {
+		return
+	}
+	replies, tags := client.labeled, Tags{LABEL_TAG: client.label}
+	client.labeled = nil
+	client.label = ""
+
+	switch len(replies) {
+	case 0:
+		client.Reply(AddTags(RplAck(client.server), tags))
+
+	case 1:
+		client.Reply(AddTags(replies[0], tags))
+
+	default:
+		batch := client.startBatch(tags, LabeledResponseBatch, nil)
+		for _, reply := range replies {
+			client.Reply(reply)
+		}
+		client.EndBatch(batch)
+	}
+}
@@ -0,0 +1,366 @@
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A bridge is a connection, authorized by a password from the config,
+// that relays another network such as Matrix or XMPP. Once logged in it
+// spawns puppets: clients with no connection of their own, one for each
+// remote user. A command from the bridge tagged draft/puppet=<nick> is
+// run as that puppet, and replies to a puppet are sent to the bridge with
+// the same tag. Puppets quit when the bridge does.
+
+const (
+	PUPPET_TAG = "draft/puppet"
+
+	DEFAULT_MAX_PUPPETS = 1000
+)
+
+type BridgeConfig struct {
+	PassConfig `yaml:",inline"`
+	// puppets' hostname; <name>.bridge by default
+	Hostname string
+	// puppets one bridge connection may spawn
+	MaxPuppets int `yaml:"max-puppets"`
+}
+
+func (conf *BridgeConfig) validate(name string) error {
+	if conf.Password == "" {
+		return fmt.Errorf("Bridge %s has no password", name)
+	}
+	if _, err := DecodePassword(conf.Password); err != nil {
+		return fmt.Errorf("Bridge %s password: %s", name, err)
+	}
+	if conf.Hostname == "" {
+		conf.Hostname = name + ".bridge"
+	}
+	if conf.MaxPuppets == 0 {
+		conf.MaxPuppets = DEFAULT_MAX_PUPPETS
+	}
+	if conf.MaxPuppets < 0 {
+		return fmt.Errorf("Bridge %s max-puppets must be positive: %d", name,
+			conf.MaxPuppets)
+	}
+	return nil
+}
+
+func (conf *Config) BridgeConfigs() map[Name]*BridgeConfig {
+	bridges := make(map[Name]*BridgeConfig)
+	for name, bridgeConf := range conf.Bridges {
+		bridges[NewName(name).ToLower()] = bridgeConf
+	}
+	return bridges
+}
+
+type Bridge struct {
+	name    Name
+	config  *BridgeConfig
+	client  *Client // the bridge's connection
+	puppets ClientSet
+}
+
+// IsPuppet reports whether client was spawned by a bridge.
+func (client *Client) IsPuppet() bool {
+	return (client.bridge != nil) && (client.bridge.client != client)
+}
+
+// IsBridge reports whether client is a bridge's connection.
+func (client *Client) IsBridge() bool {
+	return (client.bridge != nil) && (client.bridge.client == client)
+}
+
+// Puppet is the bridge's puppet with nick, if any.
+func (bridge *Bridge) Puppet(nick Name) *Client {
+	puppet := bridge.client.server.clients.Get(nick)
+	if (puppet == nil) || !bridge.puppets.Has(puppet) {
+		return nil
+	}
+	return puppet
+}
+
+func (bridge *Bridge) Nicks() []string {
+	nicks := make([]string, 0, len(bridge.puppets))
+	for puppet := range bridge.puppets {
+		nicks = append(nicks, puppet.Nick().String())
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+type BridgeSubCommand string
+
+const (
+	BridgeLogin   BridgeSubCommand = "LOGIN"
+	BridgeSpawn   BridgeSubCommand = "SPAWN"
+	BridgeDespawn BridgeSubCommand = "DESPAWN"
+	BridgeList    BridgeSubCommand = "LIST"
+)
+
+// BRIDGE LOGIN <name> <password>
+
+type BridgeLoginCommand struct {
+	PassCommand
+	name Name
+}
+
+func (msg *BridgeLoginCommand) LoadPassword(server *Server) {
+	if conf := server.bridges[msg.name.ToLower()]; conf != nil {
+		msg.hash = conf.PasswordBytes()
+	}
+}
+
+// BRIDGE SPAWN <nick> <username> :<realname>
+
+type BridgeSpawnCommand struct {
+	BaseCommand
+	nickname Name
+	username Name
+	realname Text
+}
+
+// BRIDGE DESPAWN <nick> [:<message>]
+
+type BridgeDespawnCommand struct {
+	BaseCommand
+	nickname Name
+	message  Text
+}
+
+// BRIDGE LIST
+
+type BridgeListCommand struct {
+	BaseCommand
+}
+
+func ParseBridgeCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	switch BridgeSubCommand(strings.ToUpper(args[0])) {
+	case BridgeLogin:
+		if len(args) < 3 {
+			return nil, NotEnoughArgsError
+		}
+		return &BridgeLoginCommand{
+			name:        NewName(args[1]),
+			PassCommand: PassCommand{password: []byte(args[2])},
+		}, nil
+
+	case BridgeSpawn:
+		if len(args) < 4 {
+			return nil, NotEnoughArgsError
+		}
+		return &BridgeSpawnCommand{
+			nickname: NewName(args[1]),
+			username: NewName(args[2]),
+			realname: NewText(args[3]),
+		}, nil
+
+	case BridgeDespawn:
+		if len(args) < 2 {
+			return nil, NotEnoughArgsError
+		}
+		cmd := &BridgeDespawnCommand{
+			nickname: NewName(args[1]),
+			message:  "Bridged user left",
+		}
+		if len(args) > 2 {
+			cmd.message = NewText(args[2])
+		}
+		return cmd, nil
+
+	case BridgeList:
+		return &BridgeListCommand{}, nil
+	}
+	return nil, ErrParseCommand
+}
+
+//
+// server goroutine
+//
+
+// puppetCommand finds the puppet a command from a bridge is tagged for.
+// It reports false if the command should be dropped.
+func (server *Server) puppetCommand(client *Client, cmd Command) (*Client, bool) {
+	nick, ok := cmd.Tags()[PUPPET_TAG]
+	if !ok || !client.IsBridge() {
+		return client, true
+	}
+	puppet := client.bridge.Puppet(NewName(nick))
+	if puppet == nil {
+		client.ErrNoSuchNick(NewName(nick))
+		return nil, false
+	}
+	cmd.SetClient(puppet)
+	return puppet, true
+}
+
+// PuppetReply sends a reply to a puppet through its bridge.
+func (client *Client) PuppetReply(reply string) error {
+	return client.bridge.client.Reply(AddTags(reply,
+		Tags{PUPPET_TAG: client.nick.String()}))
+}
+
+// NewPuppet adds a client for bridge without a connection of its own.
+func (server *Server) NewPuppet(bridge *Bridge, nick Name, username Name,
+	realname Text) *Client {
+	now := time.Now()
+	owner := bridge.client
+	capabilities := make(CapabilitySet)
+	for capability := range owner.capabilities {
+		capabilities[capability] = true
+	}
+	client := &Client{
+		atime:        now,
+		accepted:     make(ClientSet),
+		authorized:   true,
+		bridge:       bridge,
+		capabilities: capabilities,
+		channels:     make(ChannelSet),
+		class:        &ConnectionClass{name: "bridge"},
+		ctime:        now,
+		flags:        make(map[UserMode]bool),
+		hostname:     server.names.Intern(NewName(bridge.config.Hostname)),
+		invitedTo:    make(ChannelSet),
+		listener:     owner.listener,
+		nick:         nick,
+		realname:     realname,
+		registered:   true,
+		server:       server,
+		settings:     make(AccountSettings),
+		silence:      NewUserMaskSet(),
+		socket:       owner.socket,
+		typing:       make(map[Name]time.Time),
+		username:     server.names.Intern(username),
+	}
+	client.updateUserHost()
+	bridge.puppets.Add(client)
+	server.clients.Add(client)
+	server.Notify(EventUserRegistered, map[string]string{
+		"nick":     client.nick.String(),
+		"username": client.username.String(),
+		"hostname": client.hostname.String(),
+		"ip":       client.IP(),
+		"account":  "",
+	})
+	return client
+}
+
+func (msg *BridgeLoginCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if client.IsPuppet() {
+		client.ErrUnknownCommand(msg.Code())
+		return
+	}
+	if (msg.hash == nil) || (msg.err != nil) {
+		client.ErrPasswdMismatch()
+		return
+	}
+	if client.IsBridge() {
+		client.Reply(RplFail(server, BRIDGE, "ALREADY_LOGGED_IN",
+			"You are already a bridge"))
+		return
+	}
+	if !client.capabilities[MessageTags] {
+		client.Reply(RplFail(server, BRIDGE, "NEED_MESSAGE_TAGS",
+			"Bridges must enable message-tags"))
+		return
+	}
+	client.bridge = &Bridge{
+		name:    msg.name,
+		config:  server.bridges[msg.name.ToLower()],
+		client:  client,
+		puppets: make(ClientSet),
+	}
+	Log.info.Printf("%s: logged in as bridge %s", client, msg.name)
+	client.Notice("you are now bridging %s", msg.name)
+}
+
+// bridge finds the bridge a BRIDGE command is from, telling client if it
+// isn't one.
+func (client *Client) bridgeFor(code StringCode) *Bridge {
+	if !client.IsBridge() {
+		client.Reply(RplFail(client.server, code, "NOT_A_BRIDGE",
+			"Log in with BRIDGE LOGIN first"))
+		return nil
+	}
+	return client.bridge
+}
+
+func (msg *BridgeSpawnCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	bridge := client.bridgeFor(BRIDGE)
+	if bridge == nil {
+		return
+	}
+
+	if len(bridge.puppets) >= bridge.config.MaxPuppets {
+		client.Reply(RplFail(server, BRIDGE, "TOO_MANY_PUPPETS",
+			"Too many puppets"))
+		return
+	}
+	if !server.IsNickname(msg.nickname) {
+		client.ErrErroneusNickname(msg.nickname)
+		return
+	}
+	if (server.clients.Get(msg.nickname) != nil) ||
+		server.IsConfusable(client, msg.nickname) {
+		client.ErrNickNameInUse(msg.nickname)
+		return
+	}
+
+	puppet := server.NewPuppet(bridge, msg.nickname, msg.username,
+		msg.realname)
+	if kline := server.KLineFor(puppet); kline != nil {
+		puppet.Quit(NewText(fmt.Sprintf("K-lined: %s", kline.reason)))
+		return
+	}
+	server.CheckNickOwner(puppet)
+	client.Notice("spawned %s", puppet.UserHost())
+}
+
+func (msg *BridgeDespawnCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	bridge := client.bridgeFor(BRIDGE)
+	if bridge == nil {
+		return
+	}
+
+	puppet := bridge.Puppet(msg.nickname)
+	if puppet == nil {
+		client.ErrNoSuchNick(msg.nickname)
+		return
+	}
+	puppet.Quit(msg.message)
+}
+
+func (msg *BridgeListCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	bridge := client.bridgeFor(BRIDGE)
+	if bridge == nil {
+		return
+	}
+
+	if len(bridge.puppets) == 0 {
+		client.Notice("%s has no puppets", bridge.name)
+		return
+	}
+	client.Notice("%s puppets: %s", bridge.name,
+		strings.Join(bridge.Nicks(), ", "))
+}
+
+// destroyBridge quits a bridge's puppets when it goes, or removes a
+// puppet from its bridge.
+func (client *Client) destroyBridge() {
+	if client.IsPuppet() {
+		client.bridge.puppets.Remove(client)
+		return
+	}
+	for puppet := range client.bridge.puppets {
+		puppet.Quit("Bridge disconnected")
+	}
+}
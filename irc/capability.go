@@ -1,11 +1,15 @@
 package irc
 
 import (
+	"fmt"
 	"strings"
 )
 
 type CapSubCommand string
 
+// CAP_302 is the CAP LS version asking for capability values.
+const CAP_302 Capability = "302"
+
 const (
 	CAP_LS    CapSubCommand = "LS"
 	CAP_LIST  CapSubCommand = "LIST"
@@ -20,14 +24,42 @@ const (
 type Capability string
 
 const (
-	MultiPrefix Capability = "multi-prefix"
-	SASL        Capability = "sasl"
+	AwayNotify      Capability = "away-notify"
+	Batch           Capability = "batch"
+	ChgHost         Capability = "chghost"
+	EchoMessage     Capability = "echo-message"
+	InviteNotify    Capability = "invite-notify"
+	Languages       Capability = "draft/languages"
+	LabeledResponse Capability = "labeled-response"
+	MessageTags     Capability = "message-tags"
+	Metadata        Capability = "draft/metadata-2"
+	MultiPrefix     Capability = "multi-prefix"
+	Multiline       Capability = "draft/multiline"
+	SASL            Capability = "sasl"
+	ServerTime      Capability = "server-time"
+	SetName         Capability = "setname"
 )
 
 var (
 	SupportedCapabilities = CapabilitySet{
-		MultiPrefix: true,
+		AwayNotify:      true,
+		Batch:           true,
+		ChgHost:         true,
+		EchoMessage:     true,
+		InviteNotify:    true,
+		Languages:       true,
+		LabeledResponse: true,
+		MessageTags:     true,
+		Metadata:        true,
+		MultiPrefix:     true,
+		Multiline:       true,
+		SASL:            true,
+		ServerTime:      true,
+		SetName:         true,
 	}
+
+	// RenderCapabilities change the wire format of broadcast messages.
+	RenderCapabilities = []Capability{MessageTags}
 )
 
 func (capability Capability) String() string {
@@ -68,6 +100,41 @@ func (set CapabilitySet) String() string {
 	return strings.Join(strs, " ")
 }
 
+// RenderKey identifies the RenderCapabilities in the set. Clients with
+// equal keys receive identical broadcast messages.
+func (set CapabilitySet) RenderKey() (key string) {
+	for _, capability := range RenderCapabilities {
+		if set[capability] {
+			key += capability.String() + " "
+		}
+	}
+	return
+}
+
+// CapLS lists the supported capabilities, with their values for clients
+// that sent CAP LS 302.
+func (server *Server) CapLS(values bool) string {
+	strs := make([]string, 0, len(SupportedCapabilities))
+	for capability := range SupportedCapabilities {
+		str := capability.String()
+		if values && (capability == Multiline) {
+			str += fmt.Sprintf("=max-bytes=%d,max-lines=%d",
+				server.limits.MultilineBytes, server.limits.MultilineLines)
+		}
+		if values && (capability == Metadata) {
+			str += fmt.Sprintf("=max-subs=%d,max-keys=%d,max-value-bytes=%d",
+				server.metadata.MaxSubs, server.metadata.MaxKeys,
+				server.metadata.MaxValueBytes)
+		}
+		if values && (capability == Languages) {
+			codes := server.languages.Codes()
+			str += fmt.Sprintf("=%d,%s", len(codes), strings.Join(codes, ","))
+		}
+		strs = append(strs, str)
+	}
+	return strings.Join(strs, " ")
+}
+
 func (set CapabilitySet) DisableString() string {
 	parts := make([]string, len(set))
 	index := 0
@@ -84,10 +151,10 @@ func (msg *CapCommand) HandleRegServer(server *Server) {
 	switch msg.subCommand {
 	case CAP_LS:
 		client.capState = CapNegotiating
-		client.Reply(RplCap(client, CAP_LS, SupportedCapabilities))
+		client.Reply(RplCap(client, CAP_LS, server.CapLS(msg.capabilities[CAP_302])))
 
 	case CAP_LIST:
-		client.Reply(RplCap(client, CAP_LIST, client.capabilities))
+		client.Reply(RplCap(client, CAP_LIST, client.negotiated()))
 
 	case CAP_REQ:
 		for capability := range msg.capabilities {
@@ -96,14 +163,20 @@ func (msg *CapCommand) HandleRegServer(server *Server) {
 				return
 			}
 		}
+		negotiated := client.negotiated()
 		for capability := range msg.capabilities {
-			client.capabilities[capability] = true
+			negotiated[capability] = true
 		}
+		client.shareCapabilities()
 		client.Reply(RplCap(client, CAP_ACK, msg.capabilities))
 
 	case CAP_CLEAR:
-		reply := RplCap(client, CAP_ACK, client.capabilities.DisableString())
-		client.capabilities = make(CapabilitySet)
+		negotiated := client.negotiated()
+		reply := RplCap(client, CAP_ACK, negotiated.DisableString())
+		for capability := range negotiated {
+			delete(negotiated, capability)
+		}
+		client.shareCapabilities()
 		client.Reply(reply)
 
 	case CAP_END:
@@ -0,0 +1,170 @@
+package irc
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Accounts may list the fingerprints of TLS client certificates. A client
+// connecting with one of them is logged in to the account when it
+// registers, or may log in with SASL EXTERNAL.
+
+var (
+	CertFPExpr = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+type CertSubCommand string
+
+const (
+	CertAdd  CertSubCommand = "ADD"
+	CertList CertSubCommand = "LIST"
+	CertDel  CertSubCommand = "DEL"
+)
+
+// CERT ADD [<fingerprint>]
+// CERT LIST
+// CERT DEL <fingerprint>
+//
+// ADD without a fingerprint adds the certificate the client connected
+// with.
+
+type CertCommand struct {
+	BaseCommand
+	subCommand  CertSubCommand
+	fingerprint string
+}
+
+// NormalizeCertFP lowercases a SHA-256 fingerprint and removes colons, so
+// that it may be given as shown by openssl.
+func NormalizeCertFP(fingerprint string) string {
+	return strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+}
+
+func ParseCertCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &CertCommand{
+		subCommand: CertSubCommand(strings.ToUpper(args[0])),
+	}
+	switch cmd.subCommand {
+	case CertAdd, CertDel:
+		if len(args) > 1 {
+			cmd.fingerprint = NormalizeCertFP(args[1])
+			if !CertFPExpr.MatchString(cmd.fingerprint) {
+				return nil, ErrParseCommand
+			}
+		} else if cmd.subCommand == CertDel {
+			return nil, NotEnoughArgsError
+		}
+	case CertList:
+	default:
+		return nil, ErrParseCommand
+	}
+	return cmd, nil
+}
+
+//
+// server goroutine
+//
+
+func (msg *CertCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if client.account == "" {
+		client.Notice("you must be logged in to manage certificates")
+		return
+	}
+
+	switch msg.subCommand {
+	case CertAdd:
+		fingerprint := msg.fingerprint
+		if fingerprint == "" {
+			fingerprint = client.CertFP()
+		}
+		if fingerprint == "" {
+			client.Notice("you aren't connected with a client certificate")
+			return
+		}
+		if account := server.certFPAccount(fingerprint); account != "" {
+			client.Notice("%s is already on an account", fingerprint)
+			return
+		}
+		_, err := server.db.Exec(`
+            INSERT INTO account_certfp (account, fingerprint) VALUES (?, ?)`,
+			client.account.String(), fingerprint)
+		if err != nil {
+			log.Println("CertCommand.HandleServer:", err)
+			return
+		}
+		client.Notice("Added %s to %s", fingerprint, client.account)
+
+	case CertList:
+		fingerprints := server.accountCertFPs(client.account)
+		client.Notice("%s has %d certificates", client.account, len(fingerprints))
+		for _, fingerprint := range fingerprints {
+			client.Reply(RplNotice(server, client, NewText(fingerprint)))
+		}
+
+	case CertDel:
+		result, err := server.db.Exec(`
+            DELETE FROM account_certfp WHERE account = ? AND fingerprint = ?`,
+			client.account.String(), msg.fingerprint)
+		if err != nil {
+			log.Println("CertCommand.HandleServer:", err)
+			return
+		}
+		if deleted, _ := result.RowsAffected(); deleted == 0 {
+			client.Notice("%s isn't on %s", msg.fingerprint, client.account)
+			return
+		}
+		client.Notice("Removed %s from %s", msg.fingerprint, client.account)
+	}
+}
+
+// certFPAccount is the account with fingerprint, if any.
+func (server *Server) certFPAccount(fingerprint string) Name {
+	if fingerprint == "" {
+		return ""
+	}
+	var account string
+	err := server.db.QueryRow(`
+        SELECT account FROM account_certfp WHERE fingerprint = ?`,
+		fingerprint).Scan(&account)
+	if err != nil {
+		return ""
+	}
+	return NewName(account)
+}
+
+func (server *Server) accountCertFPs(account Name) []string {
+	rows, err := server.db.Query(`
+        SELECT fingerprint FROM account_certfp WHERE account = ?
+        ORDER BY fingerprint`, account.String())
+	if err != nil {
+		log.Println("Server.accountCertFPs:", err)
+		return nil
+	}
+	defer rows.Close()
+	fingerprints := make([]string, 0)
+	for rows.Next() {
+		var fingerprint string
+		if err := rows.Scan(&fingerprint); err != nil {
+			log.Println("Server.accountCertFPs:", err)
+			continue
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	return fingerprints
+}
+
+// CertFPLogin logs client in to the account its certificate is on, if
+// it isn't logged in already.
+func (server *Server) CertFPLogin(client *Client) {
+	if client.account != "" {
+		return
+	}
+	if account := server.certFPAccount(client.CertFP()); account != "" {
+		client.Login(account)
+	}
+}
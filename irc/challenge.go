@@ -0,0 +1,95 @@
+package irc
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// Suspicious connections (listed by a DNSBL with the challenge action,
+// over their class's throttle, or from Tor without SASL) can be made to
+// prove there's a person behind them before they register: they're sent
+// a code in a NOTICE and must send it back with VERIFY.
+
+const (
+	CHALLENGE_CODE_LEN     = 6
+	CHALLENGE_MAX_ATTEMPTS = 3
+	CHALLENGE_ALPHABET     = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no 0/O or 1/I
+)
+
+type ChallengeConfig struct {
+	// challenge connections over their class's throttle instead of
+	// turning them away
+	Throttled bool
+	// challenge Tor clients that haven't logged in with SASL instead of
+	// turning them away
+	Tor bool
+}
+
+// VERIFY <code>
+
+type VerifyCommand struct {
+	BaseCommand
+	code string
+}
+
+func ParseVerifyCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &VerifyCommand{
+		code: strings.ToUpper(args[0]),
+	}, nil
+}
+
+func NewChallengeCode() string {
+	random := make([]byte, CHALLENGE_CODE_LEN)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	code := make([]byte, CHALLENGE_CODE_LEN)
+	for i, b := range random {
+		code[i] = CHALLENGE_ALPHABET[int(b)%len(CHALLENGE_ALPHABET)]
+	}
+	return string(code)
+}
+
+//
+// server goroutine
+//
+
+// Verified reports whether client may register: it isn't suspicious, or
+// it has answered its challenge. A suspicious client is sent a challenge
+// the first time.
+func (server *Server) Verified(client *Client) bool {
+	if (client.suspect == "") || client.verified {
+		return true
+	}
+	if client.challenge == "" {
+		client.challenge = NewChallengeCode()
+		server.NoticeOperators(NewText(fmt.Sprintf("%s (%s) was challenged: %s",
+			client.Id(), client.IP(), client.suspect)))
+		client.Notice("Your connection needs to be verified before it can register (%s)",
+			client.suspect)
+		client.Notice("To finish connecting, send: /QUOTE VERIFY %s", client.challenge)
+	}
+	return false
+}
+
+func (msg *VerifyCommand) HandleRegServer(server *Server) {
+	client := msg.Client()
+	if (client.challenge == "") || client.verified {
+		return
+	}
+	if msg.code != client.challenge {
+		client.challengeFailures += 1
+		if client.challengeFailures >= CHALLENGE_MAX_ATTEMPTS {
+			client.Quit("Verification failed")
+			return
+		}
+		client.Notice("That isn't the code; try again")
+		return
+	}
+	client.verified = true
+	server.tryRegister(client)
+}
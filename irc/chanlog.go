@@ -0,0 +1,345 @@
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Channel logs are public archives of what is said in a channel, written
+// under channel-logs.directory to a file a day per channel, as text or
+// JSON lines. The channels in the config are always logged; operators of
+// a persistent channel opt it in with +L <days>, which also keeps its logs
+// that many days instead of channel-logs.retention. Files older than that
+// are removed as the logs rotate. Each channel's directory has a
+// CHANNEL_LOG_PUBLIC file while the channel is public, so that its logs
+// are only shown while it doesn't exist if it was public when last seen.
+
+type ChannelLogFormat string
+
+const (
+	ChannelLogText  ChannelLogFormat = "text"
+	ChannelLogJSONL ChannelLogFormat = "jsonl"
+
+	CHANNEL_LOG_DAY    = "2006-01-02" // the name of each day's file
+	CHANNEL_LOG_PUBLIC = "public"     // marks the logs of a public channel
+)
+
+var (
+	channelLogFileName = strings.NewReplacer("/", "_", "\\", "_", "\x00", "_")
+)
+
+type ChannelLogConfig struct {
+	Directory string
+	Format    ChannelLogFormat
+	// how long logs are kept; 0 to keep them forever
+	Retention time.Duration
+	// channels logged without +L
+	Channels []string
+	// serve public channels' logs at /logs/ on websocket listeners
+	Web bool
+}
+
+func (conf *ChannelLogConfig) validate() error {
+	if conf.Directory == "" {
+		return nil
+	}
+	switch conf.Format {
+	case "":
+		conf.Format = ChannelLogText
+	case ChannelLogText, ChannelLogJSONL:
+	default:
+		return fmt.Errorf("Channel log format unknown: %s", conf.Format)
+	}
+	if conf.Retention < 0 {
+		return fmt.Errorf("Channel log retention must be positive: %s",
+			conf.Retention)
+	}
+	return nil
+}
+
+func (conf *ChannelLogConfig) channels() map[Name]bool {
+	channels := make(map[Name]bool)
+	for _, name := range conf.Channels {
+		channels[NewName(name).ToLower()] = true
+	}
+	return channels
+}
+
+// ChannelLogEntry is a line of a channel log.
+type ChannelLogEntry struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Nick    string    `json:"nick"`
+	Target  string    `json:"target,omitempty"` // who was kicked, or the new nick
+	Message string    `json:"message,omitempty"`
+}
+
+func (entry *ChannelLogEntry) String() string {
+	prefix := entry.Time.Format(time.RFC3339) + " "
+	switch entry.Type {
+	case "message":
+		return fmt.Sprintf("%s<%s> %s", prefix, entry.Nick, entry.Message)
+	case "notice":
+		return fmt.Sprintf("%s-%s- %s", prefix, entry.Nick, entry.Message)
+	case "action":
+		return fmt.Sprintf("%s* %s %s", prefix, entry.Nick, entry.Message)
+	case "join":
+		return fmt.Sprintf("%s*** %s joined", prefix, entry.Nick)
+	case "part":
+		return fmt.Sprintf("%s*** %s left (%s)", prefix, entry.Nick, entry.Message)
+	case "quit":
+		return fmt.Sprintf("%s*** %s quit (%s)", prefix, entry.Nick, entry.Message)
+	case "kick":
+		return fmt.Sprintf("%s*** %s was kicked by %s (%s)", prefix, entry.Target,
+			entry.Nick, entry.Message)
+	case "topic":
+		return fmt.Sprintf("%s*** %s set the topic: %s", prefix, entry.Nick,
+			entry.Message)
+	case "nick":
+		return fmt.Sprintf("%s*** %s is now known as %s", prefix, entry.Nick,
+			entry.Target)
+	}
+	return prefix + entry.Type
+}
+
+// ChannelLog is the open file of a logged channel.
+type ChannelLog struct {
+	dir    string
+	day    string
+	file   *os.File
+	public *bool // as last recorded
+}
+
+func NewChannelLog(conf *ChannelLogConfig, channel Name) *ChannelLog {
+	return &ChannelLog{
+		dir: channelLogDir(conf, channel),
+	}
+}
+
+func channelLogDir(conf *ChannelLogConfig, channel Name) string {
+	return filepath.Join(conf.Directory,
+		channelLogFileName.Replace(channel.ToLower().String()))
+}
+
+// IsPublicLogDir reports whether the channel was public when its log was
+// last written.
+func IsPublicLogDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, CHANNEL_LOG_PUBLIC))
+	return err == nil
+}
+
+// SetPublic records whether the channel is public.
+func (chanLog *ChannelLog) SetPublic(public bool) {
+	if (chanLog.public != nil) && (*chanLog.public == public) {
+		return
+	}
+	path := filepath.Join(chanLog.dir, CHANNEL_LOG_PUBLIC)
+	var err error
+	if public {
+		err = ioutil.WriteFile(path, nil, 0644)
+	} else if err = os.Remove(path); os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		log.Println("ChannelLog.SetPublic:", err)
+		return
+	}
+	chanLog.public = &public
+}
+
+func (chanLog *ChannelLog) Close() {
+	if chanLog.file != nil {
+		chanLog.file.Close()
+		chanLog.file = nil
+	}
+}
+
+// rotate opens the file for now's day if it isn't open, then removes
+// files older than retention.
+func (chanLog *ChannelLog) rotate(now time.Time, format ChannelLogFormat,
+	retention time.Duration) error {
+	day := now.Format(CHANNEL_LOG_DAY)
+	if (chanLog.file != nil) && (chanLog.day == day) {
+		return nil
+	}
+	chanLog.Close()
+	if err := os.MkdirAll(chanLog.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(chanLog.dir, day+channelLogExt(format))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	chanLog.file, chanLog.day = file, day
+	if retention > 0 {
+		chanLog.prune(now.Add(-retention))
+	}
+	return nil
+}
+
+func channelLogExt(format ChannelLogFormat) string {
+	if format == ChannelLogJSONL {
+		return ".jsonl"
+	}
+	return ".log"
+}
+
+// prune removes the files for days before cutoff.
+func (chanLog *ChannelLog) prune(cutoff time.Time) {
+	files, err := ioutil.ReadDir(chanLog.dir)
+	if err != nil {
+		log.Println("ChannelLog.prune:", err)
+		return
+	}
+	oldest := cutoff.Format(CHANNEL_LOG_DAY)
+	for _, file := range files {
+		name := file.Name()
+		day := strings.TrimSuffix(name, filepath.Ext(name))
+		if _, err := time.Parse(CHANNEL_LOG_DAY, day); err != nil {
+			continue
+		}
+		if day < oldest {
+			if err := os.Remove(filepath.Join(chanLog.dir, name)); err != nil {
+				log.Println("ChannelLog.prune:", err)
+			}
+		}
+	}
+}
+
+func (chanLog *ChannelLog) Write(entry *ChannelLogEntry,
+	format ChannelLogFormat, retention time.Duration) error {
+	if err := chanLog.rotate(entry.Time, format, retention); err != nil {
+		return err
+	}
+	var line []byte
+	if format == ChannelLogJSONL {
+		var err error
+		if line, err = json.Marshal(entry); err != nil {
+			return err
+		}
+	} else {
+		line = []byte(entry.String())
+	}
+	_, err := chanLog.file.Write(append(line, '\n'))
+	return err
+}
+
+//
+// server goroutine
+//
+
+// IsLogged reports whether the channel's log is written.
+func (channel *Channel) IsLogged() bool {
+	return (channel.server.channelLogs.Directory != "") &&
+		((channel.logDays > 0) ||
+			channel.server.loggedChannels[channel.name.ToLower()])
+}
+
+func (channel *Channel) logRetention() time.Duration {
+	if channel.logDays > 0 {
+		return time.Duration(channel.logDays) * 24 * time.Hour
+	}
+	return channel.server.channelLogs.Retention
+}
+
+// Log adds an entry to the channel's log, if it is logged.
+func (channel *Channel) Log(kind string, source Identifiable, target Name,
+	message Text) {
+	if !channel.IsLogged() {
+		channel.closeLog()
+		return
+	}
+	conf := &channel.server.channelLogs
+	if channel.chanLog == nil {
+		channel.chanLog = NewChannelLog(conf, channel.name)
+	}
+	entry := &ChannelLogEntry{
+		Time:    time.Now().UTC(),
+		Type:    kind,
+		Nick:    source.Nick().String(),
+		Target:  target.String(),
+		Message: message.String(),
+	}
+	if err := channel.chanLog.Write(entry, conf.Format,
+		channel.logRetention()); err != nil {
+		log.Println("Channel.Log:", channel, err)
+		return
+	}
+	channel.chanLog.SetPublic(channel.IsPublic())
+}
+
+// IsPublic reports whether anyone may see the channel's log: it isn't
+// secret, private, invite-only or keyed.
+func (channel *Channel) IsPublic() bool {
+	return !(channel.flags[Secret] || channel.flags[Private] ||
+		channel.flags[InviteOnly] || (channel.key != ""))
+}
+
+// LogMessage logs a PRIVMSG, telling actions apart, or a NOTICE.
+func (channel *Channel) LogMessage(source Identifiable, code StringCode,
+	message Text) {
+	kind := "message"
+	if code == NOTICE {
+		kind = "notice"
+	} else if command, params, ok := ParseCTCP(message); ok {
+		if command != CTCPAction {
+			return
+		}
+		kind, message = "action", NewText(params)
+	}
+	channel.Log(kind, source, "", message)
+}
+
+// closeLog closes the channel's log, recording whether it's public as it
+// goes.
+func (channel *Channel) closeLog() {
+	if channel.chanLog != nil {
+		if channel.chanLog.file != nil {
+			channel.chanLog.SetPublic(channel.IsPublic())
+		}
+		channel.chanLog.Close()
+		channel.chanLog = nil
+	}
+}
+
+// applyModeLogging handles +L <days> and -L.
+func (channel *Channel) applyModeLogging(client *Client,
+	change *ChannelModeChange) bool {
+	switch change.op {
+	case Add:
+		if channel.server.channelLogs.Directory == "" {
+			client.ErrUnknownMode(Logged, channel)
+			return false
+		}
+		days, err := strconv.ParseUint(change.arg, 10, 64)
+		if err != nil {
+			client.ErrNeedMoreParams("MODE")
+			return false
+		}
+		if !channel.flags[Persistent] {
+			client.Notice("%s must be persistent (+P) to be logged", channel)
+			return false
+		}
+		if (days == 0) || (days == channel.logDays) {
+			return false
+		}
+		channel.logDays = days
+		return true
+
+	case Remove:
+		if channel.logDays == 0 {
+			return false
+		}
+		channel.logDays = 0
+		return true
+	}
+	return false
+}
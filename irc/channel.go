@@ -1,34 +1,81 @@
 package irc
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"strconv"
+	"time"
 )
 
+var (
+	ErrBadChannelKey    = errors.New("bad channel key")
+	ErrBannedFromChan   = errors.New("banned from channel")
+	ErrCannotSendToChan = errors.New("cannot send to channel")
+	ErrChannelIsFull    = errors.New("channel is full")
+	ErrInviteOnlyChan   = errors.New("invite only channel")
+)
+
+// ChannelFilter checks or rewrites a message sent to a channel by a member
+// who may speak there. An error refuses the message.
+type ChannelFilter func(channel *Channel, client *Client, message Text) (Text, error)
+
+var (
+	// ChannelFilters are applied in order to every PRIVMSG and NOTICE to a
+	// channel.
+	ChannelFilters = []ChannelFilter{
+		filterChannelCTCP,
+		filterChannelFormatting,
+		filterChannelSlowMode,
+	}
+)
+
+// SlowModeError refuses a message sent too soon after the member's last
+// one.
+type SlowModeError struct {
+	wait time.Duration
+}
+
+func (err *SlowModeError) Error() string {
+	return fmt.Sprintf("slow mode: wait %s", err.wait)
+}
+
 type Channel struct {
-	flags     ChannelModeSet
-	lists     map[ChannelMode]*UserMaskSet
-	key       Text
-	members   MemberSet
-	name      Name
-	server    *Server
-	topic     Text
-	userLimit uint64
+	ctime       time.Time
+	flags       ChannelModeSet
+	forward     Name
+	lists       map[ChannelMode]*UserMaskSet
+	key         Text
+	lastMessage map[*Client]time.Time // for slow mode
+	logDays     uint64                // +L: days its log is kept
+	chanLog     *ChannelLog           // open while it's logged
+	history     *History              // for +H
+	members     MemberSet
+	metadata    MetadataMap
+	name        Name
+	server      *Server
+	topic       Text
+	topicSetter Name
+	topicTime   time.Time
+	slowMode    uint64 // seconds between messages
+	userLimit   uint64
 }
 
 // NewChannel creates a new channel from a `Server` and a `name`
 // string, which must be unique on the server.
 func NewChannel(s *Server, name Name) *Channel {
 	channel := &Channel{
+		ctime: time.Now(),
 		flags: make(ChannelModeSet),
 		lists: map[ChannelMode]*UserMaskSet{
 			BanMask:    NewUserMaskSet(),
 			ExceptMask: NewUserMaskSet(),
 			InviteMask: NewUserMaskSet(),
 		},
-		members: make(MemberSet),
-		name:    name,
-		server:  s,
+		lastMessage: make(map[*Client]time.Time),
+		members:     make(MemberSet),
+		name:        name,
+		server:      s,
 	}
 
 	s.channels.Add(channel)
@@ -45,30 +92,40 @@ func (channel *Channel) Names(client *Client) {
 	client.RplEndOfNames(channel)
 }
 
+// ClientRank is the rank of the client's highest member mode on the
+// channel. Server operators outrank every member.
+func (channel *Channel) ClientRank(client *Client) uint {
+	if client.flags[Operator] {
+		return MemberModeRank(ChannelFounder) + 1
+	}
+	return channel.members[client].Rank()
+}
+
+// ClientIsAtLeast is true when the client's rank on the channel is at
+// least that of the member mode.
+func (channel *Channel) ClientIsAtLeast(client *Client, mode ChannelMode) bool {
+	return channel.ClientRank(client) >= MemberModeRank(mode)
+}
+
 func (channel *Channel) ClientIsOperator(client *Client) bool {
-	return client.flags[Operator] || channel.members.HasMode(client, ChannelOperator)
+	return channel.ClientIsAtLeast(client, ChannelOperator)
+}
+
+// isOverride is true when only being a server operator let the client
+// change the mode.
+func (channel *Channel) isOverride(client *Client, mode ChannelMode) bool {
+	def := ChannelModeDefFor(mode)
+	return client.flags[Operator] && (def != nil) &&
+		(channel.members[client].Rank() < MemberModeRank(def.setter))
 }
 
 func (channel *Channel) Nicks(target *Client) []string {
 	isMultiPrefix := (target != nil) && target.capabilities[MultiPrefix]
 	nicks := make([]string, len(channel.members))
 	i := 0
-	for client, modes := range channel.members {
-		if isMultiPrefix {
-			if modes[ChannelOperator] {
-				nicks[i] += "@"
-			}
-			if modes[Voice] {
-				nicks[i] += "+"
-			}
-		} else {
-			if modes[ChannelOperator] {
-				nicks[i] += "@"
-			} else if modes[Voice] {
-				nicks[i] += "+"
-			}
-		}
-		nicks[i] += client.Nick().String()
+	for client, membership := range channel.members {
+		nicks[i] = membership.Prefixes(channel.server.memberPrefixes, isMultiPrefix) +
+			client.Nick().String()
 		i += 1
 	}
 	return nicks
@@ -91,6 +148,9 @@ func (channel *Channel) ModeString(client *Client) (str string) {
 	isMember := client.flags[Operator] || channel.members.Has(client)
 	showKey := isMember && (channel.key != "")
 	showUserLimit := channel.userLimit > 0
+	showForward := channel.forward != ""
+	showSlowMode := channel.slowMode > 0
+	showLogged := channel.logDays > 0
 
 	// flags with args
 	if showKey {
@@ -99,6 +159,15 @@ func (channel *Channel) ModeString(client *Client) (str string) {
 	if showUserLimit {
 		str += UserLimit.String()
 	}
+	if showForward {
+		str += Forward.String()
+	}
+	if showSlowMode {
+		str += SlowMode.String()
+	}
+	if showLogged {
+		str += Logged.String()
+	}
 
 	// flags
 	for mode := range channel.flags {
@@ -115,6 +184,15 @@ func (channel *Channel) ModeString(client *Client) (str string) {
 	if showUserLimit {
 		str += " " + strconv.FormatUint(channel.userLimit, 10)
 	}
+	if showForward {
+		str += " " + channel.forward.String()
+	}
+	if showSlowMode {
+		str += " " + strconv.FormatUint(channel.slowMode, 10)
+	}
+	if showLogged {
+		str += " " + strconv.FormatUint(channel.logDays, 10)
+	}
 
 	return
 }
@@ -128,48 +206,116 @@ func (channel *Channel) CheckKey(key Text) bool {
 	return (channel.key == "") || (channel.key == key)
 }
 
+// IsBanned is true when the client matches a ban mask and no exception
+// mask.
+func (channel *Channel) IsBanned(client *Client) bool {
+	return channel.lists[BanMask].Match(client.UserHost()) &&
+		!channel.lists[ExceptMask].Match(client.UserHost())
+}
+
+// checkJoin reports why the client may not join the channel, if it may
+// not.
+func (channel *Channel) checkJoin(client *Client, key Text) error {
+	// An INVITE lets the invitee past +l, +k, +i and bans once; +I
+	// masks only exempt from +i and bans.
+	wasInvited := client.invitedTo.Has(channel)
+	isInvited := wasInvited ||
+		channel.lists[InviteMask].Match(client.UserHost())
+
+	if channel.IsFull() && !wasInvited {
+		return ErrChannelIsFull
+	}
+
+	if !channel.CheckKey(key) && !wasInvited {
+		return ErrBadChannelKey
+	}
+
+	if channel.flags[InviteOnly] && !isInvited {
+		return ErrInviteOnlyChan
+	}
+
+	if channel.IsBanned(client) && !isInvited {
+		return ErrBannedFromChan
+	}
+
+	return nil
+}
+
 func (channel *Channel) Join(client *Client, key Text) {
+	channel.join(client, key, make(ChannelSet))
+}
+
+// join follows +f forwards when the client can't join, through at most
+// MAX_FORWARDS channels and never back to one already tried.
+func (channel *Channel) join(client *Client, key Text, tried ChannelSet) {
 	if channel.members.Has(client) {
 		// already joined, no message?
 		return
 	}
 
-	if channel.IsFull() {
+	err := channel.checkJoin(client, key)
+	if (err != nil) && client.OperConfig().Override {
+		channel.server.OperOverride(client, JOIN, channel, err.Error())
+		err = nil
+	}
+	if (err != nil) && (err != ErrBadChannelKey) && (channel.forward != "") &&
+		(len(tried) < MAX_FORWARDS) {
+		tried.Add(channel)
+		target := channel.server.channels.Get(channel.forward)
+		if (target != nil) && !tried.Has(target) {
+			client.ErrLinkChannel(channel, target)
+			target.join(client, "", tried)
+			return
+		}
+	}
+
+	switch err {
+	case ErrChannelIsFull:
 		client.ErrChannelIsFull(channel)
 		return
-	}
 
-	if !channel.CheckKey(key) {
+	case ErrBadChannelKey:
 		client.ErrBadChannelKey(channel)
 		return
-	}
 
-	isInvited := channel.lists[InviteMask].Match(client.UserHost())
-	if channel.flags[InviteOnly] && !isInvited {
+	case ErrInviteOnlyChan:
 		client.ErrInviteOnlyChan(channel)
 		return
-	}
 
-	if channel.lists[BanMask].Match(client.UserHost()) &&
-		!isInvited &&
-		!channel.lists[ExceptMask].Match(client.UserHost()) {
+	case ErrBannedFromChan:
 		client.ErrBannedFromChan(channel)
 		return
 	}
 
+	if !channel.server.hooks.Join(client, channel) {
+		return
+	}
+
+	client.invitedTo.Remove(channel)
 	client.channels.Add(channel)
 	channel.members.Add(client)
 	if !channel.flags[Persistent] && (len(channel.members) == 1) {
-		channel.members[client][ChannelCreator] = true
-		channel.members[client][ChannelOperator] = true
+		channel.members[client].Set(ChannelCreator, true)
+		channel.members[client].Set(ChannelOperator, true)
 	}
 
-	reply := RplJoin(client, channel)
-	for member := range channel.members {
-		member.Reply(reply)
+	channel.Broadcast(nil, func(CapabilitySet) string {
+		return RplJoin(client, channel)
+	})
+	channel.StreamJoin(client)
+	channel.Log("join", client, "", "")
+	if client.flags[Away] {
+		reply := RplAwayNotify(client)
+		for member := range channel.members {
+			if (member != client) && member.capabilities[AwayNotify] {
+				member.Reply(reply)
+			}
+		}
 	}
 	channel.GetTopic(client)
 	channel.Names(client)
+	channel.JoinMetadata(client)
+	channel.ReplayHistory(client)
 }
 
 func (channel *Channel) Part(client *Client, message Text) {
@@ -178,10 +324,11 @@ func (channel *Channel) Part(client *Client, message Text) {
 		return
 	}
 
-	reply := RplPart(client, channel, message)
-	for member := range channel.members {
-		member.Reply(reply)
-	}
+	channel.Broadcast(nil, func(CapabilitySet) string {
+		return RplPart(client, channel, message)
+	})
+	channel.StreamPart(client, message)
+	channel.Log("part", client, "", message)
 	channel.Quit(client)
 }
 
@@ -198,6 +345,9 @@ func (channel *Channel) GetTopic(client *Client) {
 	}
 
 	client.RplTopic(channel)
+	if channel.topicSetter != "" {
+		client.RplTopicWhoTime(channel)
+	}
 }
 
 func (channel *Channel) SetTopic(client *Client, topic Text) {
@@ -212,11 +362,13 @@ func (channel *Channel) SetTopic(client *Client, topic Text) {
 	}
 
 	channel.topic = topic
+	channel.topicSetter = client.Nick()
+	channel.topicTime = time.Now()
 
-	reply := RplTopicMsg(client, channel)
-	for member := range channel.members {
-		member.Reply(reply)
-	}
+	channel.Broadcast(nil, func(CapabilitySet) string {
+		return RplTopicMsg(client, channel)
+	})
+	channel.Log("topic", client, "", topic)
 
 	if err := channel.Persist(); err != nil {
 		log.Println("Channel.Persist:", channel, err)
@@ -230,34 +382,127 @@ func (channel *Channel) CanSpeak(client *Client) bool {
 	if channel.flags[NoOutside] && !channel.members.Has(client) {
 		return false
 	}
-	if channel.flags[Moderated] && !(channel.members.HasMode(client, Voice) ||
-		channel.members.HasMode(client, ChannelOperator)) {
+	isVoiced := channel.ClientIsAtLeast(client, Voice)
+	if channel.flags[Moderated] && !isVoiced {
+		return false
+	}
+	if channel.IsBanned(client) && !isVoiced {
 		return false
 	}
 	return true
 }
 
-func (channel *Channel) PrivMsg(client *Client, message Text) {
+// Broadcast sends a message to every member except skip, which may be
+// nil. The message is rendered once per distinct set of
+// RenderCapabilities rather than once per member.
+func (channel *Channel) Broadcast(skip *Client, render ReplyRenderer) {
+	replies := NewReplyCache(render)
+	for member := range channel.members {
+		if member == skip {
+			continue
+		}
+		member.Reply(replies.For(member))
+	}
+}
+
+// BroadcastMessage sends a message from client to every other member who
+// isn't silencing them.
+func (channel *Channel) BroadcastMessage(client *Client, render ReplyRenderer) {
+	replies := NewReplyCache(render)
+	for member := range channel.members {
+		if (member == client) || member.IsSilencing(client) {
+			continue
+		}
+		member.Reply(replies.For(member))
+	}
+}
+
+// Filter applies the ChannelFilters to a message.
+func (channel *Channel) Filter(client *Client, message Text) (Text, error) {
+	var err error
+	for _, filter := range ChannelFilters {
+		if message, err = filter(channel, client, message); err != nil {
+			return message, err
+		}
+	}
+	return message, nil
+}
+
+// filterChannelFormatting strips color and formatting codes from messages
+// to channels with +c, or refuses them if color-mode is block.
+func filterChannelFormatting(channel *Channel, client *Client, message Text) (Text, error) {
+	if !channel.flags[NoColor] {
+		return message, nil
+	}
+	stripped := message.StripFormatting()
+	if (stripped != message) && (channel.server.channelColor == ColorBlock) {
+		return message, ErrCannotSendToChan
+	}
+	return stripped, nil
+}
+
+// filterChannelSlowMode refuses messages from members below channel
+// operator sent within slowMode seconds of their last one.
+func filterChannelSlowMode(channel *Channel, client *Client, message Text) (Text, error) {
+	if (channel.slowMode == 0) || client.flags[Operator] ||
+		channel.ClientIsAtLeast(client, ChannelOperator) {
+		return message, nil
+	}
+	now := time.Now()
+	interval := time.Duration(channel.slowMode) * time.Second
+	if elapsed := now.Sub(channel.lastMessage[client]); elapsed < interval {
+		wait := (interval - elapsed + time.Second - 1).Truncate(time.Second)
+		return message, &SlowModeError{wait}
+	}
+	channel.lastMessage[client] = now
+	return message, nil
+}
+
+func (channel *Channel) PrivMsg(client *Client, message Text, sent Tags) {
 	if !channel.CanSpeak(client) {
 		client.ErrCannotSendToChan(channel)
 		return
 	}
-	reply := RplPrivMsg(client, channel, message)
+	message, err := channel.Filter(client, message)
+	if slow, ok := err.(*SlowModeError); ok {
+		client.ErrSlowMode(channel, slow.wait)
+		return
+	} else if err != nil {
+		client.ErrCannotSendToChan(channel)
+		return
+	}
+	tags := client.MessageTags(sent)
+	channel.BroadcastMessage(client, func(capabilities CapabilitySet) string {
+		return TagReply(capabilities, RplPrivMsg(client, channel, message), tags)
+	})
+	channel.Record(RplPrivMsg(client, channel, message), tags)
+	channel.StreamMessage(client, client, PRIVMSG, message)
+	channel.LogMessage(client, PRIVMSG, message)
+	if theater := channel.Theater(); theater != nil {
+		theater.Log(client.Nick(), message)
+	}
+	client.Echo(TagReply(client.capabilities, RplPrivMsg(client, channel, message),
+		tags))
+}
+
+// TagMsg sends a TAGMSG to the members that have message-tags.
+func (channel *Channel) TagMsg(client *Client, sent Tags) {
+	if !channel.CanSpeak(client) {
+		client.ErrCannotSendToChan(channel)
+		return
+	}
+	reply := AddTags(RplTagMsg(client, channel), client.TagMsgTags(sent))
 	for member := range channel.members {
-		if member == client {
+		if (member == client) || !member.capabilities[MessageTags] ||
+			member.IsSilencing(client) {
 			continue
 		}
 		member.Reply(reply)
 	}
+	client.Echo(reply)
 }
 
-func (channel *Channel) applyModeFlag(client *Client, mode ChannelMode,
-	op ModeOp) bool {
-	if !channel.ClientIsOperator(client) {
-		client.ErrChanOPrivIsNeeded(channel)
-		return false
-	}
-
+func (channel *Channel) applyModeFlag(mode ChannelMode, op ModeOp) bool {
 	switch op {
 	case Add:
 		if channel.flags[mode] {
@@ -271,6 +516,9 @@ func (channel *Channel) applyModeFlag(client *Client, mode ChannelMode,
 			return false
 		}
 		delete(channel.flags, mode)
+		if mode == KeepHistory {
+			channel.history = nil
+		}
 		return true
 	}
 	return false
@@ -278,11 +526,6 @@ func (channel *Channel) applyModeFlag(client *Client, mode ChannelMode,
 
 func (channel *Channel) applyModeMember(client *Client, mode ChannelMode,
 	op ModeOp, nick Name) bool {
-	if !channel.ClientIsOperator(client) {
-		client.ErrChanOPrivIsNeeded(channel)
-		return false
-	}
-
 	if nick == "" {
 		client.ErrNeedMoreParams("MODE")
 		return false
@@ -299,20 +542,19 @@ func (channel *Channel) applyModeMember(client *Client, mode ChannelMode,
 		return false
 	}
 
+	// Members may always step down, but not touch those who outrank them.
+	if (target != client) &&
+		(channel.ClientRank(target) > channel.ClientRank(client)) {
+		client.ErrChanOPrivIsNeeded(channel)
+		return false
+	}
+
 	switch op {
 	case Add:
-		if channel.members[target][mode] {
-			return false
-		}
-		channel.members[target][mode] = true
-		return true
+		return channel.members[target].Set(mode, true)
 
 	case Remove:
-		if !channel.members[target][mode] {
-			return false
-		}
-		channel.members[target][mode] = false
-		return true
+		return channel.members[target].Set(mode, false)
 	}
 	return false
 }
@@ -324,50 +566,108 @@ func (channel *Channel) ShowMaskList(client *Client, mode ChannelMode) {
 	client.RplEndOfMaskList(mode, channel)
 }
 
-func (channel *Channel) applyModeMask(client *Client, mode ChannelMode, op ModeOp,
-	mask Name) bool {
-	list := channel.lists[mode]
+func (channel *Channel) applyModeMask(client *Client, change *ChannelModeChange) bool {
+	list := channel.lists[change.mode]
 	if list == nil {
 		// This should never happen, but better safe than panicky.
 		return false
 	}
 
-	if (op == List) || (mask == "") {
-		channel.ShowMaskList(client, mode)
-		return false
-	}
-
-	if !channel.ClientIsOperator(client) {
-		client.ErrChanOPrivIsNeeded(channel)
-		return false
-	}
+	mask := ExpandUserHost(NewName(change.arg))
+	change.arg = mask.String()
 
-	if op == Add {
+	if change.op == Add {
+		if len(list.masks) >= CHANNEL_LIST_MAX {
+			client.ErrBanListFull(channel, change.mode)
+			return false
+		}
 		return list.Add(mask)
 	}
 
-	if op == Remove {
+	if change.op == Remove {
 		return list.Remove(mask)
 	}
 
 	return false
 }
 
-func (channel *Channel) applyMode(client *Client, change *ChannelModeChange) bool {
-	switch change.mode {
-	case BanMask, ExceptMask, InviteMask:
-		return channel.applyModeMask(client, change.mode, change.op,
-			NewName(change.arg))
-
-	case InviteOnly, Moderated, NoOutside, OpOnlyTopic, Persistent, Private:
-		return channel.applyModeFlag(client, change.mode, change.op)
+// applyModeForward sets +f. The client must be an operator in the
+// channel forwarded to as well, so users can't be dumped on others.
+func (channel *Channel) applyModeForward(client *Client, change *ChannelModeChange) bool {
+	switch change.op {
+	case Add:
+		if change.arg == "" {
+			client.ErrNeedMoreParams("MODE")
+			return false
+		}
+		name := NewName(change.arg)
+		target := channel.server.channels.Get(name)
+		if target == nil {
+			client.ErrNoSuchChannel(name)
+			return false
+		}
+		if target == channel {
+			return false
+		}
+		if !target.ClientIsOperator(client) {
+			client.ErrChanOPrivIsNeeded(target)
+			return false
+		}
+		if target.name == channel.forward {
+			return false
+		}
+		channel.forward = target.name
+		change.arg = target.name.String()
+		return true
 
-	case Key:
-		if !channel.ClientIsOperator(client) {
-			client.ErrChanOPrivIsNeeded(channel)
+	case Remove:
+		if channel.forward == "" {
 			return false
 		}
+		channel.forward = ""
+		return true
+	}
+	return false
+}
+
+func (channel *Channel) applyMode(client *Client, change *ChannelModeChange) bool {
+	def := ChannelModeDefFor(change.mode)
+	if def == nil {
+		client.ErrUnknownMode(change.mode, channel)
+		return false
+	}
 
+	// Anyone may look at the lists.
+	if (def.kind == ListMode) && ((change.op == List) || (change.arg == "")) {
+		channel.ShowMaskList(client, change.mode)
+		return false
+	}
+
+	if def.setter == 0 {
+		client.ErrConfiguredMode(change.mode)
+		return false
+	}
+
+	if !channel.ClientIsAtLeast(client, def.setter) {
+		client.ErrChanOPrivIsNeeded(channel)
+		return false
+	}
+
+	switch def.kind {
+	case ListMode:
+		return channel.applyModeMask(client, change)
+
+	case FlagMode:
+		return channel.applyModeFlag(change.mode, change.op)
+
+	case MemberMode:
+		return channel.applyModeMember(client, change.mode, change.op,
+			NewName(change.arg))
+	}
+
+	// modes with arguments keep their own state
+	switch change.mode {
+	case Key:
 		switch change.op {
 		case Add:
 			if change.arg == "" {
@@ -388,24 +688,57 @@ func (channel *Channel) applyMode(client *Client, change *ChannelModeChange) boo
 		}
 
 	case UserLimit:
-		limit, err := strconv.ParseUint(change.arg, 10, 64)
-		if err != nil {
-			client.ErrNeedMoreParams("MODE")
-			return false
-		}
-		if (limit == 0) || (limit == channel.userLimit) {
-			return false
+		switch change.op {
+		case Add:
+			limit, err := strconv.ParseUint(change.arg, 10, 64)
+			if err != nil {
+				client.ErrNeedMoreParams("MODE")
+				return false
+			}
+			if (limit == 0) || (limit == channel.userLimit) {
+				return false
+			}
+
+			channel.userLimit = limit
+			return true
+
+		case Remove:
+			if channel.userLimit == 0 {
+				return false
+			}
+			channel.userLimit = 0
+			return true
 		}
 
-		channel.userLimit = limit
-		return true
+	case SlowMode:
+		switch change.op {
+		case Add:
+			seconds, err := strconv.ParseUint(change.arg, 10, 64)
+			if err != nil {
+				client.ErrNeedMoreParams("MODE")
+				return false
+			}
+			if (seconds == 0) || (seconds == channel.slowMode) {
+				return false
+			}
 
-	case ChannelOperator, Voice:
-		return channel.applyModeMember(client, change.mode, change.op,
-			NewName(change.arg))
+			channel.slowMode = seconds
+			return true
 
-	default:
-		client.ErrUnknownMode(change.mode, channel)
+		case Remove:
+			if channel.slowMode == 0 {
+				return false
+			}
+			channel.slowMode = 0
+			channel.lastMessage = make(map[*Client]time.Time)
+			return true
+		}
+
+	case Forward:
+		return channel.applyModeForward(client, change)
+
+	case Logged:
+		return channel.applyModeLogging(client, change)
 	}
 	return false
 }
@@ -417,17 +750,29 @@ func (channel *Channel) Mode(client *Client, changes ChannelModeChanges) {
 	}
 
 	applied := make(ChannelModeChanges, 0)
+	params := 0
+	overridden := false
 	for _, change := range changes {
+		if change.arg != "" {
+			params += 1
+			if params > MAX_MODE_PARAMS {
+				break
+			}
+		}
 		if channel.applyMode(client, change) {
 			applied = append(applied, change)
+			overridden = overridden || channel.isOverride(client, change.mode)
 		}
 	}
 
+	if overridden {
+		channel.server.OperOverride(client, MODE, channel, applied.String())
+	}
+
 	if len(applied) > 0 {
-		reply := RplChannelMode(client, channel, applied)
-		for member := range channel.members {
-			member.Reply(reply)
-		}
+		channel.Broadcast(nil, func(CapabilitySet) string {
+			return RplChannelMode(client, channel, applied)
+		})
 
 		if err := channel.Persist(); err != nil {
 			log.Println("Channel.Persist:", channel, err)
@@ -440,59 +785,120 @@ func (channel *Channel) Persist() (err error) {
 		_, err = channel.server.db.Exec(`
             INSERT OR REPLACE INTO channel
               (name, flags, key, topic, user_limit, ban_list, except_list,
-               invite_list)
-              VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+               invite_list, topic_setter, topic_time, forward, slow_mode,
+               log_days)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 			channel.name.String(), channel.flags.String(), channel.key.String(),
 			channel.topic.String(), channel.userLimit, channel.lists[BanMask].String(),
-			channel.lists[ExceptMask].String(), channel.lists[InviteMask].String())
+			channel.lists[ExceptMask].String(), channel.lists[InviteMask].String(),
+			channel.topicSetter.String(), channel.topicUnix(),
+			channel.forward.String(), channel.slowMode, channel.logDays)
+		if err == nil {
+			err = channel.persistAllMetadata()
+		}
 	} else {
 		_, err = channel.server.db.Exec(`
             DELETE FROM channel WHERE name = ?`, channel.name.String())
+		if err == nil {
+			_, err = channel.server.db.Exec(`
+            DELETE FROM channel_metadata WHERE channel = ?`,
+				channel.name.String())
+		}
 	}
 	return
 }
 
-func (channel *Channel) Notice(client *Client, message Text) {
+func (channel *Channel) topicUnix() int64 {
+	if channel.topicTime.IsZero() {
+		return 0
+	}
+	return channel.topicTime.Unix()
+}
+
+func (channel *Channel) Notice(client *Client, message Text, sent Tags) {
+	// RFC 2812: automatic replies MUST NEVER be sent in response to a
+	// NOTICE, so a client that can't speak is silently ignored.
 	if !channel.CanSpeak(client) {
-		client.ErrCannotSendToChan(channel)
 		return
 	}
-	reply := RplNotice(client, channel, message)
-	for member := range channel.members {
-		if member == client {
-			continue
-		}
-		member.Reply(reply)
+	message, err := channel.Filter(client, message)
+	if err != nil {
+		return
 	}
+	tags := client.MessageTags(sent)
+	channel.BroadcastMessage(client, func(capabilities CapabilitySet) string {
+		return TagReply(capabilities, RplNotice(client, channel, message), tags)
+	})
+	channel.Record(RplNotice(client, channel, message), tags)
+	channel.StreamMessage(client, client, NOTICE, message)
+	channel.LogMessage(client, NOTICE, message)
+	client.Echo(TagReply(client.capabilities, RplNotice(client, channel, message),
+		tags))
 }
 
 func (channel *Channel) Quit(client *Client) {
 	channel.members.Remove(client)
 	client.channels.Remove(channel)
+	delete(channel.lastMessage, client)
 
 	if !channel.flags[Persistent] && channel.IsEmpty() {
 		channel.server.channels.Remove(channel)
+		channel.closeLog()
 	}
 }
 
-func (channel *Channel) Kick(client *Client, target *Client, comment Text) {
+// canKick checks that client may kick or remove target: halfops and up
+// may remove members who don't outrank them, and founders may only be
+// removed by server operators.
+func (channel *Channel) canKick(client *Client, target *Client) bool {
 	if !(client.flags[Operator] || channel.members.Has(client)) {
 		client.ErrNotOnChannel(channel)
-		return
+		return false
 	}
-	if !channel.ClientIsOperator(client) {
+	if !channel.ClientIsAtLeast(client, Halfop) {
 		client.ErrChanOPrivIsNeeded(channel)
-		return
+		return false
 	}
 	if !channel.members.Has(target) {
 		client.ErrUserNotInChannel(channel, target)
+		return false
+	}
+	if channel.ClientRank(target) > channel.ClientRank(client) {
+		client.ErrChanOPrivIsNeeded(channel)
+		return false
+	}
+	if channel.members.HasMode(target, ChannelFounder) &&
+		!client.flags[Operator] && (client != target) {
+		client.ErrChanOPrivIsNeeded(channel)
+		return false
+	}
+	return true
+}
+
+func (channel *Channel) Kick(client *Client, target *Client, comment Text) {
+	if !channel.canKick(client, target) {
 		return
 	}
 
-	reply := RplKick(channel, client, target, comment)
-	for member := range channel.members {
-		member.Reply(reply)
+	channel.Broadcast(nil, func(CapabilitySet) string {
+		return RplKick(channel, client, target, comment)
+	})
+	channel.Log("kick", client, target.Nick(), comment)
+	channel.Quit(target)
+}
+
+// RemoveMember makes target part the channel, as if they had asked to.
+// Some clients auto-rejoin on KICK but not on PART.
+func (channel *Channel) RemoveMember(client *Client, target *Client, comment Text) {
+	if !channel.canKick(client, target) {
+		return
 	}
+
+	message := NewText(fmt.Sprintf("requested by %s (%s)", client.Nick(), comment))
+	channel.Broadcast(nil, func(CapabilitySet) string {
+		return RplPart(target, channel, message)
+	})
+	channel.Log("part", target, "", message)
 	channel.Quit(target)
 }
 
@@ -507,16 +913,24 @@ func (channel *Channel) Invite(invitee *Client, inviter *Client) {
 		return
 	}
 
-	if channel.flags[InviteOnly] {
-		channel.lists[InviteMask].Add(invitee.UserHost())
-		if err := channel.Persist(); err != nil {
-			log.Println("Channel.Persist:", channel, err)
-		}
+	if channel.members.Has(invitee) {
+		inviter.ErrUserOnChannel(channel, invitee)
+		return
 	}
 
+	invitee.invitedTo.Add(channel)
+
 	inviter.RplInviting(invitee, channel.name)
 	invitee.Reply(RplInviteMsg(inviter, invitee, channel.name))
 	if invitee.flags[Away] {
 		inviter.RplAway(invitee)
 	}
+
+	// invite-notify goes to the channel operators who asked for it
+	for member := range channel.members {
+		if (member != inviter) && member.capabilities[InviteNotify] &&
+			channel.ClientIsOperator(member) {
+			member.Reply(RplInviteMsg(inviter, invitee, channel.name))
+		}
+	}
 }
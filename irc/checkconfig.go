@@ -0,0 +1,117 @@
+package irc
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CheckConfig loads a config file and also checks what the server would
+// otherwise only find out when it starts or a client needs it: passwords
+// that don't decode, certificates that don't load, and missing files. It
+// returns every problem found.
+func CheckConfig(filename string) []error {
+	config, err := LoadConfig(filename)
+	if err != nil {
+		return []error{err}
+	}
+
+	errs := make([]error, 0)
+	problem := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	checkPassword := func(what string, encoded string) {
+		decoded, err := DecodePassword(encoded)
+		if err == nil {
+			_, err = bcrypt.Cost(decoded)
+		}
+		if err != nil {
+			problem("%s password: %s", what, err)
+		}
+	}
+	checkFile := func(what string, filename string) {
+		if _, err := os.Stat(filename); err != nil {
+			problem("%s: %s", what, err)
+		}
+	}
+
+	passwords := make(map[string]string)
+	if config.Server.Password != "" {
+		passwords["Server"] = config.Server.Password
+	}
+	for name, conf := range config.Operator {
+		passwords["Operator "+name] = conf.Password
+	}
+	for name, conf := range config.Theater {
+		if conf.Password != "" {
+			passwords["Theater "+name] = conf.Password
+		}
+	}
+	for name, conf := range config.API.Users {
+		passwords["API user "+name] = conf.Password
+	}
+	for name, conf := range config.Bridges {
+		passwords["Bridge "+name] = conf.Password
+	}
+	for _, what := range sortedKeys(passwords) {
+		checkPassword(what, passwords[what])
+	}
+
+	checkFile("Server database", config.Server.Database)
+	if config.Server.MOTD != "" {
+		checkFile("Server motd", config.Server.MOTD)
+	}
+	for _, plugin := range config.Plugins {
+		checkFile("Plugin", plugin)
+	}
+	if config.GeoIP.Database != "" {
+		if _, err := OpenMMDB(config.GeoIP.Database); err != nil {
+			problem("GeoIP database: %s", err)
+		}
+	}
+	if _, err := LoadLanguages(config.Languages.Path); err != nil {
+		problem("Languages: %s", err)
+	}
+
+	names := make([]string, 0, len(config.Listeners))
+	for name := range config.Listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		listener := config.Listeners[name]
+		what := "Listener " + name
+		if (listener.Password != nil) && (*listener.Password != "") {
+			checkPassword(what, *listener.Password)
+		}
+		if listener.MOTD != "" {
+			checkFile(what+" motd", listener.MOTD)
+		}
+		if listener.TLS != nil {
+			_, err := tls.LoadX509KeyPair(listener.TLS.Cert, listener.TLS.Key)
+			if err != nil {
+				problem("%s tls: %s", what, err)
+			}
+		}
+		if !strings.HasPrefix(listener.Address, UNIX_PREFIX) {
+			if _, err := net.ResolveTCPAddr("tcp", listener.Address); err != nil {
+				problem("%s address: %s", what, err)
+			}
+		}
+	}
+	return errs
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,154 @@
+package irc
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrClassFull  = errors.New("Too many connections in your class")
+	ErrServerFull = errors.New("Server is full, try again later")
+	ErrThrottled  = errors.New("Too many connections from your host, try again later")
+)
+
+// ConnectionClass groups connections that share limits, like the classes
+// of traditional ircds. Connections are put in the class named for their
+// listener, else the first class (by name) with a matching host mask,
+// else DEFAULT_CLASS.
+type ConnectionClass struct {
+	name          string
+	maxClients    int // 0 for no limit
+	sendQ         int
+	recvQ         int
+	pingFrequency time.Duration
+	pingTimeout   time.Duration
+	throttle      ThrottleConfig
+	hosts         *UserMaskSet
+	listeners     map[string]bool
+
+	clients   int
+	throttled map[Name]*throttleState
+}
+
+type throttleState struct {
+	start       time.Time
+	connections int
+}
+
+func NewConnectionClass(name string, conf *ClassConfig) *ConnectionClass {
+	class := &ConnectionClass{
+		name:          name,
+		maxClients:    conf.MaxClients,
+		sendQ:         conf.SendQ,
+		recvQ:         conf.RecvQ,
+		pingFrequency: conf.PingFrequency,
+		pingTimeout:   conf.PingTimeout,
+		throttle:      conf.Throttle,
+		hosts:         NewUserMaskSet(),
+		listeners:     make(map[string]bool),
+		throttled:     make(map[Name]*throttleState),
+	}
+	if class.sendQ <= 0 {
+		class.sendQ = DEFAULT_SENDQ
+	}
+	if class.recvQ <= 0 {
+		class.recvQ = DEFAULT_RECVQ
+	}
+	if class.pingFrequency <= 0 {
+		class.pingFrequency = IDLE_TIMEOUT
+	}
+	if class.pingTimeout <= 0 {
+		class.pingTimeout = QUIT_TIMEOUT
+	}
+	class.hosts.AddAll(NewNames(conf.Hosts))
+	for _, addr := range conf.Listeners {
+		class.listeners[addr] = true
+	}
+	return class
+}
+
+func (class *ConnectionClass) String() string {
+	return class.name
+}
+
+// NewConnectionClasses builds the configured classes, sorted by name,
+// adding DEFAULT_CLASS if it isn't configured.
+func NewConnectionClasses(config *Config) []*ConnectionClass {
+	names := make([]string, 0, len(config.Class))
+	for name := range config.Class {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	classes := make([]*ConnectionClass, 0, len(names)+1)
+	for _, name := range names {
+		classes = append(classes, NewConnectionClass(name, config.Class[name]))
+	}
+	if config.Class[DEFAULT_CLASS] == nil {
+		classes = append(classes, NewConnectionClass(DEFAULT_CLASS, &ClassConfig{
+			SendQ: config.Server.SendQ,
+		}))
+	}
+	return classes
+}
+
+// ClassFor picks the class of a new connection.
+func (server *Server) ClassFor(listener *Listener, ip Name) *ConnectionClass {
+	var fallback *ConnectionClass
+	for _, class := range server.classes {
+		if (class.name == listener.class) || class.listeners[listener.name] ||
+			class.listeners[listener.addr] {
+			return class
+		}
+	}
+	for _, class := range server.classes {
+		if class.hosts.Match(ip) {
+			return class
+		}
+		if class.name == DEFAULT_CLASS {
+			fallback = class
+		}
+	}
+	return fallback
+}
+
+// Connections is how many clients are connected, in every class.
+func (server *Server) Connections() (connections int) {
+	for _, class := range server.classes {
+		connections += class.clients
+	}
+	return
+}
+
+// Admit checks the class's client limit and connection throttle. Tor and
+// unix socket connections share an address, so they aren't throttled.
+func (class *ConnectionClass) Admit(listener *Listener, ip Name) error {
+	if (class.maxClients > 0) && (class.clients >= class.maxClients) {
+		return ErrClassFull
+	}
+	if listener.tor || listener.unix ||
+		(class.throttle.Connections <= 0) || (class.throttle.Duration <= 0) {
+		return nil
+	}
+
+	now := time.Now()
+	if len(class.throttled) >= THROTTLE_PRUNE_SIZE {
+		for addr, state := range class.throttled {
+			if now.Sub(state.start) > class.throttle.Duration {
+				delete(class.throttled, addr)
+			}
+		}
+	}
+
+	state := class.throttled[ip]
+	if (state == nil) || (now.Sub(state.start) > class.throttle.Duration) {
+		state = &throttleState{start: now}
+		class.throttled[ip] = state
+	}
+	state.connections += 1
+	if state.connections > class.throttle.Connections {
+		return ErrThrottled
+	}
+	return nil
+}
@@ -1,51 +1,117 @@
 package irc
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"time"
 )
 
 const (
+	// defaults for connection classes
 	IDLE_TIMEOUT = time.Minute // how long before a client is considered idle
 	QUIT_TIMEOUT = time.Minute // how long after idle before a client is kicked
 )
 
 type Client struct {
-	atime        time.Time
-	authorized   bool
-	awayMessage  Text
-	capabilities CapabilitySet
-	capState     CapState
-	channels     ChannelSet
-	ctime        time.Time
-	flags        map[UserMode]bool
-	hasQuit      bool
-	hops         uint
-	hostname     Name
-	idleTimer    *time.Timer
-	nick         Name
-	quitTimer    *time.Timer
-	realname     Text
-	registered   bool
-	server       *Server
-	socket       *Socket
-	username     Name
-}
-
-func NewClient(server *Server, conn net.Conn) *Client {
+	accepted          ClientSet
+	account           Name
+	atime             time.Time
+	authorized        bool
+	awayMessage       Text
+	awayTime          time.Time
+	autoAway          bool // away only because it detached or idled
+	capabilities      CapabilitySet
+	capState          CapState
+	challenge         string // code a suspicious client must VERIFY
+	challengeFailures int
+	channels          ChannelSet
+	batch             *ReplyBatch // open batch of replies
+	bridge            *Bridge     // set on bridges and their puppets
+	class             *ConnectionClass
+	country           string // from GeoIP
+	dnsbl             *DNSBL
+	buffered          []string // replies held while detached
+	detached          bool
+	detachTimer       *time.Timer
+	ctime             time.Time
+	errorReplies      uint64 // error numerics sent, for command stats
+	ctcpCount         int
+	ctcpStart         time.Time
+	flags             map[UserMode]bool
+	gtime             time.Time
+	hasQuit           bool
+	hops              uint
+	hostname          Name
+	realHostname      Name // before any cloak or vhost
+	idleTimer         *time.Timer
+	invitedTo         ChannelSet
+	label             string
+	language          string   // picked with LANGUAGE
+	labeled           []string // replies held for the label
+	listener          *Listener
+	ltime             time.Time
+	metadata          MetadataMap
+	metadataSubs      map[string]bool   // keys subscribed to with METADATA SUB
+	multiline         *MultilineMessage // draft/multiline batch being sent
+	mutedUntil        time.Time
+	nick              Name
+	nickCount         int
+	nickHistory       []Name // previous nicks, oldest first
+	nickStart         time.Time
+	nickTimer         *time.Timer
+	operName          Name
+	operConfig        *OperConfig
+	quitTimer         *time.Timer
+	regTimer          *time.Timer
+	realname          Text
+	saslData          string // AUTHENTICATE chunks, until the last
+	saslTooLong       bool   // the chunks still coming are of refused data
+	saslMechanism     string
+	settings          AccountSettings
+	session           *Client            // the client this connection attached to
+	typing            map[Name]time.Time // when +typing was last relayed to each target
+	others            []*Client          // further connections attached to this client
+	origin            *Socket            // where the command being handled came from
+	socketCaps        CapabilitySet      // negotiated on socket, while others are attached
+	registered        bool
+	server            *Server
+	service           *Service // set for pseudo-clients
+	silence           *UserMaskSet
+	socket            *Socket
+	suspect           string // why the client must VERIFY before registering
+	username          Name
+	userHost          Name // nick!username@hostname, kept by updateUserHost
+	verified          bool
+}
+
+func NewClient(server *Server, conn net.Conn, listener *Listener,
+	class *ConnectionClass) *Client {
 	now := time.Now()
 	client := &Client{
 		atime:        now,
-		authorized:   server.password == nil,
+		accepted:     make(ClientSet),
+		authorized:   listener.password == nil,
 		capState:     CapNone,
 		capabilities: make(CapabilitySet),
 		channels:     make(ChannelSet),
+		class:        class,
 		ctime:        now,
 		flags:        make(map[UserMode]bool),
+		settings:     make(AccountSettings),
+		invitedTo:    make(ChannelSet),
+		listener:     listener,
 		server:       server,
-		socket:       NewSocket(conn),
+		silence:      NewUserMaskSet(),
+		typing:       make(map[Name]time.Time),
+		socket:       NewSocket(conn, class.sendQ, class.recvQ),
 	}
+	class.clients += 1
+	client.updateUserHost()
+	client.regTimer = time.AfterFunc(server.regTimeout,
+		client.registrationTimeout)
 	client.Touch()
 	go client.run()
 
@@ -63,12 +129,23 @@ func (client *Client) run() {
 
 	// Set the hostname for this client. The client may later send a PROXY
 	// command from stunnel that sets the hostname to something more accurate.
-	client.send(NewProxyCommand(AddrLookupHostname(
-		client.socket.conn.RemoteAddr())))
+	// Tor and unix socket clients have no useful address, so they get the
+	// listener's hostname instead.
+	if client.listener.hostname != "" {
+		client.send(NewProxyCommand(client.listener.hostname))
+	} else {
+		client.send(NewProxyCommand(AddrLookupHostname(
+			client.socket.conn.RemoteAddr())))
+		if client.server.dnsbl != nil {
+			if list := client.server.dnsbl.Check(client.IP()); list != nil {
+				client.send(NewDNSBLCommand(list))
+			}
+		}
+	}
 
 	for err == nil {
 		if line, err = client.socket.Read(); err != nil {
-			command = NewQuitCommand("connection closed")
+			command = NewDisconnectCommand("connection closed")
 
 		} else if command, err = ParseCommand(line); err != nil {
 			switch err {
@@ -83,8 +160,11 @@ func (client *Client) run() {
 			// so the read loop will continue
 			err = nil
 			continue
+		}
 
-		} else if checkPass, ok := command.(checkPasswordCommand); ok {
+		command = client.server.ServiceCommand(command)
+		if checkPass, ok := command.(checkPasswordCommand); ok {
+			command.SetClient(client)
 			checkPass.LoadPassword(client.server)
 			// Block the client thread while handling a potentially expensive
 			// password bcrypt operation. Since the server is single-threaded
@@ -103,10 +183,23 @@ func (client *Client) send(command Command) {
 	client.server.commands <- command
 }
 
+// registration timer goroutine
+
+func (client *Client) registrationTimeout() {
+	client.send(NewRegistrationTimeoutCommand())
+}
+
+// nick enforcement timer goroutine
+
+func (client *Client) nickEnforcement(nick Name) {
+	client.send(NewNickEnforcementCommand(nick))
+}
+
 // quit timer goroutine
 
 func (client *Client) connectionTimeout() {
-	client.send(NewQuitCommand("connection timeout"))
+	client.send(NewDisconnectCommand(NewText(fmt.Sprintf("Ping timeout: %d seconds",
+		int(client.class.pingTimeout.Seconds())))))
 }
 
 //
@@ -123,27 +216,41 @@ func (client *Client) connectionIdle() {
 
 func (client *Client) Active() {
 	client.atime = time.Now()
+	// back from IdleAway
+	if client.autoAway && !client.detached {
+		client.AutoAway(false)
+		client.RplUnAway()
+	}
 }
 
 func (client *Client) Touch() {
+	// a puppet's connection is its bridge's
+	if client.IsPuppet() {
+		return
+	}
 	if client.quitTimer != nil {
 		client.quitTimer.Stop()
 	}
 
 	if client.idleTimer == nil {
-		client.idleTimer = time.AfterFunc(IDLE_TIMEOUT, client.connectionIdle)
+		client.idleTimer = time.AfterFunc(client.class.pingFrequency,
+			client.connectionIdle)
 	} else {
-		client.idleTimer.Reset(IDLE_TIMEOUT)
+		client.idleTimer.Reset(client.class.pingFrequency)
 	}
 }
 
 func (client *Client) Idle() {
+	if client.detached {
+		return
+	}
 	client.Reply(RplPing(client.server))
 
 	if client.quitTimer == nil {
-		client.quitTimer = time.AfterFunc(QUIT_TIMEOUT, client.connectionTimeout)
+		client.quitTimer = time.AfterFunc(client.class.pingTimeout,
+			client.connectionTimeout)
 	} else {
-		client.quitTimer.Reset(QUIT_TIMEOUT)
+		client.quitTimer.Reset(client.class.pingTimeout)
 	}
 }
 
@@ -152,6 +259,7 @@ func (client *Client) Register() {
 		return
 	}
 	client.registered = true
+	client.regTimer.Stop()
 	client.Touch()
 }
 
@@ -165,6 +273,10 @@ func (client *Client) destroy() {
 	// clean up server
 
 	client.server.clients.Remove(client)
+	client.class.clients -= 1
+	for _, other := range client.server.clients.byNick {
+		other.accepted.Remove(client)
+	}
 
 	// clean up self
 
@@ -174,8 +286,28 @@ func (client *Client) destroy() {
 	if client.quitTimer != nil {
 		client.quitTimer.Stop()
 	}
+	if client.regTimer != nil {
+		client.regTimer.Stop()
+	}
+	if client.nickTimer != nil {
+		client.nickTimer.Stop()
+	}
+	if client.detachTimer != nil {
+		client.detachTimer.Stop()
+	}
+	if client.server.sessions[client.account.ToLower()] == client {
+		delete(client.server.sessions, client.account.ToLower())
+	}
 
-	client.socket.Close()
+	if client.bridge != nil {
+		client.destroyBridge()
+	}
+	if !client.IsPuppet() {
+		client.socket.Close()
+	}
+	for _, other := range client.others {
+		other.socket.Close()
+	}
 
 	Log.debug.Printf("%s: destroyed", client)
 }
@@ -213,11 +345,84 @@ func (c *Client) ModeString() (str string) {
 }
 
 func (c *Client) UserHost() Name {
+	return c.userHost
+}
+
+// updateUserHost renders the user mask once whenever the nick, username or
+// hostname changes, rather than for every message the client sends.
+func (c *Client) updateUserHost() {
 	username := "*"
 	if c.HasUsername() {
 		username = c.username.String()
 	}
-	return Name(fmt.Sprintf("%s!%s@%s", c.Nick(), username, c.hostname))
+	c.userHost = Name(c.Nick().String() + "!" + username + "@" +
+		c.hostname.String())
+}
+
+// UserHostReply is the RPL_USERHOST entry for c:
+// <nick>['*']'='<'+'|'-'><username>@<hostname>
+func (c *Client) UserHostReply() string {
+	oper := ""
+	if c.flags[Operator] {
+		oper = "*"
+	}
+	away := "+"
+	if c.flags[Away] {
+		away = "-"
+	}
+	return fmt.Sprintf("%s%s=%s%s@%s", c.Nick(), oper, away, c.username,
+		c.hostname)
+}
+
+// IP is the address the client connected from, which may differ from a
+// hostname given by PROXY.
+func (c *Client) IP() string {
+	if c.listener.unix || c.IsService() {
+		return UNIX_IP
+	}
+	addr := c.socket.conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// Port is the client's remote port, if it has one.
+func (c *Client) Port() string {
+	if c.listener.unix || c.IsService() {
+		return ""
+	}
+	_, port, _ := net.SplitHostPort(c.socket.conn.RemoteAddr().String())
+	return port
+}
+
+// TLSState is the state of the client's TLS connection, or nil if it
+// isn't using TLS.
+func (c *Client) TLSState() *tls.ConnectionState {
+	if c.IsService() {
+		return nil
+	}
+	conn := c.socket.conn
+	if ws, ok := conn.(WSContainer); ok {
+		conn = ws.UnderlyingConn()
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	return &state
+}
+
+// CertFP is the SHA-256 fingerprint of the client's TLS certificate, in
+// hex, or empty if it didn't give one.
+func (c *Client) CertFP() string {
+	state := c.TLSState()
+	if (state == nil) || (len(state.PeerCertificates) == 0) {
+		return ""
+	}
+	sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+	return hex.EncodeToString(sum[:])
 }
 
 func (c *Client) Nick() Name {
@@ -235,6 +440,61 @@ func (c *Client) String() string {
 	return c.Id().String()
 }
 
+// OperConfig is the operator block the client opered with, or an empty
+// one once it is no longer +o.
+func (client *Client) OperConfig() *OperConfig {
+	if !client.flags[Operator] || (client.operConfig == nil) {
+		return &OperConfig{}
+	}
+	return client.operConfig
+}
+
+// IsSilencing reports whether messages from source should be dropped
+// before they reach client.
+func (client *Client) IsSilencing(source *Client) bool {
+	return client.silence.Match(source.UserHost())
+}
+
+// CanMessage applies the identified-pms account setting and caller-id
+// (+g): only accepted clients may send private messages. Blocked senders
+// are told, and the target notified at most once per
+// CALLERID_NOTIFY_INTERVAL for each sender.
+func (client *Client) CanMessage(source *Client, notify bool) bool {
+	if client.settings.Flag(SettingIdentifiedPM) && (client != source) &&
+		(source.account == "") && !source.flags[Operator] && !source.IsService() {
+		if notify {
+			source.ErrNeedReggedNick(client)
+		}
+		return false
+	}
+	if !client.flags[CallerID] || (client == source) ||
+		client.accepted.Has(source) {
+		return true
+	}
+	if !notify {
+		return false
+	}
+
+	source.ErrTargUModeG(client)
+	if time.Since(source.gtime) >= CALLERID_NOTIFY_INTERVAL {
+		source.gtime = time.Now()
+		client.RplUModeGMsg(source)
+		source.RplTargNotify(client)
+	}
+	return false
+}
+
+// NotifyAway tells friends with away-notify that client's away status
+// changed.
+func (client *Client) NotifyAway() {
+	reply := RplAwayNotify(client)
+	for friend := range client.Friends() {
+		if (friend != client) && friend.capabilities[AwayNotify] {
+			friend.Reply(reply)
+		}
+	}
+}
+
 func (client *Client) Friends() ClientSet {
 	friends := make(ClientSet)
 	friends.Add(client)
@@ -252,6 +512,7 @@ func (client *Client) SetNickname(nickname Name) {
 		return
 	}
 	client.nick = nickname
+	client.updateUserHost()
 	client.server.clients.Add(client)
 }
 
@@ -260,33 +521,111 @@ func (client *Client) ChangeNickname(nickname Name) {
 	reply := RplNick(client, nickname)
 	client.server.clients.Remove(client)
 	client.server.whoWas.Append(client)
+	client.rememberNick(client.nick)
+	for channel := range client.channels {
+		channel.Log("nick", client, nickname, "")
+	}
 	client.nick = nickname
+	client.updateUserHost()
 	client.server.clients.Add(client)
+	client.Friends().Broadcast(func(CapabilitySet) string {
+		return reply
+	})
+}
+
+// ChangeHost changes the client's user mask, telling it and the members
+// of its channels that have the chghost capability.
+func (client *Client) ChangeHost(username Name, hostname Name) {
+	if (username == client.username) && (hostname == client.hostname) {
+		return
+	}
+	reply := RplChgHost(client, username, hostname)
+	if client.HasNick() {
+		client.server.clients.db.Remove(client)
+	}
+	client.username = client.server.names.Intern(username)
+	client.hostname = client.server.names.Intern(hostname)
+	client.updateUserHost()
+	if client.HasNick() {
+		client.server.clients.db.Add(client)
+	}
+	if !client.registered {
+		return
+	}
 	for friend := range client.Friends() {
-		friend.Reply(reply)
+		if friend.capabilities[ChgHost] {
+			friend.Reply(reply)
+		}
 	}
 }
 
 func (client *Client) Reply(reply string) error {
+	if client.IsService() {
+		return nil
+	}
+	if (client.batch != nil) && !inBatch(reply) {
+		reply = AddTags(reply, Tags{BATCH_TAG: client.batch.id})
+	}
+	if client.labeled != nil {
+		client.labeled = append(client.labeled, reply)
+		return nil
+	}
+	if client.detached {
+		client.bufferReply(reply)
+		return nil
+	}
+	if client.IsPuppet() {
+		return client.PuppetReply(reply)
+	}
+	for _, other := range client.others {
+		other.socket.Write(reply)
+	}
 	return client.socket.Write(reply)
 }
 
+// Echo sends a client its own message if it asked for echo-message.
+// Otherwise only its other attached connections see it.
+func (client *Client) Echo(reply string) {
+	if client.capabilities[EchoMessage] {
+		client.Reply(reply)
+		return
+	}
+	if len(client.others) == 0 {
+		return
+	}
+	if (client.batch != nil) && !inBatch(reply) {
+		reply = AddTags(reply, Tags{BATCH_TAG: client.batch.id})
+	}
+	if client.socket != client.origin {
+		client.socket.Write(reply)
+	}
+	for _, other := range client.others {
+		if other.socket != client.origin {
+			other.socket.Write(reply)
+		}
+	}
+}
+
 func (client *Client) Quit(message Text) {
-	if client.hasQuit {
+	if client.hasQuit || client.IsService() {
 		return
 	}
 
 	client.hasQuit = true
+	client.FlushLabel()
 	client.Reply(RplError("quit"))
 	client.server.whoWas.Append(client)
+	client.StreamQuit(message)
+	for channel := range client.channels {
+		channel.Log("quit", client, "", message)
+	}
 	friends := client.Friends()
 	friends.Remove(client)
 	client.destroy()
 
 	if len(friends) > 0 {
-		reply := RplQuit(client, message)
-		for friend := range friends {
-			friend.Reply(reply)
-		}
+		friends.Broadcast(func(CapabilitySet) string {
+			return RplQuit(client, message)
+		})
 	}
 }
@@ -42,14 +42,16 @@ func QuoteLike(userhost Name) string {
 }
 
 type ClientLookupSet struct {
-	byNick map[Name]*Client
-	db     *ClientDB
+	byNick     map[Name]*Client
+	bySkeleton map[string]ClientSet
+	db         *ClientDB
 }
 
 func NewClientLookupSet() *ClientLookupSet {
 	return &ClientLookupSet{
-		byNick: make(map[Name]*Client),
-		db:     NewClientDB(),
+		byNick:     make(map[Name]*Client),
+		bySkeleton: make(map[string]ClientSet),
+		db:         NewClientDB(),
 	}
 }
 
@@ -65,6 +67,11 @@ func (clients *ClientLookupSet) Add(client *Client) error {
 		return ErrNicknameInUse
 	}
 	clients.byNick[client.Nick().ToLower()] = client
+	skeleton := Skeleton(client.nick)
+	if clients.bySkeleton[skeleton] == nil {
+		clients.bySkeleton[skeleton] = make(ClientSet)
+	}
+	clients.bySkeleton[skeleton].Add(client)
 	clients.db.Add(client)
 	return nil
 }
@@ -77,6 +84,11 @@ func (clients *ClientLookupSet) Remove(client *Client) error {
 		return ErrNicknameMismatch
 	}
 	delete(clients.byNick, client.nick.ToLower())
+	skeleton := Skeleton(client.nick)
+	clients.bySkeleton[skeleton].Remove(client)
+	if len(clients.bySkeleton[skeleton]) == 0 {
+		delete(clients.bySkeleton, skeleton)
+	}
 	clients.db.Remove(client)
 	return nil
 }
@@ -234,7 +246,7 @@ func (set *UserMaskSet) String() string {
 // `?`. All the pieces are meta-escaped. `*` is replaced with `.*`,
 // the regexp equivalent. Likewise, `?` is replaced with `.`. The
 // parts are re-joined and finally all masks are joined into a big
-// or-expression.
+// case-insensitive or-expression.
 func (set *UserMaskSet) setRegexp() {
 	if len(set.masks) == 0 {
 		set.regexp = nil
@@ -255,7 +267,8 @@ func (set *UserMaskSet) setRegexp() {
 			manyExprs[mindex] = strings.Join(oneExprs, ".")
 		}
 		maskExprs[index] = strings.Join(manyExprs, ".*")
+		index += 1
 	}
-	expr := "^" + strings.Join(maskExprs, "|") + "$"
+	expr := "(?i)^(" + strings.Join(maskExprs, "|") + ")$"
 	set.regexp, _ = regexp.Compile(expr)
 }
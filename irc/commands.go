@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Command interface {
@@ -13,6 +14,8 @@ type Command interface {
 	Code() StringCode
 	SetClient(*Client)
 	SetCode(StringCode)
+	Tags() Tags
+	SetTags(Tags)
 }
 
 type checkPasswordCommand interface {
@@ -26,43 +29,87 @@ var (
 	NotEnoughArgsError = errors.New("not enough arguments")
 	ErrParseCommand    = errors.New("failed to parse message")
 	parseCommandFuncs  = map[StringCode]parseCommandFunc{
-		AWAY:    ParseAwayCommand,
-		CAP:     ParseCapCommand,
-		DEBUG:   ParseDebugCommand,
-		INVITE:  ParseInviteCommand,
-		ISON:    ParseIsOnCommand,
-		JOIN:    ParseJoinCommand,
-		KICK:    ParseKickCommand,
-		KILL:    ParseKillCommand,
-		LIST:    ParseListCommand,
-		MODE:    ParseModeCommand,
-		MOTD:    ParseMOTDCommand,
-		NAMES:   ParseNamesCommand,
-		NICK:    ParseNickCommand,
-		NOTICE:  ParseNoticeCommand,
-		ONICK:   ParseOperNickCommand,
-		OPER:    ParseOperCommand,
-		PART:    ParsePartCommand,
-		PASS:    ParsePassCommand,
-		PING:    ParsePingCommand,
-		PONG:    ParsePongCommand,
-		PRIVMSG: ParsePrivMsgCommand,
-		PROXY:   ParseProxyCommand,
-		QUIT:    ParseQuitCommand,
-		THEATER: ParseTheaterCommand, // nonstandard
-		TIME:    ParseTimeCommand,
-		TOPIC:   ParseTopicCommand,
-		USER:    ParseUserCommand,
-		VERSION: ParseVersionCommand,
-		WHO:     ParseWhoCommand,
-		WHOIS:   ParseWhoisCommand,
-		WHOWAS:  ParseWhoWasCommand,
+		ACCEPT:       ParseAcceptCommand,
+		ADMIN:        ParseAdminCommand,
+		AUDIT:        ParseAuditCommand, // nonstandard
+		AUTHENTICATE: ParseAuthenticateCommand,
+		AWAY:         ParseAwayCommand,
+		BATCH:        ParseBatchCommand,
+		BRIDGE:       ParseBridgeCommand, // nonstandard
+		CHGHOST:      ParseChgHostCommand,
+		CAP:          ParseCapCommand,
+		CERT:         ParseCertCommand, // nonstandard
+		DEBUG:        ParseDebugCommand,
+		DEFCON:       ParseDefconCommand,       // nonstandard
+		GLOBALNOTICE: ParseGlobalNoticeCommand, // nonstandard
+		GLOBOPS:      ParseGlobopsCommand,      // nonstandard
+		HELP:         ParseHelpCommand,
+		HELPOP:       ParseHelpCommand,     // nonstandard
+		HOSTSERV:     ParseHostServCommand, // nonstandard
+		HS:           ParseHostServCommand, // nonstandard
+		INFO:         ParseInfoCommand,
+		INVITE:       ParseInviteCommand,
+		ISON:         ParseIsOnCommand,
+		JOIN:         ParseJoinCommand,
+		KICK:         ParseKickCommand,
+		KILL:         ParseKillCommand,
+		KLINE:        ParseKLineCommand,
+		LANGUAGE:     ParseLanguageCommand, // nonstandard
+		LIST:         ParseListCommand,
+		LUSERS:       ParseLUsersCommand,
+		MAP:          ParseMapCommand,
+		MEMOSERV:     ParseMemoServCommand, // nonstandard
+		METADATA:     ParseMetadataCommand,
+		MODE:         ParseModeCommand,
+		MOTD:         ParseMOTDCommand,
+		MS:           ParseMemoServCommand, // nonstandard
+		NAMES:        ParseNamesCommand,
+		NICK:         ParseNickCommand,
+		NICKSERV:     ParseNickServCommand, // nonstandard
+		NOTICE:       ParseNoticeCommand,
+		NPC:          ParseNPCCommand,       // nonstandard
+		NPCA:         ParseNPCActionCommand, // nonstandard
+		NS:           ParseNickServCommand,  // nonstandard
+		ONICK:        ParseOperNickCommand,
+		OPER:         ParseOperCommand,
+		OPERWALL:     ParseGlobopsCommand, // nonstandard
+		PART:         ParsePartCommand,
+		PASS:         ParsePassCommand,
+		PING:         ParsePingCommand,
+		PONG:         ParsePongCommand,
+		PRIVMSG:      ParsePrivMsgCommand,
+		PROXY:        ParseProxyCommand,
+		QUIT:         ParseQuitCommand,
+		REHASH:       ParseRehashCommand,
+		REMOVE:       ParseRemoveCommand,  // nonstandard
+		SCENE:        ParseSceneCommand,   // nonstandard
+		SILENCE:      ParseSilenceCommand, // nonstandard
+		SETNAME:      ParseSetNameCommand,
+		STATS:        ParseStatsCommand,
+		SVSLOGIN:     ParseSvsLoginCommand, // nonstandard
+		SVSMODE:      ParseSvsModeCommand,  // nonstandard
+		SVSNICK:      ParseSvsNickCommand,  // nonstandard
+		TAGMSG:       ParseTagMsgCommand,
+		THEATER:      ParseTheaterCommand, // nonstandard
+		TIME:         ParseTimeCommand,
+		TOPIC:        ParseTopicCommand,
+		TRACE:        ParseTraceCommand,
+		UNKLINE:      ParseUnKLineCommand,
+		USER:         ParseUserCommand,
+		USERHOST:     ParseUserHostCommand,
+		VERIFY:       ParseVerifyCommand, // nonstandard
+		VERSION:      ParseVersionCommand,
+		WALLOPS:      ParseWallopsCommand,
+		WHO:          ParseWhoCommand,
+		WHOIS:        ParseWhoisCommand,
+		WHOWAS:       ParseWhoWasCommand,
 	}
 )
 
 type BaseCommand struct {
 	client *Client
 	code   StringCode
+	tags   Tags
 }
 
 func (command *BaseCommand) Client() *Client {
@@ -81,7 +128,16 @@ func (command *BaseCommand) SetCode(code StringCode) {
 	command.code = code
 }
 
+func (command *BaseCommand) Tags() Tags {
+	return command.tags
+}
+
+func (command *BaseCommand) SetTags(tags Tags) {
+	command.tags = tags
+}
+
 func ParseCommand(line string) (cmd Command, err error) {
+	tags, line := ParseTags(line)
 	code, args := ParseLine(line)
 	constructor := parseCommandFuncs[code]
 	if constructor == nil {
@@ -91,6 +147,7 @@ func ParseCommand(line string) (cmd Command, err error) {
 	}
 	if cmd != nil {
 		cmd.SetCode(code)
+		cmd.SetTags(tags)
 	}
 	return
 }
@@ -190,13 +247,25 @@ type PassCommand struct {
 	hash     []byte
 	password []byte
 	err      error
+
+	// a bearer token given instead of the server password
+	verifier *TokenVerifier
+	account  Name
 }
 
 func (cmd *PassCommand) LoadPassword(server *Server) {
-	cmd.hash = server.password
+	if (server.tokenVerifier != nil) && LooksLikeJWT(string(cmd.password)) {
+		cmd.verifier = server.tokenVerifier
+		return
+	}
+	cmd.hash = cmd.Client().listener.password
 }
 
 func (cmd *PassCommand) CheckPassword() {
+	if cmd.verifier != nil {
+		cmd.account, cmd.err = cmd.verifier.Verify(string(cmd.password))
+		return
+	}
 	if cmd.hash == nil {
 		return
 	}
@@ -282,7 +351,8 @@ func ParseUserCommand(args []string) (Command, error) {
 
 type QuitCommand struct {
 	BaseCommand
-	message Text
+	message      Text
+	disconnected bool // the connection was lost rather than quit
 }
 
 func NewQuitCommand(message Text) *QuitCommand {
@@ -293,6 +363,40 @@ func NewQuitCommand(message Text) *QuitCommand {
 	return cmd
 }
 
+// NewDisconnectCommand is sent when a client's connection closes or times
+// out. Always-on clients detach instead of quitting.
+func NewDisconnectCommand(message Text) *QuitCommand {
+	cmd := NewQuitCommand(message)
+	cmd.disconnected = true
+	return cmd
+}
+
+// RegistrationTimeoutCommand is sent by a client's registration timer. It
+// only quits clients that still haven't registered.
+type RegistrationTimeoutCommand struct {
+	BaseCommand
+}
+
+func NewRegistrationTimeoutCommand() *RegistrationTimeoutCommand {
+	cmd := &RegistrationTimeoutCommand{}
+	cmd.code = QUIT
+	return cmd
+}
+
+// NickEnforcementCommand is sent by a client's nick enforcement timer.
+type NickEnforcementCommand struct {
+	BaseCommand
+	nick Name
+}
+
+func NewNickEnforcementCommand(nick Name) *NickEnforcementCommand {
+	cmd := &NickEnforcementCommand{
+		nick: nick,
+	}
+	cmd.code = NICK
+	return cmd
+}
+
 func ParseQuitCommand(args []string) (Command, error) {
 	msg := &QuitCommand{}
 	if len(args) > 0 {
@@ -386,6 +490,80 @@ func ParsePrivMsgCommand(args []string) (Command, error) {
 	}, nil
 }
 
+// TAGMSG <target>
+
+type TagMsgCommand struct {
+	BaseCommand
+	target Name
+}
+
+func ParseTagMsgCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &TagMsgCommand{
+		target: NewName(args[0]),
+	}, nil
+}
+
+// WALLOPS <message>
+// GLOBOPS <message>
+
+type WallopsCommand struct {
+	BaseCommand
+	message Text
+}
+
+type GlobopsCommand struct {
+	BaseCommand
+	message Text
+}
+
+func ParseWallopsCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &WallopsCommand{
+		message: NewText(args[0]),
+	}, nil
+}
+
+func ParseGlobopsCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &GlobopsCommand{
+		message: NewText(args[0]),
+	}, nil
+}
+
+// GLOBALNOTICE [-<modes>] <message>
+//
+// Clients with any of the user modes after a leading - are left out. The
+// message may be several arguments, as it is when /msg Global is turned
+// into GLOBALNOTICE.
+
+type GlobalNoticeCommand struct {
+	BaseCommand
+	exclude []UserMode
+	message Text
+}
+
+func ParseGlobalNoticeCommand(args []string) (Command, error) {
+	cmd := &GlobalNoticeCommand{}
+	if (len(args) > 1) && strings.HasPrefix(args[0], "-") {
+		for _, mode := range args[0][1:] {
+			cmd.exclude = append(cmd.exclude, UserMode(mode))
+		}
+		args = args[1:]
+	}
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	cmd.message = NewText(strings.Join(args, " "))
+	return cmd, nil
+}
+
 // TOPIC [newtopic]
 
 type TopicCommand struct {
@@ -491,15 +669,12 @@ func (change *ChannelModeChange) String() (str string) {
 type ChannelModeChanges []*ChannelModeChange
 
 func (changes ChannelModeChanges) String() (str string) {
-	if len(changes) == 0 {
-		return
-	}
-
-	str = "+"
-	if changes[0].op == Remove {
-		str = "-"
-	}
+	var op ModeOp
 	for _, change := range changes {
+		if change.op != op {
+			op = change.op
+			str += op.String()
+		}
 		str += change.mode.String()
 	}
 	for _, change := range changes {
@@ -544,13 +719,10 @@ func ParseChannelModeCommand(channel Name, args []string) (Command, error) {
 				mode: ChannelMode(mode),
 				op:   op,
 			}
-			switch change.mode {
-			case Key, BanMask, ExceptMask, InviteMask, UserLimit,
-				ChannelOperator, ChannelCreator, Voice:
-				if len(args) > skipArgs {
-					change.arg = args[skipArgs]
-					skipArgs += 1
-				}
+			def := ChannelModeDefFor(change.mode)
+			if (def != nil) && def.HasArg(op) && (len(args) > skipArgs) {
+				change.arg = args[skipArgs]
+				skipArgs += 1
 			}
 			cmd.changes = append(cmd.changes, change)
 		}
@@ -601,13 +773,52 @@ func ParseWhoisCommand(args []string) (Command, error) {
 	}, nil
 }
 
+// WhoField is a WHOX field letter.
+type WhoField rune
+
+const (
+	WhoToken    WhoField = 't'
+	WhoChannel  WhoField = 'c'
+	WhoUser     WhoField = 'u'
+	WhoIP       WhoField = 'i'
+	WhoHost     WhoField = 'h'
+	WhoServer   WhoField = 's'
+	WhoNick     WhoField = 'n'
+	WhoFlags    WhoField = 'f'
+	WhoHops     WhoField = 'd'
+	WhoIdle     WhoField = 'l'
+	WhoAccount  WhoField = 'a'
+	WhoOpLevel  WhoField = 'o'
+	WhoRealname WhoField = 'r'
+)
+
+var (
+	// WhoFieldOrder is the order of fields in RPL_WHOSPCRPL, whatever order
+	// they were requested in.
+	WhoFieldOrder = []WhoField{WhoToken, WhoChannel, WhoUser, WhoIP, WhoHost,
+		WhoServer, WhoNick, WhoFlags, WhoHops, WhoIdle, WhoAccount,
+		WhoOpLevel, WhoRealname}
+)
+
+type WhoFields map[WhoField]bool
+
+func NewWhoFields(str string) WhoFields {
+	fields := make(WhoFields)
+	for _, field := range str {
+		fields[WhoField(field)] = true
+	}
+	return fields
+}
+
 type WhoCommand struct {
 	BaseCommand
 	mask         Name
 	operatorOnly bool
+	fields       WhoFields // WHOX fields; nil for a plain WHO
+	token        string
 }
 
-// WHO [ <mask> [ "o" ] ]
+// WHO [ <mask> [ "o" ][ "%" <fields>[ "," <token> ] ] ]
 func ParseWhoCommand(args []string) (Command, error) {
 	cmd := &WhoCommand{}
 
@@ -615,8 +826,18 @@ func ParseWhoCommand(args []string) (Command, error) {
 		cmd.mask = NewName(args[0])
 	}
 
-	if (len(args) > 1) && (args[1] == "o") {
-		cmd.operatorOnly = true
+	if len(args) > 1 {
+		options := args[1]
+		if index := strings.IndexByte(options, '%'); index >= 0 {
+			fields := options[index+1:]
+			options = options[:index]
+			if comma := strings.IndexByte(fields, ','); comma >= 0 {
+				cmd.token = fields[comma+1:]
+				fields = fields[:comma]
+			}
+			cmd.fields = NewWhoFields(fields)
+		}
+		cmd.operatorOnly = strings.Contains(options, "o")
 	}
 
 	return cmd, nil
@@ -727,8 +948,31 @@ func ParseIsOnCommand(args []string) (Command, error) {
 		return nil, NotEnoughArgsError
 	}
 
+	// clients may send the nicks as separate params or in a single trailing
+	// param
 	return &IsOnCommand{
-		nicks: NewNames(args),
+		nicks: NewNames(strings.Fields(strings.Join(args, " "))),
+	}, nil
+}
+
+// USERHOST <nickname>{ <nickname>}
+
+type UserHostCommand struct {
+	BaseCommand
+	nicks []Name
+}
+
+func ParseUserHostCommand(args []string) (Command, error) {
+	if len(args) == 0 {
+		return nil, NotEnoughArgsError
+	}
+
+	nicks := strings.Fields(strings.Join(args, " "))
+	if len(nicks) > USERHOST_MAX {
+		nicks = nicks[:USERHOST_MAX]
+	}
+	return &UserHostCommand{
+		nicks: NewNames(nicks),
 	}, nil
 }
 
@@ -799,16 +1043,166 @@ func ParseKickCommand(args []string) (Command, error) {
 	return cmd, nil
 }
 
+// ListCondition is an ELIST condition on a channel's member count,
+// creation time or topic age.
+type ListCondition func(*Channel) bool
+
+// REMOVE <channel> <nickname> [ <comment> ]
+
+type RemoveCommand struct {
+	BaseCommand
+	channel  Name
+	nickname Name
+	comment  Text
+}
+
+func (msg *RemoveCommand) Comment() Text {
+	if msg.comment == "" {
+		return msg.Client().Nick().Text()
+	}
+	return msg.comment
+}
+
+func ParseRemoveCommand(args []string) (Command, error) {
+	if len(args) < 2 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &RemoveCommand{
+		channel:  NewName(args[0]),
+		nickname: NewName(args[1]),
+	}
+	if len(args) > 2 {
+		cmd.comment = NewText(args[2])
+	}
+	return cmd, nil
+}
+
 type ListCommand struct {
 	BaseCommand
-	channels []Name
-	target   Name
+	channels   []Name
+	masks      *UserMaskSet
+	notMasks   *UserMaskSet
+	conditions []ListCondition
+	target     Name
+}
+
+// IsSearch is true when the command uses ELIST extensions rather than
+// naming channels outright.
+func (cmd *ListCommand) IsSearch() bool {
+	return (len(cmd.masks.masks) > 0) || (len(cmd.notMasks.masks) > 0) ||
+		(len(cmd.conditions) > 0)
+}
+
+func (cmd *ListCommand) Match(channel *Channel) bool {
+	name := channel.name.ToLower()
+	if (len(cmd.masks.masks) > 0) || (len(cmd.channels) > 0) {
+		found := cmd.masks.Match(name)
+		for _, chname := range cmd.channels {
+			if chname.ToLower() == name {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if cmd.notMasks.Match(name) {
+		return false
+	}
+	for _, condition := range cmd.conditions {
+		if !condition(channel) {
+			return false
+		}
+	}
+	return true
 }
 
+func parseListMinutes(arg string) (time.Duration, error) {
+	minutes, err := strconv.ParseUint(arg, 10, 32)
+	return time.Duration(minutes) * time.Minute, err
+}
+
+// parseListCondition parses the ELIST forms ">n" and "<n" (users), and
+// "C>n", "C<n", "T>n" and "T<n" (minutes since creation or topic change).
+func parseListCondition(arg string) (ListCondition, error) {
+	switch {
+	case strings.HasPrefix(arg, ">"), strings.HasPrefix(arg, "<"):
+		count, err := strconv.ParseUint(arg[1:], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if arg[0] == '>' {
+			return func(channel *Channel) bool {
+				return uint64(len(channel.members)) > count
+			}, nil
+		}
+		return func(channel *Channel) bool {
+			return uint64(len(channel.members)) < count
+		}, nil
+
+	case strings.HasPrefix(arg, "C>"), strings.HasPrefix(arg, "C<"):
+		age, err := parseListMinutes(arg[2:])
+		if err != nil {
+			return nil, err
+		}
+		if arg[1] == '>' {
+			return func(channel *Channel) bool {
+				return time.Since(channel.ctime) > age
+			}, nil
+		}
+		return func(channel *Channel) bool {
+			return time.Since(channel.ctime) < age
+		}, nil
+
+	case strings.HasPrefix(arg, "T>"), strings.HasPrefix(arg, "T<"):
+		age, err := parseListMinutes(arg[2:])
+		if err != nil {
+			return nil, err
+		}
+		if arg[1] == '>' {
+			return func(channel *Channel) bool {
+				return !channel.topicTime.IsZero() &&
+					(time.Since(channel.topicTime) > age)
+			}, nil
+		}
+		return func(channel *Channel) bool {
+			return !channel.topicTime.IsZero() &&
+				(time.Since(channel.topicTime) < age)
+		}, nil
+	}
+	return nil, nil
+}
+
+// LIST [ <channel> *( "," <channel> ) [ <target> ] ]
+// Channels may also be ELIST masks ("*foo*", "!*bar*") or conditions.
 func ParseListCommand(args []string) (Command, error) {
-	cmd := &ListCommand{}
+	cmd := &ListCommand{
+		masks:    NewUserMaskSet(),
+		notMasks: NewUserMaskSet(),
+	}
 	if len(args) > 0 {
-		cmd.channels = NewNames(strings.Split(args[0], ","))
+		for _, arg := range strings.Split(args[0], ",") {
+			if arg == "" {
+				continue
+			}
+			condition, err := parseListCondition(arg)
+			if err != nil {
+				return nil, ErrParseCommand
+			}
+			switch {
+			case condition != nil:
+				cmd.conditions = append(cmd.conditions, condition)
+
+			case strings.HasPrefix(arg, "!"):
+				cmd.notMasks.Add(NewName(arg[1:]).ToLower())
+
+			case HasWildcards(arg):
+				cmd.masks.Add(NewName(arg).ToLower())
+
+			default:
+				cmd.channels = append(cmd.channels, NewName(arg))
+			}
+		}
 	}
 	if len(args) > 1 {
 		cmd.target = NewName(args[1])
@@ -861,6 +1255,82 @@ func ParseVersionCommand(args []string) (Command, error) {
 	return cmd, nil
 }
 
+// ADMIN [ <target> ]
+
+type AdminCommand struct {
+	BaseCommand
+	target Name
+}
+
+func ParseAdminCommand(args []string) (Command, error) {
+	cmd := &AdminCommand{}
+	if len(args) > 0 {
+		cmd.target = NewName(args[0])
+	}
+	return cmd, nil
+}
+
+// REHASH
+
+type RehashCommand struct {
+	BaseCommand
+}
+
+func ParseRehashCommand(args []string) (Command, error) {
+	return &RehashCommand{}, nil
+}
+
+// STATS <query> [ <target> ]
+
+type StatsCommand struct {
+	BaseCommand
+	query  StatsQuery
+	target Name
+}
+
+func ParseStatsCommand(args []string) (Command, error) {
+	if (len(args) < 1) || (args[0] == "") {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &StatsCommand{
+		query: StatsQuery([]rune(args[0])[0]),
+	}
+	if len(args) > 1 {
+		cmd.target = NewName(args[1])
+	}
+	return cmd, nil
+}
+
+// INFO [ <target> ]
+
+type InfoCommand struct {
+	BaseCommand
+	target Name
+}
+
+func ParseInfoCommand(args []string) (Command, error) {
+	cmd := &InfoCommand{}
+	if len(args) > 0 {
+		cmd.target = NewName(args[0])
+	}
+	return cmd, nil
+}
+
+// LUSERS [ <mask> [ <target> ] ]
+
+type LUsersCommand struct {
+	BaseCommand
+	target Name
+}
+
+func ParseLUsersCommand(args []string) (Command, error) {
+	cmd := &LUsersCommand{}
+	if len(args) > 1 {
+		cmd.target = NewName(args[1])
+	}
+	return cmd, nil
+}
+
 type InviteCommand struct {
 	BaseCommand
 	nickname Name
@@ -881,7 +1351,11 @@ func ParseInviteCommand(args []string) (Command, error) {
 func ParseTheaterCommand(args []string) (Command, error) {
 	if len(args) < 1 {
 		return nil, NotEnoughArgsError
-	} else if upperSubCmd := strings.ToUpper(args[0]); upperSubCmd == "IDENTIFY" && len(args) == 3 {
+	} else if upperSubCmd := strings.ToUpper(args[0]); upperSubCmd == "IDENTIFY" && len(args) == 2 {
+		return &TheaterIdentifyCommand{
+			channel: NewName(args[1]),
+		}, nil
+	} else if upperSubCmd == "IDENTIFY" && len(args) == 3 {
 		return &TheaterIdentifyCommand{
 			channel:     NewName(args[1]),
 			PassCommand: PassCommand{password: []byte(args[2])},
@@ -898,11 +1372,103 @@ func ParseTheaterCommand(args []string) (Command, error) {
 			asNick:  NewName(args[2]),
 			action:  NewCTCPText(args[3]),
 		}, nil
+	} else if upperSubCmd == "ACTORS" && len(args) == 2 {
+		return &TheaterActorsCommand{
+			channel: NewName(args[1]),
+		}, nil
+	} else if upperSubCmd == "HANDOFF" && len(args) == 3 {
+		return &TheaterHandoffCommand{
+			channel: NewName(args[1]),
+			nick:    NewName(args[2]),
+		}, nil
+	} else if upperSubCmd == "ACCESS" && len(args) == 2 {
+		return &TheaterAccessCommand{
+			channel: NewName(args[1]),
+			op:      List,
+		}, nil
+	} else if upperSubCmd == "ACCESS" && len(args) == 4 {
+		var op ModeOp
+		switch strings.ToUpper(args[2]) {
+		case "ADD":
+			op = Add
+		case "DEL":
+			op = Remove
+		default:
+			return nil, ErrParseCommand
+		}
+		return &TheaterAccessCommand{
+			channel: NewName(args[1]),
+			op:      op,
+			account: NewName(args[3]),
+		}, nil
 	} else {
 		return nil, ErrParseCommand
 	}
 }
 
+// ACCEPT {*|[-]<nick>[,...]}
+
+type AcceptCommand struct {
+	BaseCommand
+	list   bool
+	add    []Name
+	remove []Name
+}
+
+func ParseAcceptCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &AcceptCommand{}
+	for _, nick := range strings.Split(args[0], ",") {
+		switch {
+		case nick == "*":
+			cmd.list = true
+		case strings.HasPrefix(nick, "-"):
+			cmd.remove = append(cmd.remove, NewName(nick[1:]))
+		case nick != "":
+			cmd.add = append(cmd.add, NewName(nick))
+		}
+	}
+	return cmd, nil
+}
+
+type SilenceChange struct {
+	op   ModeOp
+	mask Name
+}
+
+// SILENCE [{+|-}<mask>[,...]]
+
+type SilenceCommand struct {
+	BaseCommand
+	changes []SilenceChange
+}
+
+func ParseSilenceCommand(args []string) (Command, error) {
+	cmd := &SilenceCommand{}
+	if len(args) == 0 {
+		return cmd, nil
+	}
+	for _, arg := range strings.Split(args[0], ",") {
+		if arg == "" {
+			continue
+		}
+		change := SilenceChange{op: Add}
+		switch ModeOp(arg[0]) {
+		case Add, Remove:
+			change.op = ModeOp(arg[0])
+			arg = arg[1:]
+		}
+		if arg == "" {
+			continue
+		}
+		change.mask = ExpandUserHost(NewName(arg))
+		cmd.changes = append(cmd.changes, change)
+	}
+	return cmd, nil
+}
+
 type TimeCommand struct {
 	BaseCommand
 	target Name
@@ -0,0 +1,127 @@
+package irc
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Each command handled by the server goroutine is counted and timed, so
+// that hot and slow handlers show up in STATS m and the API's metrics.
+// Commands taking longer than server.slow-command are logged.
+
+var (
+	// upper bounds of the latency histogram buckets; the last is +Inf
+	CommandLatencyBuckets = []time.Duration{
+		100 * time.Microsecond,
+		time.Millisecond,
+		10 * time.Millisecond,
+		100 * time.Millisecond,
+		time.Second,
+	}
+)
+
+type CommandStats struct {
+	count   uint64
+	errors  uint64 // times an error numeric was sent
+	total   time.Duration
+	max     time.Duration
+	buckets []uint64 // by CommandLatencyBuckets, then +Inf
+}
+
+func NewCommandStats() *CommandStats {
+	return &CommandStats{
+		buckets: make([]uint64, len(CommandLatencyBuckets)+1),
+	}
+}
+
+func (stats *CommandStats) Record(latency time.Duration, failed bool) {
+	stats.count += 1
+	if failed {
+		stats.errors += 1
+	}
+	stats.total += latency
+	if latency > stats.max {
+		stats.max = latency
+	}
+	bucket := len(CommandLatencyBuckets)
+	for index, bound := range CommandLatencyBuckets {
+		if latency <= bound {
+			bucket = index
+			break
+		}
+	}
+	stats.buckets[bucket] += 1
+}
+
+func (stats *CommandStats) Mean() time.Duration {
+	if stats.count == 0 {
+		return 0
+	}
+	return stats.total / time.Duration(stats.count)
+}
+
+// IsError reports whether code is an error numeric.
+func (code NumericCode) IsError() bool {
+	return ((code >= 400) && (code < 600)) || (code == ERR_SASLFAIL) ||
+		(code == ERR_SASLABORTED) || (code == ERR_SASLALREADY)
+}
+
+//
+// server goroutine
+//
+
+// RecordCommand counts a command client sent and how long it took.
+func (server *Server) RecordCommand(client *Client, code StringCode,
+	latency time.Duration, failed bool) {
+	stats := server.commandStats[code]
+	if stats == nil {
+		stats = NewCommandStats()
+		server.commandStats[code] = stats
+	}
+	stats.Record(latency, failed)
+	if (server.slowCommand > 0) && (latency >= server.slowCommand) {
+		Log.info.Printf("%s: slow %s took %s", client, code, latency)
+	}
+}
+
+func (server *Server) commandCodes() []string {
+	codes := make([]string, 0, len(server.commandStats))
+	for code := range server.commandStats {
+		codes = append(codes, code.String())
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// apiMetrics shows the command stats in the Prometheus text format.
+func (server *Server) apiMetrics(user Name) (interface{}, error) {
+	var buffer bytes.Buffer
+	fmt.Fprintln(&buffer, "# TYPE ergonomadic_commands_total counter")
+	fmt.Fprintln(&buffer, "# TYPE ergonomadic_command_errors_total counter")
+	fmt.Fprintln(&buffer, "# TYPE ergonomadic_command_seconds histogram")
+	for _, code := range server.commandCodes() {
+		stats := server.commandStats[StringCode(code)]
+		fmt.Fprintf(&buffer, "ergonomadic_commands_total{command=%q} %d\n",
+			code, stats.count)
+		fmt.Fprintf(&buffer, "ergonomadic_command_errors_total{command=%q} %d\n",
+			code, stats.errors)
+		cumulative := uint64(0)
+		for index, count := range stats.buckets {
+			cumulative += count
+			bound := "+Inf"
+			if index < len(CommandLatencyBuckets) {
+				bound = fmt.Sprint(CommandLatencyBuckets[index].Seconds())
+			}
+			fmt.Fprintf(&buffer,
+				"ergonomadic_command_seconds_bucket{command=%q,le=%q} %d\n",
+				code, bound, cumulative)
+		}
+		fmt.Fprintf(&buffer, "ergonomadic_command_seconds_sum{command=%q} %g\n",
+			code, stats.total.Seconds())
+		fmt.Fprintf(&buffer, "ergonomadic_command_seconds_count{command=%q} %d\n",
+			code, stats.count)
+	}
+	return APIText(buffer.String()), nil
+}
@@ -2,16 +2,34 @@ package irc
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
-
-	"gopkg.in/yaml.v2"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type PassConfig struct {
 	Password string
 }
 
+type OperConfig struct {
+	PassConfig `yaml:",inline"`
+	// may join channels past +i, +k, +l and bans
+	Override bool
+	// may send WALLOPS to +w users and GLOBOPS to other operators
+	Wallops bool
+	Globops bool
+	// may send GLOBALNOTICE to everyone
+	GlobalNotice bool `yaml:"global-notice"`
+	// may use SVSNICK, SVSMODE and SVSLOGIN, and is told about logins
+	Services bool
+}
+
 func (conf *PassConfig) PasswordBytes() []byte {
 	bytes, err := DecodePassword(conf.Password)
 	if err != nil {
@@ -20,20 +38,466 @@ func (conf *PassConfig) PasswordBytes() []byte {
 	return bytes
 }
 
+// LimitsConfig holds the lengths (in bytes) and counts advertised in
+// RPL_ISUPPORT. Zero values are replaced with the defaults.
+type LimitsConfig struct {
+	NickLen     int
+	ChannelLen  int
+	TopicLen    int
+	AwayLen     int
+	KickLen     int
+	MaxTargets  int
+	MaxChannels int `yaml:"maxchannels-per-user"`
+	// a draft/multiline message's text and line count
+	MultilineBytes int `yaml:"multiline-max-bytes"`
+	MultilineLines int `yaml:"multiline-max-lines"`
+	// connections accepted in all classes together; 0 for no limit
+	MaxConnections int `yaml:"max-connections"`
+}
+
+var (
+	DefaultLimits = LimitsConfig{
+		NickLen:     32,
+		ChannelLen:  64,
+		TopicLen:    390,
+		AwayLen:     390,
+		KickLen:     390,
+		MaxTargets:  4,
+		MaxChannels: 50,
+
+		MultilineBytes: 4096,
+		MultilineLines: 24,
+	}
+)
+
+func (limits *LimitsConfig) setDefaults() {
+	defaults := []struct {
+		value    *int
+		fallback int
+	}{
+		{&limits.NickLen, DefaultLimits.NickLen},
+		{&limits.ChannelLen, DefaultLimits.ChannelLen},
+		{&limits.TopicLen, DefaultLimits.TopicLen},
+		{&limits.AwayLen, DefaultLimits.AwayLen},
+		{&limits.KickLen, DefaultLimits.KickLen},
+		{&limits.MaxTargets, DefaultLimits.MaxTargets},
+		{&limits.MaxChannels, DefaultLimits.MaxChannels},
+		{&limits.MultilineBytes, DefaultLimits.MultilineBytes},
+		{&limits.MultilineLines, DefaultLimits.MultilineLines},
+	}
+	for _, limit := range defaults {
+		if *limit.value <= 0 {
+			*limit.value = limit.fallback
+		}
+	}
+}
+
+// ListenerACLConfig limits which addresses may connect to a listener.
+// Entries are CIDR ranges or single IPs; deny takes precedence, and a
+// non-empty allow list rejects everything it doesn't match.
+type ListenerACLConfig struct {
+	Allow []string
+	Deny  []string
+}
+
+// ListenerConfig is an address clients connect to, with settings that
+// apply only to its clients.
+type ListenerConfig struct {
+	Address   string
+	TLS       *TLSConfig
+	WebSocket bool `yaml:"websocket"`
+	// a Tor onion service's listener: its clients all get the tor
+	// hostname and must log in with SASL
+	Tor bool
+	// trust PROXY lines from a proxy such as stunnel
+	Proxy bool `yaml:"proxy-protocol"`
+	// the connection class its clients join
+	Class string
+	// used instead of the server password; an empty one lets clients in
+	// without PASS
+	Password *string
+	// used instead of the server MOTD
+	MOTD              string
+	ListenerACLConfig `yaml:",inline"`
+}
+
+type TLSConfig struct {
+	Cert string
+	Key  string
+}
+
+// addLegacyListeners adds listeners, named by their addresses, for the
+// listen, tor listen and wslisten settings of older configs. Those
+// clients may send PROXY, as they always could.
+func (conf *Config) addLegacyListeners() error {
+	if conf.Listeners == nil {
+		conf.Listeners = make(map[string]*ListenerConfig)
+	}
+	add := func(addr string) (*ListenerConfig, error) {
+		if conf.Listeners[addr] != nil {
+			return nil, fmt.Errorf("Listener %s configured twice", addr)
+		}
+		listener := &ListenerConfig{
+			Address: addr,
+			Proxy:   true,
+			MOTD:    conf.Server.ListenMOTD[addr],
+		}
+		if acl := conf.Server.ListenACL[addr]; acl != nil {
+			listener.ListenerACLConfig = *acl
+		}
+		if password, ok := conf.Server.ListenPassword[addr]; ok {
+			listener.Password = &password
+		}
+		conf.Listeners[addr] = listener
+		return listener, nil
+	}
+
+	for _, addr := range conf.Server.Listen {
+		if _, err := add(addr); err != nil {
+			return err
+		}
+	}
+	for _, addr := range conf.Server.Tor.Listen {
+		listener, err := add(addr)
+		if err != nil {
+			return err
+		}
+		listener.Tor = true
+	}
+	if conf.Server.Wslisten != "" {
+		listener, err := add(conf.Server.Wslisten)
+		if err != nil {
+			return err
+		}
+		listener.WebSocket = true
+	}
+	return nil
+}
+
+func (conf *ListenerConfig) validate(name string, classes map[string]*ClassConfig) error {
+	if conf.Address == "" {
+		return fmt.Errorf("Listener %s address missing", name)
+	}
+	unix := strings.HasPrefix(conf.Address, UNIX_PREFIX)
+	if !unix {
+		if _, _, err := net.SplitHostPort(conf.Address); err != nil {
+			return fmt.Errorf("Listener %s address: %s", name, err)
+		}
+	}
+	if unix && conf.WebSocket {
+		return fmt.Errorf("Listener %s can't be a websocket on a unix socket", name)
+	}
+	if (conf.TLS != nil) && ((conf.TLS.Cert == "") || (conf.TLS.Key == "")) {
+		return fmt.Errorf("Listener %s tls needs a cert and a key", name)
+	}
+	if (conf.Class != "") && (conf.Class != DEFAULT_CLASS) &&
+		(classes[conf.Class] == nil) {
+		return fmt.Errorf("Listener %s class %s doesn't exist", name, conf.Class)
+	}
+	if _, err := ParseNets(conf.Allow); err != nil {
+		return fmt.Errorf("Listener %s allow: %s", name, err)
+	}
+	if _, err := ParseNets(conf.Deny); err != nil {
+		return fmt.Errorf("Listener %s deny: %s", name, err)
+	}
+	return nil
+}
+
+// ParseNets parses CIDR ranges and single IPs.
+func ParseNets(strs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(strs))
+	for _, str := range strs {
+		if !strings.Contains(str, "/") {
+			ip := net.ParseIP(str)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP: %s", str)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(str)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// ClassConfig is a connection class. Zero values use the defaults.
+type ClassConfig struct {
+	MaxClients    int `yaml:"max-clients"`
+	SendQ         int
+	RecvQ         int
+	PingFrequency time.Duration `yaml:"ping-frequency"` // idle time before a PING
+	PingTimeout   time.Duration `yaml:"ping-timeout"`   // wait for a reply before dropping
+	Throttle      ThrottleConfig
+
+	// connections to these listeners (by name or address), or from
+	// matching IPs, join the class
+	Listeners []string
+	Hosts     []string
+}
+
+// ThrottleConfig allows each IP at most Connections connections per
+// Duration.
+type ThrottleConfig struct {
+	Connections int
+	Duration    time.Duration
+}
+
+// UTF8Mode is how the utf8only setting treats invalid UTF-8 in PRIVMSG,
+// NOTICE and TOPIC text.
+type UTF8Mode string
+
+const (
+	UTF8Off     UTF8Mode = ""
+	UTF8Reject  UTF8Mode = "reject"
+	UTF8Replace UTF8Mode = "replace" // with U+FFFD
+)
+
+// TorConfig describes listeners for a Tor onion service. Their clients
+// all get Hostname and must authenticate with SASL.
+type TorConfig struct {
+	Listen   []string // replaced by tor listeners
+	Hostname string
+}
+
+// AuthConfig chooses how NICKSERV IDENTIFY and SASL PLAIN check
+// passwords. Mechanisms map identify and plain to a provider name; "db",
+// the account table, is always available and is the default.
+type AuthConfig struct {
+	Providers  map[string]*AuthProviderConfig
+	Mechanisms map[string]string
+	// bearer tokens accepted from WebSocket clients
+	JWT *JWTConfig
+}
+
+type JWTConfig struct {
+	Issuer       string
+	Audience     string
+	JWKS         string `yaml:"jwks-url"`
+	Secret       string // for HS256
+	AccountClaim string `yaml:"account-claim"`
+}
+
+type AuthProviderConfig struct {
+	Type    string // db, ldap, command or webhook
+	URL     string // ldap:// or ldaps:// server, or webhook to POST to
+	BindDN  string `yaml:"bind-dn"` // with %s for the account
+	Command []string
+	Timeout time.Duration
+}
+
+// APIConfig enables the admin HTTP API. Users log in with HTTP basic auth.
+type APIConfig struct {
+	Listen string
+	Users  map[string]*PassConfig
+	// serve the web dashboard and the API on the wslisten address too
+	Dashboard bool
+	Events    EventStreamConfig
+}
+
+// FilterConfig is a pattern that messages and quit and part reasons are
+// checked against, and what to do to a client sending a match.
+type FilterConfig struct {
+	Pattern string
+	Action  FilterAction
+	Reason  string
+	// PRIVMSG, NOTICE, PART and QUIT if empty
+	Commands []string
+	// how long mute and kline last; kline is permanent if unset
+	Duration time.Duration
+}
+
+type DNSBLConfig struct {
+	// how long answers are remembered
+	Cache   time.Duration
+	Timeout time.Duration
+	Lists   []*DNSBLListConfig
+}
+
+type DNSBLListConfig struct {
+	Zone   string
+	Action DNSBLAction
+	// %s is replaced by the client's address
+	Reason string
+	// how long a kline lasts; permanent if unset
+	Duration time.Duration
+	// the 127.0.0.x answers that count; any if empty
+	Replies []string
+}
+
+// ColorMode is what +c does to messages with color or formatting codes.
+type ColorMode string
+
+const (
+	ColorStrip ColorMode = "strip"
+	ColorBlock ColorMode = "block"
+)
+
+type ChannelsConfig struct {
+	ColorMode ColorMode `yaml:"color-mode"`
+	// messages kept for channels with +H, and how long they're replayed
+	// for; an age of 0 replays them all
+	HistoryLines int           `yaml:"history-lines"`
+	HistoryAge   time.Duration `yaml:"history-age"`
+	// flag modes such as +nt set on new channels
+	DefaultModes string `yaml:"default-modes"`
+	// channels clients are joined to when they register
+	AutoJoin []string `yaml:"auto-join"`
+}
+
+// ChannelModes parses DefaultModes, which LoadConfig has checked.
+func (conf *ChannelsConfig) ChannelModes() []ChannelMode {
+	modes := make([]ChannelMode, 0)
+	for _, mode := range strings.TrimPrefix(conf.DefaultModes, "+") {
+		modes = append(modes, ChannelMode(mode))
+	}
+	return modes
+}
+
+func (conf *ChannelsConfig) validate() error {
+	for _, mode := range strings.TrimPrefix(conf.DefaultModes, "+") {
+		def := ChannelModeDefFor(ChannelMode(mode))
+		if (def == nil) || (def.kind != FlagMode) || (def.setter == 0) {
+			return fmt.Errorf("Channels default-modes can't set %c", mode)
+		}
+	}
+	for _, name := range conf.AutoJoin {
+		if !NewName(name).IsChannel() {
+			return fmt.Errorf("Channels auto-join %s isn't a channel", name)
+		}
+	}
+	return nil
+}
+
+// CTCPConfig limits CTCPs other than ACTION sent to channels.
+type CTCPConfig struct {
+	StripChannel bool `yaml:"strip-channel"`
+	// CTCPs each client may send to channels per period; 0 for no limit
+	ChannelLimit  int           `yaml:"channel-limit"`
+	ChannelPeriod time.Duration `yaml:"channel-period"`
+}
+
+// WebhookConfig is a URL to POST events to. With no events listed, it is
+// sent all of them.
+type WebhookConfig struct {
+	URL    string
+	Events []string
+	// signs payloads with HMAC-SHA256 in X-Ergonomadic-Signature
+	Secret string
+}
+
+// AdminConfig is returned by ADMIN.
+type AdminConfig struct {
+	Location    string
+	Description string
+	Email       string
+}
+
 type Config struct {
+	Filename string `yaml:"-"`
+
+	Admin AdminConfig
+
+	Network NetworkConfig
+
+	Auth AuthConfig
+
+	API APIConfig
+
+	Webhooks []*WebhookConfig
+
+	Filters []*FilterConfig
+
+	DNSBL DNSBLConfig
+
+	Challenge ChallengeConfig
+
+	GeoIP GeoIPConfig `yaml:"geoip"`
+
+	Email EmailConfig
+
+	Cloaks CloakConfig
+
+	Languages LanguagesConfig
+
+	Debug DebugConfig
+
+	CTCP CTCPConfig
+
+	Nicks NickConfig
+
+	Metadata MetadataConfig
+
+	ChannelLogs ChannelLogConfig `yaml:"channel-logs"`
+
+	AlwaysOn AlwaysOnConfig `yaml:"always-on"`
+
+	Memos MemoConfig
+
+	Typing TypingConfig
+
+	Idle IdleConfig
+
+	Maintenance MaintenanceConfig
+
+	Defcon map[int]*DefconLevelConfig
+
+	Channels ChannelsConfig
+
+	// Go plugins, built with -buildmode=plugin
+	Plugins []string
+
+	Listeners map[string]*ListenerConfig
+
 	Server struct {
 		PassConfig
 		Database string
-		Listen   []string
-		Wslisten string
-		Log      string
-		MOTD     string
-		Name     string
+		Tor      TorConfig
+		// permissions for unix domain socket listeners, in octal
+		UnixSocketMode string `yaml:"unix-socket-mode"`
+		Log            string
+
+		// older listener settings, replaced by Listeners
+		Listen         []string
+		ListenMOTD     map[string]string             `yaml:"listen-motd"`
+		ListenACL      map[string]*ListenerACLConfig `yaml:"listen-acl"`
+		ListenPassword map[string]string             `yaml:"listen-password"`
+		Wslisten       string
+
+		MOTD string
+		// pass color and formatting codes in the MOTD through to clients
+		MOTDFormatting bool `yaml:"motd-formatting"`
+		Name           string
+		SendQ          int
+		UTF8Only       string `yaml:"utf8only"`
+		CaseMapping    string
+		// time allowed to finish NICK, USER, PASS and CAP
+		RegistrationTimeout time.Duration `yaml:"registration-timeout"`
+		// commands taking longer are logged
+		SlowCommand time.Duration `yaml:"slow-command"`
+		// time allowed to identify before a registered nick is taken away
+		NickEnforcement time.Duration `yaml:"nick-enforcement"`
+		// NickServ, HostServ, MemoServ and Global pseudo-clients
+		PseudoClients bool `yaml:"pseudo-clients"`
+		Prefixes      map[string]string
 	}
 
-	Operator map[string]*PassConfig
+	Limits LimitsConfig
+
+	Class map[string]*ClassConfig
+
+	Operator map[string]*OperConfig
 
-	Theater map[string]*PassConfig
+	Theater map[string]*TheaterConfig
+
+	Bridges map[string]*BridgeConfig
 }
 
 func (conf *Config) Operators() map[Name][]byte {
@@ -44,28 +508,62 @@ func (conf *Config) Operators() map[Name][]byte {
 	return operators
 }
 
-func (conf *Config) Theaters() map[Name][]byte {
-	theaters := make(map[Name][]byte)
+func (conf *Config) OperConfigs() map[Name]*OperConfig {
+	opers := make(map[Name]*OperConfig)
+	for name, opConf := range conf.Operator {
+		opers[NewName(name)] = opConf
+	}
+	return opers
+}
+
+func (conf *Config) Theaters() map[Name]*TheaterStage {
+	theaters := make(map[Name]*TheaterStage)
 	for s, theaterConf := range conf.Theater {
 		name := NewName(s)
 		if !name.IsChannel() {
 			log.Fatal("config uses a non-channel for a theater!")
 		}
-		theaters[name] = theaterConf.PasswordBytes()
+		theaters[name.ToLower()] = NewTheaterStage(name, theaterConf)
 	}
 	return theaters
 }
 
+// UnixSocketFileMode parses UnixSocketMode, which LoadConfig has checked.
+func (conf *Config) UnixSocketFileMode() os.FileMode {
+	if conf.Server.UnixSocketMode == "" {
+		return DEFAULT_UNIX_SOCK_MODE
+	}
+	mode, _ := strconv.ParseUint(conf.Server.UnixSocketMode, 8, 32)
+	return os.FileMode(mode)
+}
+
+// MemberPrefixes maps channel member modes to the NAMES prefixes
+// configured for them.
+func (conf *Config) MemberPrefixes() map[ChannelMode]string {
+	prefixes := make(map[ChannelMode]string)
+	for mode, prefix := range conf.Server.Prefixes {
+		prefixes[ChannelMode([]rune(mode)[0])] = prefix
+	}
+	return prefixes
+}
+
 func LoadConfig(filename string) (config *Config, err error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	err = yaml.Unmarshal(data, &config)
+	err = decodeConfig(filename, data, &config)
 	if err != nil {
 		return nil, err
 	}
+	if config == nil {
+		return nil, fmt.Errorf("%s is empty", filename)
+	}
+	config.Filename = filename
+	if err := config.expandEnv(); err != nil {
+		return nil, err
+	}
 
 	if config.Server.Name == "" {
 		return nil, errors.New("Server name missing")
@@ -73,8 +571,221 @@ func LoadConfig(filename string) (config *Config, err error) {
 	if config.Server.Database == "" {
 		return nil, errors.New("Server database missing")
 	}
-	if len(config.Server.Listen) == 0 {
-		return nil, errors.New("Server listening addresses missing")
+	if err := config.Network.validate(config.Server.Name); err != nil {
+		return nil, err
+	}
+	if err := config.addLegacyListeners(); err != nil {
+		return nil, err
+	}
+	if err := config.readSecrets(); err != nil {
+		return nil, err
+	}
+	if len(config.Listeners) == 0 {
+		return nil, errors.New("Listeners missing")
+	}
+	for name, listener := range config.Listeners {
+		if err := listener.validate(name, config.Class); err != nil {
+			return nil, err
+		}
+	}
+	if config.Server.UnixSocketMode != "" {
+		_, err := strconv.ParseUint(config.Server.UnixSocketMode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Server unix-socket-mode must be octal: %s",
+				config.Server.UnixSocketMode)
+		}
+	}
+	switch CaseMapping(config.Server.CaseMapping) {
+	case "":
+		config.Server.CaseMapping = string(CaseMappingASCII)
+	case CaseMappingASCII, CaseMappingRFC1459, CaseMappingPRECIS:
+	default:
+		return nil, fmt.Errorf("Server casemapping must be ascii, rfc1459 or precis: %s",
+			config.Server.CaseMapping)
+	}
+	switch UTF8Mode(config.Server.UTF8Only) {
+	case UTF8Off, UTF8Reject, UTF8Replace:
+	default:
+		return nil, fmt.Errorf("Server utf8only must be reject or replace: %s",
+			config.Server.UTF8Only)
+	}
+	if config.Server.RegistrationTimeout <= 0 {
+		config.Server.RegistrationTimeout = DEFAULT_REGISTRATION_TIMEOUT
+	}
+	if err := config.Auth.validate(); err != nil {
+		return nil, err
+	}
+	for _, filter := range config.Filters {
+		if _, err := regexp.Compile(filter.Pattern); err != nil {
+			return nil, fmt.Errorf("Filter pattern %s: %s", filter.Pattern, err)
+		}
+		switch filter.Action {
+		case FilterBlock, FilterMute, FilterKill, FilterKLine, FilterNotify:
+		default:
+			return nil, fmt.Errorf("Filter action must be block, mute, kill, kline or notify: %s",
+				filter.Action)
+		}
+		for _, code := range filter.Commands {
+			switch StringCode(strings.ToUpper(code)) {
+			case PRIVMSG, NOTICE, PART, QUIT:
+			default:
+				return nil, fmt.Errorf("Filter command must be PRIVMSG, NOTICE, PART or QUIT: %s",
+					code)
+			}
+		}
+	}
+	switch config.Channels.ColorMode {
+	case "":
+		config.Channels.ColorMode = ColorStrip
+	case ColorStrip, ColorBlock:
+	default:
+		return nil, fmt.Errorf("Channels color-mode must be strip or block: %s",
+			config.Channels.ColorMode)
+	}
+	if err := config.Channels.validate(); err != nil {
+		return nil, err
+	}
+	if config.Channels.HistoryLines == 0 {
+		config.Channels.HistoryLines = DEFAULT_HISTORY_LINES
+	}
+	if (config.CTCP.ChannelLimit > 0) && (config.CTCP.ChannelPeriod <= 0) {
+		return nil, errors.New("CTCP channel-limit needs a channel-period")
+	}
+	if err := config.Idle.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Maintenance.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.ChannelLogs.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.API.Events.validate(); err != nil {
+		return nil, err
+	}
+	for name, bridge := range config.Bridges {
+		if err := bridge.validate(name); err != nil {
+			return nil, err
+		}
+	}
+	if (config.Nicks.ChangeLimit > 0) && (config.Nicks.ChangePeriod <= 0) {
+		return nil, errors.New("nicks change-limit needs a change-period")
+	}
+	if err := config.DNSBL.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.GeoIP.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Email.validate(); err != nil {
+		return nil, err
+	}
+	config.Cloaks.validate()
+	if err := config.Debug.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.AlwaysOn.validate(); err != nil {
+		return nil, err
+	}
+	if config.Typing.Interval == 0 {
+		config.Typing.Interval = DEFAULT_TYPING_INTERVAL
+	}
+	if config.Memos.Quota == 0 {
+		config.Memos.Quota = DEFAULT_MEMO_QUOTA
+	}
+	if err := validateDefcon(config.Defcon); err != nil {
+		return nil, err
+	}
+	for _, hook := range config.Webhooks {
+		if hook.URL == "" {
+			return nil, errors.New("Webhook url missing")
+		}
+		for _, event := range hook.Events {
+			if !WebhookEvents[WebhookEvent(event)] {
+				return nil, fmt.Errorf("Webhook event unknown: %s", event)
+			}
+		}
+	}
+	if config.Server.Tor.Hostname == "" {
+		config.Server.Tor.Hostname = DEFAULT_TOR_HOSTNAME
+	}
+	config.Limits.setDefaults()
+	config.Metadata.setDefaults()
+	for mode, prefix := range config.Server.Prefixes {
+		if (len([]rune(mode)) != 1) ||
+			(MemberModeRank(ChannelMode([]rune(mode)[0])) == 0) {
+			return nil, fmt.Errorf("Server prefix for unknown member mode: %s", mode)
+		}
+		if len([]rune(prefix)) != 1 {
+			return nil, fmt.Errorf("Server prefix must be one character: %s", prefix)
+		}
 	}
 	return config, nil
 }
+
+func (conf *DNSBLConfig) validate() error {
+	if conf.Cache <= 0 {
+		conf.Cache = DEFAULT_DNSBL_CACHE
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = DEFAULT_DNSBL_TIMEOUT
+	}
+	for _, list := range conf.Lists {
+		if list.Zone == "" {
+			return errors.New("DNSBL zone missing")
+		}
+		switch list.Action {
+		case "":
+			list.Action = DNSBLReject
+		case DNSBLReject, DNSBLKLine, DNSBLMark, DNSBLChallenge:
+		default:
+			return fmt.Errorf("DNSBL %s action must be reject, kline, mark or challenge: %s",
+				list.Zone, list.Action)
+		}
+	}
+	return nil
+}
+
+func (conf *AuthConfig) validate() error {
+	for name, provider := range conf.Providers {
+		switch provider.Type {
+		case AuthDB:
+		case AuthLDAP:
+			if (provider.URL == "") || !strings.Contains(provider.BindDN, "%s") {
+				return fmt.Errorf("Auth provider %s needs a url and a bind-dn with %%s", name)
+			}
+		case AuthCommand:
+			if len(provider.Command) == 0 {
+				return fmt.Errorf("Auth provider %s needs a command", name)
+			}
+		case AuthWebhook:
+			if provider.URL == "" {
+				return fmt.Errorf("Auth provider %s needs a url", name)
+			}
+		default:
+			return fmt.Errorf("Auth provider %s has unknown type: %s", name,
+				provider.Type)
+		}
+		if provider.Timeout <= 0 {
+			provider.Timeout = DEFAULT_AUTH_TIMEOUT
+		}
+	}
+	if conf.JWT != nil {
+		if (conf.JWT.JWKS == "") && (conf.JWT.Secret == "") {
+			return errors.New("Auth jwt needs a jwks-url or a secret")
+		}
+		if conf.JWT.AccountClaim == "" {
+			conf.JWT.AccountClaim = DEFAULT_ACCOUNT_CLAIM
+		}
+	}
+	for mechanism, name := range conf.Mechanisms {
+		if (mechanism != AuthIdentify) && (mechanism != AuthPlain) {
+			return fmt.Errorf("Auth mechanism unknown: %s", mechanism)
+		}
+		if _, ok := conf.Providers[name]; !ok && (name != AuthDB) {
+			return fmt.Errorf("Auth mechanism %s uses unknown provider: %s",
+				mechanism, name)
+		}
+	}
+	return nil
+}
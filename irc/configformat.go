@@ -0,0 +1,724 @@
+package irc
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Configs are YAML, but may also be TOML, in a file ending .toml, or in
+// the gcfg format of the configs before YAML. Either is parsed into a tree
+// of the YAML config's shape and decoded as YAML, so keys, and what their
+// values mean, are the same in all three:
+//
+//   [server]                       [server]
+//   name = ergonomadic.test        name = "ergonomadic.test"
+//   listen = ":6667"               listen = [":6667", ":6668"]
+//   listen = ":6668"
+//
+//   [operator "root"]              [operator.root]
+//   password = "..."               password = "..."
+//
+// A gcfg variable given more than once is a list, as is one given once
+// where the config takes a list. gcfg has only sections and named
+// sections, so settings in deeper blocks, such as webhooks or a listener's
+// tls, need YAML or TOML.
+
+type ConfigFormat string
+
+const (
+	ConfigYAML ConfigFormat = "yaml"
+	ConfigTOML ConfigFormat = "toml"
+	ConfigGcfg ConfigFormat = "gcfg"
+)
+
+var (
+	// a [section] or [section "name"] line of a gcfg config
+	gcfgSectionExpr = regexp.MustCompile(`(?m)^\s*\[\w+( "[^"]*")?\]\s*$`)
+	// values that mean the same to YAML unquoted: numbers, booleans and
+	// durations, but also words
+	plainScalarExpr = regexp.MustCompile(`^[+-]?\w[\w.+-]*$`)
+	tomlBareKeyExpr = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	gcfgNameExpr    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+)
+
+// configScalar is a value as it was written. A quoted one is a string;
+// YAML decides what a bare one is.
+type configScalar struct {
+	text   string
+	quoted bool
+}
+
+// configTable is a section of a gcfg or TOML config. Its values are
+// configScalars, configTables and lists of them.
+type configTable map[string]interface{}
+
+// configFormatOf says what format a config is in: TOML by its name, gcfg
+// by its sections, and otherwise YAML.
+func configFormatOf(filename string, data []byte) ConfigFormat {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		return ConfigTOML
+	case ".yaml", ".yml":
+		return ConfigYAML
+	}
+	if gcfgSectionExpr.Match(data) {
+		return ConfigGcfg
+	}
+	return ConfigYAML
+}
+
+// decodeConfig decodes a config in any of the formats into config.
+func decodeConfig(filename string, data []byte, config **Config) error {
+	var table configTable
+	var err error
+	switch configFormatOf(filename, data) {
+	case ConfigTOML:
+		table, err = parseTOML(data)
+	case ConfigGcfg:
+		if table, err = parseGcfg(data); err == nil {
+			shapeConfig(table, reflect.TypeOf(Config{}))
+		}
+	default:
+		return yaml.Unmarshal(data, config)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	var buf bytes.Buffer
+	writeYAML(&buf, table)
+	return yaml.Unmarshal(buf.Bytes(), config)
+}
+
+// writeYAML writes value as YAML in flow style.
+func writeYAML(buf *bytes.Buffer, value interface{}) {
+	switch value := value.(type) {
+	case configTable:
+		buf.WriteByte('{')
+		first := true
+		for key, elem := range value {
+			if !first {
+				buf.WriteString(", ")
+			}
+			first = false
+			writeYAML(buf, configScalar{text: key})
+			buf.WriteString(": ")
+			writeYAML(buf, elem)
+		}
+		buf.WriteByte('}')
+
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range value {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			writeYAML(buf, elem)
+		}
+		buf.WriteByte(']')
+
+	case configScalar:
+		if value.quoted || !plainScalarExpr.MatchString(value.text) {
+			buf.WriteString(strconv.Quote(value.text))
+		} else {
+			buf.WriteString(value.text)
+		}
+	}
+}
+
+// shapeConfig makes the lists that gcfg can't tell from single values:
+// wherever t, the type configured, is a slice, value becomes a list.
+func shapeConfig(value interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if table, ok := value.(configTable); ok {
+			for key, elem := range table {
+				if field, ok := yamlField(t, key); ok {
+					table[key] = shapeConfig(elem, field.Type)
+				}
+			}
+		}
+
+	case reflect.Map:
+		if table, ok := value.(configTable); ok {
+			for key, elem := range table {
+				table[key] = shapeConfig(elem, t.Elem())
+			}
+		}
+
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+		for i, elem := range list {
+			list[i] = shapeConfig(elem, t.Elem())
+		}
+		return list
+	}
+	return value
+}
+
+// yamlField finds the field of struct type t, or of a struct inlined in
+// it, that YAML decodes key into.
+func yamlField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		if (len(tag) > 1) && (tag[1] == "inline") {
+			if inlined, ok := yamlField(field.Type, key); ok {
+				return inlined, true
+			}
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		if (name == key) && (field.PkgPath == "") {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+//
+// gcfg
+//
+
+// parseGcfg parses a gcfg config: [section] and [section "name"] headers,
+// each followed by name = value variables. Section and variable names are
+// case-insensitive.
+func parseGcfg(data []byte) (configTable, error) {
+	root := make(configTable)
+	var section configTable
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	for number := 0; number < len(lines); number++ {
+		line := lines[number]
+		// a trailing backslash continues the value on the next line
+		for strings.HasSuffix(line, "\\") && (number+1 < len(lines)) &&
+			!strings.HasSuffix(line, "\\\\") {
+			number += 1
+			line = line[:len(line)-1] + lines[number]
+		}
+		line = strings.TrimSpace(line)
+		if (line == "") || (line[0] == ';') || (line[0] == '#') {
+			continue
+		}
+		where := fmt.Sprintf("line %d", number+1)
+
+		if line[0] == '[' {
+			var err error
+			if section, err = gcfgSection(root, line); err != nil {
+				return nil, fmt.Errorf("%s: %s", where, err)
+			}
+			continue
+		}
+		if section == nil {
+			return nil, fmt.Errorf("%s: variable outside a section", where)
+		}
+		name, value := line, configScalar{text: "true"} // a bare name is a true boolean
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			var err error
+			name = strings.TrimSpace(line[:i])
+			if value, err = gcfgValue(line[i+1:]); err != nil {
+				return nil, fmt.Errorf("%s: %s", where, err)
+			}
+		}
+		if !gcfgNameExpr.MatchString(name) {
+			return nil, fmt.Errorf("%s: bad variable name: %s", where, name)
+		}
+		name = strings.ToLower(name)
+		switch previous := section[name].(type) {
+		case nil:
+			section[name] = value
+		case []interface{}:
+			section[name] = append(previous, value)
+		default:
+			section[name] = []interface{}{previous, value}
+		}
+	}
+	return root, nil
+}
+
+// gcfgSection finds or adds the section a [section] or [section "name"]
+// header names.
+func gcfgSection(root configTable, header string) (configTable, error) {
+	if !strings.HasSuffix(header, "]") {
+		return nil, fmt.Errorf("unterminated section header: %s", header)
+	}
+	header = strings.TrimSpace(header[1 : len(header)-1])
+	name, sub := header, ""
+	named := false
+	if i := strings.IndexAny(header, " \t"); i >= 0 {
+		name = header[:i]
+		value, err := gcfgValue(header[i:])
+		if err != nil || !value.quoted {
+			return nil, fmt.Errorf("bad section name: %s", header)
+		}
+		sub, named = value.text, true
+	}
+	if !gcfgNameExpr.MatchString(name) {
+		return nil, fmt.Errorf("bad section name: %s", name)
+	}
+	name = strings.ToLower(name)
+	section, ok := root[name].(configTable)
+	if !ok {
+		section = make(configTable)
+		root[name] = section
+	}
+	if !named {
+		return section, nil
+	}
+	subsection, ok := section[sub].(configTable)
+	if !ok {
+		subsection = make(configTable)
+		section[sub] = subsection
+	}
+	return subsection, nil
+}
+
+// gcfgValue parses a variable's value, which may be partly quoted and
+// ends at a ; or # comment. Whitespace outside quotes is trimmed.
+func gcfgValue(raw string) (configScalar, error) {
+	var value configScalar
+	var text strings.Builder
+	quoted, kept := false, 0
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+			value.quoted = true
+			continue
+		case c == '\\':
+			if i+1 == len(raw) {
+				return value, fmt.Errorf("unterminated escape")
+			}
+			i += 1
+			switch raw[i] {
+			case 'n':
+				text.WriteByte('\n')
+			case 't':
+				text.WriteByte('\t')
+			case 'b':
+				text.WriteByte('\b')
+			case '"', '\\':
+				text.WriteByte(raw[i])
+			default:
+				return value, fmt.Errorf("bad escape: \\%c", raw[i])
+			}
+		case !quoted && ((c == ';') || (c == '#')):
+			i = len(raw)
+			continue
+		case !quoted && unicode.IsSpace(rune(c)):
+			if text.Len() > 0 {
+				text.WriteByte(c)
+			}
+			continue
+		default:
+			text.WriteByte(c)
+		}
+		kept = text.Len()
+	}
+	if quoted {
+		return value, fmt.Errorf("unterminated quote")
+	}
+	value.text = text.String()[:kept]
+	return value, nil
+}
+
+//
+// TOML
+//
+
+// tomlParser parses TOML: tables, arrays of tables, dotted and quoted
+// keys, strings, arrays and inline tables. Numbers, booleans and dates are
+// left to YAML.
+type tomlParser struct {
+	data []byte
+	pos  int
+	line int
+}
+
+func parseTOML(data []byte) (configTable, error) {
+	parser := &tomlParser{data: data, line: 1}
+	root := make(configTable)
+	table := root
+	for {
+		parser.skipSpace(true)
+		if parser.pos == len(parser.data) {
+			return root, nil
+		}
+		var err error
+		if parser.data[parser.pos] == '[' {
+			table, err = parser.header(root)
+		} else {
+			err = parser.keyValue(table)
+		}
+		if err == nil {
+			err = parser.endLine()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", parser.line, err)
+		}
+	}
+}
+
+func (parser *tomlParser) peek() byte {
+	if parser.pos == len(parser.data) {
+		return 0
+	}
+	return parser.data[parser.pos]
+}
+
+func (parser *tomlParser) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(parser.data[parser.pos:], []byte(prefix))
+}
+
+// skipSpace skips spaces and tabs, and also newlines and comments if
+// lines is set.
+func (parser *tomlParser) skipSpace(lines bool) {
+	for parser.pos < len(parser.data) {
+		switch parser.data[parser.pos] {
+		case ' ', '\t':
+		case '\r', '\n':
+			if !lines {
+				return
+			}
+			if parser.data[parser.pos] == '\n' {
+				parser.line += 1
+			}
+		case '#':
+			if !lines {
+				return
+			}
+			for (parser.pos < len(parser.data)) && (parser.data[parser.pos] != '\n') {
+				parser.pos += 1
+			}
+			continue
+		default:
+			return
+		}
+		parser.pos += 1
+	}
+}
+
+// endLine expects the end of the line, perhaps after a comment.
+func (parser *tomlParser) endLine() error {
+	parser.skipSpace(false)
+	switch parser.peek() {
+	case 0, '\r', '\n', '#':
+		return nil
+	}
+	return fmt.Errorf("unexpected %q", parser.peek())
+}
+
+// header parses a [table] or [[array of tables]] header, and returns the
+// table that follows it.
+func (parser *tomlParser) header(root configTable) (configTable, error) {
+	array := parser.hasPrefix("[[")
+	if array {
+		parser.pos += 2
+	} else {
+		parser.pos += 1
+	}
+	path, err := parser.key()
+	if err != nil {
+		return nil, err
+	}
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !parser.hasPrefix(closing) {
+		return nil, fmt.Errorf("expected %s", closing)
+	}
+	parser.pos += len(closing)
+
+	parent, err := tomlTable(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	name := path[len(path)-1]
+	if !array {
+		return tomlTable(parent, []string{name})
+	}
+	table := make(configTable)
+	switch list := parent[name].(type) {
+	case nil:
+		parent[name] = []interface{}{table}
+	case []interface{}:
+		parent[name] = append(list, table)
+	default:
+		return nil, fmt.Errorf("%s is already defined", name)
+	}
+	return table, nil
+}
+
+// tomlTable finds or adds the table at path under table; in an array of
+// tables, that's under its last.
+func tomlTable(table configTable, path []string) (configTable, error) {
+	for _, name := range path {
+		switch elem := table[name].(type) {
+		case nil:
+			sub := make(configTable)
+			table[name] = sub
+			table = sub
+		case configTable:
+			table = elem
+		case []interface{}:
+			last, ok := elem[len(elem)-1].(configTable)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a table", name)
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("%s is not a table", name)
+		}
+	}
+	return table, nil
+}
+
+// key parses a key of bare or quoted parts joined by dots.
+func (parser *tomlParser) key() ([]string, error) {
+	path := make([]string, 0, 1)
+	for {
+		parser.skipSpace(false)
+		var part string
+		switch parser.peek() {
+		case '"', '\'':
+			value, err := parser.str()
+			if err != nil {
+				return nil, err
+			}
+			part = value.text
+		default:
+			start := parser.pos
+			for (parser.pos < len(parser.data)) &&
+				tomlBareKeyExpr.Match(parser.data[parser.pos:parser.pos+1]) {
+				parser.pos += 1
+			}
+			if start == parser.pos {
+				return nil, fmt.Errorf("expected a key")
+			}
+			part = string(parser.data[start:parser.pos])
+		}
+		path = append(path, part)
+		parser.skipSpace(false)
+		if parser.peek() != '.' {
+			return path, nil
+		}
+		parser.pos += 1
+	}
+}
+
+// keyValue parses key = value into table.
+func (parser *tomlParser) keyValue(table configTable) error {
+	path, err := parser.key()
+	if err != nil {
+		return err
+	}
+	if parser.peek() != '=' {
+		return fmt.Errorf("expected = after %s", strings.Join(path, "."))
+	}
+	parser.pos += 1
+	parser.skipSpace(false)
+	value, err := parser.value()
+	if err != nil {
+		return err
+	}
+	parent, err := tomlTable(table, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	name := path[len(path)-1]
+	if _, ok := parent[name]; ok {
+		return fmt.Errorf("%s is already defined", strings.Join(path, "."))
+	}
+	parent[name] = value
+	return nil
+}
+
+func (parser *tomlParser) value() (interface{}, error) {
+	switch parser.peek() {
+	case '"', '\'':
+		return parser.str()
+
+	case '[':
+		parser.pos += 1
+		list := make([]interface{}, 0)
+		for {
+			parser.skipSpace(true)
+			if parser.peek() == ']' {
+				parser.pos += 1
+				return list, nil
+			}
+			elem, err := parser.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+			parser.skipSpace(true)
+			switch parser.peek() {
+			case ',':
+				parser.pos += 1
+			case ']':
+			default:
+				return nil, fmt.Errorf("expected , or ] in array")
+			}
+		}
+
+	case '{':
+		parser.pos += 1
+		table := make(configTable)
+		parser.skipSpace(false)
+		if parser.peek() == '}' {
+			parser.pos += 1
+			return table, nil
+		}
+		for {
+			if err := parser.keyValue(table); err != nil {
+				return nil, err
+			}
+			parser.skipSpace(false)
+			switch parser.peek() {
+			case ',':
+				parser.pos += 1
+			case '}':
+				parser.pos += 1
+				return table, nil
+			default:
+				return nil, fmt.Errorf("expected , or } in inline table")
+			}
+		}
+	}
+
+	// a number, boolean or date
+	start := parser.pos
+	for (parser.pos < len(parser.data)) &&
+		!strings.ContainsRune(" \t\r\n,]}#", rune(parser.data[parser.pos])) {
+		parser.pos += 1
+	}
+	text := string(parser.data[start:parser.pos])
+	if text == "" {
+		return nil, fmt.Errorf("expected a value")
+	}
+	if c := text[0]; (c >= '0' && c <= '9') || (c == '+') || (c == '-') {
+		text = strings.Replace(text, "_", "", -1)
+	}
+	return configScalar{text: text}, nil
+}
+
+// str parses a basic or literal string, either of which may be
+// multi-line.
+func (parser *tomlParser) str() (configScalar, error) {
+	quote := parser.data[parser.pos : parser.pos+1]
+	literal := quote[0] == '\''
+	multiline := parser.hasPrefix(strings.Repeat(string(quote), 3))
+	closing := string(quote)
+	if multiline {
+		closing = strings.Repeat(closing, 3)
+		parser.pos += 3
+		// a newline right after the opening quotes is trimmed
+		if parser.hasPrefix("\r\n") {
+			parser.pos += 2
+			parser.line += 1
+		} else if parser.hasPrefix("\n") {
+			parser.pos += 1
+			parser.line += 1
+		}
+	} else {
+		parser.pos += 1
+	}
+
+	var text strings.Builder
+	for {
+		if parser.pos == len(parser.data) {
+			return configScalar{}, fmt.Errorf("unterminated string")
+		}
+		if parser.hasPrefix(closing) {
+			parser.pos += len(closing)
+			return configScalar{text: text.String(), quoted: true}, nil
+		}
+		c := parser.data[parser.pos]
+		parser.pos += 1
+		switch {
+		case c == '\n':
+			if !multiline {
+				return configScalar{}, fmt.Errorf("unterminated string")
+			}
+			parser.line += 1
+			text.WriteByte(c)
+
+		case (c == '\\') && !literal:
+			if err := parser.escape(&text, multiline); err != nil {
+				return configScalar{}, err
+			}
+
+		default:
+			text.WriteByte(c)
+		}
+	}
+}
+
+// escape parses what follows a backslash in a basic string.
+func (parser *tomlParser) escape(text *strings.Builder, multiline bool) error {
+	c := parser.peek()
+	parser.pos += 1
+	switch c {
+	case 'b':
+		text.WriteByte('\b')
+	case 't':
+		text.WriteByte('\t')
+	case 'n':
+		text.WriteByte('\n')
+	case 'f':
+		text.WriteByte('\f')
+	case 'r':
+		text.WriteByte('\r')
+	case '"', '\\':
+		text.WriteByte(c)
+	case 'u', 'U':
+		length := 4
+		if c == 'U' {
+			length = 8
+		}
+		if parser.pos+length > len(parser.data) {
+			return fmt.Errorf("short \\%c escape", c)
+		}
+		code, err := strconv.ParseUint(string(parser.data[parser.pos:parser.pos+length]), 16, 32)
+		if err != nil {
+			return fmt.Errorf("bad \\%c escape", c)
+		}
+		parser.pos += length
+		text.WriteRune(rune(code))
+	default:
+		// in a multi-line string, a backslash ending a line trims the
+		// whitespace up to the next text
+		if multiline && strings.ContainsRune(" \t\r\n", rune(c)) {
+			parser.pos -= 1
+			parser.skipSpace(false)
+			for (parser.peek() == '\r') || (parser.peek() == '\n') {
+				if parser.peek() == '\n' {
+					parser.line += 1
+				}
+				parser.pos += 1
+				parser.skipSpace(false)
+			}
+			return nil
+		}
+		return fmt.Errorf("bad escape: \\%c", c)
+	}
+	return nil
+}
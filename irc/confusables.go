@@ -0,0 +1,94 @@
+package irc
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Confusable nicknames look the same but are different strings, like
+// "paypal" with a Cyrillic "а". With Unicode nicknames a new nick is
+// refused if its skeleton matches another client's or an account's.
+//
+// This is a small subset of the Unicode confusables data (UTS #39), aimed
+// at lookalikes of Latin letters.
+
+var (
+	confusableRunes = map[rune]rune{
+		// ASCII
+		'0': 'o', '1': 'l', 'I': 'l', '|': 'l',
+		// Cyrillic
+		'а': 'a', 'в': 'b', 'е': 'e', 'һ': 'h', 'н': 'h', 'і': 'i', 'ј': 'j',
+		'к': 'k', 'м': 'm', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't',
+		'с': 'c', 'у': 'y', 'х': 'x', 'ԁ': 'd', 'ԝ': 'w',
+		// Greek
+		'α': 'a', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't',
+		'υ': 'u', 'χ': 'x',
+	}
+	confusableSequences = strings.NewReplacer("rn", "m", "vv", "w")
+
+	// scripts with letters easily mistaken for each other
+	confusableScripts = []*unicode.RangeTable{unicode.Latin, unicode.Greek,
+		unicode.Cyrillic, unicode.Armenian}
+)
+
+func mapConfusables(str string) string {
+	return strings.Map(func(r rune) rune {
+		if mapped, ok := confusableRunes[r]; ok {
+			return mapped
+		}
+		return r
+	}, str)
+}
+
+// Skeleton maps a name to a form shared by the names it is confusable
+// with.
+func Skeleton(name Name) string {
+	decomposed := norm.NFKD.String(name.String())
+	stripped := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, decomposed)
+	skeleton := mapConfusables(strings.ToLower(mapConfusables(stripped)))
+	return confusableSequences.Replace(skeleton)
+}
+
+// IsMixedScript reports whether the name has letters from more than one
+// of the confusableScripts.
+func IsMixedScript(name Name) bool {
+	var found *unicode.RangeTable
+	for _, r := range name.String() {
+		for _, script := range confusableScripts {
+			if !unicode.Is(script, r) {
+				continue
+			}
+			if (found != nil) && (found != script) {
+				return true
+			}
+			found = script
+		}
+	}
+	return false
+}
+
+// IsConfusable checks a nick the client wants against other clients'
+// nicks and registered accounts. It only applies to Unicode nicknames.
+func (server *Server) IsConfusable(client *Client, nick Name) bool {
+	if !ServerCaseMapping.AllowsUnicode() {
+		return false
+	}
+	skeleton := Skeleton(nick)
+	for other := range server.clients.bySkeleton[skeleton] {
+		if other != client {
+			return true
+		}
+	}
+	if account, ok := server.accountSkeletons[skeleton]; ok {
+		return (account.ToLower() != nick.ToLower()) &&
+			(account.ToLower() != client.account.ToLower())
+	}
+	return false
+}
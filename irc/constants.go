@@ -1,43 +1,135 @@
 package irc
 
+import (
+	"time"
+)
+
+var (
+	// Commit is the revision the binary was built from, set with
+	// -ldflags "-X github.com/edmund-huber/ergonomadic/irc.Commit=<rev>".
+	Commit = ""
+)
+
 const (
 	SEM_VER       = "ergonomadic-1.4.4"
 	CRLF          = "\r\n"
 	MAX_REPLY_LEN = 512 - len(CRLF)
 
+	HOMEPAGE = "https://github.com/edmund-huber/ergonomadic"
+
+	DEFAULT_TOR_HOSTNAME = "tor-network.onion"
+
+	DEFAULT_REGISTRATION_TIMEOUT = 30 * time.Second
+
+	GUEST_PREFIX = "Guest" // nicks given by nick enforcement
+
+	DEFAULT_CLASS       = "default"
+	DEFAULT_RECVQ       = 8192 // bytes in a single line from a client
+	THROTTLE_PRUNE_SIZE = 1024 // throttled IPs kept before expired ones are dropped
+
+	UNIX_PREFIX            = "unix:" // listen addresses for unix domain sockets
+	UNIX_HOSTNAME          = "localhost"
+	UNIX_IP                = "127.0.0.1"
+	DEFAULT_UNIX_SOCK_MODE = 0770
+
+	MAX_ISUPPORT_TOKENS = 13 // per RPL_ISUPPORT line
+
+	CHANTYPES = "&!#+" // see ChannelNameExpr
+
+	LIST_MAX_RESULTS = 1000             // channels returned to non-opers
+	LIST_THROTTLE    = 10 * time.Second // between full LISTs from non-opers
+
+	CHANNEL_LIST_MAX = 100 // entries in each of a channel's +b, +e and +I lists
+	MAX_FORWARDS     = 4   // +f channels followed for a single JOIN
+	MAX_MODE_PARAMS  = 4   // mode changes with arguments per MODE
+	SILENCE_MAX      = 15  // entries in each client's SILENCE list
+	ACCEPT_MAX       = 20  // entries in each client's ACCEPT list
+	USERHOST_MAX     = 5   // nicks answered by a single USERHOST
+	MOTD_LINE_LEN    = 80  // characters in each RPL_MOTD line
+	VHOST_MAX_LEN    = 64  // bytes in a vhost set with HOSTSERV
+	REALNAME_MAX_LEN = 128 // bytes in a realname set with SETNAME
+	AUDIT_LIST_MAX   = 50  // entries shown by a single AUDIT
+
+	CALLERID_NOTIFY_INTERVAL = time.Minute // between +g notices from a sender
+
 	// string codes
-	AWAY    StringCode = "AWAY"
-	CAP     StringCode = "CAP"
-	DEBUG   StringCode = "DEBUG"
-	ERROR   StringCode = "ERROR"
-	INVITE  StringCode = "INVITE"
-	ISON    StringCode = "ISON"
-	JOIN    StringCode = "JOIN"
-	KICK    StringCode = "KICK"
-	KILL    StringCode = "KILL"
-	LIST    StringCode = "LIST"
-	MODE    StringCode = "MODE"
-	MOTD    StringCode = "MOTD"
-	NAMES   StringCode = "NAMES"
-	NICK    StringCode = "NICK"
-	NOTICE  StringCode = "NOTICE"
-	ONICK   StringCode = "ONICK"
-	OPER    StringCode = "OPER"
-	PART    StringCode = "PART"
-	PASS    StringCode = "PASS"
-	PING    StringCode = "PING"
-	PONG    StringCode = "PONG"
-	PRIVMSG StringCode = "PRIVMSG"
-	PROXY   StringCode = "PROXY"
-	QUIT    StringCode = "QUIT"
-	THEATER StringCode = "THEATER" // nonstandard
-	TIME    StringCode = "TIME"
-	TOPIC   StringCode = "TOPIC"
-	USER    StringCode = "USER"
-	VERSION StringCode = "VERSION"
-	WHO     StringCode = "WHO"
-	WHOIS   StringCode = "WHOIS"
-	WHOWAS  StringCode = "WHOWAS"
+	ACCEPT       StringCode = "ACCEPT"
+	ACK          StringCode = "ACK"
+	ADMIN        StringCode = "ADMIN"
+	AUDIT        StringCode = "AUDIT" // nonstandard
+	AUTHENTICATE StringCode = "AUTHENTICATE"
+	AWAY         StringCode = "AWAY"
+	BATCH        StringCode = "BATCH"
+	BRIDGE       StringCode = "BRIDGE" // nonstandard
+	CAP          StringCode = "CAP"
+	CERT         StringCode = "CERT" // nonstandard
+	CHGHOST      StringCode = "CHGHOST"
+	DEBUG        StringCode = "DEBUG"
+	DEFCON       StringCode = "DEFCON" // nonstandard
+	ERROR        StringCode = "ERROR"
+	FAIL         StringCode = "FAIL"
+	GLOBALNOTICE StringCode = "GLOBALNOTICE" // nonstandard
+	GLOBOPS      StringCode = "GLOBOPS"      // nonstandard
+	HOSTSERV     StringCode = "HOSTSERV"     // nonstandard
+	HELP         StringCode = "HELP"
+	HELPOP       StringCode = "HELPOP" // nonstandard
+	HS           StringCode = "HS"     // nonstandard
+	INFO         StringCode = "INFO"
+	INVITE       StringCode = "INVITE"
+	ISON         StringCode = "ISON"
+	JOIN         StringCode = "JOIN"
+	KICK         StringCode = "KICK"
+	KILL         StringCode = "KILL"
+	KLINE        StringCode = "KLINE"
+	LANGUAGE     StringCode = "LANGUAGE" // nonstandard
+	LIST         StringCode = "LIST"
+	LUSERS       StringCode = "LUSERS"
+	MAP          StringCode = "MAP"
+	MEMOSERV     StringCode = "MEMOSERV" // nonstandard
+	METADATA     StringCode = "METADATA"
+	MODE         StringCode = "MODE"
+	MOTD         StringCode = "MOTD"
+	MS           StringCode = "MS" // nonstandard
+	NAMES        StringCode = "NAMES"
+	NICK         StringCode = "NICK"
+	NICKSERV     StringCode = "NICKSERV" // nonstandard
+	NOTICE       StringCode = "NOTICE"
+	NPC          StringCode = "NPC"  // nonstandard
+	NPCA         StringCode = "NPCA" // nonstandard
+	NS           StringCode = "NS"   // nonstandard
+	ONICK        StringCode = "ONICK"
+	OPER         StringCode = "OPER"
+	OPERWALL     StringCode = "OPERWALL" // nonstandard
+	PART         StringCode = "PART"
+	PASS         StringCode = "PASS"
+	PING         StringCode = "PING"
+	PONG         StringCode = "PONG"
+	PRIVMSG      StringCode = "PRIVMSG"
+	PROXY        StringCode = "PROXY"
+	QUIT         StringCode = "QUIT"
+	REHASH       StringCode = "REHASH"
+	REMOVE       StringCode = "REMOVE" // nonstandard
+	SCENE        StringCode = "SCENE"  // nonstandard
+	STATS        StringCode = "STATS"
+	SVSLOGIN     StringCode = "SVSLOGIN" // nonstandard
+	SVSMODE      StringCode = "SVSMODE"  // nonstandard
+	SVSNICK      StringCode = "SVSNICK"  // nonstandard
+	SETNAME      StringCode = "SETNAME"
+	SILENCE      StringCode = "SILENCE" // nonstandard
+	TAGMSG       StringCode = "TAGMSG"
+	THEATER      StringCode = "THEATER" // nonstandard
+	TIME         StringCode = "TIME"
+	TOPIC        StringCode = "TOPIC"
+	TRACE        StringCode = "TRACE"
+	UNKLINE      StringCode = "UNKLINE"
+	USER         StringCode = "USER"
+	USERHOST     StringCode = "USERHOST"
+	VERIFY       StringCode = "VERIFY" // nonstandard
+	VERSION      StringCode = "VERSION"
+	WALLOPS      StringCode = "WALLOPS"
+	WHO          StringCode = "WHO"
+	WHOIS        StringCode = "WHOIS"
+	WHOWAS       StringCode = "WHOWAS"
 
 	// numeric codes
 	RPL_WELCOME           NumericCode = 1
@@ -45,6 +137,9 @@ const (
 	RPL_CREATED           NumericCode = 3
 	RPL_MYINFO            NumericCode = 4
 	RPL_BOUNCE            NumericCode = 5
+	RPL_ISUPPORT          NumericCode = 5
+	RPL_MAP               NumericCode = 15 // nonstandard
+	RPL_MAPEND            NumericCode = 17 // nonstandard
 	RPL_TRACELINK         NumericCode = 200
 	RPL_TRACECONNECTING   NumericCode = 201
 	RPL_TRACEHANDSHAKE    NumericCode = 202
@@ -58,12 +153,16 @@ const (
 	RPL_TRACERECONNECT    NumericCode = 210
 	RPL_STATSLINKINFO     NumericCode = 211
 	RPL_STATSCOMMANDS     NumericCode = 212
+	RPL_STATSKLINE        NumericCode = 216
+	RPL_STATSYLINE        NumericCode = 218
 	RPL_ENDOFSTATS        NumericCode = 219
+	RPL_STATSPLINE        NumericCode = 220
 	RPL_UMODEIS           NumericCode = 221
 	RPL_SERVLIST          NumericCode = 234
 	RPL_SERVLISTEND       NumericCode = 235
 	RPL_STATSUPTIME       NumericCode = 242
 	RPL_STATSOLINE        NumericCode = 243
+	RPL_STATSDEBUG        NumericCode = 249 // nonstandard
 	RPL_LUSERCLIENT       NumericCode = 251
 	RPL_LUSEROP           NumericCode = 252
 	RPL_LUSERUNKNOWN      NumericCode = 253
@@ -76,6 +175,10 @@ const (
 	RPL_TRACELOG          NumericCode = 261
 	RPL_TRACEEND          NumericCode = 262
 	RPL_TRYAGAIN          NumericCode = 263
+	RPL_SILELIST          NumericCode = 271
+	RPL_ENDOFSILELIST     NumericCode = 272
+	RPL_ACCEPTLIST        NumericCode = 281
+	RPL_ENDOFACCEPT       NumericCode = 282
 	RPL_AWAY              NumericCode = 301
 	RPL_USERHOST          NumericCode = 302
 	RPL_ISON              NumericCode = 303
@@ -89,12 +192,17 @@ const (
 	RPL_WHOISIDLE         NumericCode = 317
 	RPL_ENDOFWHOIS        NumericCode = 318
 	RPL_WHOISCHANNELS     NumericCode = 319
+	RPL_WHOISSPECIAL      NumericCode = 320 // nonstandard
 	RPL_LIST              NumericCode = 322
 	RPL_LISTEND           NumericCode = 323
 	RPL_CHANNELMODEIS     NumericCode = 324
 	RPL_UNIQOPIS          NumericCode = 325
+	RPL_WHOISACCOUNT      NumericCode = 330
 	RPL_NOTOPIC           NumericCode = 331
 	RPL_TOPIC             NumericCode = 332
+	RPL_TOPICWHOTIME      NumericCode = 333
+	RPL_WHOISBOT          NumericCode = 335
+	RPL_WHOISACTUALLY     NumericCode = 338
 	RPL_INVITING          NumericCode = 341
 	RPL_SUMMONING         NumericCode = 342
 	RPL_INVITELIST        NumericCode = 346
@@ -104,6 +212,7 @@ const (
 	RPL_VERSION           NumericCode = 351
 	RPL_WHOREPLY          NumericCode = 352
 	RPL_NAMREPLY          NumericCode = 353
+	RPL_WHOSPCRPL         NumericCode = 354
 	RPL_LINKS             NumericCode = 364
 	RPL_ENDOFLINKS        NumericCode = 365
 	RPL_ENDOFNAMES        NumericCode = 366
@@ -147,6 +256,7 @@ const (
 	ERR_NICKNAMEINUSE     NumericCode = 433
 	ERR_NICKCOLLISION     NumericCode = 436
 	ERR_UNAVAILRESOURCE   NumericCode = 437
+	ERR_NICKTOOFAST       NumericCode = 438 // nonstandard
 	ERR_USERNOTINCHANNEL  NumericCode = 441
 	ERR_NOTONCHANNEL      NumericCode = 442
 	ERR_USERONCHANNEL     NumericCode = 443
@@ -160,7 +270,11 @@ const (
 	ERR_PASSWDMISMATCH    NumericCode = 464
 	ERR_YOUREBANNEDCREEP  NumericCode = 465
 	ERR_YOUWILLBEBANNED   NumericCode = 466
+	ERR_ACCEPTFULL        NumericCode = 456
+	ERR_ACCEPTEXIST       NumericCode = 457
+	ERR_ACCEPTNOT         NumericCode = 458
 	ERR_KEYSET            NumericCode = 467
+	ERR_LINKCHANNEL       NumericCode = 470
 	ERR_CHANNELISFULL     NumericCode = 471
 	ERR_UNKNOWNMODE       NumericCode = 472
 	ERR_INVITEONLYCHAN    NumericCode = 473
@@ -168,6 +282,7 @@ const (
 	ERR_BADCHANNELKEY     NumericCode = 475
 	ERR_BADCHANMASK       NumericCode = 476
 	ERR_NOCHANMODES       NumericCode = 477
+	ERR_NEEDREGGEDNICK    NumericCode = 477
 	ERR_BANLISTFULL       NumericCode = 478
 	ERR_NOPRIVILEGES      NumericCode = 481
 	ERR_CHANOPRIVSNEEDED  NumericCode = 482
@@ -177,4 +292,29 @@ const (
 	ERR_NOOPERHOST        NumericCode = 491
 	ERR_UMODEUNKNOWNFLAG  NumericCode = 501
 	ERR_USERSDONTMATCH    NumericCode = 502
+	ERR_SILELISTFULL      NumericCode = 511
+	ERR_HELPNOTFOUND      NumericCode = 524
+	RPL_YOURLANGUAGESARE  NumericCode = 687
+	RPL_HELPSTART         NumericCode = 704
+	RPL_HELPTXT           NumericCode = 705
+	RPL_ENDOFHELP         NumericCode = 706
+	ERR_TARGUMODEG        NumericCode = 716
+	RPL_TARGNOTIFY        NumericCode = 717
+	RPL_UMODEGMSG         NumericCode = 718
+	RPL_WHOISKEYVALUE     NumericCode = 760
+	RPL_KEYVALUE          NumericCode = 761
+	RPL_METADATAEND       NumericCode = 762
+	RPL_KEYNOTSET         NumericCode = 766
+	RPL_METADATASUBOK     NumericCode = 770
+	RPL_METADATAUNSUBOK   NumericCode = 771
+	RPL_METADATASUBS      NumericCode = 772
+	RPL_LOGGEDIN          NumericCode = 900
+	RPL_LOGGEDOUT         NumericCode = 901
+	RPL_SASLSUCCESS       NumericCode = 903
+	ERR_SASLFAIL          NumericCode = 904
+	ERR_SASLTOOLONG       NumericCode = 905
+	ERR_SASLABORTED       NumericCode = 906
+	ERR_SASLALREADY       NumericCode = 907
+	RPL_SASLMECHS         NumericCode = 908
+	ERR_NOLANGUAGE        NumericCode = 982
 )
@@ -0,0 +1,92 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	CTCP_DELIM = "\x01"
+
+	CTCPAction     = "ACTION"
+	CTCPClientInfo = "CLIENTINFO"
+	CTCPPing       = "PING"
+	CTCPTime       = "TIME"
+	CTCPVersion    = "VERSION"
+)
+
+// ParseCTCP splits a CTCP message into its command and parameters. The
+// closing delimiter is optional, as many clients leave it off.
+func ParseCTCP(message Text) (command string, params string, ok bool) {
+	str := message.String()
+	if !strings.HasPrefix(str, CTCP_DELIM) {
+		return
+	}
+	str = strings.TrimSuffix(str[len(CTCP_DELIM):], CTCP_DELIM)
+	parts := strings.SplitN(str, " ", 2)
+	command = strings.ToUpper(parts[0])
+	if len(parts) > 1 {
+		params = parts[1]
+	}
+	ok = command != ""
+	return
+}
+
+func RplCTCPReply(source Identifiable, target Identifiable, command string,
+	params string) string {
+	return RplNotice(source, target, NewText(fmt.Sprintf("%s%s %s%s",
+		CTCP_DELIM, command, params, CTCP_DELIM)))
+}
+
+//
+// server goroutine
+//
+
+// ctcpReply answers a CTCP request sent to the server's name.
+func (server *Server) ctcpReply(client *Client, message Text) {
+	command, params, ok := ParseCTCP(message)
+	if !ok {
+		return
+	}
+	switch command {
+	case CTCPClientInfo:
+		params = strings.Join([]string{CTCPClientInfo, CTCPPing, CTCPTime,
+			CTCPVersion}, " ")
+	case CTCPPing:
+	case CTCPTime:
+		params = time.Now().Format(time.RFC1123Z)
+	case CTCPVersion:
+		params = SEM_VER
+	default:
+		return
+	}
+	client.Reply(RplCTCPReply(server, client, command, params))
+}
+
+// filterChannelCTCP refuses CTCPs other than ACTION to channels with +C,
+// and drops or limits them according to the ctcp settings.
+func filterChannelCTCP(channel *Channel, client *Client, message Text) (Text, error) {
+	command, _, ok := ParseCTCP(message)
+	if !ok || (command == CTCPAction) || client.flags[Operator] {
+		return message, nil
+	}
+	config := &channel.server.ctcp
+	if channel.flags[NoCTCP] || config.StripChannel {
+		return message, ErrCannotSendToChan
+	}
+	if config.ChannelLimit <= 0 {
+		return message, nil
+	}
+
+	now := time.Now()
+	if now.Sub(client.ctcpStart) > config.ChannelPeriod {
+		client.ctcpStart = now
+		client.ctcpCount = 0
+	}
+	client.ctcpCount += 1
+	if client.ctcpCount > config.ChannelLimit {
+		return message, ErrCannotSendToChan
+	}
+	return message, nil
+}
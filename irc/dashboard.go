@@ -0,0 +1,154 @@
+package irc
+
+import (
+	"net/http"
+)
+
+// The dashboard is a small page on the wslisten HTTP server that polls the
+// admin API. It logs in with the api users (HTTP basic auth), not IRC
+// operators.
+
+const (
+	DASHBOARD_PATH = "/admin/"
+)
+
+func (server *Server) dashboard(config *Config, mux *http.ServeMux) {
+	users := config.API.users()
+	server.apiRoutes(mux, users)
+	mux.HandleFunc(DASHBOARD_PATH, func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		hash := users[NewName(user)]
+		if !ok || (hash == nil) || (ComparePassword(hash, []byte(password)) != nil) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ergonomadic"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(dashboardHTML))
+	})
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ergonomadic</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+#stats span { margin-right: 2em; font-size: 1.2em; }
+button { margin-right: 0.5em; }
+</style>
+</head>
+<body>
+<h1>ergonomadic</h1>
+<p id="stats"></p>
+<p>
+<button onclick="kill()">Kill</button>
+<button onclick="kline()">K-line</button>
+<button onclick="unkline()">Remove K-line</button>
+<button onclick="notice()">Notice everyone</button>
+<button onclick="call('POST', 'rehash')">Rehash</button>
+</p>
+<h2>Recent connections</h2>
+<table id="connects"></table>
+<h2>Channels</h2>
+<table id="channels"></table>
+<h2>K-lines</h2>
+<table id="klines"></table>
+<script>
+function call(method, path, body) {
+  return fetch("/api/v1/" + path, {
+    method: method,
+    credentials: "same-origin",
+    headers: {"Content-Type": "application/json"},
+    body: body ? JSON.stringify(body) : undefined
+  }).then(function(response) {
+    return response.json().then(function(value) {
+      if (!response.ok) { alert(value.error); }
+      refresh();
+      return value;
+    });
+  });
+}
+
+function get(path) {
+  return fetch("/api/v1/" + path, {credentials: "same-origin"})
+    .then(function(response) { return response.json(); });
+}
+
+function table(id, columns, rows) {
+  var el = document.getElementById(id);
+  el.textContent = "";
+  var head = el.insertRow();
+  columns.forEach(function(column) {
+    var th = document.createElement("th");
+    th.textContent = column;
+    head.appendChild(th);
+  });
+  rows.forEach(function(row) {
+    var tr = el.insertRow();
+    columns.forEach(function(column) {
+      var value = row[column];
+      if (column == "time" || column == "ctime") {
+        value = new Date(value * 1000).toLocaleString();
+      }
+      tr.insertCell().textContent = value;
+    });
+  });
+}
+
+function refresh() {
+  get("stats").then(function(stats) {
+    var el = document.getElementById("stats");
+    el.textContent = "";
+    ["users", "invisible", "operators", "channels", "klines"].forEach(function(key) {
+      var span = document.createElement("span");
+      span.textContent = key + ": " + stats[key];
+      el.appendChild(span);
+    });
+  });
+  get("connects").then(function(rows) {
+    table("connects", ["time", "nick", "username", "hostname", "ip"], rows);
+  });
+  get("channels").then(function(rows) {
+    table("channels", ["name", "members", "modes", "topic"], rows);
+  });
+  get("klines").then(function(rows) {
+    table("klines", ["mask", "reason", "setter", "ctime"], rows);
+  });
+}
+
+function kill() {
+  var nick = prompt("Nick to kill");
+  if (nick) { call("POST", "kill", {nick: nick, reason: prompt("Reason") || ""}); }
+}
+
+function kline() {
+  var mask = prompt("user@host to K-line");
+  if (mask) {
+    call("POST", "klines", {
+      mask: mask,
+      reason: prompt("Reason") || "",
+      minutes: parseInt(prompt("Minutes (blank for permanent)") || "0", 10)
+    });
+  }
+}
+
+function unkline() {
+  var mask = prompt("user@host to remove");
+  if (mask) { call("DELETE", "klines?mask=" + encodeURIComponent(mask)); }
+}
+
+function notice() {
+  var message = prompt("Notice to send everyone");
+  if (message) { call("POST", "notice", {message: message}); }
+}
+
+refresh();
+setInterval(refresh, 10000);
+</script>
+</body>
+</html>
+`
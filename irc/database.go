@@ -21,22 +21,148 @@ func InitDB(path string) {
           user_limit INTEGER DEFAULT 0,
           ban_list TEXT DEFAULT '',
           except_list TEXT DEFAULT '',
-          invite_list TEXT DEFAULT '')`)
+          invite_list TEXT DEFAULT '',
+          topic_setter TEXT DEFAULT '',
+          topic_time INTEGER DEFAULT 0,
+          forward TEXT DEFAULT '',
+          slow_mode INTEGER DEFAULT 0,
+          log_days INTEGER DEFAULT 0)`)
 	if err != nil {
 		log.Fatal("initdb error: ", err)
 	}
+	createTables(db)
+}
+
+// tables added since the first release; created by both initdb and
+// upgradedb
+var tableSchemas = []string{
+	`CREATE TABLE IF NOT EXISTS account (
+          name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+          password TEXT NOT NULL,
+          ctime INTEGER DEFAULT 0)`,
+	`CREATE TABLE IF NOT EXISTS account_silence (
+          account TEXT NOT NULL COLLATE NOCASE,
+          mask TEXT NOT NULL,
+          UNIQUE (account, mask) ON CONFLICT IGNORE)`,
+	`CREATE TABLE IF NOT EXISTS account_realname (
+          account TEXT NOT NULL UNIQUE COLLATE NOCASE,
+          realname TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS account_certfp (
+          account TEXT NOT NULL COLLATE NOCASE,
+          fingerprint TEXT NOT NULL UNIQUE)`,
+	`CREATE TABLE IF NOT EXISTS account_email (
+          account TEXT NOT NULL UNIQUE COLLATE NOCASE,
+          email TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS account_code (
+          account TEXT NOT NULL COLLATE NOCASE,
+          purpose TEXT NOT NULL,
+          code TEXT NOT NULL,
+          email TEXT DEFAULT '',
+          expires INTEGER NOT NULL,
+          UNIQUE (account, purpose) ON CONFLICT REPLACE)`,
+	`CREATE TABLE IF NOT EXISTS account_setting (
+          account TEXT NOT NULL COLLATE NOCASE,
+          name TEXT NOT NULL,
+          value TEXT NOT NULL,
+          UNIQUE (account, name) ON CONFLICT REPLACE)`,
+	`CREATE TABLE IF NOT EXISTS account_vhost (
+          account TEXT NOT NULL UNIQUE COLLATE NOCASE,
+          vhost TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS audit (
+          time INTEGER NOT NULL,
+          oper TEXT NOT NULL,
+          mask TEXT NOT NULL,
+          action TEXT NOT NULL,
+          target TEXT DEFAULT '',
+          detail TEXT DEFAULT '')`,
+	`CREATE TABLE IF NOT EXISTS memo (
+          id INTEGER PRIMARY KEY,
+          account TEXT NOT NULL COLLATE NOCASE,
+          sender TEXT NOT NULL,
+          time INTEGER NOT NULL,
+          text TEXT NOT NULL,
+          read INTEGER DEFAULT 0)`,
+	`CREATE TABLE IF NOT EXISTS theater_access (
+          channel TEXT NOT NULL COLLATE NOCASE,
+          account TEXT NOT NULL COLLATE NOCASE,
+          UNIQUE (channel, account) ON CONFLICT IGNORE)`,
+	`CREATE TABLE IF NOT EXISTS account_metadata (
+          account TEXT NOT NULL COLLATE NOCASE,
+          key TEXT NOT NULL,
+          value TEXT NOT NULL,
+          UNIQUE (account, key) ON CONFLICT REPLACE)`,
+	`CREATE TABLE IF NOT EXISTS channel_metadata (
+          channel TEXT NOT NULL COLLATE NOCASE,
+          key TEXT NOT NULL,
+          value TEXT NOT NULL,
+          UNIQUE (channel, key) ON CONFLICT REPLACE)`,
+	`CREATE TABLE IF NOT EXISTS kline (
+          mask TEXT NOT NULL UNIQUE,
+          reason TEXT DEFAULT '',
+          setter TEXT DEFAULT '',
+          ctime INTEGER DEFAULT 0,
+          expires INTEGER DEFAULT 0)`,
+}
+
+func createTables(db *sql.DB) {
+	for _, schema := range tableSchemas {
+		if _, err := db.Exec(schema); err != nil {
+			log.Fatal("create table error: ", err)
+		}
+	}
+}
+
+// columns added to the channel table since the first release, in order
+var upgradeColumns = []struct {
+	name string
+	decl string
+}{
+	{"ban_list", "TEXT DEFAULT ''"},
+	{"except_list", "TEXT DEFAULT ''"},
+	{"invite_list", "TEXT DEFAULT ''"},
+	{"topic_setter", "TEXT DEFAULT ''"},
+	{"topic_time", "INTEGER DEFAULT 0"},
+	{"forward", "TEXT DEFAULT ''"},
+	{"slow_mode", "INTEGER DEFAULT 0"},
+	{"log_days", "INTEGER DEFAULT 0"},
 }
 
 func UpgradeDB(path string) {
 	db := OpenDB(path)
-	alter := `ALTER TABLE channel ADD COLUMN %s TEXT DEFAULT ''`
-	cols := []string{"ban_list", "except_list", "invite_list"}
-	for _, col := range cols {
-		_, err := db.Exec(fmt.Sprintf(alter, col))
+	defer db.Close()
+
+	existing := tableColumns(db, "channel")
+	alter := `ALTER TABLE channel ADD COLUMN %s %s`
+	for _, col := range upgradeColumns {
+		if existing[col.name] {
+			continue
+		}
+		_, err := db.Exec(fmt.Sprintf(alter, col.name, col.decl))
 		if err != nil {
 			log.Fatal("updatedb error: ", err)
 		}
 	}
+	createTables(db)
+}
+
+func tableColumns(db *sql.DB, table string) map[string]bool {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		log.Fatal("updatedb error: ", err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			log.Fatal("updatedb error: ", err)
+		}
+		columns[name] = true
+	}
+	return columns
 }
 
 func OpenDB(path string) *sql.DB {
@@ -1,6 +1,11 @@
 package irc
 
 import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
 	"os"
 	"runtime"
 	"runtime/debug"
@@ -8,6 +13,115 @@ import (
 	"time"
 )
 
+// The debug listener serves net/http/pprof under /debug/pprof/ and the
+// server's internals, as expvar, at /debug/vars. It has no login, so it
+// only listens on loopback addresses.
+
+type DebugConfig struct {
+	Listen string
+}
+
+func (conf *DebugConfig) validate() error {
+	if conf.Listen == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(conf.Listen)
+	if err != nil {
+		return fmt.Errorf("Debug listen: %s", err)
+	}
+	if ip := net.ParseIP(host); (host != "localhost") &&
+		((ip == nil) || !ip.IsLoopback()) {
+		return fmt.Errorf("Debug listen must be a loopback address: %s",
+			conf.Listen)
+	}
+	return nil
+}
+
+// DebugVars are the server internals published at /debug/vars.
+type DebugVars struct {
+	Goroutines    int              `json:"goroutines"`
+	Clients       int              `json:"clients"`
+	Unregistered  int              `json:"unregistered"`
+	Detached      int              `json:"detached"`
+	Channels      int              `json:"channels"`
+	PerClient     float64          `json:"goroutines_per_client"`
+	SendQLines    int              `json:"sendq_lines"`
+	SendQBytes    int              `json:"sendq_bytes"`
+	SendQs        map[string]int   `json:"sendqs"` // bytes, by nick, if any
+	WebhookQueues map[string]int   `json:"webhook_queues"`
+	Commands      map[string]int64 `json:"commands"`
+}
+
+//
+// debug HTTP goroutines
+//
+
+func (server *Server) debugListen(config *DebugConfig) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	expvar.Publish("ergonomadic", expvar.Func(func() interface{} {
+		vars, _ := server.apiCall("", (*Server).debugVars)
+		return vars
+	}))
+
+	go func() {
+		Log.info.Printf("%s debug listening on %s", server, config.Listen)
+		err := http.ListenAndServe(config.Listen, mux)
+		if err != nil {
+			Log.error.Printf("%s debug listenAndServe error: %s", server, err)
+		}
+	}()
+}
+
+//
+// server goroutine
+//
+
+func (server *Server) debugVars(user Name) (interface{}, error) {
+	vars := &DebugVars{
+		Goroutines:    runtime.NumGoroutine(),
+		Channels:      len(server.channels),
+		SendQs:        make(map[string]int),
+		WebhookQueues: make(map[string]int),
+		Commands:      make(map[string]int64),
+	}
+	for _, client := range server.clients.byNick {
+		if client.IsService() {
+			continue
+		}
+		vars.Clients += 1
+		if !client.registered {
+			vars.Unregistered += 1
+		}
+		if client.detached {
+			vars.Detached += 1
+			continue
+		}
+		lines, bytes := client.socket.Queued()
+		vars.SendQLines += lines
+		vars.SendQBytes += bytes
+		if bytes > 0 {
+			vars.SendQs[client.Nick().String()] = bytes
+		}
+	}
+	if vars.Clients > 0 {
+		vars.PerClient = float64(vars.Goroutines) / float64(vars.Clients)
+	}
+	for _, hook := range server.webhooks {
+		vars.WebhookQueues[hook.conf.URL] = len(hook.queue)
+	}
+	for code, stats := range server.commandStats {
+		vars.Commands[code.String()] = int64(stats.count)
+	}
+	return vars, nil
+}
+
 func (msg *DebugCommand) HandleServer(server *Server) {
 	client := msg.Client()
 	if !client.flags[Operator] {
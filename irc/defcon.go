@@ -0,0 +1,128 @@
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Defcon levels lock the server down during a spam attack. Level 5 is
+// normal operation; operators switch to levels 1 to 4 with DEFCON, and
+// each level's restrictions are set in the config.
+
+const (
+	DEFCON_NORMAL = 5
+)
+
+type DefconLevelConfig struct {
+	// new connections are turned away
+	NoConnections bool `yaml:"no-connections"`
+	// clients must log in with SASL to register
+	RequireSASL bool `yaml:"require-sasl"`
+	// only operators may create channels
+	NoNewChannels bool `yaml:"no-new-channels"`
+	// clients not logged in to an account may only message pseudo-clients
+	MuteUnidentified bool `yaml:"mute-unidentified"`
+}
+
+// Restrictions lists the restrictions in effect at a level.
+func (conf *DefconLevelConfig) Restrictions() []string {
+	restrictions := make([]string, 0)
+	if conf.NoConnections {
+		restrictions = append(restrictions, "no new connections")
+	}
+	if conf.RequireSASL {
+		restrictions = append(restrictions, "SASL required")
+	}
+	if conf.NoNewChannels {
+		restrictions = append(restrictions, "no new channels")
+	}
+	if conf.MuteUnidentified {
+		restrictions = append(restrictions, "unidentified users muted")
+	}
+	return restrictions
+}
+
+func validateDefcon(levels map[int]*DefconLevelConfig) error {
+	for level := range levels {
+		if (level < 1) || (level >= DEFCON_NORMAL) {
+			return fmt.Errorf("Defcon levels must be 1 to %d: %d",
+				DEFCON_NORMAL-1, level)
+		}
+	}
+	return nil
+}
+
+// DEFCON [<level>]
+
+type DefconCommand struct {
+	BaseCommand
+	level int // 0 to show the current level
+}
+
+func ParseDefconCommand(args []string) (Command, error) {
+	cmd := &DefconCommand{}
+	if len(args) > 0 {
+		level, err := strconv.Atoi(args[0])
+		if (err != nil) || (level < 1) || (level > DEFCON_NORMAL) {
+			return nil, ErrParseCommand
+		}
+		cmd.level = level
+	}
+	return cmd, nil
+}
+
+//
+// server goroutine
+//
+
+// Defcon is the restrictions at the current level.
+func (server *Server) Defcon() *DefconLevelConfig {
+	if conf := server.defconLevels[server.defcon]; conf != nil {
+		return conf
+	}
+	return &DefconLevelConfig{}
+}
+
+func (server *Server) defconDescription() string {
+	restrictions := server.Defcon().Restrictions()
+	if len(restrictions) == 0 {
+		restrictions = []string{"no restrictions"}
+	}
+	return fmt.Sprintf("DEFCON %d: %s", server.defcon,
+		strings.Join(restrictions, ", "))
+}
+
+func (msg *DefconCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.flags[Operator] {
+		client.ErrNoPrivileges()
+		return
+	}
+	if (msg.level == 0) || (msg.level == server.defcon) {
+		client.Reply(RplNotice(server, client,
+			NewText(server.defconDescription())))
+		return
+	}
+	if (msg.level != DEFCON_NORMAL) && (server.defconLevels[msg.level] == nil) {
+		client.Notice("DEFCON %d isn't configured", msg.level)
+		return
+	}
+
+	server.defcon = msg.level
+	server.Audit(client, DEFCON, strconv.Itoa(msg.level), "")
+	server.NoticeOperators(NewText(fmt.Sprintf("%s set %s", client.nick,
+		server.defconDescription())))
+}
+
+// defconMuted reports whether client may not message target at this
+// level, and tells it so.
+func (server *Server) defconMuted(client *Client, code StringCode,
+	target Name) bool {
+	if !server.Defcon().MuteUnidentified || (client.account != "") ||
+		((code != PRIVMSG) && (code != NOTICE)) || (server.services[target.ToLower()] != nil) {
+		return false
+	}
+	client.Notice("Your message to %s was blocked: log in to an account to talk", target)
+	return true
+}
@@ -0,0 +1,207 @@
+package irc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DNSBLAction string
+
+const (
+	DNSBLReject    DNSBLAction = "reject"
+	DNSBLKLine     DNSBLAction = "kline"
+	DNSBLMark      DNSBLAction = "mark"
+	DNSBLChallenge DNSBLAction = "challenge"
+
+	DEFAULT_DNSBL_CACHE   = time.Hour
+	DEFAULT_DNSBL_TIMEOUT = 5 * time.Second
+)
+
+// DNSBL is a DNS blacklist and what to do with clients it lists.
+type DNSBL struct {
+	zone     string
+	action   DNSBLAction
+	reason   string
+	duration time.Duration
+	replies  map[string]bool // any 127.0.0.x if empty
+}
+
+func (list *DNSBL) String() string {
+	return list.zone
+}
+
+// Reason is the list's reason with %s replaced by the client's address.
+func (list *DNSBL) Reason(ip string) Text {
+	if !strings.Contains(list.reason, "%s") {
+		return NewText(list.reason)
+	}
+	return NewText(fmt.Sprintf(list.reason, ip))
+}
+
+type dnsblResult struct {
+	listed  bool
+	expires time.Time
+}
+
+// DNSBLChecker looks addresses up in the blacklists, remembering answers
+// so that a client reconnecting over and over is only looked up once.
+// Lookups are made from client goroutines.
+type DNSBLChecker struct {
+	lists   []*DNSBL
+	cache   time.Duration
+	timeout time.Duration
+
+	lock    sync.Mutex
+	results map[string]dnsblResult
+}
+
+func NewDNSBLChecker(config *DNSBLConfig) *DNSBLChecker {
+	checker := &DNSBLChecker{
+		cache:   config.Cache,
+		timeout: config.Timeout,
+		results: make(map[string]dnsblResult),
+	}
+	for _, conf := range config.Lists {
+		list := &DNSBL{
+			zone:     strings.Trim(conf.Zone, "."),
+			action:   conf.Action,
+			reason:   conf.Reason,
+			duration: conf.Duration,
+			replies:  make(map[string]bool),
+		}
+		if list.reason == "" {
+			list.reason = fmt.Sprintf("Your address is listed in %s", list.zone)
+		}
+		for _, reply := range conf.Replies {
+			list.replies[reply] = true
+		}
+		checker.lists = append(checker.lists, list)
+	}
+	return checker
+}
+
+// dnsblQuery is the name to look up for ip in zone: the reversed octets of
+// an IPv4 address, or the reversed nibbles of an IPv6 one.
+func dnsblQuery(ip net.IP, zone string) string {
+	var parts []string
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			parts = append(parts, fmt.Sprintf("%d", ip4[i]))
+		}
+	} else {
+		for i := len(ip) - 1; i >= 0; i-- {
+			parts = append(parts, fmt.Sprintf("%x", ip[i]&0xf),
+				fmt.Sprintf("%x", ip[i]>>4))
+		}
+	}
+	return strings.Join(append(parts, zone), ".")
+}
+
+// Check returns the first list the address is in, or nil.
+func (checker *DNSBLChecker) Check(addr string) *DNSBL {
+	ip := net.ParseIP(addr)
+	if (ip == nil) || ip.IsLoopback() {
+		return nil
+	}
+	for _, list := range checker.lists {
+		if checker.listed(ip, list) {
+			return list
+		}
+	}
+	return nil
+}
+
+func (checker *DNSBLChecker) listed(ip net.IP, list *DNSBL) bool {
+	query := dnsblQuery(ip, list.zone)
+	now := time.Now()
+
+	checker.lock.Lock()
+	result, ok := checker.results[query]
+	checker.lock.Unlock()
+	if ok && now.Before(result.expires) {
+		return result.listed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checker.timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, query)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+			// don't remember a timeout or a server failure
+			Log.debug.Printf("dnsbl %s: %s", query, err)
+			return false
+		}
+	}
+
+	result = dnsblResult{expires: now.Add(checker.cache)}
+	for _, addr := range addrs {
+		if !strings.HasPrefix(addr, "127.") {
+			continue
+		}
+		if (len(list.replies) == 0) || list.replies[addr] {
+			result.listed = true
+			break
+		}
+	}
+
+	checker.lock.Lock()
+	defer checker.lock.Unlock()
+	// forget expired answers as new ones come in
+	for key, old := range checker.results {
+		if now.After(old.expires) {
+			delete(checker.results, key)
+		}
+	}
+	checker.results[query] = result
+	return result.listed
+}
+
+// DNSBLCommand is sent by the client goroutine when a new client's
+// address is listed.
+type DNSBLCommand struct {
+	BaseCommand
+	list *DNSBL
+}
+
+func NewDNSBLCommand(list *DNSBL) *DNSBLCommand {
+	cmd := &DNSBLCommand{
+		list: list,
+	}
+	cmd.code = PROXY
+	return cmd
+}
+
+func (msg *DNSBLCommand) HandleRegServer(server *Server) {
+	client := msg.Client()
+	list := msg.list
+	reason := list.Reason(client.IP())
+	server.NoticeOperators(NewText(fmt.Sprintf("%s (%s) is listed in %s (%s)",
+		client.Id(), client.IP(), list, list.action)))
+
+	switch list.action {
+	case DNSBLMark:
+		client.dnsbl = list
+
+	case DNSBLChallenge:
+		client.dnsbl = list
+		client.suspect = fmt.Sprintf("listed in %s", list)
+
+	case DNSBLKLine:
+		now := time.Now()
+		var expires time.Time
+		if list.duration > 0 {
+			expires = now.Add(list.duration)
+		}
+		server.AddKLine(NewKLine(client.autoKLineMask(), reason,
+			server.name, now, expires))
+		fallthrough
+
+	case DNSBLReject:
+		client.ErrYoureBannedCreep(reason)
+		client.Quit(reason)
+	}
+}
@@ -0,0 +1,315 @@
+package irc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Accounts may be registered with an email address. When email is set
+// up, the address is sent a code and the account only becomes usable once
+// the code is given with NICKSERV VERIFY. A verified address can be sent
+// a token to reset a forgotten password.
+
+const (
+	DEFAULT_EMAIL_EXPIRE = 24 * time.Hour
+
+	EmailVerify = "verify"
+	EmailReset  = "reset"
+)
+
+var (
+	EmailExpr = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+type EmailConfig struct {
+	// host:port of the SMTP relay; email is off without one
+	SMTP     string `yaml:"smtp"`
+	Username string
+	Password string
+	From     string
+	// NICKSERV REGISTER needs an email address
+	Required bool
+	// how long verification codes and reset tokens last
+	Expire time.Duration
+}
+
+func (conf *EmailConfig) validate() error {
+	if conf.SMTP == "" {
+		if conf.Required {
+			return errors.New("Email required needs an smtp relay")
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(conf.SMTP); err != nil {
+		return fmt.Errorf("Email smtp: %s", err)
+	}
+	if !EmailExpr.MatchString(conf.From) {
+		return fmt.Errorf("Email from isn't an address: %s", conf.From)
+	}
+	if conf.Expire == 0 {
+		conf.Expire = DEFAULT_EMAIL_EXPIRE
+	}
+	return nil
+}
+
+// NICKSERV VERIFY <account> <code>
+
+type NickServVerifyCommand struct {
+	BaseCommand
+	account Name
+	code    string
+}
+
+// NICKSERV RESETPASS <account>
+
+type NickServResetPassCommand struct {
+	BaseCommand
+	account Name
+}
+
+// NICKSERV SETPASS <account> <token> <password>
+
+type NickServSetPassCommand struct {
+	BaseCommand
+	account  Name
+	token    string
+	password string
+	hash     string
+	err      error
+}
+
+func (cmd *NickServSetPassCommand) LoadPassword(server *Server) {
+}
+
+func (cmd *NickServSetPassCommand) CheckPassword() {
+	cmd.hash, cmd.err = GenerateEncodedPassword(cmd.password)
+}
+
+func NewEmailToken() string {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(random)
+}
+
+// mail goroutine
+
+func (conf *EmailConfig) send(to string, subject string, body string) {
+	var auth smtp.Auth
+	if conf.Username != "" {
+		host, _, _ := net.SplitHostPort(conf.SMTP)
+		auth = smtp.PlainAuth("", conf.Username, conf.Password, host)
+	}
+	message := strings.Join([]string{
+		"From: " + conf.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+	err := smtp.SendMail(conf.SMTP, auth, conf.From, []string{to}, []byte(message))
+	if err != nil {
+		Log.error.Printf("email to %s: %s", to, err)
+	}
+}
+
+//
+// server goroutine
+//
+
+func (server *Server) emailEnabled() bool {
+	return server.email.SMTP != ""
+}
+
+// SendEmail mails an address without blocking the server.
+func (server *Server) SendEmail(to string, subject string, body string) {
+	go server.email.send(to, subject, body)
+}
+
+// addEmailCode saves a verification code or reset token for account,
+// replacing any earlier one.
+func (server *Server) addEmailCode(account Name, purpose string, code string,
+	email string) error {
+	_, err := server.db.Exec(`
+        INSERT OR REPLACE INTO account_code (account, purpose, code, email, expires)
+        VALUES (?, ?, ?, ?, ?)`, account.String(), purpose, code, email,
+		time.Now().Add(server.email.Expire).Unix())
+	return err
+}
+
+// takeEmailCode checks a code for account and uses it up. It returns the
+// email address saved with it.
+func (server *Server) takeEmailCode(account Name, purpose string,
+	code string) (email string, ok bool) {
+	var saved string
+	var expires int64
+	err := server.db.QueryRow(`
+        SELECT code, email, expires FROM account_code
+        WHERE account = ? AND purpose = ?`, account.String(), purpose).
+		Scan(&saved, &email, &expires)
+	if (err != nil) || (expires < time.Now().Unix()) ||
+		(strings.ToUpper(saved) != strings.ToUpper(code)) {
+		return "", false
+	}
+	_, err = server.db.Exec(`
+        DELETE FROM account_code WHERE account = ? AND purpose = ?`,
+		account.String(), purpose)
+	if err != nil {
+		log.Println("Server.takeEmailCode:", err)
+	}
+	return email, true
+}
+
+// accountPending reports whether account was registered with an email
+// address that hasn't been verified yet.
+func (server *Server) accountPending(account Name) bool {
+	var found int
+	err := server.db.QueryRow(`
+        SELECT 1 FROM account_code WHERE account = ? AND purpose = ?`,
+		account.String(), EmailVerify).Scan(&found)
+	return err == nil
+}
+
+// checkAccountActive tells client if account can't be logged in to yet.
+func (server *Server) checkAccountActive(client *Client, account Name) bool {
+	if !server.accountPending(account) {
+		return true
+	}
+	client.Notice("%s hasn't been verified; use NICKSERV VERIFY %s <code>", account,
+		account)
+	return false
+}
+
+func (server *Server) accountEmail(account Name) string {
+	var email string
+	err := server.db.QueryRow(`SELECT email FROM account_email WHERE account = ?`,
+		account.String()).Scan(&email)
+	if err != nil {
+		return ""
+	}
+	return email
+}
+
+// expirePendingAccounts drops accounts that weren't verified in time, so
+// that their names can be registered again.
+func (server *Server) expirePendingAccounts() {
+	now := time.Now().Unix()
+	rows, err := server.db.Query(`
+        SELECT account FROM account_code WHERE purpose = ? AND expires < ?`,
+		EmailVerify, now)
+	if err != nil {
+		log.Println("Server.expirePendingAccounts:", err)
+		return
+	}
+	expired := make([]Name, 0)
+	for rows.Next() {
+		var account string
+		if err := rows.Scan(&account); err == nil {
+			expired = append(expired, NewName(account))
+		}
+	}
+	rows.Close()
+
+	for _, account := range expired {
+		if _, err := server.db.Exec(`DELETE FROM account WHERE name = ?`,
+			account.String()); err != nil {
+			log.Println("Server.expirePendingAccounts:", err)
+		}
+		delete(server.accountSkeletons, Skeleton(account))
+	}
+	if _, err := server.db.Exec(`DELETE FROM account_code WHERE expires < ?`,
+		now); err != nil {
+		log.Println("Server.expirePendingAccounts:", err)
+	}
+}
+
+// RequestVerification sends a newly registered account's code to email.
+func (server *Server) RequestVerification(client *Client, account Name,
+	email string) {
+	code := NewChallengeCode()
+	if err := server.addEmailCode(account, EmailVerify, code, email); err != nil {
+		log.Println("Server.RequestVerification:", err)
+		return
+	}
+	server.SendEmail(email, fmt.Sprintf("Verify your %s account",
+		server.network.Name), fmt.Sprintf(
+		"Someone registered the account %s on %s with this address.\r\n\r\n"+
+			"To finish, send: /NICKSERV VERIFY %s %s\r\n\r\n"+
+			"The code expires in %s. If this wasn't you, ignore this email.\r\n",
+		account, server.network.Name, account, code, server.email.Expire))
+	client.Notice("A verification code was sent to %s; finish with NICKSERV VERIFY %s <code>",
+		email, account)
+}
+
+func (msg *NickServVerifyCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	email, ok := server.takeEmailCode(msg.account, EmailVerify, msg.code)
+	if !ok {
+		client.Notice("that code is wrong or has expired")
+		return
+	}
+	_, err := server.db.Exec(`
+        INSERT OR REPLACE INTO account_email (account, email) VALUES (?, ?)`,
+		msg.account.String(), email)
+	if err != nil {
+		log.Println("NickServVerifyCommand.HandleServer:", err)
+	}
+	client.Notice("%s is verified", msg.account)
+	if client.account == "" {
+		client.Login(msg.account)
+	}
+}
+
+func (msg *NickServResetPassCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	// the same answer whether or not there's an address, so as not to
+	// reveal which accounts have one
+	client.Notice("If %s has a verified email address, a reset token was sent to it",
+		msg.account)
+
+	email := server.accountEmail(msg.account)
+	if !server.emailEnabled() || (email == "") {
+		return
+	}
+	token := NewEmailToken()
+	if err := server.addEmailCode(msg.account, EmailReset, token, email); err != nil {
+		log.Println("NickServResetPassCommand.HandleServer:", err)
+		return
+	}
+	server.SendEmail(email, fmt.Sprintf("Reset your %s password",
+		server.network.Name), fmt.Sprintf(
+		"Someone asked to reset the password of %s on %s.\r\n\r\n"+
+			"To choose a new one, send: /NICKSERV SETPASS %s %s <password>\r\n\r\n"+
+			"The token expires in %s. If this wasn't you, ignore this email.\r\n",
+		msg.account, server.network.Name, msg.account, token, server.email.Expire))
+}
+
+func (msg *NickServSetPassCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if msg.err != nil {
+		client.Reply(RplNotice(server, client, NewText(msg.err.Error())))
+		return
+	}
+	if _, ok := server.takeEmailCode(msg.account, EmailReset, msg.token); !ok {
+		client.Notice("that token is wrong or has expired")
+		return
+	}
+	_, err := server.db.Exec(`UPDATE account SET password = ? WHERE name = ?`,
+		msg.hash, msg.account.String())
+	if err != nil {
+		log.Println("NickServSetPassCommand.HandleServer:", err)
+		return
+	}
+	client.Notice("The password of %s was changed", msg.account)
+}
@@ -0,0 +1,226 @@
+package irc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The event stream sends server events to API users over a WebSocket at
+// /api/v1/events, as the same JSON payloads webhooks are POSTed, so that
+// logging bots and analytics needn't join as IRC clients. As well as the
+// webhook events it has joins, parts and quits, and messages in the
+// channels listed in the config. ?events=<event>,... picks which are sent.
+// It's read-only: anything sent to the server is ignored.
+
+const (
+	EventChannelJoin    WebhookEvent = "channel.join"
+	EventChannelPart    WebhookEvent = "channel.part"
+	EventChannelMessage WebhookEvent = "channel.message"
+	EventUserQuit       WebhookEvent = "user.quit"
+
+	DEFAULT_EVENT_STREAM_BUFFER = 256 // events waiting for each stream
+	EVENT_STREAM_WRITE_TIMEOUT  = 10 * time.Second
+)
+
+var (
+	StreamEvents = map[WebhookEvent]bool{
+		EventChannelJoin:    true,
+		EventChannelPart:    true,
+		EventChannelMessage: true,
+		EventUserQuit:       true,
+	}
+
+	// unlike IRC over WebSocket, the stream is logged in with basic auth,
+	// which browsers send for any page; only the same origin may open it
+	streamUpgrader = websocket.Upgrader{}
+)
+
+type EventStreamConfig struct {
+	// channels whose messages are streamed
+	Channels []string
+	Buffer   int
+}
+
+func (conf *EventStreamConfig) validate() error {
+	if conf.Buffer <= 0 {
+		conf.Buffer = DEFAULT_EVENT_STREAM_BUFFER
+	}
+	return nil
+}
+
+func (conf *EventStreamConfig) channels() map[Name]bool {
+	channels := make(map[Name]bool)
+	for _, name := range conf.Channels {
+		channels[NewName(name).ToLower()] = true
+	}
+	return channels
+}
+
+type EventStream struct {
+	user   Name
+	conn   *websocket.Conn
+	events map[WebhookEvent]bool // nil for all events
+	queue  chan []byte
+	done   chan bool
+}
+
+func (stream *EventStream) String() string {
+	return stream.user.String() + "@" + stream.conn.RemoteAddr().String()
+}
+
+func (stream *EventStream) Wants(event WebhookEvent) bool {
+	return (stream.events == nil) || stream.events[event]
+}
+
+//
+// HTTP goroutines
+//
+
+// streamEvents upgrades an API request to an event stream and writes
+// events to it until it is closed.
+func (server *Server) streamEvents(w http.ResponseWriter, r *http.Request,
+	user Name) {
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		Log.error.Printf("%s event stream upgrade error: %s", server, err)
+		return
+	}
+
+	stream := &EventStream{
+		user:  user,
+		conn:  conn,
+		queue: make(chan []byte, server.eventBuffer),
+		done:  make(chan bool),
+	}
+	if events := r.URL.Query().Get("events"); events != "" {
+		stream.events = make(map[WebhookEvent]bool)
+		for _, event := range strings.Split(events, ",") {
+			stream.events[WebhookEvent(event)] = true
+		}
+	}
+	server.apiCall(user, func(server *Server, user Name) (interface{}, error) {
+		server.eventStreams[stream] = true
+		return nil, nil
+	})
+	Log.info.Printf("%s: event stream opened", stream)
+
+	go stream.readLoop(server)
+	stream.writeLoop()
+}
+
+// readLoop discards what the consumer sends until the stream closes, then
+// stops the server sending it events.
+func (stream *EventStream) readLoop(server *Server) {
+	for {
+		if _, _, err := stream.conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	server.apiCall(stream.user, func(server *Server, user Name) (interface{}, error) {
+		delete(server.eventStreams, stream)
+		return nil, nil
+	})
+	close(stream.done)
+	Log.info.Printf("%s: event stream closed", stream)
+}
+
+func (stream *EventStream) writeLoop() {
+	defer stream.conn.Close()
+	for {
+		select {
+		case body := <-stream.queue:
+			stream.conn.SetWriteDeadline(time.Now().Add(EVENT_STREAM_WRITE_TIMEOUT))
+			if err := stream.conn.WriteMessage(websocket.TextMessage, body); err != nil {
+				Log.debug.Printf("%s: event stream write error: %s", stream, err)
+				return
+			}
+		case <-stream.done:
+			return
+		}
+	}
+}
+
+//
+// server goroutine
+//
+
+// Stream queues the event for every event stream that wants it. Like
+// Notify, it never blocks.
+func (server *Server) Stream(event WebhookEvent, data map[string]string) {
+	if len(server.eventStreams) == 0 {
+		return
+	}
+	body, err := json.Marshal(&WebhookPayload{
+		Event:  event,
+		Time:   time.Now().Unix(),
+		Server: server.name.String(),
+		Data:   data,
+	})
+	if err != nil {
+		Log.error.Printf("event stream %s: %s", event, err)
+		return
+	}
+
+	for stream := range server.eventStreams {
+		if !stream.Wants(event) {
+			continue
+		}
+		select {
+		case stream.queue <- body:
+		default:
+			Log.error.Printf("%s: event stream queue full, dropping %s",
+				stream, event)
+		}
+	}
+}
+
+func (channel *Channel) StreamJoin(client *Client) {
+	channel.server.Stream(EventChannelJoin, map[string]string{
+		"channel":  channel.name.String(),
+		"nick":     client.nick.String(),
+		"username": client.username.String(),
+		"hostname": client.hostname.String(),
+		"account":  client.account.String(),
+	})
+}
+
+func (channel *Channel) StreamPart(client *Client, message Text) {
+	channel.server.Stream(EventChannelPart, map[string]string{
+		"channel": channel.name.String(),
+		"nick":    client.nick.String(),
+		"message": message.String(),
+	})
+}
+
+// StreamMessage streams a PRIVMSG or NOTICE if the channel is one whose
+// messages are. source is who it's from: client, or a character client is
+// playing.
+func (channel *Channel) StreamMessage(client *Client, source Identifiable,
+	code StringCode, message Text) {
+	if !channel.server.streamChannels[channel.name.ToLower()] {
+		return
+	}
+	channel.server.Stream(EventChannelMessage, map[string]string{
+		"channel": channel.name.String(),
+		"nick":    source.Nick().String(),
+		"account": client.account.String(),
+		"command": code.String(),
+		"message": message.String(),
+	})
+}
+
+func (client *Client) StreamQuit(message Text) {
+	channels := make([]string, 0, len(client.channels))
+	for channel := range client.channels {
+		channels = append(channels, channel.name.String())
+	}
+	client.server.Stream(EventUserQuit, map[string]string{
+		"nick":     client.nick.String(),
+		"message":  message.String(),
+		"channels": strings.Join(channels, ","),
+	})
+}
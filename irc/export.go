@@ -0,0 +1,383 @@
+package irc
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// `ergonomadic export` dumps what the database stores for good, accounts
+// with their vhosts, registered channels with their ban lists, and
+// K-lines, as JSON; `ergonomadic import` loads a dump into a database
+// made by initdb, replacing the accounts, channels and K-lines it names.
+// A dump is a backup that survives schema changes, and the way to move
+// a server's state from one database to another. Pending email codes go
+// with their accounts, so that one never verified stays unusable; memos
+// and the audit log aren't dumped.
+
+type ServerDump struct {
+	Version  string         `json:"version"`
+	Time     int64          `json:"time"`
+	Accounts []*AccountDump `json:"accounts"`
+	Channels []*ChannelDump `json:"channels"`
+	KLines   []*KLineDump   `json:"klines"`
+}
+
+type AccountDump struct {
+	Name     string            `json:"name"`
+	Password string            `json:"password"` // encoded, as in the database
+	Created  int64             `json:"created"`
+	Realname string            `json:"realname,omitempty"`
+	Email    string            `json:"email,omitempty"`
+	VHost    string            `json:"vhost,omitempty"`
+	CertFPs  []string          `json:"certfps,omitempty"`
+	Silence  []string          `json:"silence,omitempty"`
+	Settings map[string]string `json:"settings,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Codes    []*CodeDump       `json:"codes,omitempty"`
+}
+
+// CodeDump is an email verification code or reset token.
+type CodeDump struct {
+	Purpose string `json:"purpose"`
+	Code    string `json:"code"`
+	Email   string `json:"email,omitempty"`
+	Expires int64  `json:"expires"`
+}
+
+type ChannelDump struct {
+	Name          string            `json:"name"`
+	Flags         string            `json:"flags"`
+	Key           string            `json:"key,omitempty"`
+	Topic         string            `json:"topic,omitempty"`
+	TopicSetter   string            `json:"topic-setter,omitempty"`
+	TopicTime     int64             `json:"topic-time,omitempty"`
+	UserLimit     uint64            `json:"user-limit,omitempty"`
+	Forward       string            `json:"forward,omitempty"`
+	SlowMode      uint64            `json:"slow-mode,omitempty"`
+	LogDays       uint64            `json:"log-days,omitempty"`
+	Bans          []string          `json:"bans,omitempty"`
+	Excepts       []string          `json:"excepts,omitempty"`
+	Invites       []string          `json:"invites,omitempty"`
+	TheaterAccess []string          `json:"theater-access,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+type KLineDump struct {
+	Mask    string `json:"mask"`
+	Reason  string `json:"reason,omitempty"`
+	Setter  string `json:"setter,omitempty"`
+	Created int64  `json:"created"`
+	Expires int64  `json:"expires,omitempty"` // 0 for never
+}
+
+func ExportDB(path string, w io.Writer) {
+	db := OpenDB(path)
+	defer db.Close()
+
+	dump := &ServerDump{
+		Version:  SEM_VER,
+		Time:     time.Now().Unix(),
+		Accounts: exportAccounts(db),
+		Channels: exportChannels(db),
+		KLines:   exportKLines(db),
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(dump); err != nil {
+		log.Fatal("export error: ", err)
+	}
+}
+
+func exportAccounts(db *sql.DB) []*AccountDump {
+	realnames := exportValues(db, `SELECT account, realname FROM account_realname`)
+	emails := exportValues(db, `SELECT account, email FROM account_email`)
+	vhosts := exportValues(db, `SELECT account, vhost FROM account_vhost`)
+	certfps := exportLists(db, `SELECT account, fingerprint FROM account_certfp`)
+	silences := exportLists(db, `SELECT account, mask FROM account_silence`)
+	settings := exportMaps(db, `SELECT account, name, value FROM account_setting`)
+	metadata := exportMaps(db, `SELECT account, key, value FROM account_metadata`)
+	codes := exportCodes(db)
+
+	rows, err := db.Query(`SELECT name, password, ctime FROM account ORDER BY name`)
+	if err != nil {
+		log.Fatal("export error: ", err)
+	}
+	defer rows.Close()
+	accounts := make([]*AccountDump, 0)
+	for rows.Next() {
+		account := &AccountDump{}
+		if err := rows.Scan(&account.Name, &account.Password,
+			&account.Created); err != nil {
+			log.Fatal("export error: ", err)
+		}
+		name := NewName(account.Name).ToLower().String()
+		account.Realname = realnames[name]
+		account.Email = emails[name]
+		account.VHost = vhosts[name]
+		account.CertFPs = certfps[name]
+		account.Silence = silences[name]
+		account.Settings = settings[name]
+		account.Metadata = metadata[name]
+		account.Codes = codes[name]
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+// exportCodes maps casefolded account names to their email codes.
+func exportCodes(db *sql.DB) map[string][]*CodeDump {
+	rows, err := db.Query(`
+        SELECT account, purpose, code, email, expires FROM account_code`)
+	if err != nil {
+		log.Fatal("export error: ", err)
+	}
+	defer rows.Close()
+	codes := make(map[string][]*CodeDump)
+	for rows.Next() {
+		var account string
+		code := &CodeDump{}
+		if err := rows.Scan(&account, &code.Purpose, &code.Code, &code.Email,
+			&code.Expires); err != nil {
+			log.Fatal("export error: ", err)
+		}
+		name := NewName(account).ToLower().String()
+		codes[name] = append(codes[name], code)
+	}
+	return codes
+}
+
+func exportChannels(db *sql.DB) []*ChannelDump {
+	access := exportLists(db, `SELECT channel, account FROM theater_access`)
+	metadata := exportMaps(db, `SELECT channel, key, value FROM channel_metadata`)
+
+	rows, err := db.Query(`
+        SELECT name, flags, key, topic, user_limit, ban_list, except_list,
+               invite_list, topic_setter, topic_time, forward, slow_mode,
+               log_days
+          FROM channel ORDER BY name`)
+	if err != nil {
+		log.Fatal("export error: ", err)
+	}
+	defer rows.Close()
+	channels := make([]*ChannelDump, 0)
+	for rows.Next() {
+		channel := &ChannelDump{}
+		var banList, exceptList, inviteList string
+		if err := rows.Scan(&channel.Name, &channel.Flags, &channel.Key,
+			&channel.Topic, &channel.UserLimit, &banList, &exceptList,
+			&inviteList, &channel.TopicSetter, &channel.TopicTime,
+			&channel.Forward, &channel.SlowMode, &channel.LogDays); err != nil {
+			log.Fatal("export error: ", err)
+		}
+		channel.Bans = strings.Fields(banList)
+		channel.Excepts = strings.Fields(exceptList)
+		channel.Invites = strings.Fields(inviteList)
+		name := NewName(channel.Name).ToLower().String()
+		channel.TheaterAccess = access[name]
+		channel.Metadata = metadata[name]
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func exportKLines(db *sql.DB) []*KLineDump {
+	rows, err := db.Query(`
+        SELECT mask, reason, setter, ctime, expires FROM kline ORDER BY mask`)
+	if err != nil {
+		log.Fatal("export error: ", err)
+	}
+	defer rows.Close()
+	klines := make([]*KLineDump, 0)
+	for rows.Next() {
+		kline := &KLineDump{}
+		if err := rows.Scan(&kline.Mask, &kline.Reason, &kline.Setter,
+			&kline.Created, &kline.Expires); err != nil {
+			log.Fatal("export error: ", err)
+		}
+		klines = append(klines, kline)
+	}
+	return klines
+}
+
+// exportValues maps the casefolded first column of query to the second.
+func exportValues(db *sql.DB, query string) map[string]string {
+	values := make(map[string]string)
+	exportRows(db, query, func(owner string, value string, _ string) {
+		values[owner] = value
+	})
+	return values
+}
+
+// exportLists maps the casefolded first column of query to the values of
+// the second.
+func exportLists(db *sql.DB, query string) map[string][]string {
+	lists := make(map[string][]string)
+	exportRows(db, query, func(owner string, value string, _ string) {
+		lists[owner] = append(lists[owner], value)
+	})
+	return lists
+}
+
+// exportMaps maps the casefolded first column of query to a map of the
+// second to the third.
+func exportMaps(db *sql.DB, query string) map[string]map[string]string {
+	maps := make(map[string]map[string]string)
+	exportRows(db, query, func(owner string, key string, value string) {
+		if maps[owner] == nil {
+			maps[owner] = make(map[string]string)
+		}
+		maps[owner][key] = value
+	})
+	return maps
+}
+
+func exportRows(db *sql.DB, query string, add func(string, string, string)) {
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Fatal("export error: ", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		log.Fatal("export error: ", err)
+	}
+	for rows.Next() {
+		values := make([]string, 3)
+		dest := make([]interface{}, len(columns))
+		for i := range dest {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			log.Fatal("export error: ", err)
+		}
+		add(NewName(values[0]).ToLower().String(), values[1], values[2])
+	}
+}
+
+// dumpImport runs the statements of an import in one transaction.
+type dumpImport struct {
+	tx *sql.Tx
+}
+
+func (imp *dumpImport) exec(query string, args ...interface{}) {
+	if _, err := imp.tx.Exec(query, args...); err != nil {
+		imp.tx.Rollback()
+		log.Fatal("import error: ", err)
+	}
+}
+
+func ImportDB(path string, r io.Reader) {
+	dump := &ServerDump{}
+	if err := json.NewDecoder(r).Decode(dump); err != nil {
+		log.Fatal("import error: ", err)
+	}
+
+	db := OpenDB(path)
+	defer db.Close()
+	if !tableColumns(db, "channel")["name"] {
+		log.Fatal("import error: run initdb first: ", path)
+	}
+	createTables(db)
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal("import error: ", err)
+	}
+	imp := &dumpImport{tx}
+	for _, account := range dump.Accounts {
+		imp.account(account)
+	}
+	for _, channel := range dump.Channels {
+		imp.channel(channel)
+	}
+	for _, kline := range dump.KLines {
+		imp.exec(`
+            INSERT OR REPLACE INTO kline (mask, reason, setter, ctime, expires)
+            VALUES (?, ?, ?, ?, ?)`,
+			kline.Mask, kline.Reason, kline.Setter, kline.Created, kline.Expires)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal("import error: ", err)
+	}
+	log.Printf("imported %d accounts, %d channels and %d K-lines",
+		len(dump.Accounts), len(dump.Channels), len(dump.KLines))
+}
+
+// account replaces an account and everything stored with it.
+func (imp *dumpImport) account(account *AccountDump) {
+	for _, table := range []string{"account_realname", "account_email",
+		"account_vhost", "account_certfp", "account_silence",
+		"account_setting", "account_metadata", "account_code"} {
+		imp.exec(`DELETE FROM `+table+` WHERE account = ?`, account.Name)
+	}
+	imp.exec(`
+        INSERT OR REPLACE INTO account (name, password, ctime)
+        VALUES (?, ?, ?)`, account.Name, account.Password, account.Created)
+	if account.Realname != "" {
+		imp.exec(`INSERT INTO account_realname (account, realname) VALUES (?, ?)`,
+			account.Name, account.Realname)
+	}
+	if account.Email != "" {
+		imp.exec(`INSERT INTO account_email (account, email) VALUES (?, ?)`,
+			account.Name, account.Email)
+	}
+	if account.VHost != "" {
+		imp.exec(`INSERT INTO account_vhost (account, vhost) VALUES (?, ?)`,
+			account.Name, account.VHost)
+	}
+	for _, certfp := range account.CertFPs {
+		imp.exec(`
+            INSERT OR REPLACE INTO account_certfp (account, fingerprint)
+            VALUES (?, ?)`, account.Name, certfp)
+	}
+	for _, mask := range account.Silence {
+		imp.exec(`INSERT INTO account_silence (account, mask) VALUES (?, ?)`,
+			account.Name, mask)
+	}
+	for name, value := range account.Settings {
+		imp.exec(`
+            INSERT INTO account_setting (account, name, value)
+            VALUES (?, ?, ?)`, account.Name, name, value)
+	}
+	for key, value := range account.Metadata {
+		imp.exec(`
+            INSERT INTO account_metadata (account, key, value)
+            VALUES (?, ?, ?)`, account.Name, key, value)
+	}
+	for _, code := range account.Codes {
+		imp.exec(`
+            INSERT INTO account_code (account, purpose, code, email, expires)
+            VALUES (?, ?, ?, ?, ?)`, account.Name, code.Purpose, code.Code,
+			code.Email, code.Expires)
+	}
+}
+
+// channel replaces a registered channel and everything stored with it.
+func (imp *dumpImport) channel(channel *ChannelDump) {
+	imp.exec(`DELETE FROM theater_access WHERE channel = ?`, channel.Name)
+	imp.exec(`DELETE FROM channel_metadata WHERE channel = ?`, channel.Name)
+	imp.exec(`
+        INSERT OR REPLACE INTO channel
+          (name, flags, key, topic, user_limit, ban_list, except_list,
+           invite_list, topic_setter, topic_time, forward, slow_mode,
+           log_days)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		channel.Name, channel.Flags, channel.Key, channel.Topic,
+		channel.UserLimit, strings.Join(channel.Bans, " "),
+		strings.Join(channel.Excepts, " "), strings.Join(channel.Invites, " "),
+		channel.TopicSetter, channel.TopicTime, channel.Forward,
+		channel.SlowMode, channel.LogDays)
+	for _, account := range channel.TheaterAccess {
+		imp.exec(`
+            INSERT INTO theater_access (channel, account) VALUES (?, ?)`,
+			channel.Name, account)
+	}
+	for key, value := range channel.Metadata {
+		imp.exec(`
+            INSERT INTO channel_metadata (channel, key, value)
+            VALUES (?, ?, ?)`, channel.Name, key, value)
+	}
+}
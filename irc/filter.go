@@ -0,0 +1,136 @@
+package irc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type FilterAction string
+
+const (
+	FilterBlock  FilterAction = "block"
+	FilterMute   FilterAction = "mute"
+	FilterKill   FilterAction = "kill"
+	FilterKLine  FilterAction = "kline"
+	FilterNotify FilterAction = "notify"
+
+	DEFAULT_MUTE = 10 * time.Minute
+)
+
+var (
+	// commands a filter checks when it doesn't list any
+	FilterCommands = []StringCode{PRIVMSG, NOTICE, PART, QUIT}
+)
+
+// Filter is a content rule from the config. Operators aren't filtered.
+type Filter struct {
+	pattern  *regexp.Regexp
+	action   FilterAction
+	reason   Text
+	commands map[StringCode]bool
+	duration time.Duration
+	hits     uint64
+}
+
+func NewFilters(config *Config) []*Filter {
+	filters := make([]*Filter, 0, len(config.Filters))
+	for _, conf := range config.Filters {
+		filter := &Filter{
+			pattern:  regexp.MustCompile(conf.Pattern),
+			action:   conf.Action,
+			reason:   NewText(conf.Reason),
+			commands: make(map[StringCode]bool),
+			duration: conf.Duration,
+		}
+		if filter.reason == "" {
+			filter.reason = "Message matched a filter"
+		}
+		if filter.duration <= 0 && filter.action == FilterMute {
+			filter.duration = DEFAULT_MUTE
+		}
+		commands := FilterCommands
+		if len(conf.Commands) > 0 {
+			commands = nil
+			for _, code := range conf.Commands {
+				commands = append(commands, StringCode(strings.ToUpper(code)))
+			}
+		}
+		for _, code := range commands {
+			filter.commands[code] = true
+		}
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+func (filter *Filter) Matches(code StringCode, message Text) bool {
+	return filter.commands[code] && filter.pattern.MatchString(message.String())
+}
+
+func (filter *Filter) String() string {
+	return filter.pattern.String()
+}
+
+//
+// server goroutine
+//
+
+// FilterMessage applies the filters to a message and says whether it may
+// be delivered. A client whose message is refused may have been quit.
+func (server *Server) FilterMessage(client *Client, code StringCode,
+	target Name, message Text) bool {
+	if client.flags[Operator] {
+		return true
+	}
+	if client.mutedUntil.After(time.Now()) {
+		return (code != PRIVMSG) && (code != NOTICE)
+	}
+	if server.defconMuted(client, code, target) {
+		return false
+	}
+
+	for _, filter := range server.filters {
+		if !filter.Matches(code, message) {
+			continue
+		}
+		filter.hits += 1
+		server.NoticeOperators(NewText(fmt.Sprintf(
+			"Filter %s (%s) matched %s %s %s: %s", filter, filter.action,
+			client.Id(), code, target, message)))
+
+		switch filter.action {
+		case FilterNotify:
+			continue
+
+		case FilterBlock:
+			client.Notice("Your message to %s was blocked: %s", target, filter.reason)
+
+		case FilterMute:
+			client.mutedUntil = time.Now().Add(filter.duration)
+			client.Notice("You have been muted for %s: %s", filter.duration, filter.reason)
+
+		case FilterKill:
+			client.Quit(NewText(fmt.Sprintf("Killed (%s (%s))", server.name,
+				filter.reason)))
+
+		case FilterKLine:
+			now := time.Now()
+			var expires time.Time
+			if filter.duration > 0 {
+				expires = now.Add(filter.duration)
+			}
+			server.AddKLine(NewKLine(client.autoKLineMask(), filter.reason,
+				server.name, now, expires))
+		}
+		return false
+	}
+	return true
+}
+
+func (server *Server) statsFilters(client *Client) {
+	for _, filter := range server.filters {
+		client.RplStatsFilter(filter)
+	}
+}
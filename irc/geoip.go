@@ -0,0 +1,382 @@
+package irc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Clients are tagged with the country their address is in, looked up in a
+// MaxMind database (GeoLite2-Country.mmdb or the like). Operators see it
+// in WHOIS and STATS g, and countries may be blocked or restricted.
+
+type GeoIPAction string
+
+const (
+	GeoIPBlock     GeoIPAction = "block"     // turned away
+	GeoIPSASL      GeoIPAction = "sasl"      // must log in with SASL
+	GeoIPChallenge GeoIPAction = "challenge" // must VERIFY
+
+	GEOIP_UNKNOWN = "--" // addresses that aren't in the database
+)
+
+type GeoIPConfig struct {
+	Database string
+	// what happens to clients from a country, by ISO code
+	Countries map[string]GeoIPAction
+}
+
+func (conf *GeoIPConfig) validate() error {
+	for country, action := range conf.Countries {
+		switch action {
+		case GeoIPBlock, GeoIPSASL, GeoIPChallenge:
+		default:
+			return fmt.Errorf("GeoIP %s action must be block, sasl or challenge: %s",
+				country, action)
+		}
+	}
+	if (len(conf.Countries) > 0) && (conf.Database == "") {
+		return errors.New("GeoIP countries need a database")
+	}
+	return nil
+}
+
+// GeoIP looks up countries and keeps counts of the clients from each.
+type GeoIP struct {
+	db        *MMDB
+	countries map[string]GeoIPAction
+	stats     map[string]*GeoIPStats
+}
+
+type GeoIPStats struct {
+	connections uint64
+	refused     uint64
+}
+
+func NewGeoIP(config *GeoIPConfig) (*GeoIP, error) {
+	db, err := OpenMMDB(config.Database)
+	if err != nil {
+		return nil, err
+	}
+	countries := make(map[string]GeoIPAction)
+	for country, action := range config.Countries {
+		countries[strings.ToUpper(country)] = action
+	}
+	return &GeoIP{
+		db:        db,
+		countries: countries,
+		stats:     make(map[string]*GeoIPStats),
+	}, nil
+}
+
+// Country is the ISO country code for ip, or GEOIP_UNKNOWN.
+func (geoip *GeoIP) Country(ip net.IP) string {
+	record, err := geoip.db.Lookup(ip)
+	if err != nil {
+		Log.debug.Printf("geoip %s: %s", ip, err)
+		return GEOIP_UNKNOWN
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := record[key].(map[string]interface{}); ok {
+			if code, ok := country["iso_code"].(string); ok {
+				return code
+			}
+		}
+	}
+	return GEOIP_UNKNOWN
+}
+
+//
+// server goroutine
+//
+
+// Admit looks up the country of a new connection and counts it. It
+// returns what to do with the client, if anything.
+func (geoip *GeoIP) Admit(ip net.IP) (country string, action GeoIPAction) {
+	country = geoip.Country(ip)
+	stats := geoip.stats[country]
+	if stats == nil {
+		stats = &GeoIPStats{}
+		geoip.stats[country] = stats
+	}
+	stats.connections += 1
+	action = geoip.countries[country]
+	if action == GeoIPBlock {
+		stats.refused += 1
+	}
+	return
+}
+
+func (server *Server) statsCountries(client *Client) {
+	if server.geoip == nil {
+		return
+	}
+	countries := make([]string, 0, len(server.geoip.stats))
+	for country := range server.geoip.stats {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+	for _, country := range countries {
+		client.RplStatsCountry(country, server.geoip.stats[country])
+	}
+}
+
+// MMDB reads the MaxMind DB format: a binary search tree on address bits
+// whose leaves point into a section of typed data.
+// https://maxmind.github.io/MaxMind-DB/
+type MMDB struct {
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint // the node for ::/96 in an IPv6 tree
+	treeSize   uint
+}
+
+var (
+	mmdbMetadataStart = []byte("\xab\xcd\xefMaxMind.com")
+
+	ErrMMDBInvalid = errors.New("invalid MaxMind database")
+)
+
+func OpenMMDB(filename string) (*MMDB, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	start := bytes.LastIndex(data, mmdbMetadataStart)
+	if start < 0 {
+		return nil, ErrMMDBInvalid
+	}
+	decoder := &mmdbDecoder{data: data[start+len(mmdbMetadataStart):]}
+	value, _, err := decoder.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, ErrMMDBInvalid
+	}
+	nodeCount, _ := metadata["node_count"].(uint64)
+	recordSize, _ := metadata["record_size"].(uint64)
+	ipVersion, _ := metadata["ip_version"].(uint64)
+	if (nodeCount == 0) ||
+		((recordSize != 24) && (recordSize != 28) && (recordSize != 32)) {
+		return nil, ErrMMDBInvalid
+	}
+
+	db := &MMDB{
+		data:       data[:start],
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		ipVersion:  uint(ipVersion),
+	}
+	db.treeSize = db.nodeCount * db.recordSize / 4
+	if db.treeSize+16 > uint(len(db.data)) {
+		return nil, ErrMMDBInvalid
+	}
+	if db.ipVersion == 6 {
+		for i := 0; (i < 96) && (db.ipv4Start < db.nodeCount); i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record reads the left (bit 0) or right (bit 1) record of a node.
+func (db *MMDB) record(node uint, bit uint) uint {
+	offset := node * db.recordSize / 4
+	b := db.data[offset:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Lookup finds the record for ip, which is nil if there isn't one.
+func (db *MMDB) Lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	bits := ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		node = db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; (i < len(bits)*8) && (node < db.nodeCount); i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+	if node <= db.nodeCount {
+		return nil, nil
+	}
+
+	decoder := &mmdbDecoder{data: db.data[db.treeSize+16:]}
+	value, _, err := decoder.decode(node - db.nodeCount - 16)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+type mmdbDecoder struct {
+	data []byte
+}
+
+const (
+	mmdbPointer = 1
+	mmdbString  = 2
+	mmdbDouble  = 3
+	mmdbBytes   = 4
+	mmdbUint16  = 5
+	mmdbUint32  = 6
+	mmdbMap     = 7
+	mmdbInt32   = 8
+	mmdbUint64  = 9
+	mmdbUint128 = 10
+	mmdbArray   = 11
+	mmdbBoolean = 14
+	mmdbFloat   = 15
+)
+
+// decode reads the value at offset, returning the offset after it.
+func (decoder *mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+	data := decoder.data
+	if offset >= uint(len(data)) {
+		return nil, 0, ErrMMDBInvalid
+	}
+	control := data[offset]
+	offset += 1
+	kind := uint(control >> 5)
+
+	if kind == mmdbPointer {
+		ss := uint(control>>3) & 3
+		if offset+ss+1 > uint(len(data)) {
+			return nil, 0, ErrMMDBInvalid
+		}
+		pointer := uint(control & 7)
+		b := data[offset : offset+ss+1]
+		switch ss {
+		case 0:
+			pointer = pointer<<8 | uint(b[0])
+		case 1:
+			pointer = (pointer<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			pointer = (pointer<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) +
+				526336
+		case 3:
+			pointer = uint(binary.BigEndian.Uint32(b))
+		}
+		value, _, err := decoder.decode(pointer)
+		return value, offset + ss + 1, err
+	}
+
+	if kind == 0 {
+		if offset >= uint(len(data)) {
+			return nil, 0, ErrMMDBInvalid
+		}
+		kind = 7 + uint(data[offset])
+		offset += 1
+	}
+	size := uint(control & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(data)) {
+			return nil, 0, ErrMMDBInvalid
+		}
+		extra := uint(0)
+		for _, b := range data[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		size = []uint{29, 285, 65821}[n-1] + extra
+		offset += n
+	}
+
+	switch kind {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := decoder.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := decoder.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrMMDBInvalid
+			}
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := decoder.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+
+	case mmdbBoolean:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, ErrMMDBInvalid
+	}
+	b := data[offset : offset+size]
+	offset += size
+	switch kind {
+	case mmdbString:
+		return string(b), offset, nil
+
+	case mmdbBytes, mmdbUint128:
+		return append([]byte(nil), b...), offset, nil
+
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, ErrMMDBInvalid
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, ErrMMDBInvalid
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		value := uint64(0)
+		for _, c := range b {
+			value = value<<8 | uint64(c)
+		}
+		if kind == mmdbInt32 {
+			return int64(int32(value)), offset, nil
+		}
+		return value, offset, nil
+	}
+	return nil, 0, ErrMMDBInvalid
+}
@@ -0,0 +1,393 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+)
+
+// HelpTopic is the text HELP shows for a command, or for a service
+// command as "NICKSERV REGISTER". Each line is translated on its own.
+type HelpTopic struct {
+	text     string
+	operOnly bool
+}
+
+// Lines are the topic's text, without the indentation of the source.
+func (topic *HelpTopic) Lines() []string {
+	lines := strings.Split(strings.Trim(topic.text, "\n"), "\n")
+	for index, line := range lines {
+		lines[index] = strings.TrimSpace(line)
+	}
+	return lines
+}
+
+var (
+	// HelpTopics must have an entry for each command in parseCommandFuncs
+	// that clients send themselves; aliases share their command's text.
+	HelpTopics = map[string]*HelpTopic{
+		"ACCEPT": {`
+            ACCEPT {*|[-]<nick>[,...]}
+            With +g (caller-id), only accepted clients may send you private
+            messages. ACCEPT * lists them; -<nick> removes one.`, false},
+		"ADMIN": {`
+            ADMIN [<target>]
+            Shows who runs the server and how to contact them.`, false},
+		"AUDIT": {`
+            AUDIT [<oper>]
+            Lists recent operator actions, optionally by one operator.`, true},
+		"AUTHENTICATE": {`
+            AUTHENTICATE <mechanism>
+            Logs in to an account with SASL before registering, after CAP REQ
+            sasl. Mechanisms are PLAIN, EXTERNAL (a client certificate on the
+            account) and, on websocket listeners, OAUTHBEARER.`, false},
+		"AWAY": {`
+            AWAY [<message>]
+            Marks you away with a message, or back without one.`, false},
+		"BRIDGE": {`
+            BRIDGE LOGIN <name> <password> | SPAWN <nick> <username>
+                :<realname> | DESPAWN <nick> [:<message>] | LIST
+            Makes the connection a bridge from the config, which spawns
+            puppet clients; tag a command draft/puppet=<nick> to send it as
+            one. Replies to puppets come back with the same tag.`, false},
+		"CAP": {`
+            CAP LS [302] | LIST | REQ :<capabilities> | END
+            Negotiates optional IRCv3 features before registering.`, false},
+		"CERT": {`
+            CERT ADD [<fingerprint>] | LIST | DEL <fingerprint>
+            Manages the TLS client certificates on your account; connecting
+            with one logs you in. ADD alone adds the one you're using.`, false},
+		"CHGHOST": {`
+            CHGHOST <nick> <user> <host>
+            Changes a client's username and hostname.`, true},
+		"DEBUG": {`
+            DEBUG GCSTATS | NUMGOROUTINE | PROFILEHEAP | STARTCPUPROFILE |
+            STOPCPUPROFILE
+            Shows runtime statistics or writes profiles.`, true},
+		"DEFCON": {`
+            DEFCON [<level>]
+            Shows or changes the lockdown level; 5 is normal.`, true},
+		"GLOBALNOTICE": {`
+            GLOBALNOTICE [-<modes>] <message>
+            Sends a notice to every client, except those with any of the
+            modes.`, true},
+		"GLOBOPS": {`
+            GLOBOPS <message>
+            Sends a message to all operators. Also OPERWALL.`, true},
+		"HELP": {`
+            HELP [<command> [<subcommand>]]
+            Shows how to use a command, or lists the commands.`, false},
+		"HOSTSERV": {`
+            HOSTSERV SET <account> [<vhost>]
+            Sets the vhost of an account, or removes it. Also HS or
+            /msg HostServ.`, true},
+		"INFO": {`
+            INFO [<target>]
+            Shows information about the server software.`, false},
+		"INVITE": {`
+            INVITE <nick> <channel>
+            Invites someone to a channel.`, false},
+		"ISON": {`
+            ISON <nick> [<nick> ...]
+            Shows which of the nicks are online.`, false},
+		"JOIN": {`
+            JOIN <channel>[,<channel>...] [<key>[,<key>...]]
+            Joins channels, creating them if they don't exist. JOIN 0 parts
+            every channel.`, false},
+		"KICK": {`
+            KICK <channel> <nick> [<comment>]
+            Removes someone from a channel; needs channel operator.`, false},
+		"KILL": {`
+            KILL <nick> <comment>
+            Disconnects a client.`, true},
+		"KLINE": {`
+            KLINE [<minutes>] <user@host> [<reason>]
+            Bans matching clients from the server, for the number of minutes
+            or until UNKLINE.`, true},
+		"LANGUAGE": {`
+            LANGUAGE <code> [<code> ...]
+            Picks the language of server messages, the first of the codes
+            that the server has.`, false},
+		"LIST": {`
+            LIST [<channel>[,<channel>...]]
+            Lists channels with their member counts and topics.`, false},
+		"LUSERS": {`
+            LUSERS
+            Shows how many clients and channels there are.`, false},
+		"MAP": {`
+            MAP
+            Shows the servers of the network.`, true},
+		"MEMOSERV": {`
+            MEMOSERV SEND <account> <message> | LIST | READ <id> | DEL <id>
+            Leaves messages for accounts that aren't online. Also MS or
+            /msg MemoServ.`, false},
+		"METADATA": {`
+            METADATA <target|*> GET <key>... | LIST | SET <key> [:<value>] |
+                CLEAR | SUB <key>... | UNSUB <key>... | SUBS | SYNC
+            Shows or changes the key-value metadata of a nick or channel;
+            * is yourself. Subscribed keys are sent as they change. Logged
+            in clients may set avatar, an http or https URL, and
+            display-name, which are tagged on their messages.`, false},
+		"MODE": {`
+            MODE <nick> [(+|-)<modes>]
+            MODE <channel> [(+|-)<modes> [<arguments>]]
+            Shows or changes user or channel modes.`, false},
+		"MOTD": {`
+            MOTD [<target>]
+            Shows the message of the day.`, false},
+		"NAMES": {`
+            NAMES [<channel>[,<channel>...]]
+            Lists the members of channels.`, false},
+		"NICK": {`
+            NICK <nick>
+            Changes your nick.`, false},
+		"NICKSERV": {`
+            NICKSERV <subcommand> [<arguments>]
+            Manages accounts. Also NS or /msg NickServ. Subcommands:
+            REGISTER, IDENTIFY, GHOST, REGAIN, VERIFY, RESETPASS, SETPASS and
+            SET; HELP NICKSERV <subcommand> shows one.`, false},
+		"NICKSERV REGISTER": {`
+            NICKSERV REGISTER <password> [<email>]
+            Registers your current nick as an account. With an email address
+            you're sent a code to finish with NICKSERV VERIFY.`, false},
+		"NICKSERV IDENTIFY": {`
+            NICKSERV IDENTIFY <account> <password>
+            Logs in to an account.`, false},
+		"NICKSERV GHOST": {`
+            NICKSERV GHOST <nick> [<password>]
+            Disconnects someone using your registered nick.`, false},
+		"NICKSERV REGAIN": {`
+            NICKSERV REGAIN <nick> [<password>]
+            Disconnects someone using your registered nick and takes it.`, false},
+		"NICKSERV VERIFY": {`
+            NICKSERV VERIFY <account> <code>
+            Finishes registering an account with the code that was emailed.`, false},
+		"NICKSERV RESETPASS": {`
+            NICKSERV RESETPASS <account>
+            Emails a token to the account's verified address, for SETPASS.`, false},
+		"NICKSERV SETPASS": {`
+            NICKSERV SETPASS <account> <token> <password>
+            Changes the password of an account with an emailed token.`, false},
+		"NICKSERV SET": {`
+            NICKSERV SET [<setting> [<value>]]
+            Lists your account's settings, or changes one; leaving out the
+            value resets it. Settings are language, auto-away,
+            identified-pms and cloak.`, false},
+		"NOTICE": {`
+            NOTICE <target> <message>
+            Sends a message that mustn't be answered automatically.`, false},
+		"NPC": {`
+            NPC <channel> <name> <message>
+            Speaks as a character in a +E channel.`, false},
+		"NPCA": {`
+            NPCA <channel> <name> <action>
+            Acts as a character in a +E channel.`, false},
+		"ONICK": {`
+            ONICK <nick> <newnick>
+            Changes someone's nick.`, true},
+		"OPER": {`
+            OPER <name> <password>
+            Makes you an operator.`, false},
+		"PART": {`
+            PART <channel>[,<channel>...] [<message>]
+            Leaves channels.`, false},
+		"PASS": {`
+            PASS <password>
+            Gives the server password before registering.`, false},
+		"PING": {`
+            PING <token>
+            Checks that the connection is alive; answered with PONG.`, false},
+		"PRIVMSG": {`
+            PRIVMSG <target> <message>
+            Sends a message to a channel or nick.`, false},
+		"QUIT": {`
+            QUIT [<message>]
+            Disconnects from the server.`, false},
+		"REHASH": {`
+            REHASH
+            Reloads the configuration file.`, true},
+		"REMOVE": {`
+            REMOVE <channel> <nick> [<comment>]
+            Makes someone part a channel; needs channel operator.`, false},
+		"SCENE": {`
+            SCENE <channel> <message>
+            Narrates the scene in a +E channel.`, false},
+		"SETNAME": {`
+            SETNAME <realname>
+            Changes your realname, after CAP REQ setname.`, false},
+		"SILENCE": {`
+            SILENCE [{+|-}<mask>[,...]]
+            Lists, adds or removes masks whose messages you don't get.`, false},
+		"STATS": {`
+            STATS <query> [<target>]
+            Shows server statistics: u is uptime; operators also have d, f,
+            g, k, m, o, p and y.`, false},
+		"SVSLOGIN": {`
+            SVSLOGIN <nick> <account|*>
+            Logs a client in to an account, or out; for services.`, true},
+		"SVSMODE": {`
+            SVSMODE <nick> <modes>
+            Changes a client's modes; for services.`, true},
+		"SVSNICK": {`
+            SVSNICK <nick> <newnick>
+            Changes a client's nick; for services.`, true},
+		"TAGMSG": {`
+            TAGMSG <target>
+            Sends only message tags, after CAP REQ message-tags.`, false},
+		"THEATER": {`
+            THEATER <subcommand> <channel> ...
+            Speaks in a theater channel as other nicks. Subcommands are
+            IDENTIFY, PRIVMSG, ACTION, ACTORS, HANDOFF and ACCESS; see
+            HELP THEATER <subcommand>.`, false},
+		"THEATER IDENTIFY": {`
+            THEATER IDENTIFY <channel> [<password>]
+            Makes you the theater's director (+T). The password isn't
+            needed if your account has access.`, false},
+		"THEATER PRIVMSG": {`
+            THEATER PRIVMSG <channel> <nick> <message>
+            Sends a message to the theater as nick; for the director.`, false},
+		"THEATER ACTION": {`
+            THEATER ACTION <channel> <nick> <action>
+            Sends an action to the theater as nick; for the director.`, false},
+		"THEATER ACTORS": {`
+            THEATER ACTORS <channel>
+            Shows the theater's director and the nicks it has spoken as.`, false},
+		"THEATER HANDOFF": {`
+            THEATER HANDOFF <channel> <nick>
+            Makes another member the director instead of you.`, false},
+		"THEATER ACCESS": {`
+            THEATER ACCESS <channel> [{ADD|DEL} <account>]
+            Lists or changes the accounts that may direct the theater
+            without the password; for the director and operators.`, false},
+		"TIME": {`
+            TIME [<target>]
+            Shows the server's time.`, false},
+		"TOPIC": {`
+            TOPIC <channel> [<topic>]
+            Shows or changes a channel's topic.`, false},
+		"TRACE": {`
+            TRACE [<target>]
+            Shows the connections to the server.`, true},
+		"UNKLINE": {`
+            UNKLINE <user@host>
+            Removes a K-line.`, true},
+		"USER": {`
+            USER <username> 0 * <realname>
+            Gives your username and realname when registering.`, false},
+		"USERHOST": {`
+            USERHOST <nick> [<nick> ...]
+            Shows the user@host of nicks.`, false},
+		"VERIFY": {`
+            VERIFY <code>
+            Gives the code the server sent before registering, when it asks
+            for one.`, false},
+		"VERSION": {`
+            VERSION [<target>]
+            Shows the server's version.`, false},
+		"WALLOPS": {`
+            WALLOPS <message>
+            Sends a message to everyone with +w.`, true},
+		"WHO": {`
+            WHO [<mask> [o]]
+            Lists clients matching a mask or in a channel.`, false},
+		"WHOIS": {`
+            WHOIS [<target>] <nick>[,<nick>...]
+            Shows information about clients.`, false},
+		"WHOWAS": {`
+            WHOWAS <nick> [<count>]
+            Shows information about clients who have left.`, false},
+	}
+
+	// helpAliases are commands whose help is another's.
+	helpAliases = map[string]string{
+		"HELPOP":   "HELP",
+		"HS":       "HOSTSERV",
+		"MS":       "MEMOSERV",
+		"NS":       "NICKSERV",
+		"OPERWALL": "GLOBOPS",
+	}
+)
+
+// HELP [<command> [<subcommand>]]
+
+type HelpCommand struct {
+	BaseCommand
+	topic string
+}
+
+func ParseHelpCommand(args []string) (Command, error) {
+	return NewHelpCommand(args), nil
+}
+
+// NewHelpCommand asks for help on the topic in args, which may be a
+// service command.
+func NewHelpCommand(args []string) *HelpCommand {
+	words := make([]string, 0, 2)
+	for _, arg := range args {
+		// also NICKSERV-REGISTER, as the topic is shown
+		arg = strings.Replace(arg, "-", " ", -1)
+		words = append(words, strings.Fields(strings.ToUpper(arg))...)
+	}
+	if len(words) > 2 {
+		words = words[:2]
+	}
+	if (len(words) > 0) && (helpAliases[words[0]] != "") {
+		words[0] = helpAliases[words[0]]
+	}
+	return &HelpCommand{
+		topic: strings.Join(words, " "),
+	}
+}
+
+//
+// server goroutine
+//
+
+func (msg *HelpCommand) HandleRegServer(server *Server) {
+	msg.HandleServer(server)
+}
+
+func (msg *HelpCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if msg.topic == "" {
+		client.RplHelp("*", server.helpIndex(client))
+		return
+	}
+	topic := HelpTopics[msg.topic]
+	if (topic == nil) && strings.Contains(msg.topic, " ") {
+		// services without per-subcommand topics
+		msg.topic = strings.Fields(msg.topic)[0]
+		topic = HelpTopics[msg.topic]
+	}
+	if (topic == nil) || (topic.operOnly && !client.flags[Operator]) {
+		client.ErrHelpNotFound(msg.topic)
+		return
+	}
+	lines := topic.Lines()
+	for index, line := range lines {
+		lines[index] = client.T(line)
+	}
+	client.RplHelp(msg.topic, lines)
+}
+
+// helpIndex lists the commands client may get help on, a few to a line.
+func (server *Server) helpIndex(client *Client) []string {
+	names := make([]string, 0, len(HelpTopics))
+	for name, topic := range HelpTopics {
+		if strings.Contains(name, " ") ||
+			(topic.operOnly && !client.flags[Operator]) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{client.T("HELP <command> shows how to use one of these:")}
+	for start := 0; start < len(names); start += 8 {
+		end := start + 8
+		if end > len(names) {
+			end = len(names)
+		}
+		lines = append(lines, strings.Join(names[start:end], " "))
+	}
+	return lines
+}
@@ -0,0 +1,113 @@
+package irc
+
+import (
+	"time"
+)
+
+const (
+	DEFAULT_HISTORY_LINES = 50 // messages kept for a channel with +H
+
+	TIME_TAG    = "time"
+	TIME_FORMAT = "2006-01-02T15:04:05.000Z"
+)
+
+// HistoryItem is a message sent to a channel, kept to be replayed to
+// members joining later.
+type HistoryItem struct {
+	time  time.Time
+	reply string // without tags
+	tags  Tags
+}
+
+// Render is the item as sent to a client with capabilities: with its tags
+// for message-tags and the time it was sent for server-time.
+func (item *HistoryItem) Render(capabilities CapabilitySet) string {
+	reply := TagReply(capabilities, item.reply, item.tags)
+	if capabilities[ServerTime] {
+		reply = AddTags(reply, Tags{
+			TIME_TAG: item.time.UTC().Format(TIME_FORMAT),
+		})
+	}
+	return reply
+}
+
+// History keeps the last max items, oldest first.
+type History struct {
+	items []*HistoryItem
+	max   int
+}
+
+func NewHistory(max int) *History {
+	return &History{
+		items: make([]*HistoryItem, 0, max),
+		max:   max,
+	}
+}
+
+func (history *History) Add(item *HistoryItem) {
+	if len(history.items) >= history.max {
+		copy(history.items, history.items[1:])
+		history.items = history.items[:len(history.items)-1]
+	}
+	history.items = append(history.items, item)
+}
+
+// Since lists the items newer than t, oldest first.
+func (history *History) Since(t time.Time) []*HistoryItem {
+	for index, item := range history.items {
+		if item.time.After(t) {
+			return history.items[index:]
+		}
+	}
+	return nil
+}
+
+// Trim drops the items from before cutoff, returning how many.
+func (history *History) Trim(cutoff time.Time) int {
+	count := 0
+	for (count < len(history.items)) && history.items[count].time.Before(cutoff) {
+		count += 1
+	}
+	history.items = history.items[:copy(history.items, history.items[count:])]
+	return count
+}
+
+//
+// server goroutine
+//
+
+// Record keeps a message for a channel with +H.
+func (channel *Channel) Record(reply string, tags Tags) {
+	if !channel.flags[KeepHistory] || (channel.server.historyLines <= 0) {
+		return
+	}
+	if channel.history == nil {
+		channel.history = NewHistory(channel.server.historyLines)
+	}
+	channel.history.Add(&HistoryItem{
+		time:  time.Now(),
+		reply: reply,
+		tags:  tags,
+	})
+}
+
+// ReplayHistory sends a joining client the channel's recent messages in a
+// chathistory batch.
+func (channel *Channel) ReplayHistory(client *Client) {
+	if !channel.flags[KeepHistory] || (channel.history == nil) {
+		return
+	}
+	var since time.Time
+	if age := channel.server.historyAge; age > 0 {
+		since = time.Now().Add(-age)
+	}
+	items := channel.history.Since(since)
+	if len(items) == 0 {
+		return
+	}
+	batch := client.StartBatch(ChathistoryBatch, channel.name.String())
+	for _, item := range items {
+		client.Reply(item.Render(client.capabilities))
+	}
+	client.EndBatch(batch)
+}
@@ -0,0 +1,175 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Vhosts are vanity hostnames set by operators on accounts. They replace
+// the hostname in a client's user mask when it logs in.
+
+type HostServSubCommand string
+
+const (
+	HostServSet  HostServSubCommand = "SET"
+	HostServHelp HostServSubCommand = "HELP"
+)
+
+// HOSTSERV SET <account> [<vhost>]
+//
+// Leaving out the vhost removes it.
+
+type HostServSetCommand struct {
+	BaseCommand
+	account Name
+	vhost   Name
+}
+
+func ParseHostServCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	switch HostServSubCommand(strings.ToUpper(args[0])) {
+	case HostServHelp:
+		return NewHelpCommand([]string{HOSTSERV.String()}), nil
+
+	case HostServSet:
+		if len(args) < 2 {
+			return nil, NotEnoughArgsError
+		}
+		cmd := &HostServSetCommand{
+			account: NewName(args[1]),
+		}
+		if len(args) > 2 {
+			cmd.vhost = NewName(args[2])
+		}
+		return cmd, nil
+	}
+	return nil, ErrParseCommand
+}
+
+func IsVHost(vhost Name) bool {
+	return (len(vhost) <= VHOST_MAX_LEN) && VHostExpr.MatchString(vhost.String())
+}
+
+// CHGHOST <nick> <user> <host>
+
+type ChgHostCommand struct {
+	BaseCommand
+	nick     Name
+	username Name
+	hostname Name
+}
+
+func ParseChgHostCommand(args []string) (Command, error) {
+	if len(args) < 3 {
+		return nil, NotEnoughArgsError
+	}
+	return &ChgHostCommand{
+		nick:     NewName(args[0]),
+		username: NewName(args[1]),
+		hostname: NewName(args[2]),
+	}, nil
+}
+
+//
+// server goroutine
+//
+
+func (msg *HostServSetCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.flags[Operator] {
+		client.ErrNoPrivileges()
+		return
+	}
+	if !server.isAccount(msg.account) {
+		client.Notice("%s is not registered", msg.account)
+		return
+	}
+	if (msg.vhost != "") && !IsVHost(msg.vhost) {
+		client.Notice("%s is not a valid vhost", msg.vhost)
+		return
+	}
+
+	var err error
+	if msg.vhost == "" {
+		_, err = server.db.Exec(`DELETE FROM account_vhost WHERE account = ?`,
+			msg.account.String())
+	} else {
+		_, err = server.db.Exec(`
+            INSERT OR REPLACE INTO account_vhost (account, vhost) VALUES (?, ?)`,
+			msg.account.String(), msg.vhost.String())
+	}
+	if err != nil {
+		log.Println("HostServSetCommand.HandleServer:", err)
+		return
+	}
+	server.Audit(client, HOSTSERV, msg.account.String(), msg.vhost.String())
+
+	for _, other := range server.clients.byNick {
+		if other.account.ToLower() != msg.account.ToLower() {
+			continue
+		}
+		if msg.vhost != "" {
+			other.ChangeHost(other.username, msg.vhost)
+		} else {
+			other.ChangeHost(other.username, other.hostnameWithoutVHost())
+		}
+	}
+	client.Notice("vhost for %s set to %s", msg.account, msg.vhost)
+}
+
+func (msg *ChgHostCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.flags[Operator] {
+		client.ErrNoPrivileges()
+		return
+	}
+	target := server.clients.Get(msg.nick)
+	if target == nil {
+		client.ErrNoSuchNick(msg.nick)
+		return
+	}
+	if !IsVHost(msg.hostname) || (msg.username == "") ||
+		strings.ContainsAny(msg.username.String(), "!@*?") {
+		client.Reply(RplFail(server, CHGHOST, "INVALID_HOST",
+			fmt.Sprintf("%s@%s is not a valid user and host", msg.username,
+				msg.hostname)))
+		return
+	}
+	server.Audit(client, CHGHOST, target.nick.String(),
+		fmt.Sprintf("%s@%s", msg.username, msg.hostname))
+	target.ChangeHost(msg.username, msg.hostname)
+	client.Notice("%s is now %s", target.nick, target.UserHost())
+}
+
+// hostnameWithoutVHost is the client's hostname when it has no vhost:
+// its cloak with +x, otherwise its real one.
+func (client *Client) hostnameWithoutVHost() Name {
+	if client.flags[HostCloak] {
+		return client.server.cloaks.Cloak(client.realHostname)
+	}
+	return client.realHostname
+}
+
+// applyVHost gives the client its account's vhost, if there is one.
+func (client *Client) applyVHost() {
+	if client.account == "" {
+		return
+	}
+	if vhost := client.server.accountVHost(client.account); vhost != "" {
+		client.ChangeHost(client.username, vhost)
+	}
+}
+
+// accountVHost is the vhost set on account, if any.
+func (server *Server) accountVHost(account Name) Name {
+	var vhost string
+	err := server.db.QueryRow(`SELECT vhost FROM account_vhost WHERE account = ?`,
+		account.String()).Scan(&vhost)
+	if err != nil {
+		return ""
+	}
+	return NewName(vhost)
+}
@@ -0,0 +1,188 @@
+package irc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Numerics and server notices may be translated. Each language is a YAML
+// file, <code>.lang.yaml, mapping the English text (with its %s and %d) to
+// the translation:
+//
+//   name: Deutsch
+//   translations:
+//       "You have %d new memos": "Sie haben %d neue Memos"
+//
+// A client gets its account's language setting, or the one it picked
+// with LANGUAGE.
+
+const (
+	DEFAULT_LANGUAGE = "en"
+	LANGUAGE_SUFFIX  = ".lang.yaml"
+)
+
+var (
+	formatVerbExpr = regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z%]`)
+)
+
+type LanguagesConfig struct {
+	// directory of translation files
+	Path string
+}
+
+type Language struct {
+	Code         string
+	Name         string
+	Translations map[string]string
+}
+
+// LanguageSet is the loaded translations by code.
+type LanguageSet map[string]*Language
+
+func LoadLanguages(path string) (LanguageSet, error) {
+	languages := make(LanguageSet)
+	if path == "" {
+		return languages, nil
+	}
+	filenames, err := filepath.Glob(filepath.Join(path, "*"+LANGUAGE_SUFFIX))
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range filenames {
+		language, err := LoadLanguage(filename)
+		if err != nil {
+			return nil, err
+		}
+		languages[language.Code] = language
+	}
+	return languages, nil
+}
+
+func LoadLanguage(filename string) (*Language, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	language := &Language{}
+	if err := yaml.Unmarshal(data, language); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	language.Code = strings.TrimSuffix(filepath.Base(filename), LANGUAGE_SUFFIX)
+	if !LanguageExpr.MatchString(language.Code) {
+		return nil, fmt.Errorf("%s: %s isn't a language code", filename,
+			language.Code)
+	}
+	for english, translation := range language.Translations {
+		if !sameFormatVerbs(english, translation) {
+			return nil, fmt.Errorf("%s: %q must have the same %% verbs as %q",
+				filename, translation, english)
+		}
+	}
+	return language, nil
+}
+
+// sameFormatVerbs makes sure a translation formats its arguments like the
+// English text does, in the same order.
+func sameFormatVerbs(english string, translation string) bool {
+	a := formatVerbExpr.FindAllString(english, -1)
+	b := formatVerbExpr.FindAllString(translation, -1)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (languages LanguageSet) Has(code string) bool {
+	return (code == DEFAULT_LANGUAGE) || (languages[code] != nil)
+}
+
+// Codes lists the languages clients may pick, the default first.
+func (languages LanguageSet) Codes() []string {
+	codes := make([]string, 0, len(languages))
+	for code := range languages {
+		if code != DEFAULT_LANGUAGE {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	return append([]string{DEFAULT_LANGUAGE}, codes...)
+}
+
+// LANGUAGE <code> [<code> ...]
+//
+// The first code the server has a translation for is used.
+
+type LanguageCommand struct {
+	BaseCommand
+	codes []string
+}
+
+func ParseLanguageCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	codes := make([]string, 0, len(args))
+	for _, arg := range args {
+		codes = append(codes, strings.Split(arg, ",")...)
+	}
+	return &LanguageCommand{
+		codes: codes,
+	}, nil
+}
+
+//
+// server goroutine
+//
+
+// Language is the code of the language client's messages are in.
+func (client *Client) Language() string {
+	if client.language != "" {
+		return client.language
+	}
+	return client.settings.Get(SettingLanguage)
+}
+
+// T translates a numeric or notice format into client's language.
+func (client *Client) T(format string) string {
+	language := client.server.languages[client.Language()]
+	if language == nil {
+		return format
+	}
+	if translation, ok := language.Translations[format]; ok {
+		return translation
+	}
+	return format
+}
+
+// Notice sends client a translated notice from the server.
+func (client *Client) Notice(format string, args ...interface{}) {
+	client.Reply(RplNotice(client.server, client,
+		NewText(fmt.Sprintf(client.T(format), args...))))
+}
+
+func (msg *LanguageCommand) HandleRegServer(server *Server) {
+	msg.HandleServer(server)
+}
+
+func (msg *LanguageCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	for _, code := range msg.codes {
+		if server.languages.Has(code) {
+			client.language = code
+			client.RplYourLanguagesAre(code)
+			return
+		}
+	}
+	client.ErrNoLanguage(msg.codes[0])
+}
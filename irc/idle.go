@@ -0,0 +1,72 @@
+package irc
+
+import (
+	"errors"
+	"time"
+)
+
+// Servers short on resources can mark clients that have been idle (sent
+// nothing but PING and PONG) for a while as away, and later disconnect
+// them. Operators and clients logged in to an account are left alone.
+
+const (
+	IDLE_CHECK_INTERVAL = time.Minute
+)
+
+type IdleConfig struct {
+	// 0 to leave idle clients be
+	Away       time.Duration
+	Disconnect time.Duration
+}
+
+func (conf *IdleConfig) validate() error {
+	if (conf.Away < 0) || (conf.Disconnect < 0) {
+		return errors.New("idle times can't be negative")
+	}
+	if (conf.Away > 0) && (conf.Disconnect > 0) && (conf.Disconnect <= conf.Away) {
+		return errors.New("idle disconnect must be longer than idle away")
+	}
+	return nil
+}
+
+func (conf *IdleConfig) Enabled() bool {
+	return (conf.Away > 0) || (conf.Disconnect > 0)
+}
+
+//
+// server goroutine
+//
+
+// enforceIdle is run every IDLE_CHECK_INTERVAL.
+func (server *Server) enforceIdle() {
+	config := &server.idleLimits
+	for _, client := range server.clients.byNick {
+		if !client.registered || client.detached || client.IsService() ||
+			client.flags[Operator] || (client.account != "") {
+			continue
+		}
+		idle := client.IdleTime()
+		if (config.Disconnect > 0) && (idle >= config.Disconnect) {
+			Log.info.Printf("%s: idle for %s, disconnecting", client, idle)
+			client.Quit("Idle for too long")
+			continue
+		}
+		if (config.Away > 0) && (idle >= config.Away) {
+			client.IdleAway()
+		}
+	}
+}
+
+// IdleAway marks an idle client as away. It comes back with its next
+// command, like a client that was away because it detached.
+func (client *Client) IdleAway() {
+	if client.flags[Away] {
+		return
+	}
+	client.flags[Away] = true
+	client.awayTime = time.Now()
+	client.awayMessage = NewText("Idle")
+	client.autoAway = true
+	client.RplNowAway()
+	client.NotifyAway()
+}
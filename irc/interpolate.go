@@ -0,0 +1,140 @@
+package irc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Config strings may refer to ${ENV_VAR}, and a secret may be given as
+// file:///path to read it from a file, so that neither has to be written
+// in the config itself.
+
+const (
+	SECRET_FILE_PREFIX = "file://"
+)
+
+var (
+	envVarExpr = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// expandEnv replaces ${ENV_VAR} references in every string of the config
+// with the variables' values. Every variable must be set, though it may
+// be empty.
+func (conf *Config) expandEnv() error {
+	missing := make([]string, 0)
+	expandStrings(reflect.ValueOf(conf), func(str string) string {
+		return envVarExpr.ReplaceAllStringFunc(str, func(ref string) string {
+			name := envVarExpr.FindStringSubmatch(ref)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("Environment variables not set: %s",
+			strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// expandStrings applies expand to the strings in value, including those
+// in structs, pointers, maps and slices.
+func expandStrings(value reflect.Value, expand func(string) string) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !value.IsNil() {
+			expandStrings(value.Elem(), expand)
+		}
+
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if field := value.Field(i); field.CanSet() {
+				expandStrings(field, expand)
+			}
+		}
+
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			expandStrings(value.Index(i), expand)
+		}
+
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			elem := value.MapIndex(key)
+			if elem.Kind() == reflect.String {
+				value.SetMapIndex(key, reflect.ValueOf(expand(elem.String())).
+					Convert(elem.Type()))
+			} else {
+				expandStrings(elem, expand)
+			}
+		}
+
+	case reflect.String:
+		if value.CanSet() {
+			value.SetString(expand(value.String()))
+		}
+	}
+}
+
+// readSecret replaces a file:///path secret with the file's contents,
+// without a trailing newline.
+func readSecret(secret *string) error {
+	if !strings.HasPrefix(*secret, SECRET_FILE_PREFIX) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(strings.TrimPrefix(*secret, SECRET_FILE_PREFIX))
+	if err != nil {
+		return err
+	}
+	*secret = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+// readSecrets reads the passwords and other secrets given as files.
+func (conf *Config) readSecrets() error {
+	secrets := map[string]*string{
+		"Server password": &conf.Server.Password,
+	}
+	for name, listener := range conf.Listeners {
+		if listener.Password != nil {
+			secrets["Listener "+name+" password"] = listener.Password
+		}
+	}
+	for name, oper := range conf.Operator {
+		secrets["Operator "+name+" password"] = &oper.Password
+	}
+	for name, theater := range conf.Theater {
+		secrets["Theater "+name+" password"] = &theater.Password
+	}
+	for name, user := range conf.API.Users {
+		secrets["API user "+name+" password"] = &user.Password
+	}
+	for name, bridge := range conf.Bridges {
+		secrets["Bridge "+name+" password"] = &bridge.Password
+	}
+	if conf.Auth.JWT != nil {
+		secrets["Auth jwt secret"] = &conf.Auth.JWT.Secret
+	}
+	if conf.Cloaks.Secret != "" {
+		secrets["Cloaks secret"] = &conf.Cloaks.Secret
+	}
+	if conf.Email.Password != "" {
+		secrets["Email password"] = &conf.Email.Password
+	}
+	for _, hook := range conf.Webhooks {
+		secrets["Webhook "+hook.URL+" secret"] = &hook.Secret
+	}
+
+	for what, secret := range secrets {
+		if err := readSecret(secret); err != nil {
+			return fmt.Errorf("%s: %s", what, err)
+		}
+	}
+	return nil
+}
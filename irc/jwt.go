@@ -0,0 +1,280 @@
+package irc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket clients embedded in web pages may log in with a bearer token
+// (a JWT) from the site instead of a password, with SASL OAUTHBEARER or
+// PASS. Tokens are signed with HS256 using a shared secret, or RS256 or
+// ES256 using keys from the issuer's JWKS.
+
+var (
+	ErrTokenInvalid = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+const (
+	DEFAULT_ACCOUNT_CLAIM = "sub"
+	JWKS_REFRESH          = time.Hour   // before the key set is fetched again
+	JWKS_MIN_REFRESH      = time.Minute // between fetches for unknown key IDs
+)
+
+type TokenVerifier struct {
+	conf   *JWTConfig
+	client *http.Client
+
+	lock    sync.Mutex
+	keys    map[string]crypto.PublicKey // by key ID
+	fetched time.Time
+}
+
+func NewTokenVerifier(conf *JWTConfig) *TokenVerifier {
+	return &TokenVerifier{
+		conf:   conf,
+		client: &http.Client{Timeout: DEFAULT_AUTH_TIMEOUT},
+	}
+}
+
+// LooksLikeJWT is true for three dot-separated parts, the first a JSON
+// header.
+func LooksLikeJWT(token string) bool {
+	return (strings.Count(token, ".") == 2) && strings.HasPrefix(token, "eyJ")
+}
+
+// Verify checks the token's signature and claims, and returns the account
+// it names. It may fetch the JWKS, so it must not be called from the
+// server goroutine.
+func (verifier *TokenVerifier) Verify(token string) (Name, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrTokenInvalid
+	}
+
+	var header struct {
+		Alg string
+		Kid string
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return "", ErrTokenInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrTokenInvalid
+	}
+	if err := verifier.verifySignature(header.Alg, header.Kid,
+		parts[0]+"."+parts[1], signature); err != nil {
+		return "", err
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", ErrTokenInvalid
+	}
+	return verifier.checkClaims(claims)
+}
+
+func decodeJWTPart(part string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (verifier *TokenVerifier) verifySignature(alg string, kid string,
+	signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "HS256":
+		if verifier.conf.Secret == "" {
+			return ErrTokenInvalid
+		}
+		mac := hmac.New(sha256.New, []byte(verifier.conf.Secret))
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrTokenInvalid
+		}
+		return nil
+
+	case "RS256":
+		key, ok := verifier.key(kid).(*rsa.PublicKey)
+		if !ok || (rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:],
+			signature) != nil) {
+			return ErrTokenInvalid
+		}
+		return nil
+
+	case "ES256":
+		key, ok := verifier.key(kid).(*ecdsa.PublicKey)
+		if !ok || (len(signature) != 64) {
+			return ErrTokenInvalid
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return ErrTokenInvalid
+		}
+		return nil
+	}
+	return ErrTokenInvalid
+}
+
+func (verifier *TokenVerifier) checkClaims(claims map[string]interface{}) (Name, error) {
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); !ok || (now >= exp) {
+		return "", ErrTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && (now < nbf) {
+		return "", ErrTokenInvalid
+	}
+	if verifier.conf.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != verifier.conf.Issuer {
+			return "", ErrTokenInvalid
+		}
+	}
+	if verifier.conf.Audience != "" && !hasAudience(claims["aud"],
+		verifier.conf.Audience) {
+		return "", ErrTokenInvalid
+	}
+
+	account, _ := claims[verifier.conf.AccountClaim].(string)
+	if account == "" {
+		return "", ErrTokenInvalid
+	}
+	return NewName(account), nil
+}
+
+// aud may be a string or a list of them.
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//
+// JWKS
+//
+
+// key finds the key with the ID, fetching the key set when it's stale or
+// the ID is unknown.
+func (verifier *TokenVerifier) key(kid string) crypto.PublicKey {
+	verifier.lock.Lock()
+	defer verifier.lock.Unlock()
+
+	key, ok := verifier.keys[kid]
+	age := time.Since(verifier.fetched)
+	if (verifier.conf.JWKS == "") || (ok && (age < JWKS_REFRESH)) ||
+		(age < JWKS_MIN_REFRESH) {
+		return key
+	}
+
+	keys, err := verifier.fetchKeys()
+	verifier.fetched = time.Now()
+	if err != nil {
+		Log.error.Printf("jwks: %s", err)
+		return key
+	}
+	verifier.keys = keys
+	return keys[kid]
+}
+
+type jsonWebKey struct {
+	Kty string
+	Kid string
+	Crv string
+	N   string
+	E   string
+	X   string
+	Y   string
+}
+
+func (verifier *TokenVerifier) fetchKeys() (map[string]crypto.PublicKey, error) {
+	response, err := verifier.client.Get(verifier.conf.JWKS)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", verifier.conf.JWKS, response.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey
+	}
+	if err := json.NewDecoder(response.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			Log.debug.Printf("jwks: skipping key %s: %s", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(value string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(data), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+}
@@ -0,0 +1,230 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// K-lines ban user@host masks from the server. They are kept in the
+// database, and may expire.
+
+type KLine struct {
+	mask    Name // user@host
+	reason  Text
+	setter  Name
+	ctime   time.Time
+	expires time.Time // zero for permanent K-lines
+	matcher *UserMaskSet
+}
+
+func NewKLine(mask Name, reason Text, setter Name, ctime time.Time,
+	expires time.Time) *KLine {
+	kline := &KLine{
+		mask:    mask,
+		reason:  reason,
+		setter:  setter,
+		ctime:   ctime,
+		expires: expires,
+		matcher: NewUserMaskSet(),
+	}
+	kline.matcher.Add(mask)
+	return kline
+}
+
+// KLineMask makes a bare host into *@host.
+func KLineMask(mask string) Name {
+	if !strings.Contains(mask, "@") {
+		mask = "*@" + mask
+	}
+	return NewName(mask)
+}
+
+func (kline *KLine) Expired() bool {
+	return !kline.expires.IsZero() && time.Now().After(kline.expires)
+}
+
+// Matches checks the client's real hostname and IP, since its hostname may
+// be a cloak or vhost that others share.
+func (kline *KLine) Matches(client *Client) bool {
+	return kline.matcher.Match(Name(fmt.Sprintf("%s@%s", client.username,
+		client.realHostname))) ||
+		kline.matcher.Match(Name(fmt.Sprintf("%s@%s", client.username,
+			client.IP())))
+}
+
+// autoKLineMask is what a K-line set by the server itself, for a DNSBL
+// listing or a filter, bans: the client's IP.
+func (client *Client) autoKLineMask() Name {
+	return KLineMask(client.IP())
+}
+
+// KLineFor finds a K-line matching the client, dropping any that have
+// expired.
+func (server *Server) KLineFor(client *Client) *KLine {
+	for mask, kline := range server.klines {
+		if kline.Expired() {
+			server.RemoveKLine(mask)
+			continue
+		}
+		if kline.Matches(client) {
+			return kline
+		}
+	}
+	return nil
+}
+
+// AddKLine bans the mask and disconnects matching clients.
+func (server *Server) AddKLine(kline *KLine) {
+	server.klines[kline.mask] = kline
+	server.Notify(EventKLineAdded, map[string]string{
+		"mask":   kline.mask.String(),
+		"reason": kline.reason.String(),
+		"setter": kline.setter.String(),
+	})
+	var expires int64
+	if !kline.expires.IsZero() {
+		expires = kline.expires.Unix()
+	}
+	_, err := server.db.Exec(`
+        INSERT OR REPLACE INTO kline (mask, reason, setter, ctime, expires)
+        VALUES (?, ?, ?, ?, ?)`,
+		kline.mask.String(), kline.reason.String(), kline.setter.String(),
+		kline.ctime.Unix(), expires)
+	if err != nil {
+		log.Println("Server.AddKLine:", err)
+	}
+
+	for _, client := range server.clients.byNick {
+		if client.registered && kline.Matches(client) {
+			client.ErrYoureBannedCreep(kline.reason)
+			client.Quit(NewText(fmt.Sprintf("K-lined: %s", kline.reason)))
+		}
+	}
+}
+
+func (server *Server) RemoveKLine(mask Name) bool {
+	if _, ok := server.klines[mask]; !ok {
+		return false
+	}
+	delete(server.klines, mask)
+	_, err := server.db.Exec(`DELETE FROM kline WHERE mask = ?`, mask.String())
+	if err != nil {
+		log.Println("Server.RemoveKLine:", err)
+	}
+	return true
+}
+
+func (server *Server) loadKLines() {
+	server.klines = make(map[Name]*KLine)
+	rows, err := server.db.Query(`
+        SELECT mask, reason, setter, ctime, expires FROM kline`)
+	if err != nil {
+		log.Fatal("error loading klines: ", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mask, reason, setter string
+		var ctime, expires int64
+		if err := rows.Scan(&mask, &reason, &setter, &ctime, &expires); err != nil {
+			log.Println("Server.loadKLines:", err)
+			continue
+		}
+		var expiry time.Time
+		if expires > 0 {
+			expiry = time.Unix(expires, 0)
+		}
+		server.klines[NewName(mask)] = NewKLine(NewName(mask), NewText(reason),
+			NewName(setter), time.Unix(ctime, 0), expiry)
+	}
+}
+
+func (server *Server) statsKLines(client *Client) {
+	for mask, kline := range server.klines {
+		if kline.Expired() {
+			server.RemoveKLine(mask)
+			continue
+		}
+		client.RplStatsKLine(kline)
+	}
+}
+
+// KLINE [<minutes>] <user@host> [<reason>]
+
+type KLineCommand struct {
+	BaseCommand
+	duration time.Duration
+	mask     Name
+	reason   Text
+}
+
+func ParseKLineCommand(args []string) (Command, error) {
+	cmd := &KLineCommand{}
+	if len(args) > 1 {
+		if minutes, err := strconv.ParseUint(args[0], 10, 32); err == nil {
+			cmd.duration = time.Duration(minutes) * time.Minute
+			args = args[1:]
+		}
+	}
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	cmd.mask = KLineMask(args[0])
+	cmd.reason = "No reason"
+	if len(args) > 1 {
+		cmd.reason = NewText(args[1])
+	}
+	return cmd, nil
+}
+
+// UNKLINE <user@host>
+
+type UnKLineCommand struct {
+	BaseCommand
+	mask Name
+}
+
+func ParseUnKLineCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &UnKLineCommand{
+		mask: KLineMask(args[0]),
+	}, nil
+}
+
+func (msg *KLineCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.flags[Operator] {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	now := time.Now()
+	var expires time.Time
+	if msg.duration > 0 {
+		expires = now.Add(msg.duration)
+	}
+	server.Audit(client, KLINE, msg.mask.String(), msg.reason.String())
+	server.NoticeOperators(NewText(fmt.Sprintf("%s added K-line for %s: %s",
+		client.nick, msg.mask, msg.reason)))
+	server.AddKLine(NewKLine(msg.mask, msg.reason, client.nick, now, expires))
+}
+
+func (msg *UnKLineCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.flags[Operator] {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	if !server.RemoveKLine(msg.mask) {
+		client.Notice("no K-line for %s", msg.mask)
+		return
+	}
+	server.Audit(client, UNKLINE, msg.mask.String(), "")
+	server.NoticeOperators(NewText(fmt.Sprintf("%s removed K-line for %s",
+		client.nick, msg.mask)))
+}
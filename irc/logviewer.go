@@ -0,0 +1,403 @@
+package irc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With channel-logs.web, websocket listeners serve the logs of public
+// channels at /logs/: a list of channels, then of a channel's days, then a
+// day's log a page at a time, with each nick in its own color. A channel
+// is public if it isn't +s, +p, +i or +k, or while it doesn't exist, if
+// it was public when it was last logged. ?format=json gives the same as
+// JSON. Pages are read from the files, so they show what was logged in
+// either format.
+
+const (
+	LOG_VIEWER_PATH  = "/logs/"
+	LOG_VIEWER_LINES = 500 // entries on each page
+)
+
+var (
+	// the lines written by ChannelLogEntry.String, after the time
+	channelLogLineExprs = []struct {
+		kind string
+		expr *regexp.Regexp
+	}{
+		{"message", regexp.MustCompile(`^<([^>]*)> (.*)$`)},
+		{"notice", regexp.MustCompile(`^-([^ ]*)- (.*)$`)},
+		{"join", regexp.MustCompile(`^\*\*\* ([^ ]*) joined$`)},
+		{"part", regexp.MustCompile(`^\*\*\* ([^ ]*) left \((.*)\)$`)},
+		{"quit", regexp.MustCompile(`^\*\*\* ([^ ]*) quit \((.*)\)$`)},
+		{"kick", regexp.MustCompile(`^\*\*\* ([^ ]*) was kicked by ([^ ]*) \((.*)\)$`)},
+		{"topic", regexp.MustCompile(`^\*\*\* ([^ ]*) set the topic: (.*)$`)},
+		{"nick", regexp.MustCompile(`^\*\*\* ([^ ]*) is now known as ([^ ]*)$`)},
+		{"action", regexp.MustCompile(`^\* ([^ ]*) (.*)$`)},
+	}
+)
+
+type LogViewerDays struct {
+	Channel string   `json:"channel"`
+	Days    []string `json:"days"`
+}
+
+type LogViewerPage struct {
+	Channel string             `json:"channel"`
+	Day     string             `json:"day"`
+	Page    int                `json:"page"`
+	Pages   int                `json:"pages"`
+	Entries []*ChannelLogEntry `json:"entries"`
+}
+
+// ParseChannelLogLine reads a line of a text channel log back into an
+// entry.
+func ParseChannelLogLine(line string) (*ChannelLogEntry, bool) {
+	parts := strings.SplitN(line, " ", 2)
+	if len(parts) < 2 {
+		return nil, false
+	}
+	logTime, err := time.Parse(time.RFC3339, parts[0])
+	if err != nil {
+		return nil, false
+	}
+	for _, format := range channelLogLineExprs {
+		match := format.expr.FindStringSubmatch(parts[1])
+		if match == nil {
+			continue
+		}
+		entry := &ChannelLogEntry{Time: logTime, Type: format.kind}
+		switch format.kind {
+		case "kick":
+			entry.Target, entry.Nick, entry.Message = match[1], match[2], match[3]
+		case "nick":
+			entry.Nick, entry.Target = match[1], match[2]
+		default:
+			entry.Nick = match[1]
+			if len(match) > 2 {
+				entry.Message = match[2]
+			}
+		}
+		return entry, true
+	}
+	return nil, false
+}
+
+// NickColor picks a color for nick that stays the same between pages.
+func NickColor(nick string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.ToLower(nick)))
+	return fmt.Sprintf("hsl(%d, 60%%, 35%%)", hash.Sum32()%360)
+}
+
+//
+// websocket listen goroutine
+//
+
+func (server *Server) logViewer(mux *http.ServeMux) {
+	mux.HandleFunc(LOG_VIEWER_PATH, server.serveLogs)
+}
+
+func (server *Server) serveLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, LOG_VIEWER_PATH), "/")
+	asJSON := r.URL.Query().Get("format") == "json"
+	parts := strings.Split(path, "/")
+
+	if path == "" {
+		channels := server.publicLogs(server.loggedChannelNames())
+		if asJSON {
+			logViewerJSON(w, map[string][]string{"channels": channels})
+			return
+		}
+		page := logViewerHead(server.network.Name + " logs")
+		page = append(page, "<ul>")
+		for _, channel := range channels {
+			page = append(page, fmt.Sprintf(`<li><a href="%s">%s</a></li>`,
+				logViewerURL(channel, ""), html.EscapeString(channel)))
+		}
+		logViewerHTML(w, append(page, "</ul>"))
+		return
+	}
+
+	channel := NewName(parts[0])
+	if (len(parts) > 2) || !channel.IsChannel() ||
+		(len(server.publicLogs([]string{channel.String()})) == 0) {
+		http.NotFound(w, r)
+		return
+	}
+	dir := channelLogDir(&server.channelLogs, channel)
+	days := channelLogDays(dir)
+
+	if len(parts) == 1 {
+		if asJSON {
+			logViewerJSON(w, &LogViewerDays{channel.String(), days})
+			return
+		}
+		page := logViewerHead(channel.String())
+		page = append(page, "<ul>")
+		for _, day := range days {
+			page = append(page, fmt.Sprintf(`<li><a href="%s">%s</a></li>`,
+				logViewerURL(channel.String(), day), day))
+		}
+		logViewerHTML(w, append(page, "</ul>"))
+		return
+	}
+
+	day := parts[1]
+	index := sort.Search(len(days), func(i int) bool { return days[i] <= day })
+	if (index == len(days)) || (days[index] != day) {
+		http.NotFound(w, r)
+		return
+	}
+	entries, err := readChannelLog(dir, day)
+	if err != nil {
+		Log.error.Printf("%s log viewer: %s", server, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logPage := &LogViewerPage{
+		Channel: channel.String(),
+		Day:     day,
+		Page:    1,
+		Pages:   (len(entries) + LOG_VIEWER_LINES - 1) / LOG_VIEWER_LINES,
+	}
+	if logPage.Pages == 0 {
+		logPage.Pages = 1
+	}
+	if number, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil &&
+		(number >= 1) && (number <= logPage.Pages) {
+		logPage.Page = number
+	}
+	start := (logPage.Page - 1) * LOG_VIEWER_LINES
+	end := start + LOG_VIEWER_LINES
+	if end > len(entries) {
+		end = len(entries)
+	}
+	logPage.Entries = entries[start:end]
+	if asJSON {
+		logViewerJSON(w, logPage)
+		return
+	}
+	logViewerHTML(w, logPage.HTML(days, index))
+}
+
+// HTML is the page, with links to the days either side of days[index],
+// which are newest first.
+func (logPage *LogViewerPage) HTML(days []string, index int) []string {
+	page := logViewerHead(logPage.Channel + " " + logPage.Day)
+	nav := []string{fmt.Sprintf(`<a href="%s">all days</a>`,
+		logViewerURL(logPage.Channel, ""))}
+	if index+1 < len(days) {
+		nav = append(nav, fmt.Sprintf(`<a href="%s">&larr; %s</a>`,
+			logViewerURL(logPage.Channel, days[index+1]), days[index+1]))
+	}
+	if index > 0 {
+		nav = append(nav, fmt.Sprintf(`<a href="%s">%s &rarr;</a>`,
+			logViewerURL(logPage.Channel, days[index-1]), days[index-1]))
+	}
+	if logPage.Page > 1 {
+		nav = append(nav, fmt.Sprintf(`<a href="?page=%d">previous page</a>`,
+			logPage.Page-1))
+	}
+	if logPage.Page < logPage.Pages {
+		nav = append(nav, fmt.Sprintf(`<a href="?page=%d">next page</a>`,
+			logPage.Page+1))
+	}
+	page = append(page, "<p>"+strings.Join(nav, " | ")+"</p>", "<table>")
+	for _, entry := range logPage.Entries {
+		page = append(page, fmt.Sprintf(
+			`<tr><td class="time">%s</td><td>%s</td></tr>`,
+			entry.Time.Format("15:04:05"), entry.HTML()))
+	}
+	return append(page, "</table>",
+		fmt.Sprintf("<p>page %d of %d</p>", logPage.Page, logPage.Pages))
+}
+
+// HTML is the entry as it's shown by the log viewer.
+func (entry *ChannelLogEntry) HTML() string {
+	nick := func(name string) string {
+		return fmt.Sprintf(`<span style="color: %s">%s</span>`,
+			NickColor(name), html.EscapeString(name))
+	}
+	message := html.EscapeString(entry.Message)
+	switch entry.Type {
+	case "message":
+		return fmt.Sprintf("&lt;%s&gt; %s", nick(entry.Nick), message)
+	case "notice":
+		return fmt.Sprintf("-%s- %s", nick(entry.Nick), message)
+	case "action":
+		return fmt.Sprintf("* %s %s", nick(entry.Nick), message)
+	case "join":
+		return fmt.Sprintf(`<i>%s joined</i>`, nick(entry.Nick))
+	case "part":
+		return fmt.Sprintf(`<i>%s left (%s)</i>`, nick(entry.Nick), message)
+	case "quit":
+		return fmt.Sprintf(`<i>%s quit (%s)</i>`, nick(entry.Nick), message)
+	case "kick":
+		return fmt.Sprintf(`<i>%s was kicked by %s (%s)</i>`,
+			nick(entry.Target), nick(entry.Nick), message)
+	case "topic":
+		return fmt.Sprintf(`<i>%s set the topic: %s</i>`, nick(entry.Nick),
+			message)
+	case "nick":
+		return fmt.Sprintf(`<i>%s is now known as %s</i>`, nick(entry.Nick),
+			nick(entry.Target))
+	}
+	return html.EscapeString(entry.Type)
+}
+
+func logViewerURL(channel string, day string) string {
+	return LOG_VIEWER_PATH + url.PathEscape(channel) + "/" + day
+}
+
+func logViewerHead(title string) []string {
+	return []string{
+		"<!DOCTYPE html>",
+		"<html>",
+		"<head>",
+		`<meta charset="utf-8">`,
+		"<title>" + html.EscapeString(title) + "</title>",
+		"<style>",
+		"body { font-family: sans-serif; margin: 2em; }",
+		"td { padding: 0.1em 0.6em; vertical-align: top; }",
+		"td.time { color: #888; font-family: monospace; }",
+		"</style>",
+		"</head>",
+		"<body>",
+		"<h1>" + html.EscapeString(title) + "</h1>",
+	}
+}
+
+func logViewerHTML(w http.ResponseWriter, page []string) {
+	page = append(page, "</body>", "</html>", "")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(strings.Join(page, "\n")))
+}
+
+func logViewerJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// loggedChannelNames are the channels with a log directory.
+func (server *Server) loggedChannelNames() []string {
+	dirs, err := ioutil.ReadDir(server.channelLogs.Directory)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		if dir.IsDir() {
+			names = append(names, dir.Name())
+		}
+	}
+	return names
+}
+
+// channelLogDays are the days a channel's directory has logs for, newest
+// first.
+func channelLogDays(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	days := make([]string, 0, len(files))
+	for _, file := range files {
+		name := file.Name()
+		day := strings.TrimSuffix(name, filepath.Ext(name))
+		if _, err := time.Parse(CHANNEL_LOG_DAY, day); (err != nil) || seen[day] {
+			continue
+		}
+		seen[day] = true
+		days = append(days, day)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	return days
+}
+
+// readChannelLog reads a day's entries from whichever formats it was
+// logged in.
+func readChannelLog(dir string, day string) ([]*ChannelLogEntry, error) {
+	entries := make([]*ChannelLogEntry, 0)
+	for _, format := range []ChannelLogFormat{ChannelLogText, ChannelLogJSONL} {
+		file, err := os.Open(filepath.Join(dir, day+channelLogExt(format)))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var entry *ChannelLogEntry
+			ok := false
+			if format == ChannelLogJSONL {
+				entry = &ChannelLogEntry{}
+				ok = json.Unmarshal(scanner.Bytes(), entry) == nil
+			} else {
+				entry, ok = ParseChannelLogLine(scanner.Text())
+			}
+			if ok {
+				entries = append(entries, entry)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// publicLogs asks the server goroutine which of the channels have logs
+// anyone may read.
+func (server *Server) publicLogs(names []string) []string {
+	value, _ := server.apiCall("", func(server *Server, user Name) (interface{}, error) {
+		public := make([]string, 0, len(names))
+		for _, name := range names {
+			if server.IsPublicLog(NewName(name)) {
+				public = append(public, name)
+			}
+		}
+		return public, nil
+	})
+	public := value.([]string)
+	sort.Strings(public)
+	return public
+}
+
+//
+// server goroutine
+//
+
+// IsPublicLog reports whether the log viewer shows a channel: it's logged
+// and public, or, if it doesn't exist right now, logged by the config and
+// public when it was last seen.
+func (server *Server) IsPublicLog(name Name) bool {
+	channel := server.channels.Get(name)
+	if channel == nil {
+		return server.loggedChannels[name.ToLower()] &&
+			IsPublicLogDir(channelLogDir(&server.channelLogs, name))
+	}
+	return channel.IsLogged() && channel.IsPublic()
+}
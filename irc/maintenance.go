@@ -0,0 +1,141 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Maintenance runs every maintenance.interval on the server goroutine. It
+// removes expired K-lines and email codes from the database, with the
+// accounts never verified before their codes expired, drops channel
+// history older than channels.history-age and WHOWAS entries older than
+// maintenance.whowas-age, and, once in each vacuum-window when few clients
+// are connected, VACUUMs the database to give freed pages back to the
+// filesystem.
+
+const (
+	MAINTENANCE_CLOCK = "15:04" // the format of vacuum-window's times
+)
+
+type MaintenanceConfig struct {
+	// 0 to never run
+	Interval time.Duration
+	// 0 to keep the last WHOWAS entries whatever their age
+	WhoWasAge time.Duration `yaml:"whowas-age"`
+	// server local times, as "03:00-05:00"; "" to never vacuum
+	VacuumWindow string `yaml:"vacuum-window"`
+	// vacuum only with fewer clients than this; 0 for any number
+	VacuumClients int `yaml:"vacuum-clients"`
+
+	vacuumStart time.Duration // since midnight
+	vacuumEnd   time.Duration
+}
+
+func (conf *MaintenanceConfig) validate() error {
+	if (conf.Interval < 0) || (conf.WhoWasAge < 0) || (conf.VacuumClients < 0) {
+		return fmt.Errorf("maintenance times and vacuum-clients can't be negative")
+	}
+	if conf.VacuumWindow == "" {
+		return nil
+	}
+	times := strings.Split(conf.VacuumWindow, "-")
+	if len(times) != 2 {
+		return fmt.Errorf("maintenance vacuum-window must be <start>-<end>: %s",
+			conf.VacuumWindow)
+	}
+	bounds := make([]time.Duration, 2)
+	for i, clock := range times {
+		t, err := time.Parse(MAINTENANCE_CLOCK, strings.TrimSpace(clock))
+		if err != nil {
+			return fmt.Errorf("maintenance vacuum-window: %s", err)
+		}
+		bounds[i] = time.Duration(t.Hour())*time.Hour +
+			time.Duration(t.Minute())*time.Minute
+	}
+	conf.vacuumStart, conf.vacuumEnd = bounds[0], bounds[1]
+	if conf.vacuumStart == conf.vacuumEnd {
+		return fmt.Errorf("maintenance vacuum-window is empty: %s",
+			conf.VacuumWindow)
+	}
+	return nil
+}
+
+// InVacuumWindow reports whether now is in the vacuum window, which may
+// wrap around midnight.
+func (conf *MaintenanceConfig) InVacuumWindow(now time.Time) bool {
+	if conf.VacuumWindow == "" {
+		return false
+	}
+	clock := time.Duration(now.Hour())*time.Hour +
+		time.Duration(now.Minute())*time.Minute
+	if conf.vacuumStart < conf.vacuumEnd {
+		return (clock >= conf.vacuumStart) && (clock < conf.vacuumEnd)
+	}
+	return (clock >= conf.vacuumStart) || (clock < conf.vacuumEnd)
+}
+
+// vacuumLength is how long the vacuum window is open.
+func (conf *MaintenanceConfig) vacuumLength() time.Duration {
+	length := conf.vacuumEnd - conf.vacuumStart
+	if length < 0 {
+		length += 24 * time.Hour
+	}
+	return length
+}
+
+//
+// server goroutine
+//
+
+// maintain is run every maintenance interval.
+func (server *Server) maintain() {
+	start := time.Now()
+	klines := 0
+	for mask, kline := range server.klines {
+		if kline.Expired() && server.RemoveKLine(mask) {
+			klines += 1
+		}
+	}
+	// accounts whose email was never verified go with their codes
+	server.expirePendingAccounts()
+
+	history := 0
+	if server.historyAge > 0 {
+		cutoff := start.Add(-server.historyAge)
+		for _, channel := range server.channels {
+			if channel.history != nil {
+				history += channel.history.Trim(cutoff)
+			}
+		}
+	}
+	whoWas := 0
+	if age := server.maintenance.WhoWasAge; age > 0 {
+		whoWas = server.whoWas.Trim(start.Add(-age))
+	}
+	Log.debug.Printf("%s maintenance: removed %d K-lines, %d history items "+
+		"and %d WHOWAS entries in %s", server, klines, history, whoWas,
+		time.Since(start))
+
+	server.vacuum(start)
+}
+
+// vacuum VACUUMs the database if it's the vacuum window, it hasn't been
+// vacuumed in this window, and few enough clients are connected. It blocks
+// the server goroutine, which is why it waits for a quiet time.
+func (server *Server) vacuum(now time.Time) {
+	conf := &server.maintenance
+	if !conf.InVacuumWindow(now) ||
+		(now.Sub(server.lastVacuum) < conf.vacuumLength()) {
+		return
+	}
+	if (conf.VacuumClients > 0) && (len(server.clients.byNick) >= conf.VacuumClients) {
+		return
+	}
+	server.lastVacuum = now
+	if _, err := server.db.Exec(`VACUUM`); err != nil {
+		Log.error.Printf("%s vacuum: %s", server, err)
+		return
+	}
+	Log.info.Printf("%s vacuumed database in %s", server, time.Since(now))
+}
@@ -0,0 +1,140 @@
+package irc
+
+import (
+	"math/bits"
+	"time"
+)
+
+// MemberModes is a channel member's modes as a bitmask. The prefix modes
+// have the low bits in rank order, so the highest bit set is the member's
+// rank.
+type MemberModes uint
+
+const (
+	memberPrefixModes MemberModes = 1<<5 - 1
+)
+
+var (
+	// member modes in the order they're shown
+	memberModes = []ChannelMode{ChannelCreator, ChannelFounder, ChannelAdmin,
+		ChannelOperator, Halfop, Voice, Theater}
+)
+
+func memberModeBit(mode ChannelMode) MemberModes {
+	switch mode {
+	case Voice:
+		return 1 << 0
+	case Halfop:
+		return 1 << 1
+	case ChannelOperator:
+		return 1 << 2
+	case ChannelAdmin:
+		return 1 << 3
+	case ChannelFounder:
+		return 1 << 4
+	case ChannelCreator:
+		return 1 << 5
+	case Theater:
+		return 1 << 6
+	}
+	return 0
+}
+
+// Rank is that of the highest prefix mode set.
+func (modes MemberModes) Rank() uint {
+	return uint(bits.Len(uint(modes & memberPrefixModes)))
+}
+
+// Membership is a client's place in a channel. The methods may be called
+// on a nil Membership, for a client that isn't a member.
+type Membership struct {
+	modes  MemberModes
+	joined time.Time
+}
+
+func (membership *Membership) Has(mode ChannelMode) bool {
+	return (membership != nil) && (membership.modes&memberModeBit(mode) != 0)
+}
+
+// Set turns mode on or off, reporting whether that changed anything.
+func (membership *Membership) Set(mode ChannelMode, on bool) bool {
+	if membership.Has(mode) == on {
+		return false
+	}
+	membership.modes ^= memberModeBit(mode)
+	return true
+}
+
+func (membership *Membership) Rank() uint {
+	if membership == nil {
+		return 0
+	}
+	return membership.modes.Rank()
+}
+
+// Joined is when the member joined the channel.
+func (membership *Membership) Joined() time.Time {
+	if membership == nil {
+		return time.Time{}
+	}
+	return membership.joined
+}
+
+// Prefixes renders the member's status for NAMES and WHO, with the
+// server's prefixes: all of them with multi-prefix, otherwise only the
+// highest.
+func (membership *Membership) Prefixes(members []*MemberPrefix,
+	isMultiPrefix bool) (prefixes string) {
+	if (membership == nil) || (membership.modes&memberPrefixModes == 0) {
+		return
+	}
+	for _, member := range members {
+		if !membership.Has(member.mode) {
+			continue
+		}
+		prefixes += member.prefix
+		if !isMultiPrefix {
+			break
+		}
+	}
+	return
+}
+
+func (membership *Membership) String() (str string) {
+	for _, mode := range memberModes {
+		if membership.Has(mode) {
+			str += mode.String()
+		}
+	}
+	return
+}
+
+type MemberSet map[*Client]*Membership
+
+func (members MemberSet) Add(member *Client) {
+	members[member] = &Membership{
+		joined: time.Now(),
+	}
+}
+
+func (members MemberSet) Remove(member *Client) {
+	delete(members, member)
+}
+
+func (members MemberSet) Has(member *Client) bool {
+	_, ok := members[member]
+	return ok
+}
+
+func (members MemberSet) HasMode(member *Client, mode ChannelMode) bool {
+	return members[member].Has(mode)
+}
+
+func (members MemberSet) AnyHasMode(mode ChannelMode) bool {
+	for _, membership := range members {
+		if membership.Has(mode) {
+			return true
+		}
+	}
+	return false
+}
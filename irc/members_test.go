@@ -0,0 +1,104 @@
+package irc
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// The busiest channels have this many members; NAMES, rank checks and
+// KICK on them shouldn't do more than a pass over the members.
+const benchMembers = 10000
+
+// discardConn is a connection whose writes go nowhere.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(data []byte) (int, error)   { return len(data), nil }
+func (discardConn) Close() error                     { return nil }
+func (discardConn) SetWriteDeadline(time.Time) error { return nil }
+func (discardConn) RemoteAddr() net.Addr             { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+func newBenchClient(server *Server, nick string) *Client {
+	return &Client{
+		capabilities: make(CapabilitySet),
+		channels:     make(ChannelSet),
+		flags:        make(map[UserMode]bool),
+		hostname:     "localhost",
+		nick:         Name(nick),
+		server:       server,
+		silence:      NewUserMaskSet(),
+		socket:       NewSocket(discardConn{}, 1<<30, 0),
+		username:     Name(nick),
+	}
+}
+
+// newBenchChannel makes a channel of benchMembers members; the first is
+// its operator, and every tenth is voiced.
+func newBenchChannel() (*Channel, []*Client) {
+	// as ergonomadic run does, once devNull is open
+	Log.SetLevel("warn")
+	server := &Server{
+		channels:       make(ChannelNameMap),
+		memberPrefixes: DefaultMemberPrefixes,
+		name:           "bench.test",
+	}
+	channel := NewChannel(server, "#bench")
+	members := make([]*Client, benchMembers)
+	for i := range members {
+		members[i] = newBenchClient(server, fmt.Sprintf("member%d", i))
+		benchJoin(channel, members[i])
+		if i%10 == 0 {
+			channel.members[members[i]].Set(Voice, true)
+		}
+	}
+	channel.members[members[0]].Set(ChannelOperator, true)
+	return channel, members
+}
+
+func benchJoin(channel *Channel, client *Client) {
+	channel.members.Add(client)
+	client.channels.Add(channel)
+}
+
+func BenchmarkNames(b *testing.B) {
+	channel, members := newBenchChannel()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		channel.Names(members[i%benchMembers])
+	}
+}
+
+func BenchmarkNamesMultiPrefix(b *testing.B) {
+	channel, members := newBenchChannel()
+	for _, member := range members {
+		member.capabilities[MultiPrefix] = true
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		channel.Names(members[i%benchMembers])
+	}
+}
+
+func BenchmarkClientIsAtLeast(b *testing.B) {
+	channel, members := newBenchChannel()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		channel.ClientIsAtLeast(members[i%benchMembers], Halfop)
+	}
+}
+
+func BenchmarkKick(b *testing.B) {
+	channel, members := newBenchChannel()
+	op := members[0]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		target := members[1+i%(benchMembers-1)]
+		channel.Kick(op, target, "bench")
+		b.StopTimer()
+		benchJoin(channel, target)
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,303 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Memos are messages left for accounts. A PRIVMSG from a client logged in
+// to an account, to the nick of a registered account that isn't online,
+// is saved as a memo, and unread memos are sent as notices when the
+// account next logs in.
+
+const (
+	DEFAULT_MEMO_QUOTA = 20 // memos kept for each account
+)
+
+type MemoConfig struct {
+	// memos kept for each account; 0 for the default
+	Quota int
+}
+
+type MemoServSubCommand string
+
+const (
+	MemoServSend MemoServSubCommand = "SEND"
+	MemoServList MemoServSubCommand = "LIST"
+	MemoServRead MemoServSubCommand = "READ"
+	MemoServDel  MemoServSubCommand = "DEL"
+	MemoServHelp MemoServSubCommand = "HELP"
+)
+
+// MEMOSERV SEND <account> <text>
+// MEMOSERV LIST
+// MEMOSERV READ <id>
+// MEMOSERV DEL <id|ALL>
+
+type MemoServSendCommand struct {
+	BaseCommand
+	account Name
+	text    Text
+}
+
+type MemoServListCommand struct {
+	BaseCommand
+}
+
+type MemoServReadCommand struct {
+	BaseCommand
+	id int64
+}
+
+// id 0 deletes every memo
+type MemoServDelCommand struct {
+	BaseCommand
+	id int64
+}
+
+func ParseMemoServCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	switch MemoServSubCommand(strings.ToUpper(args[0])) {
+	case MemoServHelp:
+		return NewHelpCommand([]string{MEMOSERV.String()}), nil
+
+	case MemoServSend:
+		if len(args) < 3 {
+			return nil, NotEnoughArgsError
+		}
+		return &MemoServSendCommand{
+			account: NewName(args[1]),
+			text:    NewText(strings.Join(args[2:], " ")),
+		}, nil
+
+	case MemoServList:
+		return &MemoServListCommand{}, nil
+
+	case MemoServRead:
+		if len(args) < 2 {
+			return nil, NotEnoughArgsError
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if (err != nil) || (id <= 0) {
+			return nil, ErrParseCommand
+		}
+		return &MemoServReadCommand{id: id}, nil
+
+	case MemoServDel:
+		if len(args) < 2 {
+			return nil, NotEnoughArgsError
+		}
+		if strings.ToUpper(args[1]) == "ALL" {
+			return &MemoServDelCommand{}, nil
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if (err != nil) || (id <= 0) {
+			return nil, ErrParseCommand
+		}
+		return &MemoServDelCommand{id: id}, nil
+	}
+	return nil, ErrParseCommand
+}
+
+type Memo struct {
+	id     int64
+	sender Name
+	time   time.Time
+	text   Text
+	read   bool
+}
+
+func (memo *Memo) String() string {
+	return fmt.Sprintf("[%d] from %s at %s: %s", memo.id, memo.sender,
+		memo.time.UTC().Format(time.RFC1123), memo.text)
+}
+
+//
+// server goroutine
+//
+
+func (msg *MemoServSendCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !server.isAccount(msg.account) {
+		client.Notice("%s is not registered", msg.account)
+		return
+	}
+	server.SendMemo(client, msg.account, msg.text)
+}
+
+func (msg *MemoServListCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if client.account == "" {
+		client.Notice("you must be logged in to use memos")
+		return
+	}
+	memos := server.memos(client.account, false)
+	client.Notice("You have %d memos", len(memos))
+	for _, memo := range memos {
+		status := "read"
+		if !memo.read {
+			status = "new"
+		}
+		client.Notice("[%d] from %s at %s (%s)", memo.id, memo.sender,
+			memo.time.UTC().Format(time.RFC1123), status)
+	}
+}
+
+func (msg *MemoServReadCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if client.account == "" {
+		client.Notice("you must be logged in to use memos")
+		return
+	}
+	for _, memo := range server.memos(client.account, false) {
+		if memo.id == msg.id {
+			client.Reply(RplNotice(server, client, NewText(memo.String())))
+			server.markMemosRead(client.account, memo.id)
+			return
+		}
+	}
+	client.Notice("No memo %d", msg.id)
+}
+
+func (msg *MemoServDelCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if client.account == "" {
+		client.Notice("you must be logged in to use memos")
+		return
+	}
+
+	query, args := `DELETE FROM memo WHERE account = ?`,
+		[]interface{}{client.account.String()}
+	if msg.id != 0 {
+		query += ` AND id = ?`
+		args = append(args, msg.id)
+	}
+	result, err := server.db.Exec(query, args...)
+	if err != nil {
+		log.Println("MemoServDelCommand.HandleServer:", err)
+		return
+	}
+	deleted, _ := result.RowsAffected()
+	client.Notice("Deleted %d memos", deleted)
+}
+
+// SendMemo saves a memo from client for account, unless client isn't
+// logged in, account's memos are full, or account wouldn't take a private
+// message from client: its saved SILENCE list matches client, or one of
+// its clients is silencing client or blocks it with caller-id (+g).
+func (server *Server) SendMemo(client *Client, account Name, text Text) {
+	if client.account == "" {
+		client.Notice("you must be logged in to use memos")
+		return
+	}
+	if !server.acceptsMemo(account, client) {
+		return
+	}
+
+	var count int
+	err := server.db.QueryRow(`SELECT COUNT(*) FROM memo WHERE account = ?`,
+		account.String()).Scan(&count)
+	if err != nil {
+		log.Println("Server.SendMemo:", err)
+		return
+	}
+	if count >= server.memoQuota {
+		client.Notice("%s has too many memos", account)
+		return
+	}
+
+	_, err = server.db.Exec(`
+        INSERT INTO memo (account, sender, time, text) VALUES (?, ?, ?, ?)`,
+		account.String(), client.account.String(), time.Now().Unix(),
+		text.String())
+	if err != nil {
+		log.Println("Server.SendMemo:", err)
+		return
+	}
+	client.Notice("Memo sent to %s", account)
+
+	for _, other := range server.clients.byNick {
+		if other.account.ToLower() == account.ToLower() {
+			other.Notice("You have a new memo from %s; MEMOSERV LIST to see it",
+				client.account)
+		}
+	}
+}
+
+// acceptsMemo applies to a memo from client the checks a PRIVMSG to
+// account would get. Like PRIVMSG, silenced memos are dropped quietly.
+func (server *Server) acceptsMemo(account Name, client *Client) bool {
+	for _, other := range server.clients.byNick {
+		if other.account.ToLower() != account.ToLower() {
+			continue
+		}
+		if other.IsSilencing(client) || !other.CanMessage(client, true) {
+			return false
+		}
+	}
+	silence := NewUserMaskSet()
+	silence.AddAll(server.accountSilence(account))
+	return !silence.Match(client.UserHost())
+}
+
+// DeliverMemos sends client the unread memos for its account and marks
+// them read.
+func (server *Server) DeliverMemos(client *Client) {
+	memos := server.memos(client.account, true)
+	if len(memos) == 0 {
+		return
+	}
+	client.Notice("You have %d new memos", len(memos))
+	for _, memo := range memos {
+		client.Reply(RplNotice(server, client, NewText(memo.String())))
+	}
+	server.markMemosRead(client.account, 0)
+}
+
+func (server *Server) memos(account Name, unread bool) []*Memo {
+	query := `SELECT id, sender, time, text, read FROM memo WHERE account = ?`
+	if unread {
+		query += ` AND read = 0`
+	}
+	rows, err := server.db.Query(query+` ORDER BY id`, account.String())
+	if err != nil {
+		log.Println("Server.memos:", err)
+		return nil
+	}
+	defer rows.Close()
+
+	memos := make([]*Memo, 0)
+	for rows.Next() {
+		var sender, text string
+		var ts int64
+		memo := &Memo{}
+		if err := rows.Scan(&memo.id, &sender, &ts, &text, &memo.read); err != nil {
+			log.Println("Server.memos:", err)
+			continue
+		}
+		memo.sender = NewName(sender)
+		memo.time = time.Unix(ts, 0)
+		memo.text = NewText(text)
+		memos = append(memos, memo)
+	}
+	return memos
+}
+
+// markMemosRead marks the memo with id read, or all of account's memos for
+// id 0.
+func (server *Server) markMemosRead(account Name, id int64) {
+	query, args := `UPDATE memo SET read = 1 WHERE account = ?`,
+		[]interface{}{account.String()}
+	if id != 0 {
+		query += ` AND id = ?`
+		args = append(args, id)
+	}
+	if _, err := server.db.Exec(query, args...); err != nil {
+		log.Println("Server.markMemosRead:", err)
+	}
+}
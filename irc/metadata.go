@@ -0,0 +1,542 @@
+package irc
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// METADATA (draft/metadata-2) attaches key-value pairs such as url or
+// display-name to clients and channels. Clients with the capability
+// subscribe to the keys they want and are sent a METADATA message when
+// one of them changes on a channel they're in or on someone they share a
+// channel with. A logged in client's metadata is kept with its account,
+// and a persistent channel's with the channel.
+
+const (
+	METADATA_VISIBILITY = "*" // every key is public
+)
+
+var (
+	MetadataKeyExpr = regexp.MustCompile(`^[a-z0-9_./-]+$`)
+
+	DefaultMetadata = MetadataConfig{
+		MaxKeys:       20,
+		MaxSubs:       50,
+		MaxValueBytes: 300,
+	}
+)
+
+type MetadataConfig struct {
+	// keys set on each client or channel
+	MaxKeys int `yaml:"max-keys"`
+	// keys each client may subscribe to
+	MaxSubs       int `yaml:"max-subs"`
+	MaxValueBytes int `yaml:"max-value-bytes"`
+}
+
+func (conf *MetadataConfig) setDefaults() {
+	if conf.MaxKeys <= 0 {
+		conf.MaxKeys = DefaultMetadata.MaxKeys
+	}
+	if conf.MaxSubs <= 0 {
+		conf.MaxSubs = DefaultMetadata.MaxSubs
+	}
+	if conf.MaxValueBytes <= 0 {
+		conf.MaxValueBytes = DefaultMetadata.MaxValueBytes
+	}
+}
+
+type MetadataMap map[string]string
+
+func (metadata MetadataMap) Keys() []string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type MetadataSubCommand string
+
+const (
+	MetadataGet   MetadataSubCommand = "GET"
+	MetadataList  MetadataSubCommand = "LIST"
+	MetadataSet   MetadataSubCommand = "SET"
+	MetadataClear MetadataSubCommand = "CLEAR"
+	MetadataSub   MetadataSubCommand = "SUB"
+	MetadataUnsub MetadataSubCommand = "UNSUB"
+	MetadataSubs  MetadataSubCommand = "SUBS"
+	MetadataSync  MetadataSubCommand = "SYNC"
+)
+
+// METADATA <target> <subcommand> [<param> ...]
+
+type MetadataCommand struct {
+	BaseCommand
+	target     Name
+	subCommand MetadataSubCommand
+	params     []string
+	value      *Text // SET with a value; nil removes the key
+}
+
+func ParseMetadataCommand(args []string) (Command, error) {
+	if len(args) < 2 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &MetadataCommand{
+		target:     NewName(args[0]),
+		subCommand: MetadataSubCommand(strings.ToUpper(args[1])),
+		params:     args[2:],
+	}
+	switch cmd.subCommand {
+	case MetadataGet, MetadataSub, MetadataUnsub:
+		if len(cmd.params) < 1 {
+			return nil, NotEnoughArgsError
+		}
+
+	case MetadataSet:
+		if len(cmd.params) < 1 {
+			return nil, NotEnoughArgsError
+		}
+		if len(cmd.params) > 1 {
+			value := NewText(cmd.params[1])
+			cmd.value = &value
+		}
+		cmd.params = cmd.params[:1]
+	}
+	return cmd, nil
+}
+
+// metadataKey checks and lowercases a key, telling client if it's invalid.
+func (client *Client) metadataKey(key string) (string, bool) {
+	key = strings.ToLower(key)
+	if !MetadataKeyExpr.MatchString(key) {
+		client.Reply(RplFail(client.server, METADATA, "KEY_INVALID "+key,
+			"Invalid key"))
+		return "", false
+	}
+	return key, true
+}
+
+//
+// server goroutine
+//
+
+// metadataTarget is what a METADATA command is about: exactly one of
+// client and channel.
+type metadataTarget struct {
+	client  *Client
+	channel *Channel
+}
+
+func (target metadataTarget) Name() Name {
+	if target.channel != nil {
+		return target.channel.name
+	}
+	return target.client.Nick()
+}
+
+func (target metadataTarget) Values() MetadataMap {
+	if target.channel != nil {
+		return target.channel.metadata
+	}
+	return target.client.metadata
+}
+
+// findMetadataTarget looks up name, * meaning client itself, telling
+// client if it can't see it.
+func (client *Client) findMetadataTarget(name Name) (target metadataTarget, ok bool) {
+	if name == "*" {
+		return metadataTarget{client: client}, true
+	}
+	if name.IsChannel() {
+		channel := client.server.channels.Get(name)
+		if (channel != nil) && (channel.members.Has(client) ||
+			client.flags[Operator] ||
+			!(channel.flags[Secret] || channel.flags[Private])) {
+			return metadataTarget{channel: channel}, true
+		}
+	} else if other := client.server.clients.Get(name); other != nil {
+		return metadataTarget{client: other}, true
+	}
+	client.Reply(RplFail(client.server, METADATA,
+		"INVALID_TARGET "+name.String(), "Invalid target"))
+	return
+}
+
+// canSetMetadata is true for a client's own metadata, a channel's for its
+// operators, and anything for server operators.
+func (client *Client) canSetMetadata(target metadataTarget) bool {
+	if target.channel != nil {
+		return target.channel.ClientIsOperator(client)
+	}
+	return (target.client == client) || client.flags[Operator]
+}
+
+// SetMetadata changes or, with a nil value, removes a key, persisting it
+// and telling the subscribers other than setter.
+func (server *Server) SetMetadata(setter *Client, target metadataTarget,
+	key string, value *Text) {
+	metadata := target.Values()
+	if metadata == nil {
+		metadata = make(MetadataMap)
+		if target.channel != nil {
+			target.channel.metadata = metadata
+		} else {
+			target.client.metadata = metadata
+		}
+	}
+	if value == nil {
+		delete(metadata, key)
+	} else {
+		metadata[key] = value.String()
+	}
+
+	if target.channel != nil {
+		if err := target.channel.persistMetadata(key, value); err != nil {
+			log.Println("Channel.persistMetadata:", err)
+		}
+	} else if target.client.account != "" {
+		if err := server.persistAccountMetadata(target.client.account, key,
+			value); err != nil {
+			log.Println("Server.persistAccountMetadata:", err)
+		}
+	}
+
+	target.notify(setter, key, value)
+}
+
+func (target metadataTarget) notify(setter *Client, key string, value *Text) {
+	var reply string
+	for recipient := range target.subscribers(key) {
+		if recipient == setter {
+			continue
+		}
+		if reply == "" {
+			reply = RplMetadata(recipient.server, target.Name(), key, value)
+		}
+		recipient.Reply(reply)
+	}
+}
+
+// subscribers are the clients to be told when key changes on target: those
+// subscribed to it that are in the channel, or share one with the client.
+func (target metadataTarget) subscribers(key string) ClientSet {
+	subscribers := make(ClientSet)
+	var clients ClientSet
+	if target.channel != nil {
+		clients = make(ClientSet)
+		for member := range target.channel.members {
+			clients.Add(member)
+		}
+	} else {
+		clients = target.client.Friends()
+	}
+	for client := range clients {
+		if client.capabilities[Metadata] && client.metadataSubs[key] {
+			subscribers.Add(client)
+		}
+	}
+	return subscribers
+}
+
+// SendMetadata sends client the keys it subscribed to that are set on
+// target.
+func (client *Client) SendMetadata(target metadataTarget) {
+	metadata := target.Values()
+	for _, key := range metadata.Keys() {
+		if client.metadataSubs[key] {
+			value := NewText(metadata[key])
+			client.Reply(RplMetadata(client.server, target.Name(), key, &value))
+		}
+	}
+}
+
+// JoinMetadata sends a client that just joined channel the metadata of the
+// channel and its members, and the members the client's.
+func (channel *Channel) JoinMetadata(client *Client) {
+	if client.capabilities[Metadata] {
+		client.SendMetadata(metadataTarget{channel: channel})
+	}
+	for member := range channel.members {
+		if member == client {
+			continue
+		}
+		if client.capabilities[Metadata] {
+			client.SendMetadata(metadataTarget{client: member})
+		}
+		if member.capabilities[Metadata] {
+			member.SendMetadata(metadataTarget{client: client})
+		}
+	}
+}
+
+func (msg *MetadataCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	target, ok := client.findMetadataTarget(msg.target)
+	if !ok {
+		return
+	}
+	config := &server.metadata
+
+	switch msg.subCommand {
+	case MetadataGet:
+		for _, key := range msg.params {
+			key, ok := client.metadataKey(key)
+			if !ok {
+				continue
+			}
+			if value, ok := target.Values()[key]; ok {
+				client.RplKeyValue(target.Name(), key, value)
+			} else {
+				client.RplKeyNotSet(target.Name(), key)
+			}
+		}
+
+	case MetadataList:
+		metadata := target.Values()
+		for _, key := range metadata.Keys() {
+			client.RplKeyValue(target.Name(), key, metadata[key])
+		}
+		client.RplMetadataEnd()
+
+	case MetadataSet:
+		key, ok := client.metadataKey(msg.params[0])
+		if !ok {
+			return
+		}
+		if !client.canSetMetadata(target) {
+			client.Reply(RplFail(server, METADATA, "KEY_NO_PERMISSION "+
+				target.Name().String()+" "+key, "Permission denied"))
+			return
+		}
+		if !client.checkProfileValue(target, key, msg.value) {
+			return
+		}
+		if msg.value == nil {
+			server.SetMetadata(client, target, key, nil)
+			client.RplKeyNotSet(target.Name(), key)
+			return
+		}
+		if len(*msg.value) > config.MaxValueBytes {
+			client.Reply(RplFail(server, METADATA, "VALUE_INVALID",
+				"Value is too long"))
+			return
+		}
+		if _, exists := target.Values()[key]; !exists &&
+			(len(target.Values()) >= config.MaxKeys) {
+			client.Reply(RplFail(server, METADATA, "LIMIT_REACHED "+
+				target.Name().String(), "Metadata limit reached"))
+			return
+		}
+		server.SetMetadata(client, target, key, msg.value)
+		client.RplKeyValue(target.Name(), key, msg.value.String())
+
+	case MetadataClear:
+		if !client.canSetMetadata(target) {
+			client.Reply(RplFail(server, METADATA, "KEY_NO_PERMISSION "+
+				target.Name().String()+" *", "Permission denied"))
+			return
+		}
+		for _, key := range target.Values().Keys() {
+			server.SetMetadata(client, target, key, nil)
+			client.RplKeyNotSet(target.Name(), key)
+		}
+		client.RplMetadataEnd()
+
+	case MetadataSub:
+		added := make([]string, 0, len(msg.params))
+		for _, key := range msg.params {
+			key, ok := client.metadataKey(key)
+			if !ok {
+				continue
+			}
+			if !client.metadataSubs[key] &&
+				(len(client.metadataSubs) >= config.MaxSubs) {
+				client.Reply(RplFail(server, METADATA, "TOO_MANY_SUBS "+key,
+					"Too many subscriptions"))
+				break
+			}
+			if client.metadataSubs == nil {
+				client.metadataSubs = make(map[string]bool)
+			}
+			client.metadataSubs[key] = true
+			added = append(added, key)
+		}
+		if len(added) > 0 {
+			client.RplMetadataSubOK(added)
+		}
+
+	case MetadataUnsub:
+		removed := make([]string, 0, len(msg.params))
+		for _, key := range msg.params {
+			key, ok := client.metadataKey(key)
+			if !ok {
+				continue
+			}
+			delete(client.metadataSubs, key)
+			removed = append(removed, key)
+		}
+		if len(removed) > 0 {
+			client.RplMetadataUnsubOK(removed)
+		}
+
+	case MetadataSubs:
+		subs := make([]string, 0, len(client.metadataSubs))
+		for key := range client.metadataSubs {
+			subs = append(subs, key)
+		}
+		sort.Strings(subs)
+		if len(subs) > 0 {
+			client.RplMetadataSubs(subs)
+		}
+		client.RplMetadataEnd()
+
+	case MetadataSync:
+		client.SendMetadata(target)
+		if target.channel != nil {
+			for member := range target.channel.members {
+				client.SendMetadata(metadataTarget{client: member})
+			}
+		}
+
+	default:
+		client.Reply(RplFail(server, METADATA, "SUBCOMMAND_INVALID "+
+			string(msg.subCommand), "Invalid subcommand"))
+	}
+}
+
+//
+// persistence
+//
+
+func (server *Server) accountMetadata(account Name) MetadataMap {
+	metadata := make(MetadataMap)
+	rows, err := server.db.Query(`
+        SELECT key, value FROM account_metadata WHERE account = ?`,
+		account.String())
+	if err != nil {
+		log.Println("Server.accountMetadata:", err)
+		return metadata
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			log.Println("Server.accountMetadata:", err)
+			continue
+		}
+		metadata[key] = value
+	}
+	return metadata
+}
+
+func (server *Server) persistAccountMetadata(account Name, key string,
+	value *Text) (err error) {
+	if value == nil {
+		_, err = server.db.Exec(`
+            DELETE FROM account_metadata WHERE account = ? AND key = ?`,
+			account.String(), key)
+	} else {
+		_, err = server.db.Exec(`
+            INSERT INTO account_metadata (account, key, value) VALUES (?, ?, ?)`,
+			account.String(), key, value.String())
+	}
+	return
+}
+
+// loginMetadata adds the metadata kept for the account client just logged
+// in to, then keeps what client had set before with it.
+func (client *Client) loginMetadata() {
+	saved := client.server.accountMetadata(client.account)
+	if client.metadata == nil {
+		client.metadata = make(MetadataMap)
+	}
+	for key, value := range client.metadata {
+		value := NewText(value)
+		if err := client.server.persistAccountMetadata(client.account, key,
+			&value); err != nil {
+			log.Println("Server.persistAccountMetadata:", err)
+		}
+	}
+	target := metadataTarget{client: client}
+	for _, key := range saved.Keys() {
+		if _, ok := client.metadata[key]; ok {
+			continue
+		}
+		value := NewText(saved[key])
+		client.metadata[key] = saved[key]
+		if client.registered {
+			target.notify(client, key, &value)
+		}
+	}
+}
+
+// logoutMetadata drops the client's keys when it logs out; since
+// loginMetadata they are all its account's.
+func (client *Client) logoutMetadata() {
+	target := metadataTarget{client: client}
+	for key := range client.metadata {
+		delete(client.metadata, key)
+		if client.registered {
+			target.notify(client, key, nil)
+		}
+	}
+}
+
+// persistMetadata keeps a persistent channel's key in the database.
+func (channel *Channel) persistMetadata(key string, value *Text) (err error) {
+	if !channel.flags[Persistent] {
+		return
+	}
+	if value == nil {
+		_, err = channel.server.db.Exec(`
+            DELETE FROM channel_metadata WHERE channel = ? AND key = ?`,
+			channel.name.String(), key)
+	} else {
+		_, err = channel.server.db.Exec(`
+            INSERT INTO channel_metadata (channel, key, value) VALUES (?, ?, ?)`,
+			channel.name.String(), key, value.String())
+	}
+	return
+}
+
+// persistAllMetadata writes all of a channel's keys, for when it becomes
+// persistent.
+func (channel *Channel) persistAllMetadata() error {
+	for key, value := range channel.metadata {
+		value := NewText(value)
+		if err := channel.persistMetadata(key, &value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (server *Server) loadChannelMetadata() {
+	rows, err := server.db.Query(`
+        SELECT channel, key, value FROM channel_metadata`)
+	if err != nil {
+		log.Println("Server.loadChannelMetadata:", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, key, value string
+		if err := rows.Scan(&name, &key, &value); err != nil {
+			log.Println("Server.loadChannelMetadata:", err)
+			continue
+		}
+		channel := server.channels.Get(NewName(name))
+		if channel == nil {
+			continue
+		}
+		if channel.metadata == nil {
+			channel.metadata = make(MetadataMap)
+		}
+		channel.metadata[key] = value
+	}
+}
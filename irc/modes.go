@@ -52,6 +52,9 @@ const (
 
 const (
 	Away          UserMode = 'a'
+	Bot           UserMode = 'B'
+	CallerID      UserMode = 'g'
+	HostCloak     UserMode = 'x'
 	Invisible     UserMode = 'i'
 	LocalOperator UserMode = 'O'
 	Operator      UserMode = 'o'
@@ -60,41 +63,217 @@ const (
 	WallOps       UserMode = 'w'
 )
 
+// UserModeDef says how clients may change a user mode with MODE. Away
+// and Operator are set by AWAY and OPER instead.
+type UserModeDef struct {
+	mode      UserMode
+	canAdd    bool
+	canRemove bool
+}
+
 var (
-	SupportedUserModes = UserModes{
-		Away, Invisible, Operator,
+	UserModeDefs = []*UserModeDef{
+		{Away, false, false},
+		{Bot, true, true},
+		{CallerID, true, true},
+		{Invisible, true, true},
+		{LocalOperator, false, true},
+		{Operator, false, true},
+		{ServerNotice, true, true},
+		{WallOps, true, true},
+		{HostCloak, true, true},
 	}
+
+	SupportedUserModes = userModes(UserModeDefs)
 )
 
+func userModes(defs []*UserModeDef) UserModes {
+	modes := make(UserModes, len(defs))
+	for index, def := range defs {
+		modes[index] = def.mode
+	}
+	return modes
+}
+
+func UserModeDefFor(mode UserMode) *UserModeDef {
+	for _, def := range UserModeDefs {
+		if def.mode == mode {
+			return def
+		}
+	}
+	return nil
+}
+
 const (
-	Anonymous       ChannelMode = 'a' // flag
 	BanMask         ChannelMode = 'b' // arg
+	ChannelAdmin    ChannelMode = 'a' // arg
 	ChannelCreator  ChannelMode = 'O' // flag
+	ChannelFounder  ChannelMode = 'q' // arg
 	ChannelOperator ChannelMode = 'o' // arg
 	ExceptMask      ChannelMode = 'e' // arg
+	Forward         ChannelMode = 'f' // flag arg
+	Halfop          ChannelMode = 'h' // arg
 	InviteMask      ChannelMode = 'I' // arg
+	KeepHistory     ChannelMode = 'H' // flag, nonstandard
+	Logged          ChannelMode = 'L' // flag arg, nonstandard
 	InviteOnly      ChannelMode = 'i' // flag
 	Key             ChannelMode = 'k' // flag arg
 	Moderated       ChannelMode = 'm' // flag
+	NoCTCP          ChannelMode = 'C' // flag, nonstandard
+	NoColor         ChannelMode = 'c' // flag, nonstandard
 	NoOutside       ChannelMode = 'n' // flag
 	OpOnlyTopic     ChannelMode = 't' // flag
 	Persistent      ChannelMode = 'P' // flag
 	Private         ChannelMode = 'p' // flag
-	Quiet           ChannelMode = 'q' // flag
 	ReOp            ChannelMode = 'r' // flag
+	Roleplay        ChannelMode = 'E' // flag, nonstandard
 	Secret          ChannelMode = 's' // flag, deprecated
+	SlowMode        ChannelMode = 'S' // flag arg, nonstandard
 	Theater         ChannelMode = 'T' // flag, nonstandard
 	UserLimit       ChannelMode = 'l' // flag arg
 	Voice           ChannelMode = 'v' // arg
 )
 
+// ChannelModeType is the RPL_ISUPPORT CHANMODES class of a mode, which
+// decides when it takes an argument.
+type ChannelModeType uint
+
+const (
+	ListMode     ChannelModeType = iota // A: adds to or removes from a list
+	ParamMode                           // B: always has an argument
+	SetParamMode                        // C: has an argument only when set
+	FlagMode                            // D: never has an argument
+	MemberMode                          // PREFIX: argument is a member's nick
+)
+
+// ChannelModeDef describes a channel mode. setter is the lowest member
+// mode allowed to change it; 0 means it can only be set by the daemon.
+type ChannelModeDef struct {
+	mode   ChannelMode
+	kind   ChannelModeType
+	setter ChannelMode
+}
+
+// HasArg is true when a change with the op consumes a MODE argument.
+func (def *ChannelModeDef) HasArg(op ModeOp) bool {
+	switch def.kind {
+	case ListMode, ParamMode, MemberMode:
+		return true
+
+	case SetParamMode:
+		return op == Add
+	}
+	return false
+}
+
 var (
-	SupportedChannelModes = ChannelModes{
-		BanMask, ExceptMask, InviteMask, InviteOnly, Key, NoOutside,
-		OpOnlyTopic, Persistent, Private, Theater, UserLimit,
+	// ChannelModeDefs lists every channel mode understood by MODE. Add a
+	// mode here, then handle its state in Channel.applyMode if it isn't a
+	// plain list, flag or member mode.
+	ChannelModeDefs = []*ChannelModeDef{
+		{BanMask, ListMode, ChannelOperator},
+		{ExceptMask, ListMode, ChannelOperator},
+		{InviteMask, ListMode, ChannelOperator},
+		{Key, ParamMode, ChannelOperator},
+		{Forward, SetParamMode, ChannelOperator},
+		{UserLimit, SetParamMode, ChannelOperator},
+		{SlowMode, SetParamMode, ChannelOperator},
+		{Logged, SetParamMode, ChannelOperator},
+		{InviteOnly, FlagMode, ChannelOperator},
+		{KeepHistory, FlagMode, ChannelOperator},
+		{Moderated, FlagMode, ChannelOperator},
+		{NoCTCP, FlagMode, ChannelOperator},
+		{NoColor, FlagMode, ChannelOperator},
+		{NoOutside, FlagMode, ChannelOperator},
+		{OpOnlyTopic, FlagMode, ChannelOperator},
+		{Persistent, FlagMode, ChannelOperator},
+		{Private, FlagMode, ChannelOperator},
+		{Roleplay, FlagMode, ChannelOperator},
+		{Theater, FlagMode, 0},
+		{ChannelFounder, MemberMode, ChannelFounder},
+		{ChannelAdmin, MemberMode, ChannelAdmin},
+		{ChannelOperator, MemberMode, ChannelOperator},
+		{Halfop, MemberMode, ChannelOperator},
+		{Voice, MemberMode, Halfop},
+	}
+
+	SupportedChannelModes = channelModes(ChannelModeDefs)
+
+	// DefaultMemberPrefixes are the NAMES/WHO prefixes of the channel
+	// member modes, highest rank first. A server may be configured with
+	// others; see NewMemberPrefixes.
+	DefaultMemberPrefixes = []*MemberPrefix{
+		{ChannelFounder, "~"},
+		{ChannelAdmin, "&"},
+		{ChannelOperator, "@"},
+		{Halfop, "%"},
+		{Voice, "+"},
 	}
 )
 
+func channelModes(defs []*ChannelModeDef) ChannelModes {
+	modes := make(ChannelModes, len(defs))
+	for index, def := range defs {
+		modes[index] = def.mode
+	}
+	return modes
+}
+
+func ChannelModeDefFor(mode ChannelMode) *ChannelModeDef {
+	for _, def := range ChannelModeDefs {
+		if def.mode == mode {
+			return def
+		}
+	}
+	return nil
+}
+
+// ChannelModesToken is the RPL_ISUPPORT CHANMODES value, e.g.
+// "beI,k,fl,imnt".
+func ChannelModesToken() string {
+	classes := make([]string, MemberMode)
+	for _, def := range ChannelModeDefs {
+		if def.kind < MemberMode {
+			classes[def.kind] += def.mode.String()
+		}
+	}
+	return strings.Join(classes, ",")
+}
+
+type MemberPrefix struct {
+	mode   ChannelMode
+	prefix string
+}
+
+// MemberModeRank orders the member modes: the higher the rank, the more a
+// member may do. Modes that aren't member modes have rank 0.
+func MemberModeRank(mode ChannelMode) uint {
+	return memberModeBit(mode).Rank()
+}
+
+// NewMemberPrefixes is DefaultMemberPrefixes with the configured prefixes
+// in place of the defaults.
+func NewMemberPrefixes(configured map[ChannelMode]string) []*MemberPrefix {
+	members := make([]*MemberPrefix, len(DefaultMemberPrefixes))
+	for index, member := range DefaultMemberPrefixes {
+		members[index] = &MemberPrefix{member.mode, member.prefix}
+		if prefix, ok := configured[member.mode]; ok {
+			members[index].prefix = prefix
+		}
+	}
+	return members
+}
+
+// MemberPrefixToken is the RPL_ISUPPORT PREFIX value, e.g. "(ov)@+".
+func MemberPrefixToken(members []*MemberPrefix) string {
+	modes, prefixes := "", ""
+	for _, member := range members {
+		modes += member.mode.String()
+		prefixes += member.prefix
+	}
+	return "(" + modes + ")" + prefixes
+}
+
 //
 // commands
 //
@@ -116,32 +295,34 @@ func (m *ModeCommand) HandleServer(s *Server) {
 	changes := make(ModeChanges, 0, len(m.changes))
 
 	for _, change := range m.changes {
-		switch change.mode {
-		case Invisible, ServerNotice, WallOps:
-			switch change.op {
-			case Add:
-				if target.flags[change.mode] {
-					continue
-				}
-				target.flags[change.mode] = true
-				changes = append(changes, change)
+		def := UserModeDefFor(change.mode)
+		if def == nil {
+			continue
+		}
 
-			case Remove:
-				if !target.flags[change.mode] {
-					continue
-				}
-				delete(target.flags, change.mode)
+		// +x changes the hostname too
+		if change.mode == HostCloak {
+			if ((change.op == Add) || (change.op == Remove)) &&
+				target.SetCloak(change.op == Add) {
 				changes = append(changes, change)
 			}
+			continue
+		}
+
+		switch change.op {
+		case Add:
+			if !def.canAdd || target.flags[change.mode] {
+				continue
+			}
+			target.flags[change.mode] = true
+			changes = append(changes, change)
 
-		case Operator, LocalOperator:
-			if change.op == Remove {
-				if !target.flags[change.mode] {
-					continue
-				}
-				delete(target.flags, change.mode)
-				changes = append(changes, change)
+		case Remove:
+			if !def.canRemove || !target.flags[change.mode] {
+				continue
 			}
+			delete(target.flags, change.mode)
+			changes = append(changes, change)
 		}
 	}
 
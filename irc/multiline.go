@@ -0,0 +1,279 @@
+package irc
+
+import (
+	"strings"
+)
+
+const (
+	MultilineBatch       = "draft/multiline"
+	MULTILINE_CONCAT_TAG = "draft/multiline-concat"
+)
+
+// MultilineLine is one line of a multiline message. A concat line
+// continues the previous one rather than starting a new line.
+type MultilineLine struct {
+	text   Text
+	concat bool
+}
+
+// MultilineMessage is a draft/multiline batch being received from a
+// client.
+type MultilineMessage struct {
+	id     string
+	target Name
+	code   StringCode
+	lines  []MultilineLine
+	bytes  int
+	tags   Tags // from the sender
+}
+
+func (message *MultilineMessage) Text() Text {
+	strs := make([]string, len(message.lines))
+	for index, line := range message.lines {
+		strs[index] = line.text.String()
+	}
+	return NewText(strings.Join(strs, "\n"))
+}
+
+// SetText replaces the lines' text after filtering, keeping their concat
+// flags. The text must have as many lines as the message.
+func (message *MultilineMessage) SetText(text Text) {
+	for index, str := range strings.Split(text.String(), "\n") {
+		if index < len(message.lines) {
+			message.lines[index].text = Text(str)
+		}
+	}
+}
+
+func (message *MultilineMessage) fallbackLines() []Text {
+	lines := make([]Text, 0, len(message.lines))
+	for _, line := range message.lines {
+		if line.concat && (len(lines) > 0) {
+			lines[len(lines)-1] += line.text
+			continue
+		}
+		lines = append(lines, line.text)
+	}
+	return lines
+}
+
+func RplMessage(code StringCode, source Identifiable, target Identifiable,
+	message Text) string {
+	if code == NOTICE {
+		return RplNotice(source, target, message)
+	}
+	return RplPrivMsg(source, target, message)
+}
+
+// Send sends the message to a client, as a batch if it supports
+// multiline or as separate messages if not.
+func (message *MultilineMessage) Send(to *Client, source Identifiable,
+	target Identifiable) {
+	if !to.capabilities[Multiline] || !to.capabilities[Batch] {
+		for _, line := range message.fallbackLines() {
+			to.Reply(TagReply(to.capabilities,
+				RplMessage(message.code, source, target, line), message.tags))
+		}
+		return
+	}
+	batch := to.startBatch(message.tags, MultilineBatch,
+		[]string{target.Nick().String()})
+	for _, line := range message.lines {
+		reply := RplMessage(message.code, source, target, line.text)
+		if line.concat {
+			reply = AddTags(reply, Tags{MULTILINE_CONCAT_TAG: ""})
+		}
+		to.Reply(reply)
+	}
+	to.EndBatch(batch)
+}
+
+// BATCH +<id> <type> [<params>...]
+// BATCH -<id>
+
+type BatchCommand struct {
+	BaseCommand
+	start  bool
+	id     string
+	kind   string
+	params []string
+}
+
+func ParseBatchCommand(args []string) (Command, error) {
+	if (len(args) < 1) || (len(args[0]) < 2) {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &BatchCommand{
+		start: args[0][0] == '+',
+		id:    args[0][1:],
+	}
+	if !cmd.start && (args[0][0] != '-') {
+		return nil, ErrParseCommand
+	}
+	if cmd.start {
+		if len(args) < 2 {
+			return nil, NotEnoughArgsError
+		}
+		cmd.kind = args[1]
+		cmd.params = args[2:]
+	}
+	return cmd, nil
+}
+
+func (msg *BatchCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	if !msg.start {
+		message := client.multiline
+		if (message == nil) || (message.id != msg.id) {
+			client.Reply(RplFail(server, BATCH, "MULTILINE_INVALID",
+				"No such batch"))
+			return
+		}
+		client.multiline = nil
+		if len(message.lines) == 0 {
+			client.Reply(RplFail(server, BATCH, "MULTILINE_INVALID",
+				"Empty batch"))
+			return
+		}
+		server.multilineMessage(client, message)
+		return
+	}
+
+	if msg.kind != MultilineBatch {
+		client.Reply(RplFail(server, BATCH, "UNKNOWN_TYPE",
+			"Unsupported batch type"))
+		return
+	}
+	if !client.capabilities[Multiline] || (len(msg.params) < 1) {
+		client.Reply(RplFail(server, BATCH, "MULTILINE_INVALID",
+			"Invalid multiline batch"))
+		return
+	}
+	client.multiline = &MultilineMessage{
+		id:     msg.id,
+		target: NewName(msg.params[0]),
+		tags:   msg.Tags(),
+	}
+}
+
+// addToMultiline keeps a PRIVMSG or NOTICE in a client's open multiline
+// batch. It returns false for messages that aren't in a batch.
+func (server *Server) addToMultiline(client *Client, code StringCode,
+	target Name, message Text, tags Tags) bool {
+	id, ok := tags[BATCH_TAG]
+	if !ok {
+		return false
+	}
+	multiline := client.multiline
+	fail := func(failCode string, description string) {
+		client.multiline = nil
+		client.Reply(RplFail(server, BATCH, failCode, description))
+	}
+	if (multiline == nil) || (multiline.id != id) {
+		fail("MULTILINE_INVALID", "No such batch")
+		return true
+	}
+	if target.ToLower() != multiline.target.ToLower() {
+		fail("MULTILINE_INVALID_TARGET", "Target doesn't match the batch")
+		return true
+	}
+	if (multiline.code != "") && (multiline.code != code) {
+		fail("MULTILINE_INVALID", "PRIVMSG and NOTICE can't be mixed")
+		return true
+	}
+	_, concat := tags[MULTILINE_CONCAT_TAG]
+	if concat && (message == "") {
+		fail("MULTILINE_INVALID", "A concat line can't be blank")
+		return true
+	}
+
+	multiline.code = code
+	multiline.bytes += len(message)
+	multiline.lines = append(multiline.lines, MultilineLine{message, concat})
+	if multiline.bytes > server.limits.MultilineBytes {
+		fail("MULTILINE_MAX_BYTES", "Too many bytes")
+	} else if len(multiline.lines) > server.limits.MultilineLines {
+		fail("MULTILINE_MAX_LINES", "Too many lines")
+	}
+	return true
+}
+
+// multilineMessage delivers a finished multiline batch with the checks
+// PRIVMSG and NOTICE make, applied to all of its lines together.
+func (server *Server) multilineMessage(client *Client, message *MultilineMessage) {
+	text := message.Text()
+	if !server.FilterMessage(client, message.code, message.target, text) ||
+		!server.hooks.Message(client, message.target, &text) {
+		return
+	}
+	message.SetText(text)
+	message.tags = client.MessageTags(message.tags)
+	quiet := message.code == NOTICE
+
+	if message.target.IsChannel() {
+		channel := server.channels.Get(message.target)
+		if channel == nil {
+			if !quiet {
+				client.ErrNoSuchChannel(message.target)
+			}
+			return
+		}
+		channel.Multiline(client, message)
+		return
+	}
+
+	target := server.clients.Get(message.target)
+	if target == nil {
+		if !quiet {
+			client.ErrNoSuchNick(message.target)
+		}
+		return
+	}
+	if target.IsSilencing(client) || !target.CanMessage(client, !quiet) {
+		return
+	}
+	message.Send(target, client, target)
+	if client.capabilities[EchoMessage] {
+		message.Send(client, client, target)
+	}
+	if !quiet && target.flags[Away] {
+		client.RplAway(target)
+	}
+}
+
+func (channel *Channel) Multiline(client *Client, message *MultilineMessage) {
+	quiet := message.code == NOTICE
+	if !channel.CanSpeak(client) {
+		if !quiet {
+			client.ErrCannotSendToChan(channel)
+		}
+		return
+	}
+	text, err := channel.Filter(client, message.Text())
+	if err != nil {
+		if slow, ok := err.(*SlowModeError); ok && !quiet {
+			client.ErrSlowMode(channel, slow.wait)
+		} else if !quiet {
+			client.ErrCannotSendToChan(channel)
+		}
+		return
+	}
+	message.SetText(text)
+
+	for member := range channel.members {
+		if (member == client) || member.IsSilencing(client) {
+			continue
+		}
+		message.Send(member, client, channel)
+	}
+	if client.capabilities[EchoMessage] {
+		message.Send(client, client, channel)
+	}
+	for _, line := range message.fallbackLines() {
+		channel.Record(RplMessage(message.code, client, channel, line),
+			message.tags)
+		channel.StreamMessage(client, client, message.code, line)
+		channel.LogMessage(client, message.code, line)
+	}
+}
@@ -5,6 +5,18 @@ import (
 	"strings"
 )
 
+// StringAddr is a net.Addr for an address known only as a string, such as
+// an http.Request's RemoteAddr.
+type StringAddr string
+
+func (addr StringAddr) Network() string {
+	return "tcp"
+}
+
+func (addr StringAddr) String() string {
+	return string(addr)
+}
+
 func IPString(addr net.Addr) Name {
 	addrStr := addr.String()
 	ipaddr, _, err := net.SplitHostPort(addrStr)
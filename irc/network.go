@@ -0,0 +1,86 @@
+package irc
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// NetworkConfig describes the network the server is part of, as opposed
+// to the server itself.
+type NetworkConfig struct {
+	// defaults to the server name
+	Name string
+	// may be several lines
+	Description string
+	// contacts listed by ADMIN, e.g. "nick <nick@example.com>"
+	Admins []string
+}
+
+func (conf *NetworkConfig) validate(serverName string) error {
+	if conf.Name == "" {
+		conf.Name = serverName
+	}
+	if strings.ContainsAny(conf.Name, " ,:") {
+		return fmt.Errorf("Network name can't contain spaces, commas or colons: %s",
+			conf.Name)
+	}
+	return nil
+}
+
+// DescriptionLines is the network description split into lines, without
+// blank lines at either end.
+func (conf *NetworkConfig) DescriptionLines() []string {
+	description := strings.Trim(conf.Description, "\r\n")
+	if description == "" {
+		return nil
+	}
+	return strings.Split(strings.Replace(description, "\r\n", "\n", -1), "\n")
+}
+
+//
+// server goroutine
+//
+
+// RplNetwork ends the registration burst with the network description.
+func (server *Server) RplNetwork(client *Client) {
+	for _, line := range server.network.DescriptionLines() {
+		client.Reply(RplNotice(server, client, NewText(line)))
+	}
+}
+
+//
+// websocket listen goroutine
+//
+
+// landingPage is served on websocket listeners to browsers that just visit
+// the address instead of opening a WebSocket.
+func (server *Server) landingPage(w http.ResponseWriter, r *http.Request) bool {
+	if websocket.IsWebSocketUpgrade(r) {
+		return false
+	}
+	network := server.network
+	page := []string{
+		"<!DOCTYPE html>",
+		"<html>",
+		"<head>",
+		`<meta charset="utf-8">`,
+		"<title>" + html.EscapeString(network.Name) + "</title>",
+		"</head>",
+		"<body>",
+		"<h1>" + html.EscapeString(network.Name) + "</h1>",
+	}
+	for _, line := range network.DescriptionLines() {
+		page = append(page, "<p>"+html.EscapeString(line)+"</p>")
+	}
+	page = append(page, fmt.Sprintf(
+		"<p>This is a WebSocket IRC server, %s, running ergonomadic %s.</p>",
+		html.EscapeString(server.name.String()), SEM_VER))
+	page = append(page, "</body>", "</html>", "")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(strings.Join(page, "\n")))
+	return true
+}
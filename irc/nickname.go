@@ -1,5 +1,19 @@
 package irc
 
+import (
+	"time"
+)
+
+// NickConfig limits how often clients may change their nicks, against
+// nick floods, and how many of each client's previous nicks opers see in
+// WHOIS.
+type NickConfig struct {
+	// changes each client may make per period; 0 for no limit
+	ChangeLimit  int           `yaml:"change-limit"`
+	ChangePeriod time.Duration `yaml:"change-period"`
+	History      int
+}
+
 type NickCommand struct {
 	BaseCommand
 	nickname Name
@@ -13,7 +27,7 @@ func (m *NickCommand) HandleRegServer(s *Server) {
 		return
 	}
 
-	if client.capState == CapNegotiating {
+	if (client.capState == CapNegotiating) && !client.capabilities[SASL] {
 		client.capState = CapNegotiated
 	}
 
@@ -22,12 +36,12 @@ func (m *NickCommand) HandleRegServer(s *Server) {
 		return
 	}
 
-	if s.clients.Get(m.nickname) != nil {
+	if (s.clients.Get(m.nickname) != nil) || s.IsConfusable(client, m.nickname) {
 		client.ErrNickNameInUse(m.nickname)
 		return
 	}
 
-	if !m.nickname.IsNickname() {
+	if !s.IsNickname(m.nickname) {
 		client.ErrErroneusNickname(m.nickname)
 		return
 	}
@@ -44,7 +58,7 @@ func (msg *NickCommand) HandleServer(server *Server) {
 		return
 	}
 
-	if !msg.nickname.IsNickname() {
+	if !server.IsNickname(msg.nickname) {
 		client.ErrErroneusNickname(msg.nickname)
 		return
 	}
@@ -54,12 +68,55 @@ func (msg *NickCommand) HandleServer(server *Server) {
 	}
 
 	target := server.clients.Get(msg.nickname)
-	if (target != nil) && (target != client) {
+	if ((target != nil) && (target != client)) ||
+		server.IsConfusable(client, msg.nickname) {
 		client.ErrNickNameInUse(msg.nickname)
 		return
 	}
 
+	if client.registered && !server.hooks.Nick(client, msg.nickname) {
+		return
+	}
+
+	if wait := client.nickChangeWait(); wait > 0 {
+		client.ErrNickTooFast(msg.nickname, wait)
+		return
+	}
+
 	client.ChangeNickname(msg.nickname)
+	server.CheckNickOwner(client)
+}
+
+// nickChangeWait counts a nick change by client, unless it has made too
+// many this period; then it is how long until it may change again.
+// Operators aren't limited.
+func (client *Client) nickChangeWait() time.Duration {
+	config := &client.server.nicks
+	if (config.ChangeLimit <= 0) || client.flags[Operator] {
+		return 0
+	}
+	now := time.Now()
+	if now.Sub(client.nickStart) > config.ChangePeriod {
+		client.nickStart = now
+		client.nickCount = 0
+	}
+	if client.nickCount >= config.ChangeLimit {
+		return config.ChangePeriod - now.Sub(client.nickStart)
+	}
+	client.nickCount += 1
+	return 0
+}
+
+// rememberNick keeps nick as one of client's previous nicks.
+func (client *Client) rememberNick(nick Name) {
+	limit := client.server.nicks.History
+	if limit <= 0 {
+		return
+	}
+	client.nickHistory = append(client.nickHistory, nick)
+	if len(client.nickHistory) > limit {
+		client.nickHistory = client.nickHistory[len(client.nickHistory)-limit:]
+	}
 }
 
 type OperNickCommand struct {
@@ -76,7 +133,7 @@ func (msg *OperNickCommand) HandleServer(server *Server) {
 		return
 	}
 
-	if !msg.nick.IsNickname() {
+	if !server.IsNickname(msg.nick) {
 		client.ErrErroneusNickname(msg.nick)
 		return
 	}
@@ -91,10 +148,12 @@ func (msg *OperNickCommand) HandleServer(server *Server) {
 		return
 	}
 
-	if server.clients.Get(msg.nick) != nil {
+	if (server.clients.Get(msg.nick) != nil) || server.IsConfusable(target, msg.nick) {
 		client.ErrNickNameInUse(msg.nick)
 		return
 	}
 
+	server.Audit(client, ONICK, target.nick.String(), msg.nick.String())
 	target.ChangeNickname(msg.nick)
+	server.CheckNickOwner(target)
 }
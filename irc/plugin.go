@@ -0,0 +1,160 @@
+package irc
+
+import (
+	"fmt"
+	"plugin"
+	"strings"
+)
+
+// Plugins are Go plugins (built with -buildmode=plugin against this
+// package) that export
+//
+//   func Register(api *irc.PluginAPI)
+//
+// Register adds hooks and commands. Hooks run in the server goroutine, in
+// the order they were added, and must not block; a hook returning false
+// stops the connection, message, join or nick change.
+
+type ConnectHook func(client *Client) bool
+type MessageHook func(client *Client, target Name, message *Text) bool
+type JoinHook func(client *Client, channel *Channel) bool
+type NickHook func(client *Client, nick Name) bool
+
+// PluginCommandFunc handles a command added by a plugin. Registered
+// clients only.
+type PluginCommandFunc func(client *Client, args []string)
+
+type PluginHooks struct {
+	connect  []ConnectHook
+	message  []MessageHook
+	join     []JoinHook
+	nick     []NickHook
+	commands map[StringCode]PluginCommandFunc
+}
+
+func NewPluginHooks() *PluginHooks {
+	return &PluginHooks{
+		commands: make(map[StringCode]PluginCommandFunc),
+	}
+}
+
+// PluginAPI is what a plugin's Register is given.
+type PluginAPI struct {
+	server *Server
+	hooks  *PluginHooks
+}
+
+func (api *PluginAPI) OnConnect(hook ConnectHook) {
+	api.hooks.connect = append(api.hooks.connect, hook)
+}
+
+func (api *PluginAPI) OnMessage(hook MessageHook) {
+	api.hooks.message = append(api.hooks.message, hook)
+}
+
+func (api *PluginAPI) OnJoin(hook JoinHook) {
+	api.hooks.join = append(api.hooks.join, hook)
+}
+
+func (api *PluginAPI) OnNick(hook NickHook) {
+	api.hooks.nick = append(api.hooks.nick, hook)
+}
+
+// Command adds a command. It can't replace a built-in one.
+func (api *PluginAPI) Command(name string, handler PluginCommandFunc) {
+	code := StringCode(strings.ToUpper(name))
+	if parseCommandFuncs[code] != nil {
+		Log.error.Printf("plugin command %s is built in", code)
+		return
+	}
+	api.hooks.commands[code] = handler
+}
+
+// Notice sends a server notice to the client.
+func (api *PluginAPI) Notice(client *Client, message string) {
+	client.Reply(RplNotice(api.server, client, NewText(message)))
+}
+
+// Message sends a PRIVMSG from the server to the client.
+func (api *PluginAPI) Message(client *Client, message string) {
+	api.server.Reply(client, message)
+}
+
+func (api *PluginAPI) Client(nick string) *Client {
+	return api.server.clients.Get(NewName(nick))
+}
+
+func (api *PluginAPI) Channel(name string) *Channel {
+	return api.server.channels.Get(NewName(name))
+}
+
+func (server *Server) loadPlugins(paths []string) error {
+	api := &PluginAPI{server, server.hooks}
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return err
+		}
+		symbol, err := p.Lookup("Register")
+		if err != nil {
+			return err
+		}
+		register, ok := symbol.(func(*PluginAPI))
+		if !ok {
+			return fmt.Errorf("%s: Register must be a func(*irc.PluginAPI)", path)
+		}
+		register(api)
+		Log.info.Printf("%s loaded plugin %s", server, path)
+	}
+	return nil
+}
+
+//
+// server goroutine
+//
+
+func (hooks *PluginHooks) Connect(client *Client) bool {
+	for _, hook := range hooks.connect {
+		if !hook(client) {
+			return false
+		}
+	}
+	return true
+}
+
+func (hooks *PluginHooks) Message(client *Client, target Name, message *Text) bool {
+	for _, hook := range hooks.message {
+		if !hook(client, target, message) {
+			return false
+		}
+	}
+	return true
+}
+
+func (hooks *PluginHooks) Join(client *Client, channel *Channel) bool {
+	for _, hook := range hooks.join {
+		if !hook(client, channel) {
+			return false
+		}
+	}
+	return true
+}
+
+func (hooks *PluginHooks) Nick(client *Client, nick Name) bool {
+	for _, hook := range hooks.nick {
+		if !hook(client, nick) {
+			return false
+		}
+	}
+	return true
+}
+
+func (msg *UnknownCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	handler := server.hooks.commands[msg.Code()]
+	if handler == nil {
+		client.ErrUnknownCommand(msg.Code())
+		return
+	}
+	handler(client, msg.args)
+}
@@ -0,0 +1,67 @@
+package irc
+
+import (
+	"net/url"
+)
+
+// An account's profile is two METADATA keys, an avatar URL and a display
+// name, that web clients show beside its messages. They're sent as tags
+// on everything the client says, so message-tags clients needn't
+// subscribe, and only clients logged in to an account may set them.
+
+const (
+	METADATA_AVATAR       = "avatar"
+	METADATA_DISPLAY_NAME = "display-name"
+
+	AVATAR_TAG       = "draft/avatar"
+	DISPLAY_NAME_TAG = "draft/display-name"
+)
+
+var (
+	profileTags = map[string]string{
+		METADATA_AVATAR:       AVATAR_TAG,
+		METADATA_DISPLAY_NAME: DISPLAY_NAME_TAG,
+	}
+)
+
+func IsProfileKey(key string) bool {
+	_, ok := profileTags[key]
+	return ok
+}
+
+// checkProfileValue tells client if it can't set a profile key on target.
+func (client *Client) checkProfileValue(target metadataTarget, key string,
+	value *Text) bool {
+	if (target.channel != nil) || !IsProfileKey(key) {
+		return true
+	}
+	if target.client.account == "" {
+		client.Reply(RplFail(client.server, METADATA, "KEY_NO_PERMISSION "+
+			target.Name().String()+" "+key, "Log in to an account first"))
+		return false
+	}
+	if (value != nil) && (key == METADATA_AVATAR) && !IsAvatarURL(*value) {
+		client.Reply(RplFail(client.server, METADATA, "VALUE_INVALID",
+			"Avatar must be an http or https URL"))
+		return false
+	}
+	return true
+}
+
+func IsAvatarURL(value Text) bool {
+	avatar, err := url.Parse(value.String())
+	return (err == nil) && (avatar.Host != "") &&
+		((avatar.Scheme == "http") || (avatar.Scheme == "https"))
+}
+
+// addProfileTags tags a message from client with its account's profile.
+func (client *Client) addProfileTags(tags Tags) {
+	if client.account == "" {
+		return
+	}
+	for key, tag := range profileTags {
+		if value, ok := client.metadata[key]; ok {
+			tags[tag] = value
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Pseudo-clients are clients run by the server itself, for built-in
+// services like NickServ. They show up in WHOIS and WHO like anyone else,
+// but have no connection: a PRIVMSG to one is turned into the command it
+// stands for, so PRIVMSG NickServ :IDENTIFY bob hunter2 is handled as
+// NICKSERV IDENTIFY bob hunter2, and anything else goes to its handler.
+// They can't be killed and their nicks can't be taken.
+
+// ServiceHandler handles a message to a pseudo-client that isn't one of
+// its commands.
+type ServiceHandler func(server *Server, client *Client, service *Client,
+	message Text)
+
+type Service struct {
+	command StringCode // what PRIVMSGs stand for; empty for none
+	handler ServiceHandler
+}
+
+// NewService adds a pseudo-client to the server. It must be called before
+// the server starts listening; the services are read by every client's
+// command goroutine.
+func (server *Server) NewService(nick Name, realname Text, command StringCode,
+	handler ServiceHandler) *Client {
+	now := time.Now()
+	client := &Client{
+		atime:        now,
+		accepted:     make(ClientSet),
+		authorized:   true,
+		capabilities: make(CapabilitySet),
+		channels:     make(ChannelSet),
+		class:        &ConnectionClass{name: "services"},
+		ctime:        now,
+		flags:        map[UserMode]bool{Bot: true},
+		hostname:     server.name,
+		invitedTo:    make(ChannelSet),
+		listener:     &Listener{},
+		nick:         nick,
+		realname:     realname,
+		registered:   true,
+		server:       server,
+		service: &Service{
+			command: command,
+			handler: handler,
+		},
+		silence:  NewUserMaskSet(),
+		typing:   make(map[Name]time.Time),
+		username: NewName(strings.ToLower(nick.String())),
+	}
+	client.updateUserHost()
+	server.services[nick.ToLower()] = client
+	server.clients.Add(client)
+	return client
+}
+
+// addServices adds the built-in pseudo-clients.
+func (server *Server) addServices() {
+	server.NewService("NickServ", "Nickname services", NICKSERV, nil)
+	server.NewService("HostServ", "Vhost services", HOSTSERV, nil)
+	server.NewService("MemoServ", "Memo services", MEMOSERV, nil)
+	server.NewService("Global", "Network announcements", GLOBALNOTICE, nil)
+}
+
+// IsService reports whether client is a pseudo-client.
+func (client *Client) IsService() bool {
+	return client.service != nil
+}
+
+// RplServiceNotice is a notice to client from a pseudo-client.
+func (service *Client) RplServiceNotice(client *Client, message string) {
+	client.Reply(RplNotice(service, client, NewText(message)))
+}
+
+//
+// command goroutine
+//
+
+// ServiceCommand turns a PRIVMSG to a pseudo-client into the command it
+// stands for, so that commands with passwords are still checked outside
+// the server goroutine. Anything else is returned as is.
+func (server *Server) ServiceCommand(command Command) Command {
+	msg, ok := command.(*PrivMsgCommand)
+	if !ok || msg.target.IsChannel() {
+		return command
+	}
+	service := server.services[msg.target.ToLower()]
+	if (service == nil) || (service.service.command == "") {
+		return command
+	}
+	routed, err := ParseCommand(
+		service.service.command.String() + " " + msg.message.String())
+	if (err != nil) || (routed == nil) {
+		return command
+	}
+	routed.SetTags(msg.Tags())
+	return routed
+}
+
+//
+// server goroutine
+//
+
+// ServiceMessage handles a PRIVMSG to a pseudo-client that wasn't one of
+// its commands.
+func (server *Server) ServiceMessage(client *Client, service *Client, message Text) {
+	if service.service.handler != nil {
+		service.service.handler(server, client, service, message)
+		return
+	}
+	service.RplServiceNotice(client, fmt.Sprintf("Unknown command: %s", message))
+}
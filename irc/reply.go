@@ -1,7 +1,10 @@
 package irc
 
 import (
+	"crypto/tls"
 	"fmt"
+	"runtime"
+	"sort"
 	"strings"
 	"time"
 )
@@ -28,7 +31,7 @@ func NewStringReply(source Identifiable, code StringCode,
 	if source == nil {
 		header = code.String() + " "
 	} else {
-		header = fmt.Sprintf(":%s %s ", source, code)
+		header = ":" + source.Id().String() + " " + code.String() + " "
 	}
 	var message string
 	if len(args) > 0 {
@@ -53,7 +56,10 @@ func NewNumericReply(target *Client, code NumericCode,
 
 func (target *Client) NumericReply(code NumericCode,
 	format string, args ...interface{}) {
-	target.Reply(NewNumericReply(target, code, format, args...))
+	if code.IsError() {
+		target.errorReplies += 1
+	}
+	target.Reply(NewNumericReply(target, code, target.T(format), args...))
 }
 
 //
@@ -91,6 +97,40 @@ func (target *Client) MultilineReply(names []string, code NumericCode, format st
 	}
 }
 
+//
+// broadcast replies
+//
+
+// ReplyRenderer formats a message for a recipient with the given
+// capabilities. It must not depend on anything else about the recipient,
+// so that its result can be shared.
+type ReplyRenderer func(CapabilitySet) string
+
+// ReplyCache renders a broadcast message once per distinct combination
+// of RenderCapabilities among its recipients, instead of once per
+// recipient.
+type ReplyCache struct {
+	render  ReplyRenderer
+	replies map[string]string
+}
+
+func NewReplyCache(render ReplyRenderer) *ReplyCache {
+	return &ReplyCache{
+		render:  render,
+		replies: make(map[string]string),
+	}
+}
+
+func (cache *ReplyCache) For(target *Client) string {
+	key := target.capabilities.RenderKey()
+	reply, ok := cache.replies[key]
+	if !ok {
+		reply = cache.render(target.capabilities)
+		cache.replies[key] = reply
+	}
+	return reply
+}
+
 //
 // messaging replies
 //
@@ -160,6 +200,12 @@ func RplQuit(client *Client, message Text) string {
 	return NewStringReply(client, QUIT, ":%s", message)
 }
 
+// RplFail is an IRCv3 standard reply.
+func RplFail(server *Server, code StringCode, failCode string,
+	description string) string {
+	return NewStringReply(server, FAIL, "%s %s :%s", code, failCode, description)
+}
+
 func RplError(message string) string {
 	return NewStringReply(nil, ERROR, ":%s", message)
 }
@@ -168,16 +214,65 @@ func RplInviteMsg(inviter *Client, invitee *Client, channel Name) string {
 	return NewStringReply(inviter, INVITE, "%s :%s", invitee.Nick(), channel)
 }
 
+func RplChgHost(client *Client, username Name, hostname Name) string {
+	return NewStringReply(client, CHGHOST, "%s %s", username, hostname)
+}
+
+// RplMetadata tells a subscriber that key changed on target, or was
+// removed if value is nil.
+func RplMetadata(server *Server, target Name, key string, value *Text) string {
+	if value == nil {
+		return NewStringReply(server, METADATA, "%s %s %s", target, key,
+			METADATA_VISIBILITY)
+	}
+	return NewStringReply(server, METADATA, "%s %s %s :%s", target, key,
+		METADATA_VISIBILITY, *value)
+}
+
+// RplTagMsg is a TAGMSG without its tags, which only message-tags clients
+// can be sent.
+func RplTagMsg(source Identifiable, target Identifiable) string {
+	return NewStringReply(source, TAGMSG, target.Nick().String())
+}
+
+func RplWallops(source Identifiable, message Text) string {
+	return NewStringReply(source, WALLOPS, ":%s", message)
+}
+
+func RplSvsLogin(server *Server, client *Client) string {
+	account := client.account.String()
+	if account == "" {
+		account = "*"
+	}
+	return NewStringReply(server, SVSLOGIN, "%s %s", client.nick, account)
+}
+
 func RplKick(channel *Channel, client *Client, target *Client, comment Text) string {
 	return NewStringReply(client, KICK, "%s %s :%s",
 		channel, target.Nick(), comment)
 }
 
-func RplKill(client *Client, target *Client, comment Text) string {
-	return NewStringReply(client, KICK,
+func RplKill(source Identifiable, target *Client, comment Text) string {
+	return NewStringReply(source, KILL,
 		"%s :%s", target.Nick(), comment)
 }
 
+func RplSilence(client *Client, change SilenceChange) string {
+	return NewStringReply(client, SILENCE, "%s%s", change.op, change.mask)
+}
+
+// RplAwayNotify is the away-notify message for client's current status.
+func RplAwayNotify(client *Client) string {
+	if client.flags[Away] {
+		return NewStringReply(client, AWAY, ":%s", client.awayMessage)
+	}
+	return fmt.Sprintf(":%s %s", client, AWAY)
+}
+
+func RplAuthenticate(data string) string {
+	return NewStringReply(nil, AUTHENTICATE, data)
+}
+
 func RplCap(client *Client, subCommand CapSubCommand, arg interface{}) string {
 	return NewStringReply(nil, CAP, "%s %s :%s", client.Nick(), subCommand, arg)
 }
@@ -186,7 +281,8 @@ func RplCap(client *Client, subCommand CapSubCommand, arg interface{}) string {
 
 func (target *Client) RplWelcome() {
 	target.NumericReply(RPL_WELCOME,
-		":Welcome to the Internet Relay Network %s", target.Id())
+		":Welcome to the %s Internet Relay Network %s",
+		target.server.network.Name, target.Id())
 }
 
 func (target *Client) RplYourHost() {
@@ -205,6 +301,19 @@ func (target *Client) RplMyInfo() {
 		target.server.name, SEM_VER, SupportedUserModes, SupportedChannelModes)
 }
 
+// <token> *( " " <token> ) :are supported by this server
+func (target *Client) RplISupport(tokens []string) {
+	for len(tokens) > 0 {
+		count := len(tokens)
+		if count > MAX_ISUPPORT_TOKENS {
+			count = MAX_ISUPPORT_TOKENS
+		}
+		target.NumericReply(RPL_ISUPPORT,
+			"%s :are supported by this server", strings.Join(tokens[:count], " "))
+		tokens = tokens[count:]
+	}
+}
+
 func (target *Client) RplUModeIs(client *Client) {
 	target.NumericReply(RPL_UMODEIS, client.ModeString())
 }
@@ -219,6 +328,104 @@ func (target *Client) RplTopic(channel *Channel) {
 		"%s :%s", channel.name, channel.topic)
 }
 
+// <channel> <nick> <setat>
+func (target *Client) RplTopicWhoTime(channel *Channel) {
+	target.NumericReply(RPL_TOPICWHOTIME,
+		"%s %s %d", channel.name, channel.topicSetter, channel.topicUnix())
+}
+
+// <nick> <mask>
+func (target *Client) RplSilenceList(mask Name) {
+	target.NumericReply(RPL_SILELIST,
+		"%s %s", target.Nick(), mask)
+}
+
+func (target *Client) RplEndOfSilenceList() {
+	target.NumericReply(RPL_ENDOFSILELIST,
+		":End of Silence List")
+}
+
+func (target *Client) RplAcceptList(nicks []string) {
+	target.MultilineReply(nicks, RPL_ACCEPTLIST, "%s")
+}
+
+func (target *Client) RplEndOfAccept() {
+	target.NumericReply(RPL_ENDOFACCEPT,
+		":End of /ACCEPT list")
+}
+
+func (target *Client) RplTargNotify(client *Client) {
+	target.NumericReply(RPL_TARGNOTIFY,
+		"%s :has been informed that you messaged them.", client.Nick())
+}
+
+func (target *Client) RplUModeGMsg(client *Client) {
+	target.NumericReply(RPL_UMODEGMSG,
+		"%s %s@%s :is messaging you, and you have umode +g.", client.Nick(),
+		client.username, client.hostname)
+}
+
+func (target *Client) RplLoggedIn() {
+	target.NumericReply(RPL_LOGGEDIN,
+		"%s %s :You are now logged in as %s", target.Nick(), target.UserHost(),
+		target.account)
+}
+
+func (target *Client) RplLoggedOut() {
+	target.NumericReply(RPL_LOGGEDOUT,
+		"%s %s :You are now logged out", target.Nick(), target.UserHost())
+}
+
+func (target *Client) RplSaslSuccess() {
+	target.NumericReply(RPL_SASLSUCCESS,
+		":SASL authentication successful")
+}
+
+func (target *Client) RplYourLanguagesAre(code string) {
+	target.NumericReply(RPL_YOURLANGUAGESARE,
+		"%s :Language preferences are set", code)
+}
+
+func (target *Client) ErrNoLanguage(code string) {
+	target.NumericReply(ERR_NOLANGUAGE,
+		"%s :There is no translation for this language", code)
+}
+
+func (target *Client) RplKeyValue(name Name, key string, value string) {
+	target.NumericReply(RPL_KEYVALUE,
+		"%s %s %s :%s", name, key, METADATA_VISIBILITY, value)
+}
+
+func (target *Client) RplKeyNotSet(name Name, key string) {
+	target.NumericReply(RPL_KEYNOTSET,
+		"%s %s :key not set", name, key)
+}
+
+func (target *Client) RplMetadataEnd() {
+	target.NumericReply(RPL_METADATAEND,
+		":end of metadata")
+}
+
+func (target *Client) RplMetadataSubOK(keys []string) {
+	target.NumericReply(RPL_METADATASUBOK,
+		":%s", strings.Join(keys, " "))
+}
+
+func (target *Client) RplMetadataUnsubOK(keys []string) {
+	target.NumericReply(RPL_METADATAUNSUBOK,
+		":%s", strings.Join(keys, " "))
+}
+
+func (target *Client) RplMetadataSubs(keys []string) {
+	target.NumericReply(RPL_METADATASUBS,
+		":%s", strings.Join(keys, " "))
+}
+
+func (target *Client) RplSaslMechs(mechanisms string) {
+	target.NumericReply(RPL_SASLMECHS,
+		"%s :are available SASL mechanisms", mechanisms)
+}
+
 // <nick> <channel>
 // NB: correction in errata
 func (target *Client) RplInvitingMsg(invitee *Client, channel Name) {
@@ -239,12 +446,51 @@ func (target *Client) RplYoureOper() {
 
 func (target *Client) RplWhois(client *Client) {
 	target.RplWhoisUser(client)
+	target.RplWhoisChannels(client)
+	target.RplWhoisServer(client)
+	if client.flags[Away] {
+		target.RplAway(client)
+		target.RplWhoisSpecial(client, "has been away since "+
+			client.awayTime.UTC().Format(time.RFC1123))
+	}
 	if client.flags[Operator] {
 		target.RplWhoisOperator(client)
 	}
+	if client.account != "" {
+		target.RplWhoisAccount(client)
+	}
+	if client.flags[Bot] {
+		target.RplWhoisBot(client)
+	}
+	if target.capabilities[Metadata] {
+		for _, key := range client.metadata.Keys() {
+			if target.metadataSubs[key] {
+				target.RplWhoisKeyValue(client, key, client.metadata[key])
+			}
+		}
+	}
+	if target.flags[Operator] || (target == client) {
+		target.RplWhoisActually(client)
+	}
+	if target.flags[Operator] && (client.dnsbl != nil) {
+		target.RplWhoisSpecial(client, fmt.Sprintf("is listed in %s", client.dnsbl))
+	}
+	if target.flags[Operator] && (len(client.nickHistory) > 0) {
+		nicks := make([]string, len(client.nickHistory))
+		for index, nick := range client.nickHistory {
+			nicks[index] = nick.String()
+		}
+		target.RplWhoisSpecial(client, "was previously "+
+			strings.Join(nicks, ", "))
+	}
+	if target.flags[Operator] && (client.country != "") {
+		target.RplWhoisSpecial(client, fmt.Sprintf("is connecting from %s", client.country))
+	}
+	if target.flags[Operator] && !client.IsService() {
+		target.RplWhoisConnection(client)
+	}
 	target.RplWhoisIdle(client)
-	target.RplWhoisChannels(client)
-	target.RplEndOfWhois()
+	target.RplEndOfWhois(client)
 }
 
 func (target *Client) RplWhoisUser(client *Client) {
@@ -253,6 +499,69 @@ func (target *Client) RplWhoisUser(client *Client) {
 		client.realname)
 }
 
+func (target *Client) RplWhoisServer(client *Client) {
+	target.NumericReply(RPL_WHOISSERVER,
+		"%s %s :%s", client.Nick(), client.server.name, client.server.name)
+}
+
+func (target *Client) RplWhoisAccount(client *Client) {
+	target.NumericReply(RPL_WHOISACCOUNT,
+		"%s %s :is logged in as", client.Nick(), client.account)
+}
+
+func (target *Client) RplWhoisSpecial(client *Client, text string) {
+	target.NumericReply(RPL_WHOISSPECIAL,
+		"%s :%s", client.Nick(), text)
+}
+
+func (target *Client) RplWhoisBot(client *Client) {
+	target.NumericReply(RPL_WHOISBOT,
+		"%s :is a bot", client.Nick())
+}
+
+func (target *Client) RplWhoisKeyValue(client *Client, key string,
+	value string) {
+	target.NumericReply(RPL_WHOISKEYVALUE,
+		"%s %s %s :%s", client.Nick(), key, METADATA_VISIBILITY, value)
+}
+
+func (target *Client) RplWhoisActually(client *Client) {
+	target.NumericReply(RPL_WHOISACTUALLY,
+		"%s %s@%s %s :actually using host", client.Nick(), client.username,
+		client.hostname, client.IP())
+}
+
+// RplWhoisConnection tells operators how client is connected.
+func (target *Client) RplWhoisConnection(client *Client) {
+	listener := client.listener
+	if port := client.Port(); port != "" {
+		target.RplWhoisSpecial(client, fmt.Sprintf(
+			"is connected from %s port %s to %s (%s)", client.IP(), port,
+			listener.name, listener))
+	} else {
+		target.RplWhoisSpecial(client, fmt.Sprintf("is connected to %s (%s)",
+			listener.name, listener))
+	}
+
+	if state := client.TLSState(); state != nil {
+		target.RplWhoisSpecial(client, fmt.Sprintf("is using %s with %s",
+			tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)))
+	} else {
+		target.RplWhoisSpecial(client, "is not using TLS")
+	}
+	if certfp := client.CertFP(); certfp != "" {
+		target.RplWhoisSpecial(client, fmt.Sprintf(
+			"has client certificate fingerprint %s", certfp))
+	}
+
+	if len(client.capabilities) > 0 {
+		caps := strings.Split(client.capabilities.String(), " ")
+		sort.Strings(caps)
+		target.RplWhoisSpecial(client, fmt.Sprintf("has capabilities %s",
+			strings.Join(caps, " ")))
+	}
+}
+
 func (target *Client) RplWhoisOperator(client *Client) {
 	target.NumericReply(RPL_WHOISOPERATOR,
 		"%s :is an IRC operator", client.Nick())
@@ -264,9 +573,9 @@ func (target *Client) RplWhoisIdle(client *Client) {
 		client.Nick(), client.IdleSeconds(), client.SignonTime())
 }
 
-func (target *Client) RplEndOfWhois() {
+func (target *Client) RplEndOfWhois(client *Client) {
 	target.NumericReply(RPL_ENDOFWHOIS,
-		":End of WHOIS list")
+		"%s :End of WHOIS list", client.Nick())
 }
 
 func (target *Client) RplChannelModeIs(channel *Channel) {
@@ -276,8 +585,7 @@ func (target *Client) RplChannelModeIs(channel *Channel) {
 
 // <channel> <user> <host> <server> <nick> ( "H" / "G" ) ["*"] [ ( "@" / "+" ) ]
 // :<hopcount> <real name>
-func (target *Client) RplWhoReply(channel *Channel, client *Client) {
-	channelName := "*"
+func (target *Client) whoFlags(channel *Channel, client *Client) string {
 	flags := ""
 
 	if client.flags[Away] {
@@ -289,26 +597,78 @@ func (target *Client) RplWhoReply(channel *Channel, client *Client) {
 		flags += "*"
 	}
 
+	if channel != nil {
+		flags += channel.members[client].Prefixes(target.server.memberPrefixes,
+			target.capabilities[MultiPrefix])
+	}
+	return flags
+}
+
+func (target *Client) RplWhoReply(channel *Channel, client *Client) {
+	channelName := "*"
 	if channel != nil {
 		channelName = channel.name.String()
-		if target.capabilities[MultiPrefix] {
-			if channel.members[client][ChannelOperator] {
-				flags += "@"
+	}
+	target.NumericReply(RPL_WHOREPLY,
+		"%s %s %s %s %s %s :%d %s", channelName, client.username, client.hostname,
+		client.server.name, client.Nick(), target.whoFlags(channel, client),
+		client.hops, client.realname)
+}
+
+// RplWhoSpcRpl is the WHOX reply, with the requested fields in their
+// canonical order and the realname, if any, last.
+func (target *Client) RplWhoSpcRpl(channel *Channel, client *Client,
+	fields WhoFields, token string) {
+	params := make([]string, 0, len(WhoFieldOrder))
+	for _, field := range WhoFieldOrder {
+		if !fields[field] {
+			continue
+		}
+		var param string
+		switch field {
+		case WhoToken:
+			param = token
+			if param == "" {
+				param = "0"
 			}
-			if channel.members[client][Voice] {
-				flags += "+"
+		case WhoChannel:
+			param = "*"
+			if channel != nil {
+				param = channel.name.String()
 			}
-		} else {
-			if channel.members[client][ChannelOperator] {
-				flags += "@"
-			} else if channel.members[client][Voice] {
-				flags += "+"
+		case WhoUser:
+			param = client.username.String()
+		case WhoIP:
+			// only opers and the client itself see the real address
+			param = "255.255.255.255"
+			if target.flags[Operator] || (target == client) {
+				param = client.IP()
+			}
+		case WhoHost:
+			param = client.hostname.String()
+		case WhoServer:
+			param = client.server.name.String()
+		case WhoNick:
+			param = client.Nick().String()
+		case WhoFlags:
+			param = target.whoFlags(channel, client)
+		case WhoHops:
+			param = fmt.Sprintf("%d", client.hops)
+		case WhoIdle:
+			param = fmt.Sprintf("%d", client.IdleSeconds())
+		case WhoAccount:
+			param = "0"
+			if client.account != "" {
+				param = client.account.String()
 			}
+		case WhoOpLevel:
+			param = "n/a"
+		case WhoRealname:
+			param = ":" + client.realname.String()
 		}
+		params = append(params, param)
 	}
-	target.NumericReply(RPL_WHOREPLY,
-		"%s %s %s %s %s %s :%d %s", channelName, client.username, client.hostname,
-		client.server.name, client.Nick(), flags, client.hops, client.realname)
+	target.NumericReply(RPL_WHOSPCRPL, "%s", strings.Join(params, " "))
 }
 
 // <name> :End of WHO list
@@ -388,6 +748,11 @@ func (target *Client) RplAway(client *Client) {
 		"%s :%s", client.Nick(), client.awayMessage)
 }
 
+func (target *Client) RplUserHost(replies []string) {
+	target.NumericReply(RPL_USERHOST,
+		":%s", strings.Join(replies, " "))
+}
+
 func (target *Client) RplIsOn(nicks []string) {
 	target.NumericReply(RPL_ISON,
 		":%s", strings.Join(nicks, " "))
@@ -398,7 +763,7 @@ func (target *Client) RplMOTDStart() {
 		":- %s Message of the day - ", target.server.name)
 }
 
-func (target *Client) RplMOTD(line string) {
+func (target *Client) RplMOTD(line Text) {
 	target.NumericReply(RPL_MOTD,
 		":- %s", line)
 }
@@ -413,6 +778,11 @@ func (target *Client) RplList(channel *Channel) {
 		"%s %d :%s", channel, len(channel.members), channel.topic)
 }
 
+func (target *Client) RplTryAgain(code StringCode) {
+	target.NumericReply(RPL_TRYAGAIN,
+		"%s :Please wait a while and try again.", code)
+}
+
 func (target *Client) RplListEnd(server *Server) {
 	target.NumericReply(RPL_LISTEND,
 		":End of LIST")
@@ -424,13 +794,173 @@ func (target *Client) RplNamReply(channel *Channel) {
 }
 
 func (target *Client) RplWhoisChannels(client *Client) {
-	target.MultilineReply(client.WhoisChannelsNames(), RPL_WHOISCHANNELS,
+	names := client.WhoisChannelsNames(target)
+	if len(names) == 0 {
+		return
+	}
+	target.MultilineReply(names, RPL_WHOISCHANNELS,
 		"%s :%s", client.Nick())
 }
 
 func (target *Client) RplVersion() {
+	comments := runtime.Version()
+	if Commit != "" {
+		comments = Commit + " " + comments
+	}
 	target.NumericReply(RPL_VERSION,
-		"%s %s", SEM_VER, target.server.name)
+		"%s %s :%s", SEM_VER, target.server.name, comments)
+}
+
+func (target *Client) RplStatsCommands(code string, stats *CommandStats) {
+	target.NumericReply(RPL_STATSCOMMANDS,
+		"%s %d 0 0 :%d errors, %s mean, %s max", code, stats.count, stats.errors,
+		stats.Mean(), stats.max)
+}
+
+func (target *Client) RplStatsKLine(kline *KLine) {
+	parts := strings.SplitN(kline.mask.String(), "@", 2)
+	target.NumericReply(RPL_STATSKLINE,
+		"K %s * %s :%s", parts[1], parts[0], kline.reason)
+}
+
+func (target *Client) RplStatsFilter(filter *Filter) {
+	target.NumericReply(RPL_STATSDEBUG,
+		"f %d %s :%s", filter.hits, filter.action, filter)
+}
+
+func (target *Client) RplStatsOLine(name Name) {
+	target.NumericReply(RPL_STATSOLINE,
+		"O * * %s", name)
+}
+
+func (target *Client) RplStatsPLine(addr string) {
+	target.NumericReply(RPL_STATSPLINE,
+		"P %s", addr)
+}
+
+func (target *Client) RplStatsYLine(class *ConnectionClass) {
+	target.NumericReply(RPL_STATSYLINE,
+		"Y %s %d %d %d %d %d", class, int(class.pingFrequency.Seconds()),
+		int(class.pingTimeout.Seconds()), class.maxClients, class.sendQ,
+		class.clients)
+}
+
+func (target *Client) RplStatsCountry(country string, stats *GeoIPStats) {
+	target.NumericReply(RPL_STATSDEBUG,
+		"g %s %d %d", country, stats.connections, stats.refused)
+}
+
+func (target *Client) RplStatsUptime(uptime time.Duration) {
+	seconds := int(uptime.Seconds())
+	target.NumericReply(RPL_STATSUPTIME,
+		":Server Up %d days %d:%02d:%02d", seconds/86400, (seconds/3600)%24,
+		(seconds/60)%60, seconds%60)
+}
+
+func (target *Client) RplEndOfStats(query StatsQuery) {
+	target.NumericReply(RPL_ENDOFSTATS,
+		"%s :End of STATS report", query)
+}
+
+func (target *Client) RplRehashing(filename string) {
+	target.NumericReply(RPL_REHASHING,
+		"%s :Rehashing", filename)
+}
+
+func (target *Client) RplAdminMe() {
+	target.NumericReply(RPL_ADMINME,
+		"%s :Administrative info", target.server.name)
+}
+
+func (target *Client) RplAdminLoc1(location string) {
+	target.NumericReply(RPL_ADMINLOC1,
+		":%s", location)
+}
+
+func (target *Client) RplAdminLoc2(description string) {
+	target.NumericReply(RPL_ADMINLOC2,
+		":%s", description)
+}
+
+func (target *Client) RplAdminEmail(email string) {
+	target.NumericReply(RPL_ADMINEMAIL,
+		":%s", email)
+}
+
+func (target *Client) RplInfo(line string) {
+	target.NumericReply(RPL_INFO,
+		":%s", line)
+}
+
+func (target *Client) RplEndOfInfo() {
+	target.NumericReply(RPL_ENDOFINFO,
+		":End of INFO list")
+}
+
+func (target *Client) RplLUserClient(users int, invisible int) {
+	target.NumericReply(RPL_LUSERCLIENT,
+		":There are %d users and %d invisible on 1 servers", users, invisible)
+}
+
+func (target *Client) RplLUserOp(opers int) {
+	target.NumericReply(RPL_LUSEROP,
+		"%d :operator(s) online", opers)
+}
+
+func (target *Client) RplLUserChannels(channels int) {
+	target.NumericReply(RPL_LUSERCHANNELS,
+		"%d :channels formed", channels)
+}
+
+// RplTrace describes one connection: a pseudo-client, an operator, a
+// registered user, or one still registering.
+func (target *Client) RplTrace(client *Client) {
+	switch {
+	case client.IsService():
+		target.NumericReply(RPL_TRACESERVICE,
+			"Service %s %s * *", client.class, client.Nick())
+
+	case !client.registered:
+		target.NumericReply(RPL_TRACEUNKNOWN,
+			"???? %s %s", client.class, client.IP())
+
+	case client.flags[Operator]:
+		target.NumericReply(RPL_TRACEOPERATOR,
+			"Oper %s %s[%s@%s] (%s) %d :%d", client.class, client.Nick(),
+			client.username, client.hostname, client.IP(),
+			int(time.Since(client.ctime).Seconds()), client.IdleSeconds())
+
+	default:
+		target.NumericReply(RPL_TRACEUSER,
+			"User %s %s[%s@%s] (%s) %d :%d", client.class, client.Nick(),
+			client.username, client.hostname, client.IP(),
+			int(time.Since(client.ctime).Seconds()), client.IdleSeconds())
+	}
+}
+
+func (target *Client) RplTraceClass(class *ConnectionClass) {
+	target.NumericReply(RPL_TRACECLASS,
+		"Class %s %d", class, class.clients)
+}
+
+func (target *Client) RplTraceEnd() {
+	target.NumericReply(RPL_TRACEEND,
+		"%s %s :End of TRACE", target.server.name, SEM_VER)
+}
+
+func (target *Client) RplMap(name Name, users int) {
+	target.NumericReply(RPL_MAP,
+		":%s [Users: %d]", name, users)
+}
+
+func (target *Client) RplMapEnd() {
+	target.NumericReply(RPL_MAPEND,
+		":End of /MAP")
+}
+
+func (target *Client) RplLUserMe(clients int) {
+	target.NumericReply(RPL_LUSERME,
+		":I have %d clients and 0 servers", clients)
 }
 
 func (target *Client) RplInviting(invitee *Client, channel Name) {
@@ -468,11 +998,99 @@ func (target *Client) ErrNickNameInUse(nick Name) {
 		"%s :Nickname is already in use", nick)
 }
 
+func (target *Client) ErrNickTooFast(nick Name, wait time.Duration) {
+	target.NumericReply(ERR_NICKTOOFAST,
+		"%s :Nick change too fast. Please wait %d seconds", nick,
+		int(wait.Seconds())+1)
+}
+
+func (target *Client) ErrNotRegistered() {
+	target.NumericReply(ERR_NOTREGISTERED,
+		":You have not registered")
+}
+
 func (target *Client) ErrUnknownCommand(code StringCode) {
 	target.NumericReply(ERR_UNKNOWNCOMMAND,
 		"%s :Unknown command", code)
 }
 
+func (target *Client) ErrSaslFail() {
+	target.NumericReply(ERR_SASLFAIL,
+		":SASL authentication failed")
+}
+
+func (target *Client) ErrSaslTooLong() {
+	target.NumericReply(ERR_SASLTOOLONG,
+		":SASL message too long")
+}
+
+func (target *Client) ErrSaslAborted() {
+	target.NumericReply(ERR_SASLABORTED,
+		":SASL authentication aborted")
+}
+
+func (target *Client) ErrSaslAlready() {
+	target.NumericReply(ERR_SASLALREADY,
+		":You have already authenticated using SASL")
+}
+
+func (target *Client) ErrAcceptFull() {
+	target.NumericReply(ERR_ACCEPTFULL,
+		":Accept list is full")
+}
+
+func (target *Client) ErrAcceptExist(client *Client) {
+	target.NumericReply(ERR_ACCEPTEXIST,
+		"%s :is already on your accept list", client.Nick())
+}
+
+func (target *Client) ErrAcceptNot(nick Name) {
+	target.NumericReply(ERR_ACCEPTNOT,
+		"%s :is not on your accept list", nick)
+}
+
+func (target *Client) ErrTargUModeG(client *Client) {
+	target.NumericReply(ERR_TARGUMODEG,
+		"%s :is in +g mode (server-side ignore.)", client.Nick())
+}
+
+func (target *Client) ErrNeedReggedNick(client *Client) {
+	target.NumericReply(ERR_NEEDREGGEDNICK,
+		"%s :only accepts private messages from users logged in to an account",
+		client.Nick())
+}
+
+// helpSubject is a help topic as one parameter, NICKSERV-REGISTER for
+// NICKSERV REGISTER.
+func helpSubject(topic string) string {
+	return strings.Replace(topic, " ", "-", -1)
+}
+
+// RplHelp sends the lines of a help topic.
+func (target *Client) RplHelp(topic string, lines []string) {
+	subject := helpSubject(topic)
+	for index, line := range lines {
+		code := RPL_HELPTXT
+		switch index {
+		case 0:
+			code = RPL_HELPSTART
+		case len(lines) - 1:
+			code = RPL_ENDOFHELP
+		}
+		target.Reply(NewNumericReply(target, code, "%s :%s", subject, line))
+	}
+}
+
+func (target *Client) ErrHelpNotFound(topic string) {
+	target.NumericReply(ERR_HELPNOTFOUND, "%s :No help available on this topic",
+		helpSubject(topic))
+}
+
+func (target *Client) ErrSileListFull(mask Name) {
+	target.NumericReply(ERR_SILELISTFULL,
+		"%s :Your silence list is full", mask)
+}
+
 func (target *Client) ErrUsersDontMatch() {
 	target.NumericReply(ERR_USERSDONTMATCH,
 		":Cannot change mode for other users")
@@ -483,6 +1101,16 @@ func (target *Client) ErrNeedMoreParams(command StringCode) {
 		"%s :Not enough parameters", command)
 }
 
+func (target *Client) ErrTooManyChannels(channel Name) {
+	target.NumericReply(ERR_TOOMANYCHANNELS,
+		"%s :You have joined too many channels", channel)
+}
+
+func (target *Client) ErrTooManyTargets(code StringCode) {
+	target.NumericReply(ERR_TOOMANYTARGETS,
+		"%s :Too many targets", code)
+}
+
 func (target *Client) ErrNoSuchChannel(channel Name) {
 	target.NumericReply(ERR_NOSUCHCHANNEL,
 		"%s :No such channel", channel)
@@ -517,11 +1145,21 @@ func (target *Client) ErrPasswdMismatch() {
 	target.NumericReply(ERR_PASSWDMISMATCH, ":Password incorrect")
 }
 
+func (target *Client) ErrYoureBannedCreep(reason Text) {
+	target.NumericReply(ERR_YOUREBANNEDCREEP,
+		":You are banned from this server: %s", reason)
+}
+
 func (target *Client) ErrNoChanModes(channel *Channel) {
 	target.NumericReply(ERR_NOCHANMODES,
 		"%s :Channel doesn't support modes", channel)
 }
 
+func (target *Client) ErrCantKillServer() {
+	target.NumericReply(ERR_CANTKILLSERVER,
+		":You can't kill a server!")
+}
+
 func (target *Client) ErrNoPrivileges() {
 	target.NumericReply(ERR_NOPRIVILEGES, ":Permission Denied")
 }
@@ -544,12 +1182,23 @@ func (target *Client) ErrCannotSendToChan(channel *Channel) {
 		"%s :Cannot send to channel", channel)
 }
 
+func (target *Client) ErrSlowMode(channel *Channel, wait time.Duration) {
+	target.NumericReply(ERR_CANNOTSENDTOCHAN,
+		"%s :Cannot send to channel (slow mode, wait %d seconds)", channel,
+		int(wait.Seconds()))
+}
+
 // <channel> :You're not channel operator
 func (target *Client) ErrChanOPrivIsNeeded(channel *Channel) {
 	target.NumericReply(ERR_CHANOPRIVSNEEDED,
 		"%s :You're not channel operator", channel)
 }
 
+func (target *Client) ErrNoAdminInfo() {
+	target.NumericReply(ERR_NOADMININFO,
+		"%s :No administrative info available", target.server.name)
+}
+
 func (target *Client) ErrNoMOTD() {
 	target.NumericReply(ERR_NOMOTD, ":MOTD File is missing")
 }
@@ -573,6 +1222,11 @@ func (target *Client) ErrConfiguredMode(mode ChannelMode) {
 		"%s :can only change this mode in daemon configuration", mode)
 }
 
+func (target *Client) ErrLinkChannel(channel *Channel, forward *Channel) {
+	target.NumericReply(ERR_LINKCHANNEL,
+		"%s %s :Forwarding to another channel", channel, forward)
+}
+
 func (target *Client) ErrChannelIsFull(channel *Channel) {
 	target.NumericReply(ERR_CHANNELISFULL,
 		"%s :Cannot join channel (+l)", channel)
@@ -593,6 +1247,11 @@ func (target *Client) ErrBannedFromChan(channel *Channel) {
 		"%s :Cannot join channel (+b)", channel)
 }
 
+func (target *Client) ErrBanListFull(channel *Channel, mode ChannelMode) {
+	target.NumericReply(ERR_BANLISTFULL,
+		"%s %s :Channel list is full", channel, mode)
+}
+
 func (target *Client) ErrInviteOnlyChan(channel *Channel) {
 	target.NumericReply(ERR_INVITEONLYCHAN,
 		"%s :Cannot join channel (+i)", channel)
@@ -0,0 +1,136 @@
+package irc
+
+// Role-play lets any member who may speak in a +E channel talk as a
+// character, as a theater's director can, but without a password. The
+// character's mask names who is playing it, so that it can't pass for a
+// real client:
+//
+//   :Gandalf!alice@npc.<server> PRIVMSG #chan :You shall not pass
+
+const (
+	NPC_HOST_PREFIX = "npc."
+	SCENE_NICK      = "=Scene="
+)
+
+// NPCClient is the source of a character's messages.
+type NPCClient struct {
+	name   Name
+	player *Client
+}
+
+func (npc NPCClient) Id() Name {
+	return Name(npc.name.String() + "!" + npc.player.Nick().String() + "@" +
+		NPC_HOST_PREFIX + npc.player.server.name.String())
+}
+
+func (npc NPCClient) Nick() Name {
+	return npc.name
+}
+
+// NPC <channel> <name> <message>
+// NPCA <channel> <name> <action>
+// SCENE <channel> <message>
+
+type NPCCommand struct {
+	BaseCommand
+	channel Name
+	name    Name // SCENE_NICK for SCENE
+	message Text
+	action  bool
+}
+
+func ParseNPCCommand(args []string) (Command, error) {
+	if len(args) < 3 {
+		return nil, NotEnoughArgsError
+	}
+	return &NPCCommand{
+		channel: NewName(args[0]),
+		name:    NewName(args[1]),
+		message: NewText(args[2]),
+	}, nil
+}
+
+func ParseNPCActionCommand(args []string) (Command, error) {
+	command, err := ParseNPCCommand(args)
+	if err != nil {
+		return nil, err
+	}
+	command.(*NPCCommand).action = true
+	return command, nil
+}
+
+func ParseSceneCommand(args []string) (Command, error) {
+	if len(args) < 2 {
+		return nil, NotEnoughArgsError
+	}
+	return &NPCCommand{
+		channel: NewName(args[0]),
+		name:    SCENE_NICK,
+		message: NewText(args[1]),
+	}, nil
+}
+
+//
+// server goroutine
+//
+
+func (msg *NPCCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	channel := server.channels.Get(msg.channel)
+	if channel == nil {
+		client.ErrNoSuchChannel(msg.channel)
+		return
+	}
+	if !channel.flags[Roleplay] {
+		client.ErrCannotSendToChan(channel)
+		return
+	}
+	if msg.name != SCENE_NICK {
+		// a character mustn't be mistaken for someone online
+		if !server.IsNickname(msg.name) || (server.clients.Get(msg.name) != nil) {
+			client.ErrErroneusNickname(msg.name)
+			return
+		}
+	}
+	// a character's line is the player's PRIVMSG to the channel, so it has
+	// to get past everything theirs would
+	message, ok := server.checkUTF8(client, msg.Code(), msg.message, true)
+	if !ok || !server.FilterMessage(client, PRIVMSG, msg.channel, message) ||
+		!server.hooks.Message(client, msg.channel, &message) {
+		return
+	}
+	channel.NPCMsg(client, NPCClient{msg.name, client}, message, msg.action)
+}
+
+// NPCMsg sends a character's message to the channel, like PrivMsg.
+func (channel *Channel) NPCMsg(client *Client, npc NPCClient, message Text,
+	action bool) {
+	if !channel.CanSpeak(client) {
+		client.ErrCannotSendToChan(channel)
+		return
+	}
+	message, err := channel.Filter(client, message)
+	if slow, ok := err.(*SlowModeError); ok {
+		client.ErrSlowMode(channel, slow.wait)
+		return
+	} else if err != nil {
+		client.ErrCannotSendToChan(channel)
+		return
+	}
+	if action {
+		message = NewText("\x01ACTION " + message.String() + "\x01")
+	}
+	reply := RplPrivMsg(npc, channel, message)
+	for member := range channel.members {
+		if (member != client) && member.IsSilencing(client) {
+			continue
+		}
+		member.Reply(reply)
+	}
+	channel.Record(reply, nil)
+	channel.StreamMessage(client, npc, PRIVMSG, message)
+	channel.LogMessage(npc, PRIVMSG, message)
+	if theater := channel.Theater(); theater != nil {
+		theater.Log(npc.name, message)
+	}
+}
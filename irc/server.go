@@ -2,16 +2,22 @@ package irc
 
 import (
 	"bufio"
+	"crypto/tls"
 	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 type ServerCommand interface {
@@ -25,20 +31,75 @@ type RegServerCommand interface {
 }
 
 type Server struct {
-	channels  ChannelNameMap
-	clients   *ClientLookupSet
-	commands  chan Command
-	ctime     time.Time
-	db        *sql.DB
-	idle      chan *Client
-	motdFile  string
-	name      Name
-	newConns  chan net.Conn
-	operators map[Name][]byte
-	password  []byte
-	signals   chan os.Signal
-	whoWas    *WhoWasList
-	theaters  map[Name][]byte
+	accountSkeletons map[string]Name
+	admin            AdminConfig
+	network          NetworkConfig
+	alwaysOn         AlwaysOnConfig
+	apiRequests      chan *APIRequest
+	eventStreams     map[*EventStream]bool
+	streamChannels   map[Name]bool // channels whose messages are streamed
+	channelLogs      ChannelLogConfig
+	loggedChannels   map[Name]bool // logged without +L
+	eventBuffer      int
+	authProviders    map[string]AuthProvider
+	channels         ChannelNameMap
+	clients          *ClientLookupSet
+	commandStats     map[StringCode]*CommandStats
+	commands         chan Command
+	connects         []APIConnect
+	ctime            time.Time
+	db               *sql.DB
+	idle             chan *Client
+	idleLimits       IdleConfig
+	klines           map[Name]*KLine
+	lastVacuum       time.Time
+	limits           LimitsConfig
+	maintenance      MaintenanceConfig
+	listeners        []*Listener
+	memoQuota        int
+	configFile       string
+	motd             []Text
+	name             Name
+	names            NamePool // hostnames and usernames shared by clients
+	newConns         chan NewConn
+	operators        map[Name][]byte
+	operConfigs      map[Name]*OperConfig
+	nickEnforcement  time.Duration
+	regTimeout       time.Duration
+	slowCommand      time.Duration
+	classes          []*ConnectionClass
+	challenge        ChallengeConfig
+	languages        LanguageSet
+	cloaks           CloakConfig
+	email            EmailConfig
+	defcon           int
+	defconLevels     map[int]*DefconLevelConfig
+	services         map[Name]*Client // pseudo-clients by nick
+	sessions         map[Name]*Client // detached always-on clients by lowercased account
+	signals          chan os.Signal
+	webhooks         []*Webhook
+	batchID          uint64
+	msgID            uint64
+	filters          []*Filter
+	dnsbl            *DNSBLChecker
+	geoip            *GeoIP
+	ctcp             CTCPConfig
+	nicks            NickConfig
+	metadata         MetadataConfig
+	autoJoin         []Name
+	channelColor     ColorMode
+	channelModes     []ChannelMode
+	historyAge       time.Duration
+	historyLines     int
+	hooks            *PluginHooks
+	whoWas           *WhoWasList
+	theaters         map[Name]*TheaterStage
+	bridges          map[Name]*BridgeConfig
+	utf8Only         UTF8Mode
+	tokenVerifier    *TokenVerifier
+	typing           TypingConfig
+	unixSocketMode   os.FileMode
+	memberPrefixes   []*MemberPrefix // as configured
 }
 
 var (
@@ -47,35 +108,122 @@ var (
 )
 
 func NewServer(config *Config) *Server {
+	ServerCaseMapping = CaseMapping(config.Server.CaseMapping)
 	server := &Server{
-		channels:  make(ChannelNameMap),
-		clients:   NewClientLookupSet(),
-		commands:  make(chan Command),
-		ctime:     time.Now(),
-		db:        OpenDB(config.Server.Database),
-		idle:      make(chan *Client),
-		motdFile:  config.Server.MOTD,
-		name:      NewName(config.Server.Name),
-		newConns:  make(chan net.Conn),
-		operators: config.Operators(),
-		signals:   make(chan os.Signal, len(SERVER_SIGNALS)),
-		whoWas:    NewWhoWasList(100),
-		theaters:  config.Theaters(),
+		admin:           config.Admin,
+		network:         config.Network,
+		alwaysOn:        config.AlwaysOn,
+		apiRequests:     make(chan *APIRequest),
+		eventStreams:    make(map[*EventStream]bool),
+		streamChannels:  config.API.Events.channels(),
+		channelLogs:     config.ChannelLogs,
+		loggedChannels:  config.ChannelLogs.channels(),
+		eventBuffer:     config.API.Events.Buffer,
+		channels:        make(ChannelNameMap),
+		clients:         NewClientLookupSet(),
+		commandStats:    make(map[StringCode]*CommandStats),
+		commands:        make(chan Command),
+		ctime:           time.Now(),
+		ctcp:            config.CTCP,
+		nicks:           config.Nicks,
+		metadata:        config.Metadata,
+		autoJoin:        NewNames(config.Channels.AutoJoin),
+		channelColor:    config.Channels.ColorMode,
+		channelModes:    config.Channels.ChannelModes(),
+		historyAge:      config.Channels.HistoryAge,
+		historyLines:    config.Channels.HistoryLines,
+		db:              OpenDB(config.Server.Database),
+		filters:         NewFilters(config),
+		hooks:           NewPluginHooks(),
+		idle:            make(chan *Client),
+		idleLimits:      config.Idle,
+		limits:          config.Limits,
+		maintenance:     config.Maintenance,
+		memoQuota:       config.Memos.Quota,
+		configFile:      config.Filename,
+		name:            NewName(config.Server.Name),
+		names:           make(NamePool),
+		newConns:        make(chan NewConn),
+		operators:       config.Operators(),
+		operConfigs:     config.OperConfigs(),
+		nickEnforcement: config.Server.NickEnforcement,
+		regTimeout:      config.Server.RegistrationTimeout,
+		slowCommand:     config.Server.SlowCommand,
+		challenge:       config.Challenge,
+		cloaks:          config.Cloaks,
+		email:           config.Email,
+		defcon:          DEFCON_NORMAL,
+		defconLevels:    config.Defcon,
+		services:        make(map[Name]*Client),
+		sessions:        make(map[Name]*Client),
+		signals:         make(chan os.Signal, len(SERVER_SIGNALS)),
+		whoWas:          NewWhoWasList(100),
+		theaters:        config.Theaters(),
+		bridges:         config.BridgeConfigs(),
+		utf8Only:        UTF8Mode(config.Server.UTF8Only),
+		typing:          config.Typing,
+		unixSocketMode:  config.UnixSocketFileMode(),
+		memberPrefixes:  NewMemberPrefixes(config.MemberPrefixes()),
 	}
 
-	if config.Server.Password != "" {
-		server.password = config.Server.PasswordBytes()
+	if len(config.DNSBL.Lists) > 0 {
+		server.dnsbl = NewDNSBLChecker(&config.DNSBL)
+	}
+	if config.GeoIP.Database != "" {
+		geoip, err := NewGeoIP(&config.GeoIP)
+		if err != nil {
+			log.Fatal("error loading geoip database: ", err)
+		}
+		server.geoip = geoip
+	}
+
+	languages, err := LoadLanguages(config.Languages.Path)
+	if err != nil {
+		log.Fatal("error loading languages: ", err)
+	}
+	server.languages = languages
+
+	for _, conf := range config.Webhooks {
+		server.webhooks = append(server.webhooks, NewWebhook(conf))
 	}
 
+	server.classes = NewConnectionClasses(config)
 	server.loadChannels()
+	server.loadAccountSkeletons()
+	server.loadKLines()
+	if config.Server.PseudoClients {
+		server.addServices()
+	}
+	if err := server.loadPlugins(config.Plugins); err != nil {
+		log.Fatal("error loading plugins: ", err)
+	}
+	server.authProviders = NewAuthProviders(config, server.db)
+	if config.Auth.JWT != nil {
+		server.tokenVerifier = NewTokenVerifier(config.Auth.JWT)
+	}
 
-	for _, addr := range config.Server.Listen {
-		server.listen(addr)
+	names := make([]string, 0, len(config.Listeners))
+	for name := range config.Listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		listener := NewListener(config, name)
+		if listener.websocket {
+			server.wslisten(listener, config)
+		} else {
+			server.listen(listener)
+		}
 	}
 
-	if config.Server.Wslisten != "" {
-		server.wslisten(config.Server.Wslisten)
+	if config.Debug.Listen != "" {
+		server.debugListen(&config.Debug)
 	}
+	if config.API.Listen != "" {
+		server.apiListen(config)
+	}
+
+	server.loadMOTD(config)
 
 	signal.Notify(server.signals, SERVER_SIGNALS...)
 
@@ -92,17 +240,20 @@ func loadChannelList(channel *Channel, list string, maskMode ChannelMode) {
 func (server *Server) loadChannels() {
 	rows, err := server.db.Query(`
         SELECT name, flags, key, topic, user_limit, ban_list, except_list,
-               invite_list
+               invite_list, topic_setter, topic_time, forward, slow_mode,
+               log_days
           FROM channel`)
 	if err != nil {
 		log.Fatal("error loading channels: ", err)
 	}
 	for rows.Next() {
-		var name, flags, key, topic string
-		var userLimit uint64
+		var name, flags, key, topic, topicSetter, forward string
+		var userLimit, slowMode, logDays uint64
+		var topicTime int64
 		var banList, exceptList, inviteList string
 		err = rows.Scan(&name, &flags, &key, &topic, &userLimit, &banList,
-			&exceptList, &inviteList)
+			&exceptList, &inviteList, &topicSetter, &topicTime, &forward,
+			&slowMode, &logDays)
 		if err != nil {
 			log.Println("Server.loadChannels:", err)
 			continue
@@ -114,16 +265,45 @@ func (server *Server) loadChannels() {
 		}
 		channel.key = NewText(key)
 		channel.topic = NewText(topic)
+		channel.topicSetter = NewName(topicSetter)
+		if topicTime > 0 {
+			channel.topicTime = time.Unix(topicTime, 0)
+		}
 		channel.userLimit = userLimit
+		channel.slowMode = slowMode
+		channel.logDays = logDays
+		channel.forward = NewName(forward)
 		loadChannelList(channel, banList, BanMask)
 		loadChannelList(channel, exceptList, ExceptMask)
 		loadChannelList(channel, inviteList, InviteMask)
 	}
+	server.loadChannelMetadata()
 }
 
 func (server *Server) processCommand(cmd Command) {
 	client := cmd.Client()
 
+	// a connection attached to another client speaks for it
+	socket := client.socket
+	if session := client.session; session != nil {
+		if session.hasQuit {
+			return
+		}
+		cmd.SetClient(session)
+		client = session
+	}
+	client, ok := server.puppetCommand(client, cmd)
+	if !ok {
+		return
+	}
+	client.origin = socket
+
+	if label := cmd.Tags()[LABEL_TAG]; (label != "") &&
+		client.capabilities[LabeledResponse] {
+		client.Label(label)
+		defer client.FlushLabel()
+	}
+
 	if !client.registered {
 		regCmd, ok := cmd.(RegServerCommand)
 		if !ok {
@@ -152,32 +332,71 @@ func (server *Server) processCommand(cmd Command) {
 		client.Touch()
 	}
 
+	start := time.Now()
+	errorReplies := client.errorReplies
 	srvCmd.HandleServer(server)
+
+	// unknown commands are counted once a plugin claims them
+	_, unknown := cmd.(*UnknownCommand)
+	if !unknown || (server.hooks.commands[cmd.Code()] != nil) {
+		server.RecordCommand(client, cmd.Code(), time.Since(start),
+			client.errorReplies > errorReplies)
+	}
 }
 
 func (server *Server) Shutdown() {
 	server.db.Close()
 	for _, client := range server.clients.byNick {
-		client.Reply(RplNotice(server, client, "shutting down"))
+		client.Notice("shutting down")
 	}
+	server.Notify(EventServerStop, nil)
+	server.CloseWebhooks()
 }
 
 func (server *Server) Run() {
+	server.Notify(EventServerStart, nil)
+	var idleCheck <-chan time.Time
+	if server.idleLimits.Enabled() {
+		ticker := time.NewTicker(IDLE_CHECK_INTERVAL)
+		defer ticker.Stop()
+		idleCheck = ticker.C
+	}
+	var maintenance <-chan time.Time
+	if server.maintenance.Interval > 0 {
+		ticker := time.NewTicker(server.maintenance.Interval)
+		defer ticker.Stop()
+		maintenance = ticker.C
+	}
 	done := false
 	for !done {
 		select {
-		case <-server.signals:
+		case sig := <-server.signals:
+			if sig == syscall.SIGHUP {
+				if err := server.rehash(); err != nil {
+					Log.error.Printf("%s rehash error: %s", server, err)
+				}
+				continue
+			}
 			server.Shutdown()
 			done = true
 
 		case conn := <-server.newConns:
-			NewClient(server, conn)
+			server.accept(conn)
 
 		case cmd := <-server.commands:
 			server.processCommand(cmd)
 
 		case client := <-server.idle:
 			client.Idle()
+
+		case <-idleCheck:
+			server.enforceIdle()
+
+		case <-maintenance:
+			server.maintain()
+
+		case request := <-server.apiRequests:
+			request.run(server)
 		}
 	}
 }
@@ -186,24 +405,218 @@ func (server *Server) Run() {
 // listen goroutine
 //
 
-func (s *Server) listen(addr string) {
-	listener, err := net.Listen("tcp", addr)
+// Listener is an address clients connect to, with any settings that
+// apply only to its clients.
+type Listener struct {
+	name      string
+	addr      string
+	allow     []*net.IPNet
+	class     string // joined by all its clients
+	deny      []*net.IPNet
+	hostname  Name   // given to all clients instead of looking them up
+	password  []byte // needed from clients before they register
+	proxy     bool   // PROXY lines are trusted
+	tls       *tls.Config
+	tor       bool // only reachable through a Tor onion service
+	unix      bool
+	websocket bool
+	motd      []Text // nil to use the server's MOTD
+}
+
+// Permits checks a new connection's address against the allow and deny
+// lists.
+func (listener *Listener) Permits(addr net.Addr) bool {
+	if listener.unix {
+		return true
+	}
+	ip := net.ParseIP(IPString(addr).String())
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range listener.deny {
+		if ipnet.Contains(ip) {
+			return false
+		}
+	}
+	if len(listener.allow) == 0 {
+		return true
+	}
+	for _, ipnet := range listener.allow {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (listener *Listener) String() string {
+	if listener.websocket {
+		return "ws " + listener.addr
+	}
+	return listener.addr
+}
+
+// accept puts a new connection in its class and starts a client for it,
+// unless the class turns it away.
+func (server *Server) accept(conn NewConn) {
+	ip := IPString(conn.conn.RemoteAddr())
+	if server.Defcon().NoConnections {
+		Log.info.Printf("%s DEFCON %d rejected %s", server, server.defcon, ip)
+		conn.conn.Write([]byte(RplError("Not accepting connections") + CRLF))
+		conn.conn.Close()
+		return
+	}
+	if (server.limits.MaxConnections > 0) &&
+		(server.Connections() >= server.limits.MaxConnections) {
+		Log.info.Printf("%s full, rejected %s", server, ip)
+		conn.conn.Write([]byte(RplError(ErrServerFull.Error()) + CRLF))
+		conn.conn.Close()
+		return
+	}
+	country, action := "", GeoIPAction("")
+	if (server.geoip != nil) && !conn.listener.tor && !conn.listener.unix {
+		country, action = server.geoip.Admit(net.ParseIP(ip.String()))
+		if action == GeoIPBlock {
+			Log.info.Printf("%s rejected %s from %s", server, ip, country)
+			conn.conn.Write([]byte(RplError("Connections from your country aren't allowed") + CRLF))
+			conn.conn.Close()
+			return
+		}
+	}
+	class := server.ClassFor(conn.listener, ip)
+	suspect := ""
+	if action == GeoIPChallenge {
+		suspect = "connecting from " + country
+	}
+	if err := class.Admit(conn.listener, ip); err != nil {
+		if (err != ErrThrottled) || !server.challenge.Throttled {
+			Log.info.Printf("%s %s rejected %s: %s", server, class, ip, err)
+			conn.conn.Write([]byte(RplError(err.Error()) + CRLF))
+			conn.conn.Close()
+			return
+		}
+		suspect = "too many connections from your host"
+	}
+	client := NewClient(server, conn.conn, conn.listener, class)
+	client.country = country
+	client.suspect = suspect
+}
+
+func NewListener(config *Config, name string) *Listener {
+	conf := config.Listeners[name]
+	listener := &Listener{
+		name:      name,
+		addr:      conf.Address,
+		class:     conf.Class,
+		proxy:     conf.Proxy,
+		tor:       conf.Tor,
+		unix:      strings.HasPrefix(conf.Address, UNIX_PREFIX),
+		websocket: conf.WebSocket,
+	}
+	// already checked by LoadConfig
+	listener.allow, _ = ParseNets(conf.Allow)
+	listener.deny, _ = ParseNets(conf.Deny)
+	if listener.unix {
+		listener.hostname = UNIX_HOSTNAME
+	}
+	if listener.tor {
+		listener.hostname = NewName(config.Server.Tor.Hostname)
+	}
+
+	password := config.Server.PassConfig
+	if conf.Password != nil {
+		password.Password = *conf.Password
+	}
+	if password.Password != "" {
+		listener.password = password.PasswordBytes()
+	}
+
+	if conf.TLS != nil {
+		cert, err := tls.LoadX509KeyPair(conf.TLS.Cert, conf.TLS.Key)
+		if err != nil {
+			log.Fatal("listener ", name, " tls error: ", err)
+		}
+		listener.tls = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			// for CERTFP; certificates aren't verified, only fingerprinted
+			ClientAuth: tls.RequestClientCert,
+		}
+	}
+	return listener
+}
+
+// NewConn is a connection accepted by a listener.
+type NewConn struct {
+	conn     net.Conn
+	listener *Listener
+}
+
+// listenUnix makes a unix socket in a private directory beside addr and
+// moves it there once it has unixSocketMode, so that it's never reachable
+// with the looser permissions it's made with.
+func (s *Server) listenUnix(addr string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(addr), ".listen-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, filepath.Base(addr))
+	netListener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, s.unixSocketMode); err == nil {
+		err = os.Rename(path, addr)
+	}
+	if err != nil {
+		netListener.Close()
+		return nil, err
+	}
+	return netListener, nil
+}
+
+func (s *Server) listen(listener *Listener) {
+	addr := listener.addr
+	if listener.unix {
+		addr = strings.TrimPrefix(addr, UNIX_PREFIX)
+		// remove a socket left over from an unclean exit
+		if info, err := os.Lstat(addr); (err == nil) && (info.Mode()&os.ModeSocket != 0) {
+			os.Remove(addr)
+		}
+	}
+
+	var netListener net.Listener
+	var err error
+	if listener.unix {
+		netListener, err = s.listenUnix(addr)
+	} else {
+		netListener, err = net.Listen("tcp", addr)
+	}
 	if err != nil {
 		log.Fatal(s, "listen error: ", err)
 	}
+	if listener.tls != nil {
+		netListener = tls.NewListener(netListener, listener.tls)
+	}
 
 	Log.info.Printf("%s listening on %s", s, addr)
+	s.listeners = append(s.listeners, listener)
 
 	go func() {
 		for {
-			conn, err := listener.Accept()
+			conn, err := netListener.Accept()
 			if err != nil {
 				Log.error.Printf("%s accept error: %s", s, err)
 				continue
 			}
 			Log.debug.Printf("%s accept: %s", s, conn.RemoteAddr())
+			if !listener.Permits(conn.RemoteAddr()) {
+				Log.info.Printf("%s %s denied: %s", s, listener, conn.RemoteAddr())
+				conn.Close()
+				continue
+			}
 
-			s.newConns <- conn
+			s.newConns <- NewConn{conn, listener}
 		}
 	}()
 }
@@ -212,12 +625,23 @@ func (s *Server) listen(addr string) {
 // websocket listen goroutine
 //
 
-func (s *Server) wslisten(addr string) {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+func (s *Server) wslisten(listener *Listener, config *Config) {
+	addr := listener.addr
+	mux := http.NewServeMux()
+	if config.API.Dashboard {
+		s.dashboard(config, mux)
+	}
+	if config.ChannelLogs.Web {
+		s.logViewer(mux)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			Log.error.Printf("%s method not allowed", s)
 			return
 		}
+		if s.landingPage(w, r) {
+			return
+		}
 
 		// We don't have any subprotocols, so if someone attempts to `new
 		// WebSocket(server, "subprotocol")` they'll break here, instead of
@@ -226,17 +650,34 @@ func (s *Server) wslisten(addr string) {
 			http.Error(w, fmt.Sprintf("WebSocket subprocotols (e.g. %s) not supported", v), 400)
 		}
 
+		if !listener.Permits(StringAddr(r.RemoteAddr)) {
+			Log.info.Printf("%s %s denied: %s", s, listener, r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
 		ws, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			Log.error.Printf("%s websocket upgrade error: %s", s, err)
 			return
 		}
 
-		s.newConns <- WSContainer{ws}
+		s.newConns <- NewConn{WSContainer{ws}, listener}
 	})
+	s.listeners = append(s.listeners, listener)
 	go func() {
 		Log.info.Printf("%s listening on %s", s, addr)
-		err := http.ListenAndServe(addr, nil)
+		httpServer := &http.Server{
+			Addr:      addr,
+			Handler:   mux,
+			TLSConfig: listener.tls,
+		}
+		var err error
+		if listener.tls != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
 		if err != nil {
 			Log.error.Printf("%s listenAndServe error: %s", s, err)
 		}
@@ -253,41 +694,250 @@ func (s *Server) tryRegister(c *Client) {
 		return
 	}
 
+	if kline := s.KLineFor(c); kline != nil {
+		c.ErrYoureBannedCreep(kline.reason)
+		c.Quit(NewText(fmt.Sprintf("K-lined: %s", kline.reason)))
+		return
+	}
+
+	s.CertFPLogin(c)
+
+	if c.listener.tor && (c.account == "") {
+		if !s.challenge.Tor {
+			c.ErrSaslFail()
+			c.Quit("SASL authentication is required on this port")
+			return
+		}
+		c.suspect = "Tor without SASL"
+	}
+
+	if (c.account == "") && (s.geoip != nil) &&
+		(s.geoip.countries[c.country] == GeoIPSASL) {
+		c.ErrSaslFail()
+		c.Quit(NewText(fmt.Sprintf("SASL authentication is required from %s",
+			c.country)))
+		return
+	}
+
+	if (c.account == "") && s.Defcon().RequireSASL {
+		c.ErrSaslFail()
+		c.Quit("SASL authentication is required right now")
+		return
+	}
+
+	if !s.Verified(c) {
+		return
+	}
+
+	if !s.hooks.Connect(c) {
+		c.Quit("Connection refused")
+		return
+	}
+	// only now that the K-lines have seen the real host
+	c.applyVHost()
+	c.applyCloakSetting()
+
+	if session := s.Session(c.account); session != nil {
+		s.Attach(c, session)
+		return
+	}
+
 	c.Register()
+	s.RecordConnect(c)
+	s.Notify(EventUserRegistered, map[string]string{
+		"nick":     c.nick.String(),
+		"username": c.username.String(),
+		"hostname": c.hostname.String(),
+		"ip":       c.IP(),
+		"account":  c.account.String(),
+	})
 	c.RplWelcome()
 	c.RplYourHost()
 	c.RplCreated()
 	c.RplMyInfo()
+	c.RplISupport(s.ISupport())
+	s.RplNetwork(c)
+	s.LUsers(c)
 	s.MOTD(c)
+	s.CheckNickOwner(c)
+	if c.account != "" {
+		s.DeliverMemos(c)
+	}
+	s.AutoJoin(c)
 }
 
-func (server *Server) MOTD(client *Client) {
-	if server.motdFile == "" {
-		client.ErrNoMOTD()
-		return
+// ISupport is the list of RPL_ISUPPORT tokens sent on registration.
+func (s *Server) ISupport() []string {
+	targets := s.limits.MaxTargets
+	tokens := []string{
+		fmt.Sprintf("AWAYLEN=%d", s.limits.AwayLen),
+		"CALLERID=" + CallerID.String(),
+		"CASEMAPPING=" + ServerCaseMapping.Token(),
+		fmt.Sprintf("CHANLIMIT=%s:%d", CHANTYPES, s.limits.MaxChannels),
+		fmt.Sprintf("CHANNELLEN=%d", s.limits.ChannelLen),
+		"CHANMODES=" + ChannelModesToken(),
+		"BOT=" + Bot.String(),
+		"CHANTYPES=" + CHANTYPES,
+		"ELIST=CMNTU",
+		"EXCEPTS=" + ExceptMask.String(),
+		"INVEX=" + InviteMask.String(),
+		fmt.Sprintf("MAXLIST=%s:%d", ChannelModes{BanMask, ExceptMask, InviteMask},
+			CHANNEL_LIST_MAX),
+		fmt.Sprintf("KICKLEN=%d", s.limits.KickLen),
+		fmt.Sprintf("MODES=%d", MAX_MODE_PARAMS),
+		"NETWORK=" + s.network.Name,
+		fmt.Sprintf("NICKLEN=%d", s.limits.NickLen),
+		"PREFIX=" + MemberPrefixToken(s.memberPrefixes),
+		fmt.Sprintf("TARGMAX=JOIN:,KICK:%d,NAMES:%d,NOTICE:1,PART:,"+
+			"PRIVMSG:1,WHOIS:%d,WHOWAS:%d",
+			targets, targets, targets, targets),
+		fmt.Sprintf("SILENCE=%d", SILENCE_MAX),
+		fmt.Sprintf("TOPICLEN=%d", s.limits.TopicLen),
+		"WHOX",
+	}
+	if s.utf8Only != UTF8Off {
+		tokens = append(tokens, "UTF8ONLY")
 	}
+	return tokens
+}
+
+// checkUTF8 applies the utf8only setting to message text: invalid UTF-8
+// is either rejected, with a FAIL unless quiet, or has the bad bytes
+// replaced.
+func (s *Server) checkUTF8(client *Client, code StringCode, text Text,
+	fail bool) (Text, bool) {
+	if (s.utf8Only == UTF8Off) || utf8.ValidString(text.String()) {
+		return text, true
+	}
+	if s.utf8Only == UTF8Replace {
+		return Text(strings.ToValidUTF8(text.String(), "\uFFFD")), true
+	}
+	if fail {
+		client.Reply(RplFail(s, code, "INVALID_UTF8",
+			"Message rejected, your message contained invalid UTF-8"))
+	}
+	return text, false
+}
+
+// IsNickname checks the nick's characters and configured length.
+func (s *Server) IsNickname(nick Name) bool {
+	if ServerCaseMapping.AllowsUnicode() {
+		if IsMixedScript(nick) {
+			return false
+		}
+	} else if !isASCII(nick.String()) {
+		return false
+	}
+	for _, member := range s.memberPrefixes {
+		if strings.HasPrefix(nick.String(), member.prefix) {
+			return false
+		}
+	}
+	return nick.IsNickname() && (len(nick) <= s.limits.NickLen)
+}
+
+// IsChannel checks the channel name's characters and configured length.
+func (s *Server) IsChannel(name Name) bool {
+	return name.IsChannel() && (len(name) <= s.limits.ChannelLen)
+}
+
+// checkTargets refuses commands naming more than the configured number of
+// targets. JOIN and PART aren't limited, so that clients can join their
+// whole channel list at once; JOIN is limited by maxchannels-per-user
+// instead.
+func (s *Server) checkTargets(client *Client, code StringCode, count int) bool {
+	if count > s.limits.MaxTargets {
+		client.ErrTooManyTargets(code)
+		return false
+	}
+	return true
+}
 
-	file, err := os.Open(server.motdFile)
+// loadMOTD reads the server's and each listener's MOTD files.
+func (server *Server) loadMOTD(config *Config) {
+	formatting := config.Server.MOTDFormatting
+	server.motd = readMOTD(config.Server.MOTD, formatting)
+	for _, listener := range server.listeners {
+		listener.motd = nil
+		if conf := config.Listeners[listener.name]; (conf != nil) && (conf.MOTD != "") {
+			listener.motd = readMOTD(conf.MOTD, formatting)
+		}
+	}
+}
+
+// readMOTD reads a MOTD file, wrapping its lines to MOTD_LINE_LEN. A
+// missing file gives no MOTD.
+func readMOTD(filename string, formatting bool) []Text {
+	if filename == "" {
+		return nil
+	}
+
+	file, err := os.Open(filename)
 	if err != nil {
-		client.ErrNoMOTD()
-		return
+		Log.error.Printf("motd error: %s", err)
+		return nil
 	}
 	defer file.Close()
 
-	client.RplMOTDStart()
-	reader := bufio.NewReader(file)
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			break
+	motd := make([]Text, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := NewText(strings.TrimRight(scanner.Text(), "\r"))
+		if !formatting {
+			line = line.StripFormatting()
 		}
-		line = strings.TrimRight(line, "\r\n")
+		motd = append(motd, line.Wrap(MOTD_LINE_LEN)...)
+	}
+	return motd
+}
+
+// rehash reloads the config file. Only the MOTD and ADMIN settings take
+// effect without a restart.
+func (server *Server) rehash() error {
+	config, err := LoadConfig(server.configFile)
+	if err != nil {
+		return err
+	}
+	server.admin = config.Admin
+	server.loadMOTD(config)
+	Log.info.Printf("%s rehashed %s", server, server.configFile)
+	return nil
+}
+
+func (server *Server) MOTD(client *Client) {
+	motd := server.motd
+	if client.listener.motd != nil {
+		motd = client.listener.motd
+	}
+	if motd == nil {
+		client.ErrNoMOTD()
+		return
+	}
 
+	client.RplMOTDStart()
+	for _, line := range motd {
 		client.RplMOTD(line)
 	}
 	client.RplMOTDEnd()
 }
 
+func (msg *RehashCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.flags[Operator] {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	client.RplRehashing(server.configFile)
+	err := server.rehash()
+	if err != nil {
+		client.Notice("rehash failed: %s", err)
+		server.Audit(client, REHASH, server.configFile, err.Error())
+		return
+	}
+	server.Audit(client, REHASH, server.configFile, "")
+}
+
 func (s *Server) Id() Name {
 	return s.name
 }
@@ -314,17 +964,31 @@ func (server *Server) Replyf(target *Client, format string, args ...interface{})
 
 func (msg *PassCommand) HandleRegServer(server *Server) {
 	client := msg.Client()
-	if msg.err != nil {
+	if (msg.err != nil) || ((msg.verifier != nil) && !client.listener.websocket) {
 		client.ErrPasswdMismatch()
 		client.Quit("bad password")
 		return
 	}
+	if msg.verifier != nil {
+		client.Login(msg.account)
+	}
 
 	client.authorized = true
 }
 
 func (msg *ProxyCommand) HandleRegServer(server *Server) {
-	msg.Client().hostname = msg.hostname
+	client := msg.Client()
+	// PROXY lines, unlike the client's own lookup, need a trusted proxy
+	if (msg.sourceIP != "") && !client.listener.proxy {
+		return
+	}
+	// a Tor client mustn't replace its hostname
+	if client.listener.tor && (client.hostname != "") {
+		return
+	}
+	client.hostname = server.names.Intern(msg.hostname)
+	client.realHostname = client.hostname
+	client.updateUserHost()
 }
 
 func (msg *RFC1459UserCommand) HandleRegServer(server *Server) {
@@ -356,12 +1020,15 @@ func (msg *RFC2812UserCommand) HandleRegServer(server *Server) {
 
 func (msg *UserCommand) setUserInfo(server *Server) {
 	client := msg.Client()
-	if client.capState == CapNegotiating {
+	// clients authenticating with SASL register on CAP END
+	if (client.capState == CapNegotiating) && !client.capabilities[SASL] {
 		client.capState = CapNegotiated
 	}
 
 	server.clients.Remove(client)
-	client.username, client.realname = msg.username, msg.realname
+	client.username = server.names.Intern(msg.username)
+	client.realname = msg.realname
+	client.updateUserHost()
 	server.clients.Add(client)
 
 	server.tryRegister(client)
@@ -371,6 +1038,14 @@ func (msg *QuitCommand) HandleRegServer(server *Server) {
 	msg.Client().Quit(msg.message)
 }
 
+func (msg *RegistrationTimeoutCommand) HandleRegServer(server *Server) {
+	msg.Client().Quit("Registration timed out")
+}
+
+func (msg *RegistrationTimeoutCommand) HandleServer(server *Server) {
+	// registered before the timer's command was processed
+}
+
 //
 // normal commands
 //
@@ -393,7 +1068,15 @@ func (m *UserCommand) HandleServer(s *Server) {
 }
 
 func (msg *QuitCommand) HandleServer(server *Server) {
-	msg.Client().Quit(msg.message)
+	client := msg.Client()
+	if msg.disconnected && server.Disconnect(client) {
+		return
+	}
+	message := msg.message
+	if !server.FilterMessage(client, msg.Code(), client.nick, message) {
+		message = client.nick.Text()
+	}
+	client.Quit(message)
 }
 
 func (m *JoinCommand) HandleServer(s *Server) {
@@ -407,21 +1090,56 @@ func (m *JoinCommand) HandleServer(s *Server) {
 	}
 
 	for name, key := range m.channels {
-		if !name.IsChannel() {
+		if !s.IsChannel(name) {
 			client.ErrNoSuchChannel(name)
 			continue
 		}
 
+		if len(client.channels) >= s.limits.MaxChannels {
+			client.ErrTooManyChannels(name)
+			continue
+		}
+
 		channel := s.channels.Get(name)
+		if (channel == nil) && s.Defcon().NoNewChannels && !client.flags[Operator] {
+			client.Reply(RplFail(s, m.Code(), "CANNOT_CREATE",
+				fmt.Sprintf("%s can't be created right now", name)))
+			continue
+		}
 		if channel == nil {
 			channel = NewChannel(s, name)
+			for _, mode := range s.channelModes {
+				channel.flags[mode] = true
+			}
+			s.Notify(EventChannelCreated, map[string]string{
+				"channel": name.String(),
+				"nick":    client.nick.String(),
+			})
 		}
 		channel.Join(client, key)
 	}
 }
 
+// AutoJoin joins a newly registered client to the configured channels.
+func (server *Server) AutoJoin(client *Client) {
+	for _, name := range server.autoJoin {
+		cmd := &JoinCommand{
+			channels: map[Name]Text{name: ""},
+		}
+		cmd.SetClient(client)
+		cmd.HandleServer(server)
+	}
+}
+
 func (m *PartCommand) HandleServer(server *Server) {
 	client := m.Client()
+	message := m.Message()
+	if !server.FilterMessage(client, m.Code(), client.nick, message) {
+		if client.hasQuit {
+			return
+		}
+		message = client.nick.Text()
+	}
 	for _, chname := range m.channels {
 		channel := server.channels.Get(chname)
 
@@ -430,7 +1148,7 @@ func (m *PartCommand) HandleServer(server *Server) {
 			continue
 		}
 
-		channel.Part(client, m.Message())
+		channel.Part(client, message)
 	}
 }
 
@@ -443,7 +1161,11 @@ func (msg *TopicCommand) HandleServer(server *Server) {
 	}
 
 	if msg.setTopic {
-		channel.SetTopic(client, msg.topic)
+		topic, ok := server.checkUTF8(client, msg.Code(), msg.topic, true)
+		if !ok {
+			return
+		}
+		channel.SetTopic(client, topic.Truncate(server.limits.TopicLen))
 	} else {
 		channel.GetTopic(client)
 	}
@@ -451,6 +1173,17 @@ func (msg *TopicCommand) HandleServer(server *Server) {
 
 func (msg *PrivMsgCommand) HandleServer(server *Server) {
 	client := msg.Client()
+	message, ok := server.checkUTF8(client, msg.Code(), msg.message, true)
+	if ok && server.addToMultiline(client, msg.Code(), msg.target, message,
+		msg.Tags()) {
+		return
+	}
+	if !ok || !server.FilterMessage(client, msg.Code(), msg.target, message) ||
+		!server.hooks.Message(client, msg.target, &message) {
+		return
+	}
+	msg.message = message
+
 	if msg.target.IsChannel() {
 		channel := server.channels.Get(msg.target)
 		if channel == nil {
@@ -458,36 +1191,87 @@ func (msg *PrivMsgCommand) HandleServer(server *Server) {
 			return
 		}
 
-		channel.PrivMsg(client, msg.message)
+		channel.PrivMsg(client, msg.message, msg.Tags())
+		return
+	}
+
+	if msg.target.ToLower() == server.name.ToLower() {
+		server.ctcpReply(client, msg.message)
 		return
 	}
 
 	target := server.clients.Get(msg.target)
+	if (target != nil) && target.IsService() {
+		server.ServiceMessage(client, target, msg.message)
+		return
+	}
 	if target == nil {
+		if (client.account != "") && server.isAccount(msg.target) {
+			server.SendMemo(client, msg.target, msg.message)
+			return
+		}
 		client.ErrNoSuchNick(msg.target)
 		return
 	}
-	target.Reply(RplPrivMsg(client, target, msg.message))
+	if target.IsSilencing(client) || !target.CanMessage(client, true) {
+		return
+	}
+	tags := client.MessageTags(msg.Tags())
+	target.Reply(TagReply(target.capabilities,
+		RplPrivMsg(client, target, msg.message), tags))
+	client.Echo(TagReply(client.capabilities,
+		RplPrivMsg(client, target, msg.message), tags))
 	if target.flags[Away] {
 		client.RplAway(target)
 	}
 }
 
-func (client *Client) WhoisChannelsNames() []string {
-	chstrs := make([]string, len(client.channels))
-	index := 0
-	for channel := range client.channels {
-		switch {
-		case channel.members[client][ChannelOperator]:
-			chstrs[index] = "@" + channel.name.String()
+func (msg *TagMsgCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.capabilities[MessageTags] ||
+		!server.filterTyping(client, msg.target, msg.Tags()) {
+		return
+	}
 
-		case channel.members[client][Voice]:
-			chstrs[index] = "+" + channel.name.String()
+	if msg.target.IsChannel() {
+		channel := server.channels.Get(msg.target)
+		if channel == nil {
+			client.ErrNoSuchChannel(msg.target)
+			return
+		}
+
+		channel.TagMsg(client, msg.Tags())
+		return
+	}
+
+	target := server.clients.Get(msg.target)
+	if target == nil {
+		client.ErrNoSuchNick(msg.target)
+		return
+	}
+	if target.IsSilencing(client) || !target.CanMessage(client, true) {
+		return
+	}
+	reply := AddTags(RplTagMsg(client, target), client.TagMsgTags(msg.Tags()))
+	if target.capabilities[MessageTags] {
+		target.Reply(reply)
+	}
+	client.Echo(reply)
+}
 
-		default:
-			chstrs[index] = channel.name.String()
+// WhoisChannelsNames lists client's channels as seen by target: secret
+// and private channels are hidden unless target shares them or is an
+// operator.
+func (client *Client) WhoisChannelsNames(target *Client) []string {
+	chstrs := make([]string, 0, len(client.channels))
+	for channel := range client.channels {
+		hidden := channel.flags[Secret] || channel.flags[Private]
+		if hidden && !target.flags[Operator] && !channel.members.Has(target) {
+			continue
 		}
-		index += 1
+		chstrs = append(chstrs, channel.members[client].Prefixes(
+			client.server.memberPrefixes, target.capabilities[MultiPrefix])+
+			channel.name.String())
 	}
 	return chstrs
 }
@@ -497,6 +1281,10 @@ func (m *WhoisCommand) HandleServer(server *Server) {
 
 	// TODO implement target query
 
+	if !server.checkTargets(client, m.Code(), len(m.masks)) {
+		return
+	}
+
 	for _, mask := range m.masks {
 		matches := server.clients.FindAll(mask)
 		if len(matches) == 0 {
@@ -509,10 +1297,25 @@ func (m *WhoisCommand) HandleServer(server *Server) {
 	}
 }
 
-func whoChannel(client *Client, channel *Channel, friends ClientSet) {
+func (msg *WhoCommand) matches(client *Client, member *Client, friends ClientSet) bool {
+	if msg.operatorOnly && !member.flags[Operator] {
+		return false
+	}
+	return !member.flags[Invisible] || friends[member] || client.flags[Operator]
+}
+
+func (msg *WhoCommand) reply(client *Client, channel *Channel, member *Client) {
+	if msg.fields != nil {
+		client.RplWhoSpcRpl(channel, member, msg.fields, msg.token)
+		return
+	}
+	client.RplWhoReply(channel, member)
+}
+
+func (msg *WhoCommand) whoChannel(client *Client, channel *Channel, friends ClientSet) {
 	for member := range channel.members {
-		if !client.flags[Invisible] || friends[client] {
-			client.RplWhoReply(channel, member)
+		if msg.matches(client, member, friends) {
+			msg.reply(client, channel, member)
 		}
 	}
 }
@@ -522,19 +1325,21 @@ func (msg *WhoCommand) HandleServer(server *Server) {
 	friends := client.Friends()
 	mask := msg.mask
 
-	if mask == "" {
+	if (mask == "") || (mask == "0") {
 		for _, channel := range server.channels {
-			whoChannel(client, channel, friends)
+			msg.whoChannel(client, channel, friends)
 		}
 	} else if mask.IsChannel() {
 		// TODO implement wildcard matching
 		channel := server.channels.Get(mask)
 		if channel != nil {
-			whoChannel(client, channel, friends)
+			msg.whoChannel(client, channel, friends)
 		}
 	} else {
 		for mclient := range server.clients.FindAll(mask) {
-			client.RplWhoReply(nil, mclient)
+			if msg.matches(client, mclient, friends) {
+				msg.reply(client, nil, mclient)
+			}
 		}
 	}
 
@@ -545,26 +1350,37 @@ func (msg *OperCommand) HandleServer(server *Server) {
 	client := msg.Client()
 
 	if (msg.hash == nil) || (msg.err != nil) {
+		server.Audit(client, OPER, msg.name.String(), "failed")
 		client.ErrPasswdMismatch()
 		return
 	}
 
 	client.flags[Operator] = true
+	client.operName = msg.name
+	client.operConfig = server.operConfigs[msg.name]
+	server.Audit(client, OPER, msg.name.String(), "")
 	client.RplYoureOper()
 	client.Reply(RplModeChanges(client, client, ModeChanges{&ModeChange{
 		mode: Operator,
 		op:   Add,
 	}}))
+	if client.OperConfig().Services {
+		server.ServicesBurst(client)
+	}
 }
 
 func (msg *AwayCommand) HandleServer(server *Server) {
 	client := msg.Client()
 	if len(msg.text) > 0 {
 		client.flags[Away] = true
+		client.awayTime = time.Now()
 	} else {
 		delete(client.flags, Away)
+		client.awayTime = time.Time{}
 	}
-	client.awayMessage = msg.text
+	client.awayMessage = msg.text.Truncate(server.limits.AwayLen)
+	client.autoAway = false
+	client.NotifyAway()
 
 	var op ModeOp
 	if client.flags[Away] {
@@ -580,6 +1396,18 @@ func (msg *AwayCommand) HandleServer(server *Server) {
 	}}))
 }
 
+func (msg *UserHostCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	replies := make([]string, 0, len(msg.nicks))
+	for _, nick := range msg.nicks {
+		if target := server.clients.Get(nick); target != nil {
+			replies = append(replies, target.UserHostReply())
+		}
+	}
+	client.RplUserHost(replies)
+}
+
 func (msg *IsOnCommand) HandleServer(server *Server) {
 	client := msg.Client()
 
@@ -599,6 +1427,17 @@ func (msg *MOTDCommand) HandleServer(server *Server) {
 
 func (msg *NoticeCommand) HandleServer(server *Server) {
 	client := msg.Client()
+	message, ok := server.checkUTF8(client, msg.Code(), msg.message, false)
+	if ok && server.addToMultiline(client, msg.Code(), msg.target, message,
+		msg.Tags()) {
+		return
+	}
+	if !ok || !server.FilterMessage(client, msg.Code(), msg.target, message) ||
+		!server.hooks.Message(client, msg.target, &message) {
+		return
+	}
+	msg.message = message
+
 	if msg.target.IsChannel() {
 		channel := server.channels.Get(msg.target)
 		if channel == nil {
@@ -606,7 +1445,7 @@ func (msg *NoticeCommand) HandleServer(server *Server) {
 			return
 		}
 
-		channel.Notice(client, msg.message)
+		channel.Notice(client, msg.message, msg.Tags())
 		return
 	}
 
@@ -615,11 +1454,92 @@ func (msg *NoticeCommand) HandleServer(server *Server) {
 		client.ErrNoSuchNick(msg.target)
 		return
 	}
-	target.Reply(RplNotice(client, target, msg.message))
+	if target.IsSilencing(client) || !target.CanMessage(client, false) {
+		return
+	}
+	tags := client.MessageTags(msg.Tags())
+	target.Reply(TagReply(target.capabilities,
+		RplNotice(client, target, msg.message), tags))
+	client.Echo(TagReply(client.capabilities,
+		RplNotice(client, target, msg.message), tags))
+}
+
+func (msg *AcceptCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	for _, nick := range msg.remove {
+		target := server.clients.Get(nick)
+		if (target == nil) || !client.accepted.Has(target) {
+			client.ErrAcceptNot(nick)
+			continue
+		}
+		client.accepted.Remove(target)
+	}
+
+	for _, nick := range msg.add {
+		target := server.clients.Get(nick)
+		if target == nil {
+			client.ErrNoSuchNick(nick)
+			continue
+		}
+		if client.accepted.Has(target) {
+			client.ErrAcceptExist(target)
+			continue
+		}
+		if len(client.accepted) >= ACCEPT_MAX {
+			client.ErrAcceptFull()
+			break
+		}
+		client.accepted.Add(target)
+	}
+
+	if msg.list {
+		nicks := make([]string, 0, len(client.accepted))
+		for target := range client.accepted {
+			nicks = append(nicks, target.Nick().String())
+		}
+		client.RplAcceptList(nicks)
+		client.RplEndOfAccept()
+	}
+}
+
+func (msg *SilenceCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if len(msg.changes) == 0 {
+		for mask := range client.silence.masks {
+			client.RplSilenceList(mask)
+		}
+		client.RplEndOfSilenceList()
+		return
+	}
+
+	for _, change := range msg.changes {
+		switch change.op {
+		case Add:
+			if len(client.silence.masks) >= SILENCE_MAX {
+				client.ErrSileListFull(change.mask)
+				continue
+			}
+			if !client.silence.Add(change.mask) {
+				continue
+			}
+
+		case Remove:
+			if !client.silence.Remove(change.mask) {
+				continue
+			}
+		}
+		client.persistSilence(change.mask, change.op)
+		client.Reply(RplSilence(client, change))
+	}
 }
 
 func (msg *KickCommand) HandleServer(server *Server) {
 	client := msg.Client()
+	if !server.checkTargets(client, msg.Code(), len(msg.kicks)) {
+		return
+	}
+	comment := msg.Comment().Truncate(server.limits.KickLen)
 	for chname, nickname := range msg.kicks {
 		channel := server.channels.Get(chname)
 		if channel == nil {
@@ -633,10 +1553,27 @@ func (msg *KickCommand) HandleServer(server *Server) {
 			continue
 		}
 
-		channel.Kick(client, target, msg.Comment())
+		channel.Kick(client, target, comment)
 	}
 }
 
+func (msg *RemoveCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	channel := server.channels.Get(msg.channel)
+	if channel == nil {
+		client.ErrNoSuchChannel(msg.channel)
+		return
+	}
+
+	target := server.clients.Get(msg.nickname)
+	if target == nil {
+		client.ErrNoSuchNick(msg.nickname)
+		return
+	}
+
+	channel.RemoveMember(client, target, msg.Comment().Truncate(server.limits.KickLen))
+}
+
 func (msg *ListCommand) HandleServer(server *Server) {
 	client := msg.Client()
 
@@ -646,12 +1583,30 @@ func (msg *ListCommand) HandleServer(server *Server) {
 		return
 	}
 
-	if len(msg.channels) == 0 {
+	isOper := client.flags[Operator]
+	if (len(msg.channels) == 0) || msg.IsSearch() {
+		// only listing every channel is throttled; clients that are
+		// refused still expect the list to end
+		if !isOper && (time.Since(client.ltime) < LIST_THROTTLE) {
+			client.RplTryAgain(msg.Code())
+			client.RplListEnd(server)
+			return
+		}
+		client.ltime = time.Now()
+
+		count := 0
 		for _, channel := range server.channels {
-			if !client.flags[Operator] && channel.flags[Private] {
+			if !isOper && channel.flags[Private] {
+				continue
+			}
+			if !msg.Match(channel) {
 				continue
 			}
+			if !isOper && (count >= LIST_MAX_RESULTS) {
+				break
+			}
 			client.RplList(channel)
+			count += 1
 		}
 	} else {
 		for _, chname := range msg.channels {
@@ -668,13 +1623,17 @@ func (msg *ListCommand) HandleServer(server *Server) {
 
 func (msg *NamesCommand) HandleServer(server *Server) {
 	client := msg.Client()
-	if len(server.channels) == 0 {
+	if len(msg.channels) == 0 {
 		for _, channel := range server.channels {
 			channel.Names(client)
 		}
 		return
 	}
 
+	if !server.checkTargets(client, msg.Code(), len(msg.channels)) {
+		return
+	}
+
 	for _, chname := range msg.channels {
 		channel := server.channels.Get(chname)
 		if channel == nil {
@@ -714,6 +1673,80 @@ func (msg *InviteCommand) HandleServer(server *Server) {
 	channel.Invite(target, client)
 }
 
+func (msg *AdminCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if (msg.target != "") && (msg.target != server.name) {
+		client.ErrNoSuchServer(msg.target)
+		return
+	}
+
+	admin := server.admin
+	if (admin.Location == "") && (admin.Description == "") && (admin.Email == "") &&
+		(len(server.network.Admins) == 0) {
+		client.ErrNoAdminInfo()
+		return
+	}
+	client.RplAdminMe()
+	client.RplAdminLoc1(admin.Location)
+	client.RplAdminLoc2(admin.Description)
+	client.RplAdminEmail(admin.Email)
+	for _, contact := range server.network.Admins {
+		client.RplAdminEmail(contact)
+	}
+}
+
+func (msg *InfoCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if (msg.target != "") && (msg.target != server.name) {
+		client.ErrNoSuchServer(msg.target)
+		return
+	}
+
+	client.RplInfo(SEM_VER)
+	client.RplInfo(HOMEPAGE)
+	client.RplInfo("built with " + runtime.Version())
+	if Commit != "" {
+		client.RplInfo("from commit " + Commit)
+	}
+	client.RplInfo("started " + server.ctime.Format(time.RFC1123))
+	client.RplEndOfInfo()
+}
+
+func (msg *LUsersCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if (msg.target != "") && (msg.target != server.name) {
+		client.ErrNoSuchServer(msg.target)
+		return
+	}
+	server.LUsers(client)
+}
+
+// LUsers sends the live user, operator and channel counts.
+func (server *Server) LUsers(client *Client) {
+	var users, invisible, opers int
+	for _, member := range server.clients.byNick {
+		if !member.registered {
+			continue
+		}
+		users += 1
+		if member.flags[Invisible] {
+			invisible += 1
+		}
+		if member.flags[Operator] {
+			opers += 1
+		}
+	}
+
+	client.RplLUserClient(users-invisible, invisible)
+	if opers > 0 {
+		client.RplLUserOp(opers)
+	}
+	if len(server.channels) > 0 {
+		client.RplLUserChannels(len(server.channels))
+	}
+	client.RplLUserMe(users)
+}
+
 func (msg *TimeCommand) HandleServer(server *Server) {
 	client := msg.Client()
 	if (msg.target != "") && (msg.target != server.name) {
@@ -735,13 +1768,105 @@ func (msg *KillCommand) HandleServer(server *Server) {
 		client.ErrNoSuchNick(msg.nickname)
 		return
 	}
+	if target.IsService() {
+		client.ErrCantKillServer()
+		return
+	}
+
+	server.Audit(client, KILL, target.nick.String(), msg.comment.String())
+	server.NoticeOperators(NewText(fmt.Sprintf("%s used KILL on %s: %s",
+		client.nick, target.nick, msg.comment)))
 
-	quitMsg := fmt.Sprintf("KILLed by %s: %s", client.Nick(), msg.comment)
+	target.Reply(RplKill(client, target, msg.comment))
+	quitMsg := fmt.Sprintf("Killed (%s (%s))", client.Nick(), msg.comment)
 	target.Quit(NewText(quitMsg))
 }
 
+// WALLOPS goes to everyone with +w.
+func (msg *WallopsCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.OperConfig().Wallops {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	reply := RplWallops(client, msg.message)
+	for _, member := range server.clients.byNick {
+		if member.flags[WallOps] {
+			member.Reply(reply)
+		}
+	}
+}
+
+// GLOBOPS goes only to operators.
+func (msg *GlobopsCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.OperConfig().Globops {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	server.NoticeOperators(NewText(fmt.Sprintf("*** Global -- from %s: %s",
+		client.nick, msg.message)))
+}
+
+func (msg *GlobalNoticeCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.OperConfig().GlobalNotice {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	server.Audit(client, GLOBALNOTICE, "*", msg.message.String())
+	var source Identifiable = server
+	if global := server.services[NewName("Global").ToLower()]; global != nil {
+		source = global
+	}
+	count := 0
+	for _, member := range server.clients.byNick {
+		if member.IsService() || member.hasAnyMode(msg.exclude) {
+			continue
+		}
+		member.Reply(RplNotice(source, member, msg.message))
+		count += 1
+	}
+	server.NoticeOperators(NewText(fmt.Sprintf("%s sent a global notice to %d clients",
+		client.nick, count)))
+}
+
+// hasAnyMode reports whether the client has any of modes set.
+func (client *Client) hasAnyMode(modes []UserMode) bool {
+	for _, mode := range modes {
+		if client.flags[mode] {
+			return true
+		}
+	}
+	return false
+}
+
+// NoticeOperators sends a server notice to every operator.
+func (server *Server) NoticeOperators(message Text) {
+	for _, member := range server.clients.byNick {
+		if member.flags[Operator] {
+			member.Reply(RplNotice(server, member, message))
+		}
+	}
+}
+
+// OperOverride records an operator acting on a channel with powers it
+// doesn't have as a member.
+func (server *Server) OperOverride(client *Client, code StringCode,
+	channel *Channel, detail string) {
+	server.Audit(client, code, channel.name.String(), "override: "+detail)
+	server.NoticeOperators(NewText(fmt.Sprintf("%s used oper override: %s %s %s",
+		client.nick, code, channel.name, detail)))
+}
+
 func (msg *WhoWasCommand) HandleServer(server *Server) {
 	client := msg.Client()
+	if !server.checkTargets(client, msg.Code(), len(msg.nicknames)) {
+		return
+	}
 	for _, nickname := range msg.nicknames {
 		results := server.whoWas.Find(nickname, msg.count)
 		if len(results) == 0 {
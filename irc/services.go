@@ -0,0 +1,211 @@
+package irc
+
+// This server doesn't link to other servers, and services packages such
+// as Atheme and Anope only link as a server, with ENCAP and an account
+// burst over TS6 or a similar protocol, so they can't be used with it. A
+// services bot written for this server instead connects as a client and
+// OPERs with an operator block that has services set. It may then use:
+//
+//   SVSNICK <nick> <newnick>
+//   SVSMODE <nick> <modes>
+//   SVSLOGIN <nick> <account|*>
+//
+// and is sent an SVSLOGIN line for every client logged in to an account
+// when it opers, and whenever a client logs in or out afterwards.
+
+// SVSNICK <nick> <newnick>
+
+type SvsNickCommand struct {
+	BaseCommand
+	target Name
+	nick   Name
+}
+
+func ParseSvsNickCommand(args []string) (Command, error) {
+	if len(args) < 2 {
+		return nil, NotEnoughArgsError
+	}
+	return &SvsNickCommand{
+		target: NewName(args[0]),
+		nick:   NewName(args[1]),
+	}, nil
+}
+
+// SVSMODE <nick> <modes>
+
+type SvsModeCommand struct {
+	ModeCommand
+}
+
+func ParseSvsModeCommand(args []string) (Command, error) {
+	if len(args) < 2 {
+		return nil, NotEnoughArgsError
+	}
+	cmd, err := ParseUserModeCommand(NewName(args[0]), args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &SvsModeCommand{*cmd.(*ModeCommand)}, nil
+}
+
+// SVSLOGIN <nick> <account|*>
+
+type SvsLoginCommand struct {
+	BaseCommand
+	target  Name
+	account Name
+}
+
+func ParseSvsLoginCommand(args []string) (Command, error) {
+	if len(args) < 2 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &SvsLoginCommand{
+		target: NewName(args[0]),
+	}
+	if args[1] != "*" {
+		cmd.account = NewName(args[1])
+	}
+	return cmd, nil
+}
+
+//
+// server goroutine
+//
+
+// servicesTarget checks that client is services and finds the target.
+func (server *Server) servicesTarget(client *Client, nick Name) *Client {
+	if !client.OperConfig().Services {
+		client.ErrNoPrivileges()
+		return nil
+	}
+	target := server.clients.Get(nick)
+	if target == nil {
+		client.ErrNoSuchNick(nick)
+	} else if target.IsService() {
+		client.ErrNoPrivileges()
+		return nil
+	}
+	return target
+}
+
+func (msg *SvsNickCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	target := server.servicesTarget(client, msg.target)
+	if target == nil {
+		return
+	}
+	if !server.IsNickname(msg.nick) {
+		client.ErrErroneusNickname(msg.nick)
+		return
+	}
+	if other := server.clients.Get(msg.nick); (other != nil) && (other != target) {
+		client.ErrNickNameInUse(msg.nick)
+		return
+	}
+	if target.nick == msg.nick {
+		return
+	}
+
+	server.Audit(client, SVSNICK, target.nick.String(), msg.nick.String())
+	target.ChangeNickname(msg.nick)
+	server.CheckNickOwner(target)
+}
+
+func (msg *SvsModeCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	target := server.servicesTarget(client, msg.nickname)
+	if target == nil {
+		return
+	}
+
+	// services may change any mode but can't make operators
+	changes := make(ModeChanges, 0, len(msg.changes))
+	for _, change := range msg.changes {
+		if (UserModeDefFor(change.mode) == nil) ||
+			((change.op == Add) && (change.mode == Operator)) {
+			continue
+		}
+		if change.mode == HostCloak {
+			if ((change.op == Add) || (change.op == Remove)) &&
+				target.SetCloak(change.op == Add) {
+				changes = append(changes, change)
+			}
+			continue
+		}
+		switch change.op {
+		case Add:
+			if target.flags[change.mode] {
+				continue
+			}
+			target.flags[change.mode] = true
+		case Remove:
+			if !target.flags[change.mode] {
+				continue
+			}
+			delete(target.flags, change.mode)
+		}
+		changes = append(changes, change)
+	}
+
+	if len(changes) > 0 {
+		server.Audit(client, SVSMODE, target.nick.String(), changes.String())
+		target.Reply(RplModeChanges(target, target, changes))
+	}
+}
+
+func (msg *SvsLoginCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	target := server.servicesTarget(client, msg.target)
+	if target == nil {
+		return
+	}
+
+	server.Audit(client, SVSLOGIN, target.nick.String(), msg.account.String())
+	if msg.account == "" {
+		target.Logout()
+	} else {
+		target.Login(msg.account)
+	}
+}
+
+// Logout ends the client's association with its account, undoing the
+// vhost, settings and metadata that Login gave it.
+func (client *Client) Logout() {
+	if client.account == "" {
+		return
+	}
+	vhost := client.server.accountVHost(client.account)
+	client.dropCloakSetting()
+	client.account = ""
+	client.settings = make(AccountSettings)
+	if (vhost != "") && (client.hostname == vhost) {
+		client.ChangeHost(client.username, client.hostnameWithoutVHost())
+	}
+	client.logoutMetadata()
+	client.RplLoggedOut()
+	client.server.NotifyServices(client)
+	if client.registered {
+		client.server.CheckNickOwner(client)
+	}
+}
+
+// NotifyServices tells services clients which account client is logged in
+// to.
+func (server *Server) NotifyServices(client *Client) {
+	reply := RplSvsLogin(server, client)
+	for _, member := range server.clients.byNick {
+		if member.OperConfig().Services {
+			member.Reply(reply)
+		}
+	}
+}
+
+// ServicesBurst sends services every client logged in to an account.
+func (server *Server) ServicesBurst(services *Client) {
+	for _, member := range server.clients.byNick {
+		if member.account != "" {
+			services.Reply(RplSvsLogin(server, member))
+		}
+	}
+}
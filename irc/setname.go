@@ -0,0 +1,82 @@
+package irc
+
+import (
+	"log"
+)
+
+// SETNAME :<realname>
+
+type SetNameCommand struct {
+	BaseCommand
+	realname Text
+}
+
+func ParseSetNameCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &SetNameCommand{
+		realname: NewText(args[0]),
+	}, nil
+}
+
+func RplSetName(client *Client, realname Text) string {
+	return NewStringReply(client, SETNAME, ":%s", realname)
+}
+
+//
+// server goroutine
+//
+
+func (msg *SetNameCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	realname, ok := server.checkUTF8(client, msg.Code(), msg.realname, true)
+	if !ok {
+		return
+	}
+	if (realname == "") || (len(realname) > REALNAME_MAX_LEN) {
+		client.Reply(RplFail(server, SETNAME, "INVALID_REALNAME",
+			"Realname is not valid"))
+		return
+	}
+	client.ChangeRealname(realname)
+	if client.account == "" {
+		return
+	}
+	_, err := server.db.Exec(`
+        INSERT OR REPLACE INTO account_realname (account, realname) VALUES (?, ?)`,
+		client.account.String(), realname.String())
+	if err != nil {
+		log.Println("SetNameCommand.HandleServer:", err)
+	}
+}
+
+// ChangeRealname tells the client and the members of its channels that
+// have the setname capability about a new realname.
+func (client *Client) ChangeRealname(realname Text) {
+	if realname == client.realname {
+		return
+	}
+	reply := RplSetName(client, realname)
+	client.realname = realname
+	if !client.registered {
+		return
+	}
+	for friend := range client.Friends() {
+		if friend.capabilities[SetName] {
+			friend.Reply(reply)
+		}
+	}
+}
+
+// accountRealname is the realname last set with SETNAME by account.
+func (server *Server) accountRealname(account Name) Text {
+	var realname string
+	err := server.db.QueryRow(`
+        SELECT realname FROM account_realname WHERE account = ?`,
+		account.String()).Scan(&realname)
+	if err != nil {
+		return ""
+	}
+	return NewText(realname)
+}
@@ -0,0 +1,304 @@
+package irc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Accounts keep settings, changed with NICKSERV SET, which apply to every
+// client logged in to them.
+
+const (
+	SettingLanguage     = "language"       // for replies that are translated
+	SettingAutoAway     = "auto-away"      // away while detached
+	SettingIdentifiedPM = "identified-pms" // only logged in clients may PM
+	SettingCloak        = "cloak"          // always +x
+
+	DEFAULT_CLOAK_SUFFIX = "cloak"
+)
+
+var (
+	LanguageExpr = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{1,8})?$`)
+)
+
+// AccountSettingDef describes a setting. Flags are "on" or "off"; other
+// settings are checked against expr.
+type AccountSettingDef struct {
+	name  string
+	flag  bool
+	expr  *regexp.Regexp
+	value string // default
+	help  string
+}
+
+var (
+	AccountSettingDefs = []*AccountSettingDef{
+		{SettingLanguage, false, LanguageExpr, "en",
+			"language of server messages"},
+		{SettingAutoAway, true, nil, "off",
+			"marked away while an always-on session is detached"},
+		{SettingIdentifiedPM, true, nil, "off",
+			"private messages only from clients logged in to an account"},
+		{SettingCloak, true, nil, "off",
+			"hostname cloaked (+x) whenever logged in"},
+	}
+)
+
+func AccountSettingDefFor(name string) *AccountSettingDef {
+	for _, def := range AccountSettingDefs {
+		if def.name == name {
+			return def
+		}
+	}
+	return nil
+}
+
+// normalize checks a value, returning the form it is saved in.
+func (def *AccountSettingDef) normalize(value string) (string, bool) {
+	if def.flag {
+		switch strings.ToLower(value) {
+		case "on", "true", "yes", "1":
+			return "on", true
+		case "off", "false", "no", "0":
+			return "off", true
+		}
+		return "", false
+	}
+	return value, def.expr.MatchString(value)
+}
+
+// AccountSettings maps setting names to values; missing ones have their
+// default.
+type AccountSettings map[string]string
+
+func (settings AccountSettings) Get(name string) string {
+	if value, ok := settings[name]; ok {
+		return value
+	}
+	if def := AccountSettingDefFor(name); def != nil {
+		return def.value
+	}
+	return ""
+}
+
+func (settings AccountSettings) Flag(name string) bool {
+	return settings.Get(name) == "on"
+}
+
+type CloakConfig struct {
+	// keyed hash of the real hostname; without a secret, cloaks change
+	// when the server restarts
+	Secret string
+	Suffix string
+}
+
+func (conf *CloakConfig) validate() {
+	if conf.Suffix == "" {
+		conf.Suffix = DEFAULT_CLOAK_SUFFIX
+	}
+	if conf.Secret == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			panic(err)
+		}
+		conf.Secret = hex.EncodeToString(random)
+	}
+}
+
+// Cloak hides hostname behind two groups of the keyed hash of it.
+func (conf *CloakConfig) Cloak(hostname Name) Name {
+	mac := hmac.New(sha256.New, []byte(conf.Secret))
+	mac.Write([]byte(hostname.ToLower()))
+	sum := hex.EncodeToString(mac.Sum(nil))
+	return NewName(fmt.Sprintf("%s.%s.%s", sum[:8], sum[8:16], conf.Suffix))
+}
+
+// NICKSERV SET [<setting> [<value>]]
+//
+// Without a setting, lists them; leaving out the value resets the setting.
+
+type NickServSetCommand struct {
+	BaseCommand
+	setting string
+	value   string
+	reset   bool
+}
+
+func ParseNickServSetCommand(args []string) (Command, error) {
+	cmd := &NickServSetCommand{}
+	if len(args) > 0 {
+		cmd.setting = strings.ToLower(args[0])
+		cmd.reset = len(args) < 2
+		if !cmd.reset {
+			cmd.value = strings.Join(args[1:], " ")
+		}
+	}
+	return cmd, nil
+}
+
+//
+// server goroutine
+//
+
+func (msg *NickServSetCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if client.account == "" {
+		client.Notice("you must be logged in to change settings")
+		return
+	}
+	if msg.setting == "" {
+		for _, def := range AccountSettingDefs {
+			client.Notice("%s: %s (%s)", def.name, client.settings.Get(def.name), def.help)
+		}
+		return
+	}
+
+	def := AccountSettingDefFor(msg.setting)
+	if def == nil {
+		client.Notice("there's no setting %s", msg.setting)
+		return
+	}
+	value := def.value
+	if !msg.reset {
+		var ok bool
+		value, ok = def.normalize(msg.value)
+		if ok && (def.name == SettingLanguage) {
+			ok = server.languages.Has(value)
+		}
+		if !ok {
+			client.Notice("%s isn't a valid %s", msg.value, def.name)
+			return
+		}
+	}
+	if err := server.saveAccountSetting(client.account, def, value); err != nil {
+		log.Println("NickServSetCommand.HandleServer:", err)
+		return
+	}
+
+	for _, other := range server.clients.byNick {
+		if other.account.ToLower() == client.account.ToLower() {
+			other.SetAccountSetting(def.name, value)
+		}
+	}
+	client.Notice("%s is now %s", def.name, value)
+}
+
+func (server *Server) saveAccountSetting(account Name, def *AccountSettingDef,
+	value string) error {
+	if value == def.value {
+		_, err := server.db.Exec(`
+            DELETE FROM account_setting WHERE account = ? AND name = ?`,
+			account.String(), def.name)
+		return err
+	}
+	_, err := server.db.Exec(`
+        INSERT INTO account_setting (account, name, value) VALUES (?, ?, ?)`,
+		account.String(), def.name, value)
+	return err
+}
+
+func (server *Server) accountSettings(account Name) AccountSettings {
+	settings := make(AccountSettings)
+	rows, err := server.db.Query(`
+        SELECT name, value FROM account_setting WHERE account = ?`,
+		account.String())
+	if err != nil {
+		log.Println("Server.accountSettings:", err)
+		return settings
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			log.Println("Server.accountSettings:", err)
+			continue
+		}
+		settings[name] = value
+	}
+	return settings
+}
+
+// SetAccountSetting changes a setting of client's account and applies
+// it.
+func (client *Client) SetAccountSetting(name string, value string) {
+	client.settings[name] = value
+	if name == SettingCloak {
+		client.applyCloakSetting()
+	}
+}
+
+// applyCloakSetting adds +x if the account always has it.
+func (client *Client) applyCloakSetting() {
+	if !client.settings.Flag(SettingCloak) || !client.SetCloak(true) ||
+		!client.registered {
+		return
+	}
+	client.Reply(RplModeChanges(client, client, ModeChanges{&ModeChange{
+		mode: HostCloak,
+		op:   Add,
+	}}))
+}
+
+// dropCloakSetting removes the +x that applyCloakSetting added, for a
+// client logging out.
+func (client *Client) dropCloakSetting() {
+	if !client.settings.Flag(SettingCloak) || !client.SetCloak(false) ||
+		!client.registered {
+		return
+	}
+	client.Reply(RplModeChanges(client, client, ModeChanges{&ModeChange{
+		mode: HostCloak,
+		op:   Remove,
+	}}))
+}
+
+// SetCloak adds or removes +x, reporting whether it changed. A vhost is
+// kept either way.
+func (client *Client) SetCloak(on bool) bool {
+	if on == client.flags[HostCloak] {
+		return false
+	}
+	cloak := client.server.cloaks.Cloak(client.realHostname)
+	if on {
+		client.flags[HostCloak] = true
+		if (client.account == "") ||
+			(client.server.accountVHost(client.account) == "") {
+			client.ChangeHost(client.username, cloak)
+		}
+	} else {
+		delete(client.flags, HostCloak)
+		if client.hostname == cloak {
+			client.ChangeHost(client.username, client.realHostname)
+		}
+	}
+	return true
+}
+
+// AutoAway marks a detaching client away, if its account asks for it, or
+// brings it back when it attaches.
+func (client *Client) AutoAway(away bool) {
+	if away == client.autoAway {
+		return
+	}
+	if away {
+		if client.flags[Away] || !client.settings.Flag(SettingAutoAway) {
+			return
+		}
+		client.flags[Away] = true
+		client.awayTime = time.Now()
+		client.awayMessage = NewText("Detached")
+	} else {
+		delete(client.flags, Away)
+		client.awayTime = time.Time{}
+		client.awayMessage = ""
+	}
+	client.autoAway = away
+	client.NotifyAway()
+}
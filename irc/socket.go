@@ -2,45 +2,98 @@ package irc
 
 import (
 	"bufio"
+	"errors"
 	"io"
 	"net"
+	"sync"
+	"time"
 )
 
 const (
 	R = '→'
 	W = '←'
+
+	DEFAULT_SENDQ = 256 * 1024 // bytes queued for a client before it is dropped
+	WRITE_BUFFER  = 4096
+	WRITE_TIMEOUT = time.Minute
+)
+
+var (
+	ErrSendQExceeded = errors.New("SendQ exceeded")
+
+	// Write buffers are only needed while lines are being written, so
+	// idle connections don't each hold one.
+	writerPool = sync.Pool{
+		New: func() interface{} {
+			return bufio.NewWriterSize(nil, WRITE_BUFFER)
+		},
+	}
 )
 
+// Socket reads lines in the client goroutine and writes them in a writer
+// goroutine, which only runs while there are lines queued. Writes are
+// queued so that a slow client can't block the server goroutine; once
+// more than sendQ bytes are waiting the connection is dropped.
 type Socket struct {
 	closed  bool
 	conn    net.Conn
 	scanner *bufio.Scanner
-	writer  *bufio.Writer
+
+	lock    sync.Mutex
+	queue   []string
+	queued  int
+	sendQ   int
+	writing bool // the writer goroutine is running
 }
 
-func NewSocket(conn net.Conn) *Socket {
-	return &Socket{
+func NewSocket(conn net.Conn, sendQ int, recvQ int) *Socket {
+	if sendQ <= 0 {
+		sendQ = DEFAULT_SENDQ
+	}
+	if recvQ <= 0 {
+		recvQ = DEFAULT_RECVQ
+	}
+	socket := &Socket{
 		conn:    conn,
 		scanner: bufio.NewScanner(conn),
-		writer:  bufio.NewWriter(conn),
+		sendQ:   sendQ,
 	}
+	// lines longer than recvQ end the connection
+	socket.scanner.Buffer(make([]byte, 0, MAX_REPLY_LEN), recvQ)
+	return socket
 }
 
 func (socket *Socket) String() string {
 	return socket.conn.RemoteAddr().String()
 }
 
+// Queued is how many lines, and bytes, are waiting to be written.
+func (socket *Socket) Queued() (lines int, bytes int) {
+	socket.lock.Lock()
+	defer socket.lock.Unlock()
+	return len(socket.queue), socket.queued
+}
+
+func (socket *Socket) isClosed() bool {
+	socket.lock.Lock()
+	defer socket.lock.Unlock()
+	return socket.closed
+}
+
+// Close stops accepting writes. Lines already queued are flushed by the
+// writer goroutine before the connection is closed.
 func (socket *Socket) Close() {
+	socket.lock.Lock()
+	defer socket.lock.Unlock()
 	if socket.closed {
 		return
 	}
 	socket.closed = true
-	socket.conn.Close()
-	Log.debug.Printf("%s closed", socket)
+	socket.notify()
 }
 
 func (socket *Socket) Read() (line string, err error) {
-	if socket.closed {
+	if socket.isClosed() {
 		err = io.EOF
 		return
 	}
@@ -62,25 +115,108 @@ func (socket *Socket) Read() (line string, err error) {
 	return
 }
 
+// Write queues a line for the writer goroutine. It never blocks on the
+// network.
 func (socket *Socket) Write(line string) (err error) {
+	socket.lock.Lock()
+	defer socket.lock.Unlock()
+
 	if socket.closed {
 		err = io.EOF
 		return
 	}
 
-	if _, err = socket.writer.WriteString(line); socket.isError(err, W) {
+	socket.queued += len(line) + len(CRLF)
+	if socket.queued > socket.sendQ {
+		Log.info.Printf("%s %s: %d bytes", socket, ErrSendQExceeded, socket.queued)
+		// Drop whatever is queued; the reader will notice the closed
+		// connection and quit the client.
+		socket.closed = true
+		socket.queue = nil
+		socket.conn.Close()
+		socket.notify()
+		err = ErrSendQExceeded
 		return
 	}
 
-	if _, err = socket.writer.WriteString(CRLF); socket.isError(err, W) {
-		return
+	socket.queue = append(socket.queue, line)
+	socket.notify()
+	return
+}
+
+// notify starts the writer goroutine if it isn't running. The lock must
+// be held.
+func (socket *Socket) notify() {
+	if !socket.writing {
+		socket.writing = true
+		go socket.writeLoop()
 	}
+}
 
-	if err = socket.writer.Flush(); socket.isError(err, W) {
-		return
+//
+// writer goroutine
+//
+
+// writeLoop writes until the queue is empty, then exits; the next Write
+// starts it again.
+func (socket *Socket) writeLoop() {
+	for {
+		socket.lock.Lock()
+		lines, closed := socket.queue, socket.closed
+		socket.queue = nil
+		if (len(lines) == 0) && !closed {
+			socket.writing = false
+			socket.lock.Unlock()
+			return
+		}
+		socket.lock.Unlock()
+
+		err := socket.writeLines(lines)
+
+		socket.lock.Lock()
+		socket.queued = 0
+		for _, line := range socket.queue {
+			socket.queued += len(line) + len(CRLF)
+		}
+		if err != nil {
+			socket.closed = true
+		}
+		socket.lock.Unlock()
+
+		if closed || (err != nil) {
+			break
+		}
 	}
 
-	Log.debug.Printf("%s ← %s", socket, line)
+	socket.conn.Close()
+	Log.debug.Printf("%s closed", socket)
+}
+
+func (socket *Socket) writeLines(lines []string) (err error) {
+	writer := writerPool.Get().(*bufio.Writer)
+	writer.Reset(socket.conn)
+	defer func() {
+		writer.Reset(nil)
+		writerPool.Put(writer)
+	}()
+
+	// a peer that stopped reading mustn't hold the writer forever
+	socket.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	for _, line := range lines {
+		if _, err = writer.WriteString(line); socket.isError(err, W) {
+			return
+		}
+
+		if _, err = writer.WriteString(CRLF); socket.isError(err, W) {
+			return
+		}
+
+		Log.debug.Printf("%s ← %s", socket, line)
+	}
+
+	if err = writer.Flush(); socket.isError(err, W) {
+		return
+	}
 	return
 }
 
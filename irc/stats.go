@@ -0,0 +1,101 @@
+package irc
+
+import (
+	"time"
+)
+
+// StatsQuery is a STATS letter.
+type StatsQuery rune
+
+func (query StatsQuery) String() string {
+	return string(query)
+}
+
+const (
+	StatsDLines    StatsQuery = 'd'
+	StatsFilters   StatsQuery = 'f'
+	StatsCountries StatsQuery = 'g'
+	StatsKLines    StatsQuery = 'k'
+	StatsCommands  StatsQuery = 'm'
+	StatsOperators StatsQuery = 'o'
+	StatsPorts     StatsQuery = 'p'
+	StatsUptime    StatsQuery = 'u'
+	StatsClasses   StatsQuery = 'y'
+)
+
+// StatsDef says who may run a STATS query and how to answer it.
+type StatsDef struct {
+	query    StatsQuery
+	operOnly bool
+	reply    func(*Server, *Client)
+}
+
+var (
+	StatsDefs = []*StatsDef{
+		// no D-lines are kept; the query returns an empty list
+		{StatsDLines, true, func(*Server, *Client) {}},
+		{StatsFilters, true, (*Server).statsFilters},
+		{StatsCountries, true, (*Server).statsCountries},
+		{StatsKLines, true, (*Server).statsKLines},
+		{StatsCommands, true, (*Server).statsCommands},
+		{StatsOperators, true, (*Server).statsOperators},
+		{StatsPorts, true, (*Server).statsPorts},
+		{StatsUptime, false, (*Server).statsUptime},
+		{StatsClasses, true, (*Server).statsClasses},
+	}
+)
+
+func StatsDefFor(query StatsQuery) *StatsDef {
+	for _, def := range StatsDefs {
+		if def.query == query {
+			return def
+		}
+	}
+	return nil
+}
+
+func (msg *StatsCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if (msg.target != "") && (msg.target != server.name) {
+		client.ErrNoSuchServer(msg.target)
+		return
+	}
+
+	def := StatsDefFor(msg.query)
+	if (def != nil) && def.operOnly && !client.flags[Operator] {
+		client.ErrNoPrivileges()
+		return
+	}
+	if def != nil {
+		def.reply(server, client)
+	}
+	client.RplEndOfStats(msg.query)
+}
+
+func (server *Server) statsCommands(client *Client) {
+	for _, code := range server.commandCodes() {
+		client.RplStatsCommands(code, server.commandStats[StringCode(code)])
+	}
+}
+
+func (server *Server) statsOperators(client *Client) {
+	for name := range server.operators {
+		client.RplStatsOLine(name)
+	}
+}
+
+func (server *Server) statsPorts(client *Client) {
+	for _, listener := range server.listeners {
+		client.RplStatsPLine(listener.String())
+	}
+}
+
+func (server *Server) statsClasses(client *Client) {
+	for _, class := range server.classes {
+		client.RplStatsYLine(class)
+	}
+}
+
+func (server *Server) statsUptime(client *Client) {
+	client.RplStatsUptime(time.Since(server.ctime))
+}
@@ -3,14 +3,22 @@ package irc
 import (
 	"regexp"
 	"strings"
+	"unicode/utf8"
 
+	"golang.org/x/text/secure/precis"
 	"golang.org/x/text/unicode/norm"
 )
 
 var (
 	// regexps
-	ChannelNameExpr = regexp.MustCompile(`^[&!#+][\pL\pN]{1,63}$`)
-	NicknameExpr    = regexp.MustCompile("^[\\pL\\pN\\pP\\pS]{1,32}$")
+	// lengths are checked against the configured limits
+	ChannelNameExpr = regexp.MustCompile(`^[&!#+][\pL\pN]+$`)
+	NicknameExpr    = regexp.MustCompile("^[\\pL\\pN\\pP\\pS]+$")
+	VHostExpr       = regexp.MustCompile(`^[A-Za-z0-9/-]+(\.[A-Za-z0-9/-]+)*$`)
+
+	// mIRC and hex colors and the bold, italic, underline, reverse,
+	// monospace, strikethrough and reset controls
+	FormattingExpr = regexp.MustCompile("\x03([0-9]{1,2}(,[0-9]{1,2})?)?|\x04([0-9a-fA-F]{6}(,[0-9a-fA-F]{6})?)?|[\x02\x0f\x11\x16\x1d\x1e\x1f]")
 )
 
 // Names are normalized and canonicalized to remove formatting marks
@@ -29,6 +37,28 @@ func NewNames(strs []string) []Name {
 	return names
 }
 
+// NamePool interns names that many clients share, such as the hostname
+// of a busy proxy, so that each is kept in memory once. It's only used by
+// the server goroutine, and is emptied when it fills up.
+type NamePool map[Name]Name
+
+const (
+	MAX_POOLED_NAMES = 10000
+)
+
+func (pool NamePool) Intern(name Name) Name {
+	if interned, ok := pool[name]; ok {
+		return interned
+	}
+	if len(pool) >= MAX_POOLED_NAMES {
+		for key := range pool {
+			delete(pool, key)
+		}
+	}
+	pool[name] = name
+	return name
+}
+
 // tests
 
 func (name Name) IsChannel() bool {
@@ -39,11 +69,17 @@ func (name Name) IsNickname() bool {
 	namestr := name.String()
 	// * is used for unregistered clients
 	// , is used as a separator by the protocol
-	// # is a channel prefix
-	// @+ are channel membership prefixes
-	if namestr == "*" || strings.Contains(namestr, ",") || strings.Contains("#@+", string(namestr[0])) {
+	// #& are channel prefixes
+	// ~&@%+ are the default channel membership prefixes; Server.IsNickname
+	// checks for those configured
+	if namestr == "*" || strings.Contains(namestr, ",") || strings.Contains("#&", string(namestr[0])) {
 		return false
 	}
+	for _, member := range DefaultMemberPrefixes {
+		if strings.HasPrefix(namestr, member.prefix) {
+			return false
+		}
+	}
 	return NicknameExpr.MatchString(namestr)
 }
 
@@ -53,8 +89,73 @@ func (name Name) String() string {
 	return string(name)
 }
 
+// ToLower folds the name with the server's casemapping, for use as a
+// lookup key.
 func (name Name) ToLower() Name {
-	return Name(strings.ToLower(name.String()))
+	return Name(ServerCaseMapping.Fold(name.String()))
+}
+
+// CaseMapping decides which names are equal.
+type CaseMapping string
+
+const (
+	CaseMappingASCII   CaseMapping = "ascii"
+	CaseMappingRFC1459 CaseMapping = "rfc1459" // ascii, and []\~ are {}|^
+	CaseMappingPRECIS  CaseMapping = "precis"  // Unicode names, RFC 8265
+)
+
+var (
+	// ServerCaseMapping is set from the config when the server starts.
+	ServerCaseMapping = CaseMappingASCII
+
+	rfc1459Folder = strings.NewReplacer("[", "{", "]", "}", "\\", "|", "~", "^")
+)
+
+func (mapping CaseMapping) Fold(str string) string {
+	switch mapping {
+	case CaseMappingRFC1459:
+		return rfc1459Folder.Replace(asciiToLower(str))
+
+	case CaseMappingPRECIS:
+		folded, err := precis.UsernameCaseMapped.String(str)
+		if err != nil {
+			// not a valid PRECIS identifier; still fold it sensibly
+			return strings.ToLower(str)
+		}
+		return folded
+	}
+	return asciiToLower(str)
+}
+
+// Token is the RPL_ISUPPORT CASEMAPPING value.
+func (mapping CaseMapping) Token() string {
+	if mapping == CaseMappingPRECIS {
+		return "rfc8265"
+	}
+	return string(mapping)
+}
+
+// AllowsUnicode says whether nicknames may use non-ASCII characters.
+func (mapping CaseMapping) AllowsUnicode() bool {
+	return mapping == CaseMappingPRECIS
+}
+
+func asciiToLower(str string) string {
+	return strings.Map(func(r rune) rune {
+		if ('A' <= r) && (r <= 'Z') {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, str)
+}
+
+func isASCII(str string) bool {
+	for index := 0; index < len(str); index += 1 {
+		if str[index] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
 }
 
 // It's safe to coerce a Name to Text. Name is a strict subset of Text.
@@ -74,6 +175,45 @@ func (text Text) String() string {
 	return string(text)
 }
 
+// Truncate shortens the text to at most max bytes without splitting a
+// UTF-8 sequence.
+func (text Text) Truncate(max int) Text {
+	if len(text) <= max {
+		return text
+	}
+	for (max > 0) && !utf8.RuneStart(text[max]) {
+		max -= 1
+	}
+	return text[:max]
+}
+
+// StripFormatting removes color and other formatting codes.
+func (text Text) StripFormatting() Text {
+	return Text(FormattingExpr.ReplaceAllString(text.String(), ""))
+}
+
+// Wrap splits the text into lines of at most width characters, breaking
+// at spaces where possible.
+func (text Text) Wrap(width int) []Text {
+	lines := make([]Text, 0, 1)
+	runes := []rune(text.String())
+	for len(runes) > width {
+		split := width
+		for index := width; index > 0; index -= 1 {
+			if runes[index] == ' ' {
+				split = index
+				break
+			}
+		}
+		lines = append(lines, Text(runes[:split]))
+		runes = runes[split:]
+		if runes[0] == ' ' {
+			runes = runes[1:]
+		}
+	}
+	return append(lines, Text(runes))
+}
+
 // CTCPText is text suitably escaped for CTCP.
 type CTCPText string
 
@@ -0,0 +1,175 @@
+package irc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags are IRCv3 message tags. A tag without a value maps to "".
+type Tags map[string]string
+
+var (
+	tagEscaper = strings.NewReplacer("\\", "\\\\", ";", "\\:", " ", "\\s",
+		"\r", "\\r", "\n", "\\n")
+	tagUnescaper = strings.NewReplacer("\\\\", "\\", "\\:", ";", "\\s", " ",
+		"\\r", "\r", "\\n", "\n", "\\", "")
+)
+
+const (
+	BOT_TAG    = "draft/bot"
+	MSGID_TAG  = "msgid"
+	TYPING_TAG = "+typing"
+
+	DEFAULT_TYPING_INTERVAL = 3 * time.Second
+	TYPING_TARGETS          = 16 // targets remembered before pruning
+)
+
+// TypingConfig controls relaying +typing notifications, which clients
+// send every few seconds while their user types.
+type TypingConfig struct {
+	Relay bool
+	// a client's notifications to a target are dropped within this
+	// interval of the last one relayed
+	Interval time.Duration
+}
+
+// IsClientOnlyTag is true for tags such as +draft/reply, which the server
+// passes between clients without understanding them.
+func IsClientOnlyTag(key string) bool {
+	return strings.HasPrefix(key, "+")
+}
+
+// MessageTags are the tags on a client's PRIVMSGs and NOTICEs: the
+// client-only tags the client sent them with, a new msgid, and those the
+// server adds. +typing is only relayed on TAGMSG, after filterTyping.
+func (client *Client) MessageTags(sent Tags) Tags {
+	tags := make(Tags)
+	for key, value := range sent {
+		if IsClientOnlyTag(key) && (key != TYPING_TAG) {
+			tags[key] = value
+		}
+	}
+	tags[MSGID_TAG] = client.server.NewMsgID()
+	if client.flags[Bot] {
+		tags[BOT_TAG] = ""
+	}
+	client.addProfileTags(tags)
+	return tags
+}
+
+// TagMsgTags are the tags on a client's TAGMSG: its message tags and the
+// +typing tag, if filterTyping left it.
+func (client *Client) TagMsgTags(sent Tags) Tags {
+	tags := client.MessageTags(sent)
+	if value, ok := sent[TYPING_TAG]; ok {
+		tags[TYPING_TAG] = value
+	}
+	return tags
+}
+
+// filterTyping removes a +typing tag that is turned off or sent too soon
+// after the last one to the same target. It reports whether any
+// client-only tags are left to send.
+func (server *Server) filterTyping(client *Client, target Name, tags Tags) bool {
+	if _, ok := tags[TYPING_TAG]; ok {
+		if !server.typing.Relay || !client.allowTyping(target) {
+			delete(tags, TYPING_TAG)
+		}
+	}
+	for key := range tags {
+		if IsClientOnlyTag(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowTyping reports whether a +typing notification to target may be
+// relayed, whatever its state: at most one goes to each target per
+// interval.
+func (client *Client) allowTyping(target Name) bool {
+	interval := client.server.typing.Interval
+	now := time.Now()
+	key := target.ToLower()
+	if last, ok := client.typing[key]; ok && (now.Sub(last) < interval) {
+		return false
+	}
+
+	if len(client.typing) >= TYPING_TARGETS {
+		for other, last := range client.typing {
+			if now.Sub(last) >= interval {
+				delete(client.typing, other)
+			}
+		}
+	}
+	client.typing[key] = now
+	return true
+}
+
+// NewMsgID is unique among the messages of every run of the server.
+func (server *Server) NewMsgID() string {
+	server.msgID += 1
+	return strconv.FormatInt(server.ctime.UnixNano(), 36) + "-" +
+		strconv.FormatUint(server.msgID, 36)
+}
+
+// TagReply adds tags to a reply for a recipient that has message-tags.
+func TagReply(capabilities CapabilitySet, reply string, tags Tags) string {
+	if !capabilities[MessageTags] {
+		return reply
+	}
+	return AddTags(reply, tags)
+}
+
+// ParseTags splits the tags, if any, off the front of a line.
+func ParseTags(line string) (tags Tags, rest string) {
+	if !strings.HasPrefix(line, "@") {
+		return nil, line
+	}
+	tagStr, rest := splitArg(line[len("@"):])
+	tags = make(Tags)
+	for _, tag := range strings.Split(tagStr, ";") {
+		if tag == "" {
+			continue
+		}
+		parts := strings.SplitN(tag, "=", 2)
+		var value string
+		if len(parts) > 1 {
+			value = tagUnescaper.Replace(parts[1])
+		}
+		tags[parts[0]] = value
+	}
+	return tags, rest
+}
+
+func (tags Tags) String() string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for index, key := range keys {
+		if value := tags[key]; value != "" {
+			keys[index] = key + "=" + tagEscaper.Replace(value)
+		}
+	}
+	return strings.Join(keys, ";")
+}
+
+// AddTags puts tags on a line, merging them with any it already has.
+func AddTags(line string, tags Tags) string {
+	if len(tags) == 0 {
+		return line
+	}
+	existing, rest := ParseTags(line)
+	merged := make(Tags, len(existing)+len(tags))
+	for key, value := range existing {
+		merged[key] = value
+	}
+	for key, value := range tags {
+		merged[key] = value
+	}
+	return "@" + merged.String() + " " + rest
+}
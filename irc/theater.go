@@ -1,5 +1,76 @@
 package irc
 
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A theater is a channel from the config in which one member at a time,
+// its director (+T), may speak as other nicks: the actors. Directors
+// identify with the theater's password, or without one if their account
+// was given access.
+
+type TheaterConfig struct {
+	PassConfig `yaml:",inline"`
+	// file the theater's lines are appended to
+	Transcript string
+}
+
+type TheaterStage struct {
+	name       Name
+	password   []byte
+	transcript *os.File
+	actors     map[Name]Name // by folded name
+}
+
+func NewTheaterStage(name Name, conf *TheaterConfig) *TheaterStage {
+	theater := &TheaterStage{
+		name:   name,
+		actors: make(map[Name]Name),
+	}
+	if conf.Password != "" {
+		theater.password = conf.PasswordBytes()
+	}
+	if conf.Transcript != "" {
+		file, err := os.OpenFile(conf.Transcript,
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal("theater transcript error: ", err)
+		}
+		theater.transcript = file
+	}
+	return theater
+}
+
+// Log appends a line spoken in the theater to its transcript.
+func (theater *TheaterStage) Log(nick Name, message Text) {
+	if theater.transcript == nil {
+		return
+	}
+	_, err := fmt.Fprintf(theater.transcript, "%s <%s> %s\n",
+		time.Now().UTC().Format(time.RFC3339), nick, message)
+	if err != nil {
+		log.Println("TheaterStage.Log:", err)
+	}
+}
+
+func (theater *TheaterStage) addActor(nick Name) {
+	theater.actors[nick.ToLower()] = nick
+}
+
+func (theater *TheaterStage) Actors() []string {
+	actors := make([]string, 0, len(theater.actors))
+	for _, actor := range theater.actors {
+		actors = append(actors, actor.String())
+	}
+	sort.Strings(actors)
+	return actors
+}
+
 type TheaterClient Name
 
 func (c TheaterClient) Id() Name {
@@ -22,33 +93,126 @@ type TheaterIdentifyCommand struct {
 }
 
 func (m *TheaterIdentifyCommand) LoadPassword(s *Server) {
-	m.hash = s.theaters[m.channel]
+	if theater := s.theaters[m.channel.ToLower()]; theater != nil {
+		m.hash = theater.password
+	}
+}
+
+func (m *TheaterIdentifyCommand) CheckPassword() {
+	if len(m.password) > 0 {
+		m.PassCommand.CheckPassword()
+	}
+}
+
+//
+// server goroutine
+//
+
+// theaterChannel finds the theater and its channel for a THEATER command,
+// telling client if there isn't one.
+func (client *Client) theaterChannel(name Name) (*TheaterStage, *Channel) {
+	if !name.IsChannel() {
+		client.ErrNoSuchChannel(name)
+		return nil, nil
+	}
+	channel := client.server.channels.Get(name)
+	theater := client.server.theaters[name.ToLower()]
+	if (channel == nil) || (theater == nil) {
+		client.ErrNoSuchChannel(name)
+		return nil, nil
+	}
+	return theater, channel
+}
+
+// Theater is the channel's theater, if it is one.
+func (channel *Channel) Theater() *TheaterStage {
+	return channel.server.theaters[channel.name.ToLower()]
+}
+
+// Director is the member who is +T, if any.
+func (channel *Channel) Director() *Client {
+	for member, membership := range channel.members {
+		if membership.Has(Theater) {
+			return member
+		}
+	}
+	return nil
+}
+
+func (server *Server) theaterAccess(theater *TheaterStage) []string {
+	accounts := make([]string, 0)
+	rows, err := server.db.Query(`
+        SELECT account FROM theater_access WHERE channel = ? ORDER BY account`,
+		theater.name.String())
+	if err != nil {
+		log.Println("Server.theaterAccess:", err)
+		return accounts
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var account string
+		if err := rows.Scan(&account); err != nil {
+			log.Println("Server.theaterAccess:", err)
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+func (server *Server) hasTheaterAccess(theater *TheaterStage, account Name) bool {
+	var found int
+	err := server.db.QueryRow(`
+        SELECT 1 FROM theater_access WHERE channel = ? AND account = ?`,
+		theater.name.String(), account.String()).Scan(&found)
+	return err == nil
+}
+
+func (server *Server) setTheaterAccess(theater *TheaterStage, account Name,
+	op ModeOp) (err error) {
+	switch op {
+	case Add:
+		_, err = server.db.Exec(`
+            INSERT INTO theater_access (channel, account) VALUES (?, ?)`,
+			theater.name.String(), account.String())
+	case Remove:
+		_, err = server.db.Exec(`
+            DELETE FROM theater_access WHERE channel = ? AND account = ?`,
+			theater.name.String(), account.String())
+	}
+	return
 }
 
 func (m *TheaterIdentifyCommand) HandleServer(s *Server) {
 	client := m.Client()
-	if !m.channel.IsChannel() {
-		client.ErrNoSuchChannel(m.channel)
+	theater, channel := client.theaterChannel(m.channel)
+	if theater == nil {
 		return
 	}
 
-	channel := s.channels.Get(m.channel)
-	if channel == nil {
-		client.ErrNoSuchChannel(m.channel)
+	if len(m.password) == 0 {
+		if !client.flags[Operator] && ((client.account == "") ||
+			!s.hasTheaterAccess(theater, client.account)) {
+			client.ErrPasswdMismatch()
+			return
+		}
+	} else if (m.hash == nil) || (m.err != nil) {
+		client.ErrPasswdMismatch()
 		return
 	}
 
-	if (m.hash == nil) || (m.err != nil) {
-		client.ErrPasswdMismatch()
+	if !channel.members.Has(client) {
+		client.ErrNotOnChannel(channel)
 		return
 	}
 
 	if channel.members.AnyHasMode(Theater) {
-		client.Reply(RplNotice(s, client, "someone else is +T in this channel"))
+		client.Notice("someone else is +T in this channel")
 		return
 	}
 
-	channel.members[client][Theater] = true
+	channel.members[client].Set(Theater, true)
+	client.Notice("you are now directing %s", channel)
 }
 
 type TheaterPrivMsgCommand struct {
@@ -60,27 +224,21 @@ type TheaterPrivMsgCommand struct {
 
 func (m *TheaterPrivMsgCommand) HandleServer(s *Server) {
 	client := m.Client()
-
-	if !m.channel.IsChannel() {
-		client.ErrNoSuchChannel(m.channel)
-		return
-	}
-
-	channel := s.channels.Get(m.channel)
-	if channel == nil {
-		client.ErrNoSuchChannel(m.channel)
+	theater, channel := client.theaterChannel(m.channel)
+	if theater == nil {
 		return
 	}
 
 	if !channel.members.HasMode(client, Theater) {
-		client.Reply(RplNotice(s, client, "you are not +T"))
+		client.Notice("you are not +T")
 		return
 	}
 
-	reply := RplPrivMsg(TheaterClient(m.asNick), channel, m.message)
-	for member := range channel.members {
-		member.Reply(reply)
-	}
+	theater.addActor(m.asNick)
+	theater.Log(m.asNick, m.message)
+	channel.Broadcast(nil, func(CapabilitySet) string {
+		return RplPrivMsg(TheaterClient(m.asNick), channel, m.message)
+	})
 }
 
 type TheaterActionCommand struct {
@@ -92,25 +250,134 @@ type TheaterActionCommand struct {
 
 func (m *TheaterActionCommand) HandleServer(s *Server) {
 	client := m.Client()
+	theater, channel := client.theaterChannel(m.channel)
+	if theater == nil {
+		return
+	}
+
+	if !channel.members.HasMode(client, Theater) {
+		client.Notice("you are not +T")
+		return
+	}
+
+	theater.addActor(m.asNick)
+	theater.Log(m.asNick, NewText("* "+string(m.action)))
+	channel.Broadcast(nil, func(CapabilitySet) string {
+		return RplCTCPAction(TheaterClient(m.asNick), channel, m.action)
+	})
+}
+
+// THEATER ACTORS <channel>
+
+type TheaterActorsCommand struct {
+	BaseCommand
+	channel Name
+}
 
-	if !m.channel.IsChannel() {
-		client.ErrNoSuchChannel(m.channel)
+func (m *TheaterActorsCommand) HandleServer(s *Server) {
+	client := m.Client()
+	theater, channel := client.theaterChannel(m.channel)
+	if theater == nil {
 		return
 	}
 
-	channel := s.channels.Get(m.channel)
-	if channel == nil {
-		client.ErrNoSuchChannel(m.channel)
+	director := "nobody"
+	if member := channel.Director(); member != nil {
+		director = member.Nick().String()
+	}
+	client.Notice("%s is directed by %s", channel, director)
+	actors := theater.Actors()
+	if len(actors) == 0 {
+		client.Notice("%s has no actors", channel)
+		return
+	}
+	client.Notice("%s actors: %s", channel, strings.Join(actors, ", "))
+}
+
+// THEATER HANDOFF <channel> <nick>
+//
+// The director passes +T to another member.
+
+type TheaterHandoffCommand struct {
+	BaseCommand
+	channel Name
+	nick    Name
+}
+
+func (m *TheaterHandoffCommand) HandleServer(s *Server) {
+	client := m.Client()
+	theater, channel := client.theaterChannel(m.channel)
+	if theater == nil {
 		return
 	}
 
 	if !channel.members.HasMode(client, Theater) {
-		client.Reply(RplNotice(s, client, "you are not +T"))
+		client.Notice("you are not +T")
 		return
 	}
 
-	reply := RplCTCPAction(TheaterClient(m.asNick), channel, m.action)
-	for member := range channel.members {
-		member.Reply(reply)
+	target := s.clients.Get(m.nick)
+	if target == nil {
+		client.ErrNoSuchNick(m.nick)
+		return
+	}
+	if !channel.members.Has(target) {
+		client.ErrUserNotInChannel(channel, target)
+		return
+	}
+
+	channel.members[client].Set(Theater, false)
+	channel.members[target].Set(Theater, true)
+	client.Notice("%s is now directing %s", target.nick, channel)
+	target.Notice("%s handed you %s; you are now directing it", client.nick,
+		channel)
+}
+
+// THEATER ACCESS <channel> [{ADD|DEL} <account>]
+//
+// Accounts with access may identify as the director without the password.
+// Operators and the director may change the list.
+
+type TheaterAccessCommand struct {
+	BaseCommand
+	channel Name
+	op      ModeOp
+	account Name
+}
+
+func (m *TheaterAccessCommand) HandleServer(s *Server) {
+	client := m.Client()
+	theater, channel := client.theaterChannel(m.channel)
+	if theater == nil {
+		return
+	}
+
+	if !client.flags[Operator] && !channel.members.HasMode(client, Theater) {
+		client.ErrChanOPrivIsNeeded(channel)
+		return
+	}
+
+	if m.op == List {
+		accounts := s.theaterAccess(theater)
+		if len(accounts) == 0 {
+			client.Notice("no accounts have access to %s", channel)
+			return
+		}
+		client.Notice("%s access: %s", channel, strings.Join(accounts, ", "))
+		return
+	}
+
+	if (m.op == Add) && !s.isAccount(m.account) {
+		client.Notice("%s isn't registered", m.account)
+		return
+	}
+	if err := s.setTheaterAccess(theater, m.account, m.op); err != nil {
+		log.Println("TheaterAccessCommand.HandleServer:", err)
+		return
+	}
+	if m.op == Add {
+		client.Notice("%s now has access to %s", m.account, channel)
+	} else {
+		client.Notice("%s no longer has access to %s", m.account, channel)
 	}
 }
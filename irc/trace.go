@@ -0,0 +1,84 @@
+package irc
+
+import (
+	"sort"
+)
+
+// TRACE [<target>]
+//
+// Operators are shown every connection, or just the target's, and the
+// connection classes; everyone else only sees the operators.
+
+type TraceCommand struct {
+	BaseCommand
+	target Name
+}
+
+func ParseTraceCommand(args []string) (Command, error) {
+	cmd := &TraceCommand{}
+	if len(args) > 0 {
+		cmd.target = NewName(args[0])
+	}
+	return cmd, nil
+}
+
+// MAP
+
+type MapCommand struct {
+	BaseCommand
+}
+
+func ParseMapCommand(args []string) (Command, error) {
+	return &MapCommand{}, nil
+}
+
+//
+// server goroutine
+//
+
+func (msg *TraceCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	oper := client.flags[Operator]
+
+	if (msg.target != "") && (msg.target.ToLower() != server.name.ToLower()) {
+		target := server.clients.Get(msg.target)
+		if (target == nil) || (!oper && !target.flags[Operator]) {
+			client.ErrNoSuchServer(msg.target)
+			return
+		}
+		client.RplTrace(target)
+		client.RplTraceEnd()
+		return
+	}
+
+	nicks := make([]string, 0, len(server.clients.byNick))
+	for nick := range server.clients.byNick {
+		nicks = append(nicks, nick.String())
+	}
+	sort.Strings(nicks)
+	for _, nick := range nicks {
+		member := server.clients.byNick[Name(nick)]
+		if oper || member.flags[Operator] {
+			client.RplTrace(member)
+		}
+	}
+	if oper {
+		for _, class := range server.classes {
+			client.RplTraceClass(class)
+		}
+	}
+	client.RplTraceEnd()
+}
+
+func (msg *MapCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	users := 0
+	for _, member := range server.clients.byNick {
+		if member.registered && !member.IsService() {
+			users += 1
+		}
+	}
+	// there are no links yet, so the map is just this server
+	client.RplMap(server.name, users)
+	client.RplMapEnd()
+}
@@ -60,36 +60,11 @@ func (clients ClientSet) Has(client *Client) bool {
 	return clients[client]
 }
 
-type MemberSet map[*Client]ChannelModeSet
-
-func (members MemberSet) Add(member *Client) {
-	members[member] = make(ChannelModeSet)
-}
-
-func (members MemberSet) Remove(member *Client) {
-	delete(members, member)
-}
-
-func (members MemberSet) Has(member *Client) bool {
-	_, ok := members[member]
-	return ok
-}
-
-func (members MemberSet) HasMode(member *Client, mode ChannelMode) bool {
-	modes, ok := members[member]
-	if !ok {
-		return false
-	}
-	return modes[mode]
-}
-
-func (members MemberSet) AnyHasMode(mode ChannelMode) bool {
-	for _, modes := range members {
-		if modes[mode] {
-			return true
-		}
+func (clients ClientSet) Broadcast(render ReplyRenderer) {
+	replies := NewReplyCache(render)
+	for client := range clients {
+		client.Reply(replies.For(client))
 	}
-	return false
 }
 
 type ChannelSet map[*Channel]bool
@@ -102,6 +77,10 @@ func (channels ChannelSet) Remove(channel *Channel) {
 	delete(channels, channel)
 }
 
+func (channels ChannelSet) Has(channel *Channel) bool {
+	return channels[channel]
+}
+
 func (channels ChannelSet) First() *Channel {
 	for channel := range channels {
 		return channel
@@ -0,0 +1,179 @@
+package irc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Webhooks POST server events as JSON to configured URLs, for chat and ops
+// tooling. Each URL has its own queue and delivery goroutine, so a slow
+// endpoint doesn't hold up the server or the others. Failed deliveries are
+// retried with exponential backoff, then dropped.
+
+type WebhookEvent string
+
+const (
+	EventServerStart    WebhookEvent = "server.start"
+	EventServerStop     WebhookEvent = "server.stop"
+	EventUserRegistered WebhookEvent = "user.registered"
+	EventChannelCreated WebhookEvent = "channel.created"
+	EventOperAction     WebhookEvent = "oper.action"
+	EventKLineAdded     WebhookEvent = "kline.added"
+
+	WEBHOOK_QUEUE         = 256 // payloads waiting for each URL
+	WEBHOOK_RETRIES       = 5
+	WEBHOOK_BACKOFF       = time.Second // doubled after each failure
+	WEBHOOK_TIMEOUT       = 10 * time.Second
+	WEBHOOK_SHUTDOWN_WAIT = 5 * time.Second
+)
+
+var (
+	WebhookEvents = map[WebhookEvent]bool{
+		EventServerStart:    true,
+		EventServerStop:     true,
+		EventUserRegistered: true,
+		EventChannelCreated: true,
+		EventOperAction:     true,
+		EventKLineAdded:     true,
+	}
+)
+
+// WebhookPayload is the JSON body sent for an event.
+type WebhookPayload struct {
+	Event  WebhookEvent      `json:"event"`
+	Time   int64             `json:"time"`
+	Server string            `json:"server"`
+	Data   map[string]string `json:"data"`
+}
+
+type Webhook struct {
+	conf   *WebhookConfig
+	events map[WebhookEvent]bool // nil for all events
+	queue  chan []byte
+	done   chan bool
+	client *http.Client
+}
+
+func NewWebhook(conf *WebhookConfig) *Webhook {
+	hook := &Webhook{
+		conf:   conf,
+		queue:  make(chan []byte, WEBHOOK_QUEUE),
+		done:   make(chan bool),
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+	}
+	if len(conf.Events) > 0 {
+		hook.events = make(map[WebhookEvent]bool)
+		for _, event := range conf.Events {
+			hook.events[WebhookEvent(event)] = true
+		}
+	}
+	go hook.deliverLoop()
+	return hook
+}
+
+func (hook *Webhook) Wants(event WebhookEvent) bool {
+	return (hook.events == nil) || hook.events[event]
+}
+
+//
+// server goroutine
+//
+
+// Notify queues the event for every webhook and event stream that wants
+// it. It never blocks; events are dropped when a webhook's queue is full.
+func (server *Server) Notify(event WebhookEvent, data map[string]string) {
+	server.Stream(event, data)
+	if len(server.webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(&WebhookPayload{
+		Event:  event,
+		Time:   time.Now().Unix(),
+		Server: server.name.String(),
+		Data:   data,
+	})
+	if err != nil {
+		Log.error.Printf("webhook %s: %s", event, err)
+		return
+	}
+
+	for _, hook := range server.webhooks {
+		if !hook.Wants(event) {
+			continue
+		}
+		select {
+		case hook.queue <- body:
+		default:
+			Log.error.Printf("webhook %s: queue full, dropping %s",
+				hook.conf.URL, event)
+		}
+	}
+}
+
+// CloseWebhooks stops accepting events and gives queued ones a little
+// time to be delivered.
+func (server *Server) CloseWebhooks() {
+	for _, hook := range server.webhooks {
+		close(hook.queue)
+	}
+	timeout := time.After(WEBHOOK_SHUTDOWN_WAIT)
+	for _, hook := range server.webhooks {
+		select {
+		case <-hook.done:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+//
+// webhook goroutine
+//
+
+func (hook *Webhook) deliverLoop() {
+	for body := range hook.queue {
+		backoff := WEBHOOK_BACKOFF
+		for attempt := 1; !hook.deliver(body); attempt += 1 {
+			if attempt >= WEBHOOK_RETRIES {
+				Log.error.Printf("webhook %s: giving up after %d attempts",
+					hook.conf.URL, attempt)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	close(hook.done)
+}
+
+func (hook *Webhook) deliver(body []byte) bool {
+	request, err := http.NewRequest("POST", hook.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		Log.error.Printf("webhook %s: %s", hook.conf.URL, err)
+		return true // retrying won't help
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if hook.conf.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.conf.Secret))
+		mac.Write(body)
+		request.Header.Set("X-Ergonomadic-Signature",
+			"sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := hook.client.Do(request)
+	if err != nil {
+		Log.debug.Printf("webhook %s: %s", hook.conf.URL, err)
+		return false
+	}
+	response.Body.Close()
+	if (response.StatusCode < 200) || (response.StatusCode > 299) {
+		Log.debug.Printf("webhook %s: %s", hook.conf.URL, response.Status)
+		return false
+	}
+	return true
+}
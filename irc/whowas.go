@@ -1,5 +1,9 @@
 package irc
 
+import (
+	"time"
+)
+
 type WhoWasList struct {
 	buffer []*WhoWas
 	start  int
@@ -11,6 +15,7 @@ type WhoWas struct {
 	username Name
 	hostname Name
 	realname Text
+	time     time.Time
 }
 
 func NewWhoWasList(size uint) *WhoWasList {
@@ -25,6 +30,7 @@ func (list *WhoWasList) Append(client *Client) {
 		username: client.username,
 		hostname: client.hostname,
 		realname: client.realname,
+		time:     time.Now(),
 	}
 	list.end = (list.end + 1) % len(list.buffer)
 	if list.end == list.start {
@@ -46,6 +52,17 @@ func (list *WhoWasList) Find(nickname Name, limit int64) []*WhoWas {
 	return results
 }
 
+// Trim forgets the entries from before cutoff, returning how many.
+func (list *WhoWasList) Trim(cutoff time.Time) int {
+	count := 0
+	for (list.start != list.end) && list.buffer[list.start].time.Before(cutoff) {
+		list.buffer[list.start] = nil
+		list.start = (list.start + 1) % len(list.buffer)
+		count += 1
+	}
+	return count
+}
+
 func (list *WhoWasList) prev(index int) int {
 	index -= 1
 	if index < 0 {